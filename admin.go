@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"auditlog-cleaner/cleaner"
+)
+
+// tableCleanup is what POST /admin/cleanup did to one table.
+type tableCleanup struct {
+	Table      string   `json:"table"`
+	Status     string   `json:"status"` // ok, skipped or failed
+	Error      string   `json:"error,omitempty"`
+	Rows       int      `json:"rows"`
+	Partitions []string `json:"partitions"`
+	Bytes      int64    `json:"bytes"`
+	Archive    string   `json:"archive,omitempty"`
+}
+
+// adminCleanupResponse is the JSON body returned by POST /admin/cleanup.
+type adminCleanupResponse struct {
+	Error  string         `json:"error,omitempty"`
+	Tables []tableCleanup `json:"tables,omitempty"`
+}
+
+// tablePause is the state of one table after a pause or resume request.
+type tablePause struct {
+	Table   string `json:"table"`
+	Paused  bool   `json:"paused"`
+	Changed bool   `json:"changed"` // False if the table already was in that state
+}
+
+// adminPauseResponse is the JSON body returned by POST
+// /admin/cleanup/pause and /admin/cleanup/resume.
+type adminPauseResponse struct {
+	Error  string       `json:"error,omitempty"`
+	Tables []tablePause `json:"tables,omitempty"`
+}
+
+// newAdminCleanupHandler serves POST /admin/cleanup, which runs a cleanup
+// pass over every managed table right away, e.g. when the disk is filling
+// up, and responds with what was removed. The passes run under ctx rather
+// than the request's context, so that a client giving up doesn't interrupt
+// a drop. Each pass takes the table's cleanup lock like the scheduled ones,
+// so a table being cleaned up already is skipped, answered with 409 unless
+// another table failed, which is answered with 500. With a token, requests
+// must carry it as a bearer token.
+func newAdminCleanupHandler(ctx context.Context, cleaners []*cleaner.Cleaner, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r, token) {
+			return
+		}
+
+		status := http.StatusOK
+		var body adminCleanupResponse
+		for _, c := range cleaners {
+			result, err := c.Cleanup(ctx)
+			t := tableCleanup{
+				Table:      c.Table(),
+				Status:     "ok",
+				Rows:       result.Rows,
+				Partitions: result.Partitions,
+				Bytes:      result.Bytes,
+				Archive:    result.Archive,
+			}
+			switch {
+			case err != nil:
+				t.Status = "failed"
+				t.Error = err.Error()
+				status = http.StatusInternalServerError
+			case result.Paused:
+				t.Status = "skipped"
+				t.Error = "cleanup of the table is paused"
+				if status == http.StatusOK {
+					status = http.StatusConflict
+				}
+			case result.Missing:
+				t.Status = "skipped"
+				t.Error = "the table does not exist"
+			case result.Skipped:
+				t.Status = "skipped"
+				t.Error = "cleanup of the table is already running"
+				if status == http.StatusOK {
+					status = http.StatusConflict
+				}
+			}
+			if t.Partitions == nil {
+				t.Partitions = []string{}
+			}
+			body.Tables = append(body.Tables, t)
+		}
+		respondAdmin(w, status, body)
+	}
+}
+
+// newAdminPauseHandler serves POST /admin/cleanup/pause, or
+// /admin/cleanup/resume with pause false, which pauses or resumes the
+// cleanup of every managed table, see cleaner.Cleaner.Pause. Inserts and
+// ingestion are not affected. The state is kept in memory: it is lost on
+// restart and applies to this instance only.
+func newAdminPauseHandler(cleaners []*cleaner.Cleaner, token string, pause bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r, token) {
+			return
+		}
+
+		var body adminPauseResponse
+		for _, c := range cleaners {
+			var changed bool
+			if pause {
+				changed = c.Pause()
+			} else {
+				changed = c.Resume()
+			}
+			body.Tables = append(body.Tables, tablePause{Table: c.Table(), Paused: c.Paused(), Changed: changed})
+		}
+		respondAdmin(w, http.StatusOK, body)
+	}
+}
+
+// authorized checks the bearer token of an admin request, if token is set,
+// and answers it with 401 if missing or wrong. Without a token, main only
+// serves the admin endpoints if ADMIN_ALLOW_UNAUTHENTICATED is set.
+func authorized(w http.ResponseWriter, r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		respondAdmin(w, http.StatusUnauthorized, adminCleanupResponse{Error: "missing or invalid bearer token"})
+		return false
+	}
+	return true
+}
+
+func respondAdmin(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
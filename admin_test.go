@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"auditlog-cleaner/cleaner"
+)
+
+func TestAuthorized(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		want          bool
+	}{
+		{"no token configured", "", "", true},
+		{"valid token", "s3cret", "Bearer s3cret", true},
+		{"missing header", "s3cret", "", false},
+		{"wrong token", "s3cret", "Bearer other", false},
+		{"wrong scheme", "s3cret", "Basic s3cret", false},
+		{"token prefix", "s3cret", "Bearer s3c", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/admin/cleanup", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			if got := authorized(w, r, tt.token); got != tt.want {
+				t.Fatalf("authorized() = %t, want %t", got, tt.want)
+			}
+			if !tt.want {
+				if w.Code != http.StatusUnauthorized {
+					t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+				}
+				if got := w.Header().Get("WWW-Authenticate"); got != "Bearer" {
+					t.Errorf("WWW-Authenticate = %q, want Bearer", got)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminCleanupHandlerRejectsMissingToken(t *testing.T) {
+	handler := newAdminCleanupHandler(context.Background(), nil, "s3cret")
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/admin/cleanup", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAdminPauseHandler(t *testing.T) {
+	cleaners := []*cleaner.Cleaner{
+		cleaner.New(nil, cleaner.Options{Table: "audit_logs"}),
+		cleaner.New(nil, cleaner.Options{Table: "access_logs"}),
+	}
+	pause := newAdminPauseHandler(cleaners, "s3cret", true)
+	resume := newAdminPauseHandler(cleaners, "s3cret", false)
+
+	steps := []struct {
+		name        string
+		handler     http.HandlerFunc
+		wantPaused  bool
+		wantChanged bool
+	}{
+		{"pause", pause, true, true},
+		{"pause again", pause, true, false},
+		{"resume", resume, false, true},
+		{"resume again", resume, false, false},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/admin/cleanup/pause", nil)
+			r.Header.Set("Authorization", "Bearer s3cret")
+			w := httptest.NewRecorder()
+			step.handler(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			var body adminPauseResponse
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if len(body.Tables) != len(cleaners) {
+				t.Fatalf("response lists %d tables, want %d", len(body.Tables), len(cleaners))
+			}
+			for i, table := range body.Tables {
+				want := tablePause{Table: cleaners[i].Table(), Paused: step.wantPaused, Changed: step.wantChanged}
+				if table != want {
+					t.Errorf("table %d = %+v, want %+v", i, table, want)
+				}
+				if cleaners[i].Paused() != step.wantPaused {
+					t.Errorf("%s paused = %t, want %t", table.Table, cleaners[i].Paused(), step.wantPaused)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminCleanupHandler(t *testing.T) {
+	exists := func(mock sqlmock.Sqlmock, table string, ok bool) {
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(table).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(ok))
+	}
+	tests := []struct {
+		name       string
+		paused     bool
+		expect     func(mock sqlmock.Sqlmock)
+		wantCode   int
+		wantStatus []string
+	}{
+		{"tables missing", false, func(mock sqlmock.Sqlmock) {
+			exists(mock, "audit_logs", false)
+			exists(mock, "access_logs", false)
+		}, http.StatusOK, []string{"skipped", "skipped"}},
+		{"paused", true, func(sqlmock.Sqlmock) {}, http.StatusConflict, []string{"skipped", "skipped"}},
+		{"already running", false, func(mock sqlmock.Sqlmock) {
+			exists(mock, "audit_logs", true)
+			mock.ExpectQuery("pg_try_advisory_lock").WithArgs("auditlog-cleaner:audit_logs").
+				WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+			exists(mock, "access_logs", false)
+		}, http.StatusConflict, []string{"skipped", "skipped"}},
+		{"failed", false, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("SELECT EXISTS").WithArgs("audit_logs").WillReturnError(errors.New("permission denied"))
+			exists(mock, "access_logs", false)
+		}, http.StatusInternalServerError, []string{"failed", "skipped"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("opening mock database: %v", err)
+			}
+			defer db.Close()
+			cleaners := []*cleaner.Cleaner{
+				cleaner.New(db, cleaner.Options{Table: "audit_logs"}),
+				cleaner.New(db, cleaner.Options{Table: "access_logs"}),
+			}
+			if tt.paused {
+				for _, c := range cleaners {
+					c.Pause()
+				}
+			}
+			tt.expect(mock)
+
+			r := httptest.NewRequest(http.MethodPost, "/admin/cleanup", nil)
+			w := httptest.NewRecorder()
+			newAdminCleanupHandler(t.Context(), cleaners, "")(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			var body adminCleanupResponse
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if len(body.Tables) != len(tt.wantStatus) {
+				t.Fatalf("response lists %d tables, want %d", len(body.Tables), len(tt.wantStatus))
+			}
+			for i, table := range body.Tables {
+				if table.Status != tt.wantStatus[i] {
+					t.Errorf("%s status = %s (%s), want %s", table.Table, table.Status, table.Error, tt.wantStatus[i])
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
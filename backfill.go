@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"auditlog-cleaner/cleaner"
+	"auditlog-cleaner/config"
+)
+
+// parseBackfillTime parses the value of a backfill range flag: a time, or
+// a duration, e.g. 72h or 7d, for that long before now.
+func parseBackfillTime(s string, now time.Time) (time.Time, error) {
+	if t, err := config.ParseTime(s); err == nil {
+		return t, nil
+	}
+	if d, err := config.ParseDuration(s); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected e.g. 2024-01-15, 2024-01-15T12:00, RFC 3339 or a duration like 72h before now", s)
+}
+
+// runBackfill creates the missing partitions of c between the from and to
+// flag values, to defaulting to now, in steps of step. With a rate, it then
+// writes that many generated audit logs per hour of the range to them and
+// logs how many each partition got.
+func runBackfill(ctx context.Context, c *cleaner.Cleaner, fromFlag, toFlag, stepFlag string, limit int, rate float64) error {
+	now := time.Now()
+	from, err := parseBackfillTime(fromFlag, now)
+	if err != nil {
+		return fmt.Errorf("--backfill-from: %w", err)
+	}
+	to := now
+	if toFlag != "" {
+		if to, err = parseBackfillTime(toFlag, now); err != nil {
+			return fmt.Errorf("--backfill-to: %w", err)
+		}
+	}
+	step, err := config.ParseDuration(stepFlag)
+	if err != nil {
+		return fmt.Errorf("--backfill-step: invalid duration %q", stepFlag)
+	}
+
+	created, err := c.Backfill(ctx, from, to, step, limit)
+	slog.Info("backfill finished", "table", c.Table(), "partitions_created", created,
+		"from", from.Format(time.RFC3339), "to", to.Format(time.RFC3339), "step", step)
+	if err != nil || rate == 0 {
+		return err
+	}
+
+	counts, err := c.BackfillRows(ctx, from, to, rate)
+	total := 0
+	for _, count := range counts {
+		slog.Info("audit logs backfilled", "table", c.Table(), "partition", count.Partition, "rows", count.Rows)
+		total += count.Rows
+	}
+	slog.Info("audit log backfill finished", "table", c.Table(), "rows", total, "partitions", len(counts),
+		"rate_per_hour", rate)
+	return err
+}
@@ -0,0 +1,256 @@
+package cleaner
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// archiveWriter appends deleted rows to a CSV file, optionally
+// gzip-compressed. The file is only created on the first write, so cleanup
+// runs with nothing to delete don't leave empty archives behind. The header
+// is taken from the columns of the first row written.
+//
+// Rows are written before the transaction deleting them commits, so that
+// nothing is deleted unarchived. Each attempt at such a transaction starts
+// with mark, and rollback returns the file to the mark if the attempt fails
+// before committing, so that its rows aren't archived again when it is
+// retried. Compressed archives are written as one gzip member per sync,
+// which gzip and other readers decompress as a single stream, so that a
+// mark is always at a member boundary.
+type archiveWriter struct {
+	path     string
+	compress bool
+	rows     int
+
+	file   *os.File
+	gz     *gzip.Writer
+	csv    *csv.Writer
+	member bool // A gzip member is open
+
+	marked     int64 // File offset at the last mark
+	markedRows int   // rows at the last mark
+}
+
+// newArchiveWriter returns a writer for dir/<table>_<cutoff>.csv, with a .gz
+// suffix when compress is set.
+func newArchiveWriter(dir, table string, cutoff time.Time, compress bool) *archiveWriter {
+	name := fmt.Sprintf("%s_%s.csv", table, cutoff.Format("20060102_150405"))
+	if compress {
+		name += ".gz"
+	}
+	return &archiveWriter{path: filepath.Join(dir, name), compress: compress}
+}
+
+func (a *archiveWriter) open(columns []string) error {
+	if err := os.MkdirAll(filepath.Dir(a.path), 0o755); err != nil {
+		return err
+	}
+
+	// Never overwrite an existing archive
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	a.file = f
+
+	var w io.Writer = f
+	if a.compress {
+		a.gz = gzip.NewWriter(f)
+		a.member = true
+		w = a.gz
+	}
+	a.csv = csv.NewWriter(w)
+	return a.csv.Write(columns)
+}
+
+// write appends a row to the archive, creating the file if needed.
+func (a *archiveWriter) write(r row) error {
+	if a.file == nil {
+		if err := a.open(r.columns); err != nil {
+			return err
+		}
+	}
+	if a.gz != nil && !a.member {
+		a.gz.Reset(a.file)
+		a.member = true
+	}
+
+	record := make([]string, len(r.values))
+	for i, v := range r.values {
+		record[i] = formatValue(v)
+	}
+	if err := a.csv.Write(record); err != nil {
+		return err
+	}
+	a.rows++
+	return nil
+}
+
+// sync flushes everything written so far through to stable storage.
+func (a *archiveWriter) sync() error {
+	if a.file == nil {
+		return nil
+	}
+
+	a.csv.Flush()
+	if err := a.csv.Error(); err != nil {
+		return err
+	}
+	if a.member {
+		if err := a.gz.Close(); err != nil {
+			return err
+		}
+		a.member = false
+	}
+	return a.file.Sync()
+}
+
+// mark records the end of the archive as the point rollback returns to. It
+// is called before each attempt at a transaction whose rows are archived,
+// when everything written before has been synced or rolled back. It is a
+// no-op on a nil archiveWriter.
+func (a *archiveWriter) mark() error {
+	if a == nil {
+		return nil
+	}
+	a.marked, a.markedRows = 0, a.rows
+	if a.file == nil {
+		return nil
+	}
+	offset, err := a.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("marking archive %s: %w", a.path, err)
+	}
+	a.marked = offset
+	return nil
+}
+
+// rollback discards the rows written since the last mark, after the
+// transaction deleting them failed before committing, and returns err. It
+// is a no-op on a nil archiveWriter. A failed commit is not rolled back:
+// the server may have committed it anyway.
+func (a *archiveWriter) rollback(err error) error {
+	if a == nil || a.file == nil {
+		return err
+	}
+
+	// An archive without rows before the mark is removed, so that the
+	// next write starts it over with its header
+	if a.markedRows == 0 {
+		a.file.Close()
+		a.file, a.gz, a.csv, a.member, a.rows = nil, nil, nil, false, 0
+		if rmErr := os.Remove(a.path); rmErr != nil {
+			return errors.Join(err, fmt.Errorf("removing archive %s: %w", a.path, rmErr))
+		}
+		return err
+	}
+
+	if truncErr := a.file.Truncate(a.marked); truncErr != nil {
+		return errors.Join(err, fmt.Errorf("truncating archive %s: %w", a.path, truncErr))
+	}
+	if _, seekErr := a.file.Seek(a.marked, io.SeekStart); seekErr != nil {
+		return errors.Join(err, fmt.Errorf("truncating archive %s: %w", a.path, seekErr))
+	}
+	var w io.Writer = a.file
+	if a.gz != nil {
+		a.gz.Reset(a.file)
+		a.member = false
+		w = a.gz
+	}
+	a.csv = csv.NewWriter(w)
+	a.rows = a.markedRows
+	return err
+}
+
+// close syncs and closes the archive file, if one was created.
+func (a *archiveWriter) close() error {
+	if a.file == nil {
+		return nil
+	}
+	defer a.file.Close()
+
+	if err := a.sync(); err != nil {
+		return err
+	}
+	return a.file.Close()
+}
+
+// writeGzipCSV streams rows to w as gzip-compressed CSV with a header line,
+// without holding them in memory, and returns how many rows it wrote. rows
+// is always closed.
+func writeGzipCSV(rows *sql.Rows, w io.Writer) (int, error) {
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	gz := gzip.NewWriter(w)
+	out := csv.NewWriter(gz)
+	if err := out.Write(columns); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return count, fmt.Errorf("scanning row: %w", err)
+		}
+		for i, v := range values {
+			record[i] = formatValue(v)
+		}
+		if err := out.Write(record); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return count, err
+	}
+	return count, gz.Close()
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// formatValue renders a value scanned from the database for CSV output.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,237 @@
+package cleaner
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// connectionFailure is a transient error, which withRetry retries.
+var connectionFailure = &pq.Error{Code: "08006", Message: "connection failure"}
+
+// readArchive returns the records of the archive at path, header first.
+func readArchive(t *testing.T, path string, compressed bool) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening archive: %v", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if compressed {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("decompressing archive: %v", err)
+		}
+		r = gz
+	}
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		t.Fatalf("reading archive: %v", err)
+	}
+	return records
+}
+
+// archiveIDs returns the ids in the first column of an archive's records,
+// after the header.
+func archiveIDs(records [][]string) []string {
+	var ids []string
+	for _, r := range records[1:] {
+		ids = append(ids, r[0])
+	}
+	return ids
+}
+
+// testRow returns a row of an id and a message.
+func testRow(id int) row {
+	return row{columns: []string{"id", "message"}, values: []any{int64(id), "log"}}
+}
+
+func TestArchiveWriterRollback(t *testing.T) {
+	tests := []struct {
+		name     string
+		compress bool
+	}{
+		{"plain", false},
+		{"gzip", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newArchiveWriter(t.TempDir(), "audit_logs", utc(2024, 1, 15, 12, 0), tt.compress)
+
+			// A failed first attempt leaves no archive behind
+			write := func(ids ...int) {
+				t.Helper()
+				for _, id := range ids {
+					if err := a.write(testRow(id)); err != nil {
+						t.Fatalf("write() = %v", err)
+					}
+				}
+				if err := a.sync(); err != nil {
+					t.Fatalf("sync() = %v", err)
+				}
+			}
+			a.mark()
+			write(1, 2)
+			a.rollback(errors.New("commit failed"))
+			if _, err := os.Stat(a.path); !os.IsNotExist(err) {
+				t.Fatalf("archive exists after rolling back its only rows: %v", err)
+			}
+
+			a.mark()
+			write(1, 2)
+			a.mark()
+			write(3, 4)
+			a.rollback(errors.New("drop failed"))
+			a.mark()
+			write(3, 4)
+			a.mark()
+			write(5)
+
+			if err := a.close(); err != nil {
+				t.Fatalf("close() = %v", err)
+			}
+			records := readArchive(t, a.path, tt.compress)
+			if !slices.Equal(records[0], []string{"id", "message"}) {
+				t.Errorf("header = %v, want [id message]", records[0])
+			}
+			if got, want := archiveIDs(records), []string{"1", "2", "3", "4", "5"}; !slices.Equal(got, want) {
+				t.Errorf("archived ids %v, want %v", got, want)
+			}
+			if a.rows != 5 {
+				t.Errorf("rows = %d, want 5", a.rows)
+			}
+		})
+	}
+}
+
+// deleteQuery is the statement deleteBatch deletes a batch with.
+const deleteQuery = `
+	DELETE FROM "audit_logs"
+	WHERE ctid = ANY(ARRAY(
+		SELECT ctid FROM "audit_logs"
+		WHERE "created_at" < $1
+		ORDER BY "created_at" ASC
+		LIMIT $2
+	)) AND "created_at" < $1
+	RETURNING *
+`
+
+func TestDeleteExpiredRowsArchivesOnce(t *testing.T) {
+	cutoff := utc(2024, 1, 15, 12, 0)
+	c, mock := newMockCleaner(t, Options{BatchSize: 3, MaxRetries: 2, RetryBaseDelay: time.Millisecond})
+	archive := newArchiveWriter(t.TempDir(), "audit_logs", cutoff, true)
+
+	batch := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "message"}).AddRow(1, "log").AddRow(2, "log").AddRow(3, "log")
+	}
+	// The connection fails after two rows of the batch were archived, and
+	// the whole batch is deleted again
+	mock.ExpectBegin()
+	mock.ExpectQuery(deleteQuery).WithArgs(cutoff, 3).WillReturnRows(batch().RowError(2, connectionFailure))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(deleteQuery).WithArgs(cutoff, 3).WillReturnRows(batch())
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(deleteQuery).WithArgs(cutoff, 3).WillReturnRows(sqlmock.NewRows([]string{"id", "message"}))
+	mock.ExpectCommit()
+
+	deleted, err := c.deleteExpiredRows(t.Context(), c.ident, cutoff, archive)
+	if err != nil {
+		t.Fatalf("deleteExpiredRows() = %v", err)
+	}
+	if err := archive.close(); err != nil {
+		t.Fatalf("closing archive: %v", err)
+	}
+	if got := archiveIDs(readArchive(t, archive.path, true)); deleted != 3 || !slices.Equal(got, []string{"1", "2", "3"}) {
+		t.Errorf("deleted %d rows and archived %v, want 3 rows archived once", deleted, got)
+	}
+}
+
+// expectArchivedDrop expects the statements that archive partition p,
+// holding rows, and then drop it if it still holds as many rows as were
+// archived. A non-nil dropErr fails the DROP TABLE.
+func expectArchivedDrop(mock sqlmock.Sqlmock, p partition, rows int, rowsAtDrop int, dropErr error) {
+	mock.ExpectQuery(`SELECT pg_total_relation_size($1::regclass)`).WithArgs(p.ident).
+		WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(8192))
+	mock.ExpectBegin()
+	archived := sqlmock.NewRows([]string{"id", "message"})
+	for i := range rows {
+		archived.AddRow(i+1, "log")
+	}
+	mock.ExpectQuery(`SELECT * FROM ` + p.ident).WillReturnRows(archived)
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`LOCK TABLE ` + p.ident + ` IN SHARE MODE`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT count(*) FROM ` + p.ident).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(rowsAtDrop))
+	if rowsAtDrop != rows {
+		mock.ExpectRollback()
+		return
+	}
+	drop := mock.ExpectExec(`DROP TABLE ` + p.ident)
+	if dropErr != nil {
+		drop.WillReturnError(dropErr)
+		mock.ExpectRollback()
+		return
+	}
+	drop.WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+}
+
+func TestDropPartitionArchivesOnce(t *testing.T) {
+	p := partition{name: "audit_logs_20240115_1100", ident: `"audit_logs_20240115_1100"`,
+		from: utc(2024, 1, 15, 11, 0), to: utc(2024, 1, 15, 12, 0)}
+	c, mock := newMockCleaner(t, Options{MaxRetries: 1, RetryBaseDelay: time.Millisecond})
+	archive := newArchiveWriter(t.TempDir(), "audit_logs", utc(2024, 1, 15, 12, 0), false)
+
+	// The drop fails once after the partition's rows were archived
+	expectArchivedDrop(mock, p, 3, 3, connectionFailure)
+	expectArchivedDrop(mock, p, 3, 3, nil)
+
+	result, err := c.dropPartitions(t.Context(), []partition{p}, archive, policyMaxAge)
+	if err != nil {
+		t.Fatalf("dropPartitions() = %v", err)
+	}
+	if err := archive.close(); err != nil {
+		t.Fatalf("closing archive: %v", err)
+	}
+
+	// The archive holds exactly the rows the partition had when dropped
+	if got := archiveIDs(readArchive(t, archive.path, false)); result.Rows != 3 || !slices.Equal(got, []string{"1", "2", "3"}) {
+		t.Errorf("dropped %d rows and archived %v, want 3 rows archived once", result.Rows, got)
+	}
+}
+
+func TestDropPartitionChangedSinceArchived(t *testing.T) {
+	p := partition{name: "audit_logs_20240115_1100", ident: `"audit_logs_20240115_1100"`,
+		from: utc(2024, 1, 15, 11, 0), to: utc(2024, 1, 15, 12, 0)}
+	c, mock := newMockCleaner(t, Options{})
+	archive := newArchiveWriter(t.TempDir(), "audit_logs", utc(2024, 1, 15, 12, 0), false)
+
+	// A row arrives between the archive and the drop, which is left for the
+	// next cycle along with the archived rows
+	expectArchivedDrop(mock, p, 3, 4, nil)
+
+	result, err := c.dropPartitions(t.Context(), []partition{p}, archive, policyMaxAge)
+	if err != nil {
+		t.Fatalf("dropPartitions() = %v", err)
+	}
+	if len(result.Partitions) != 0 || archive.rows != 0 {
+		t.Errorf("dropped %v and archived %d rows, want none", result.Partitions, archive.rows)
+	}
+	if _, err := os.Stat(archive.path); !os.IsNotExist(err) {
+		t.Errorf("archive exists after the drop was abandoned: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxIdentifierLength is the longest name Postgres keeps without truncation.
+const maxIdentifierLength = 63
+
+// Backfill creates a partition for every step between from and to that no
+// existing partition covers yet, e.g. when adopting a table with older data,
+// and returns how many it created. The first boundary is from rounded down
+// to a multiple of step. Partitions are named after their lower bound,
+// following PartitionTemplate, like audit_logs_20240115_1200. Backfill refuses
+// to create more than limit partitions. The table must be range-partitioned on its time column.
+func (c *Cleaner) Backfill(ctx context.Context, from, to time.Time, step time.Duration, limit int) (int, error) {
+	if c.strategy != StrategyPartition || c.opts.Storage == StorageTimescale {
+		return 0, fmt.Errorf("backfill needs table %s to be range-partitioned on %s", c.opts.Table, c.opts.TimeColumn)
+	}
+	if step <= 0 {
+		return 0, fmt.Errorf("backfill step must be greater than 0, got %s", step)
+	}
+	if !from.Before(to) {
+		return 0, fmt.Errorf("backfill range start %s is not before its end %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	start := from.UTC().Truncate(step)
+	if n := (to.Sub(start) + step - 1) / step; n > time.Duration(limit) {
+		return 0, fmt.Errorf("backfill from %s to %s in steps of %s would create up to %d partitions, more than the limit of %d",
+			from.Format(time.RFC3339), to.Format(time.RFC3339), step, n, limit)
+	}
+
+	var existing []partition
+	err := c.withRetry(ctx, "list partitions", func(ctx context.Context) error {
+		var err error
+		existing, err = c.listPartitions(ctx)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("listing partitions: %w", err)
+	}
+
+	created := 0
+	for lower := start; lower.Before(to); lower = lower.Add(step) {
+		upper := lower.Add(step)
+		name, err := c.newPartitionName(lower, step)
+		if err != nil {
+			return created, err
+		}
+
+		covered := false
+		for _, p := range existing {
+			if p.overlaps(lower, upper) {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			slog.Debug("range already partitioned, skipping", "table", c.opts.Table,
+				"from", lower.Format(time.RFC3339), "to", upper.Format(time.RFC3339))
+			continue
+		}
+
+		made, err := c.ensurePartition(ctx, name, lower, upper)
+		if err != nil {
+			return created, fmt.Errorf("creating partition %s: %w", name, err)
+		}
+		if made {
+			created++
+		}
+	}
+	return created, nil
+}
+
+// BackfillCount is how many audit logs BackfillRows wrote to a partition.
+type BackfillCount struct {
+	Partition string
+	Rows      int
+}
+
+// BackfillRows writes generated audit logs dated between from and to, at
+// perHour logs per hour of that range, spread evenly over it, e.g. to try
+// out retention without waiting for the logs to age. Every partition in the
+// range gets its share, so Backfill must have created them first. It
+// returns how many logs each partition got, oldest first; the logs are
+// cleaned up like any others. Only a Cleaner with Generate set can make up
+// the logs, and a dry run writes none.
+func (c *Cleaner) BackfillRows(ctx context.Context, from, to time.Time, perHour float64) ([]BackfillCount, error) {
+	if c.gen == nil {
+		return nil, fmt.Errorf("backfilling audit logs needs the generator for table %s", c.opts.Table)
+	}
+	if perHour <= 0 {
+		return nil, fmt.Errorf("backfill rate must be greater than 0, got %g", perHour)
+	}
+
+	var partitions []partition
+	err := c.withRetry(ctx, "list partitions", func(ctx context.Context) error {
+		var err error
+		partitions, err = c.listPartitions(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing partitions: %w", err)
+	}
+	slices.SortFunc(partitions, func(a, b partition) int { return a.from.Compare(b.from) })
+
+	var counts []BackfillCount
+	var due float64 // The fraction of a log left over from earlier partitions
+	counter := 0
+	for _, p := range partitions {
+		if !p.overlaps(from, to) {
+			continue
+		}
+		lower, upper := from, to
+		if !p.from.IsZero() && p.from.After(lower) {
+			lower = p.from
+		}
+		if !p.to.IsZero() && p.to.Before(upper) {
+			upper = p.to
+		}
+
+		due += perHour * upper.Sub(lower).Hours()
+		n := int(due)
+		due -= float64(n)
+		if n == 0 {
+			continue
+		}
+		if c.opts.DryRun {
+			slog.Info("[DRY RUN] would backfill audit logs", "table", c.opts.Table, "partition", p.name, "count", n)
+			continue
+		}
+
+		// Written a chunk at a time, so that a long range is never held in
+		// memory at once
+		gap := upper.Sub(lower) / time.Duration(n)
+		written := 0
+		for written < n {
+			rows := make([]logRow, min(n-written, c.opts.InsertChunkSize))
+			for i := range rows {
+				at := lower.Add(gap*time.Duration(written+i) + gap/2)
+				rows[i] = c.generatedRow(fmt.Sprintf("Backfilled audit log #%d", counter+i), at)
+			}
+			inserted, err := c.postToDB(ctx, rows)
+			written += inserted
+			counter += inserted
+			if err != nil {
+				counts = append(counts, BackfillCount{Partition: p.name, Rows: written})
+				return counts, fmt.Errorf("writing audit logs to partition %s: %w", p.name, err)
+			}
+		}
+		counts = append(counts, BackfillCount{Partition: p.name, Rows: written})
+	}
+	return counts, nil
+}
+
+// ensurePartition creates the partition name for [from, to) unless a table
+// of that name already exists, and reports whether it created it. A dry run
+// creates nothing and reports false. With IndexConcurrent, the indexes of
+// Options.Indexes are then built on the new partition.
+func (c *Cleaner) ensurePartition(ctx context.Context, name string, from, to time.Time) (created bool, err error) {
+	var exists bool
+	err = c.withRetry(ctx, "check partition", func(ctx context.Context) error {
+		return c.db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, pq.QuoteIdentifier(name)).Scan(&exists)
+	})
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		slog.Debug("partition already exists", "table", c.opts.Table, "partition", name)
+		return false, nil
+	}
+
+	if c.opts.DryRun {
+		slog.Info("[DRY RUN] would create partition", "table", c.opts.Table, "partition", name,
+			"from", from.Format(time.RFC3339), "to", to.Format(time.RFC3339))
+		return false, nil
+	}
+
+	// IF NOT EXISTS still guards against another instance creating the
+	// partition in the meantime; the command tag doesn't tell the two apart.
+	// When both create it at the same moment, IF NOT EXISTS doesn't help
+	// and the loser gets an error instead, which means the partition exists.
+	query := c.partitionStatement(name, from, to)
+	err = c.withRetry(ctx, "create partition", func(ctx context.Context) error {
+		_, err := c.db.ExecContext(ctx, query)
+		return err
+	})
+	if isPartitionExists(err) {
+		slog.Debug("partition created concurrently by another session", "table", c.opts.Table,
+			"partition", name, "error", err)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	partitionsCreated.WithLabelValues(c.opts.Table).Inc()
+	c.partitionsCreated.Add(1)
+	slog.Info("partition created", "table", c.opts.Table, "partition", name,
+		"from", from.Format(time.RFC3339), "to", to.Format(time.RFC3339))
+	c.createPartitionIndexes(ctx, name)
+	return true, nil
+}
+
+// partitionStatement returns the statement that creates the partition name
+// for [from, to) unless it exists. DDL takes no parameters, so the bounds
+// are quoted as literals.
+func (c *Cleaner) partitionStatement(name string, from, to time.Time) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM (%s) TO (%s)`,
+		pq.QuoteIdentifier(name), c.ident,
+		pq.QuoteLiteral(from.Format(time.RFC3339Nano)), pq.QuoteLiteral(to.Format(time.RFC3339Nano)))
+}
@@ -0,0 +1,111 @@
+package cleaner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// existsQuery is the statement ensurePartition checks for a partition with.
+const existsQuery = `SELECT to_regclass($1) IS NOT NULL`
+
+func TestPartitionStatement(t *testing.T) {
+	c := New(nil, Options{Table: "audit_logs"})
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     string
+	}{
+		{"hour", utc(2024, 1, 15, 12, 0), utc(2024, 1, 15, 13, 0),
+			`CREATE TABLE IF NOT EXISTS "p" PARTITION OF "audit_logs" FOR VALUES FROM ('2024-01-15T12:00:00Z') TO ('2024-01-15T13:00:00Z')`},
+		{"across the year", utc(2023, 12, 31, 0, 0), utc(2024, 1, 1, 0, 0),
+			`CREATE TABLE IF NOT EXISTS "p" PARTITION OF "audit_logs" FOR VALUES FROM ('2023-12-31T00:00:00Z') TO ('2024-01-01T00:00:00Z')`},
+		{"leap day", utc(2024, 2, 29, 0, 0), utc(2024, 3, 1, 0, 0),
+			`CREATE TABLE IF NOT EXISTS "p" PARTITION OF "audit_logs" FOR VALUES FROM ('2024-02-29T00:00:00Z') TO ('2024-03-01T00:00:00Z')`},
+		{"seconds", utc(2024, 1, 15, 12, 0).Add(10 * time.Second), utc(2024, 1, 15, 12, 0).Add(20 * time.Second),
+			`CREATE TABLE IF NOT EXISTS "p" PARTITION OF "audit_logs" FOR VALUES FROM ('2024-01-15T12:00:10Z') TO ('2024-01-15T12:00:20Z')`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.partitionStatement("p", tt.from, tt.to); got != tt.want {
+				t.Errorf("partitionStatement() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsurePartition(t *testing.T) {
+	from, to := utc(2023, 12, 31, 0, 0), utc(2024, 1, 1, 0, 0)
+	const name = "audit_logs_20231231_0000"
+	create := `CREATE TABLE IF NOT EXISTS "audit_logs_20231231_0000" PARTITION OF "audit_logs" FOR VALUES FROM ('2023-12-31T00:00:00Z') TO ('2024-01-01T00:00:00Z')`
+
+	tests := []struct {
+		name        string
+		exists      bool
+		dryRun      bool
+		createErr   error // Of the CREATE TABLE, if it runs
+		wantCreated bool
+		wantErr     bool
+	}{
+		{"created", false, false, nil, true, false},
+		{"already exists", true, false, nil, false, false},
+		{"dry run", false, true, nil, false, false},
+		{"created concurrently", false, false, &pq.Error{Code: "42P07", Message: `relation "audit_logs_20231231_0000" already exists`}, false, false},
+		{"overlapping another partition", false, false, &pq.Error{Code: "42P17", Message: "partition would overlap"}, false, false},
+		{"creation fails", false, false, &pq.Error{Code: "42501", Message: "permission denied"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, mock := newMockCleaner(t, Options{DryRun: tt.dryRun})
+			mock.ExpectQuery(existsQuery).WithArgs(`"` + name + `"`).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.exists))
+			if !tt.exists && !tt.dryRun {
+				exec := mock.ExpectExec(create)
+				if tt.createErr != nil {
+					exec.WillReturnError(tt.createErr)
+				} else {
+					exec.WillReturnResult(sqlmock.NewResult(0, 0))
+				}
+			}
+
+			created, err := c.ensurePartition(t.Context(), name, from, to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ensurePartition() = %v, want error %t", err, tt.wantErr)
+			}
+			if created != tt.wantCreated {
+				t.Errorf("ensurePartition() created = %t, want %t", created, tt.wantCreated)
+			}
+		})
+	}
+}
+
+func TestBackfillSkipsCoveredRanges(t *testing.T) {
+	c, mock := newMockCleaner(t, Options{})
+	c.strategy = StrategyPartition
+
+	// The existing partition's bounds touch the ranges on either side
+	// without overlapping them
+	mock.ExpectQuery(catalogQuery).WithArgs("audit_logs").WillReturnRows(
+		sqlmock.NewRows([]string{"relname", "ident", "bound"}).
+			AddRow("audit_logs_20240115_1200", `"audit_logs_20240115_1200"`, rangeBound(utc(2024, 1, 15, 12, 0), utc(2024, 1, 15, 13, 0))),
+	)
+	for _, p := range []struct{ name, create string }{
+		{"audit_logs_20240115_1100", `CREATE TABLE IF NOT EXISTS "audit_logs_20240115_1100" PARTITION OF "audit_logs" FOR VALUES FROM ('2024-01-15T11:00:00Z') TO ('2024-01-15T12:00:00Z')`},
+		{"audit_logs_20240115_1300", `CREATE TABLE IF NOT EXISTS "audit_logs_20240115_1300" PARTITION OF "audit_logs" FOR VALUES FROM ('2024-01-15T13:00:00Z') TO ('2024-01-15T14:00:00Z')`},
+	} {
+		mock.ExpectQuery(existsQuery).WithArgs(`"` + p.name + `"`).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectExec(p.create).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	// From is rounded down to the hour
+	created, err := c.Backfill(t.Context(), utc(2024, 1, 15, 11, 30), utc(2024, 1, 15, 14, 0), time.Hour, 10)
+	if err != nil {
+		t.Fatalf("Backfill() = %v", err)
+	}
+	if created != 2 {
+		t.Errorf("Backfill() created %d partitions, want 2", created)
+	}
+}
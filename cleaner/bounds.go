@@ -0,0 +1,149 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// rangeBoundPattern matches the bound expression of a range partition on a
+// single column, as rendered by pg_get_expr, e.g.
+// FOR VALUES FROM ('2024-01-15 12:00:00+00') TO ('2024-01-15 13:00:00+00').
+var rangeBoundPattern = regexp.MustCompile(`^FOR VALUES FROM \((.+)\) TO \((.+)\)$`)
+
+// boundLayouts are the forms Postgres renders timestamp bounds in, with and
+// without a time zone offset. Fractional seconds are accepted by each.
+var boundLayouts = []string{
+	"2006-01-02 15:04:05-07",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02 15:04:05-07:00:00",
+	"2006-01-02 15:04:05",
+}
+
+// parsePartitionBound parses the bound expression of a partition of a table
+// range-partitioned on a timestamp column. It reports isDefault for the
+// DEFAULT partition; otherwise from or to is zero for MINVALUE or MAXVALUE.
+// Bounds without a time zone are taken as UTC.
+func parsePartitionBound(expr string) (from, to time.Time, isDefault bool, err error) {
+	if expr == "DEFAULT" {
+		return time.Time{}, time.Time{}, true, nil
+	}
+	m := rangeBoundPattern.FindStringSubmatch(expr)
+	if m == nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("not a range bound: %s", expr)
+	}
+	if from, err = parseBoundValue(m[1]); err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	if to, err = parseBoundValue(m[2]); err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	return from, to, false, nil
+}
+
+// parseBoundValue parses one side of a range bound: a quoted timestamp, or
+// MINVALUE or MAXVALUE, which yield the zero time.
+func parseBoundValue(s string) (time.Time, error) {
+	if s == "MINVALUE" || s == "MAXVALUE" {
+		return time.Time{}, nil
+	}
+	if len(s) < 2 || s[0] != '\'' || s[len(s)-1] != '\'' {
+		return time.Time{}, fmt.Errorf("bound %s is not a timestamp", s)
+	}
+	value := strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	for _, layout := range boundLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bound %s is not a timestamp", s)
+}
+
+// catalogPartition is a partition of the table as listed in the catalog,
+// with the bound expression pg_get_expr renders for it.
+type catalogPartition struct {
+	name  string
+	ident string // Schema-qualified name quoted for use in SQL
+	bound string
+}
+
+// catalogPartitions lists the partitions of the table, by name.
+func (c *Cleaner) catalogPartitions(ctx context.Context) ([]catalogPartition, error) {
+	query := `
+		SELECT child.relname, format('%I.%I', cn.nspname, child.relname),
+		       pg_get_expr(child.relpartbound, child.oid)
+		FROM pg_inherits i
+		JOIN pg_class parent ON parent.oid = i.inhparent
+		JOIN pg_namespace pn ON pn.oid = parent.relnamespace
+		JOIN pg_class child ON child.oid = i.inhrelid
+		JOIN pg_namespace cn ON cn.oid = child.relnamespace
+		WHERE parent.relname = $1 AND pn.nspname = current_schema()
+		ORDER BY child.relname
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, c.opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partitions []catalogPartition
+	for rows.Next() {
+		var p catalogPartition
+		if err := rows.Scan(&p.name, &p.ident, &p.bound); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, rows.Err()
+}
+
+// listPartitions returns the partitions of the table with their time
+// ranges, as parsed from their bounds; partition names play no part. The
+// default partition is left out, and so is any partition whose bound cannot
+// be parsed, which Prepare has already reported, so that it is never
+// dropped by mistake.
+func (c *Cleaner) listPartitions(ctx context.Context) ([]partition, error) {
+	listed, err := c.catalogPartitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var partitions []partition
+	for _, cp := range listed {
+		from, to, isDefault, err := parsePartitionBound(cp.bound)
+		if err != nil {
+			slog.Debug("partition range cannot be read, leaving it alone", "table", c.opts.Table,
+				"partition", cp.name, "error", err)
+			continue
+		}
+		if isDefault {
+			continue
+		}
+		partitions = append(partitions, partition{name: cp.name, ident: cp.ident, from: from, to: to})
+	}
+	return partitions, nil
+}
+
+// partitionProblems describes every partition of the table whose range
+// cannot be told: those whose bound does not parse as a timestamp range and
+// whose name does not follow PartitionTemplate either.
+func (c *Cleaner) partitionProblems(ctx context.Context) ([]string, error) {
+	listed, err := c.catalogPartitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, cp := range listed {
+		_, _, _, err := parsePartitionBound(cp.bound)
+		if err == nil || c.namedPartition(cp.name) {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("partition %s has an unreadable range (%v) and an unexpected name", cp.name, err))
+	}
+	return problems, nil
+}
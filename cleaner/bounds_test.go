@@ -0,0 +1,86 @@
+package cleaner
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// catalogQuery is the statement catalogPartitions lists partitions with.
+const catalogQuery = `
+	SELECT child.relname, format('%I.%I', cn.nspname, child.relname),
+	       pg_get_expr(child.relpartbound, child.oid)
+	FROM pg_inherits i
+	JOIN pg_class parent ON parent.oid = i.inhparent
+	JOIN pg_namespace pn ON pn.oid = parent.relnamespace
+	JOIN pg_class child ON child.oid = i.inhrelid
+	JOIN pg_namespace cn ON cn.oid = child.relnamespace
+	WHERE parent.relname = $1 AND pn.nspname = current_schema()
+	ORDER BY child.relname
+`
+
+// rangeBound renders the bound of a partition for [from, to) like
+// pg_get_expr does.
+func rangeBound(from, to time.Time) string {
+	const layout = "2006-01-02 15:04:05-07"
+	return "FOR VALUES FROM ('" + from.Format(layout) + "') TO ('" + to.Format(layout) + "')"
+}
+
+func TestParsePartitionBound(t *testing.T) {
+	tests := []struct {
+		expr        string
+		from, to    time.Time
+		wantDefault bool
+		wantErr     bool
+	}{
+		{"FOR VALUES FROM ('2024-01-15 12:00:00+00') TO ('2024-01-15 13:00:00+00')", utc(2024, 1, 15, 12, 0), utc(2024, 1, 15, 13, 0), false, false},
+		{"FOR VALUES FROM ('2024-01-15 13:00:00+01') TO ('2024-01-15 14:00:00+01')", utc(2024, 1, 15, 12, 0), utc(2024, 1, 15, 13, 0), false, false},
+		{"FOR VALUES FROM ('2024-01-15 17:30:00+05:30') TO ('2024-01-15 18:30:00+05:30')", utc(2024, 1, 15, 12, 0), utc(2024, 1, 15, 13, 0), false, false},
+		{"FOR VALUES FROM ('2024-01-15 12:00:00.5+00') TO ('2024-01-15 13:00:00+00')", utc(2024, 1, 15, 12, 0).Add(500 * time.Millisecond), utc(2024, 1, 15, 13, 0), false, false},
+		{"FOR VALUES FROM ('2024-01-15 12:00:00') TO ('2024-01-15 13:00:00')", utc(2024, 1, 15, 12, 0), utc(2024, 1, 15, 13, 0), false, false},
+		{"FOR VALUES FROM (MINVALUE) TO ('2024-01-15 13:00:00+00')", time.Time{}, utc(2024, 1, 15, 13, 0), false, false},
+		{"FOR VALUES FROM ('2024-01-15 12:00:00+00') TO (MAXVALUE)", utc(2024, 1, 15, 12, 0), time.Time{}, false, false},
+		{"DEFAULT", time.Time{}, time.Time{}, true, false},
+		{"FOR VALUES IN ('a', 'b')", time.Time{}, time.Time{}, false, true},
+		{"FOR VALUES FROM (1) TO (100)", time.Time{}, time.Time{}, false, true},
+		{"FOR VALUES FROM ('2024-01-15') TO ('2024-01-16')", time.Time{}, time.Time{}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			from, to, isDefault, err := parsePartitionBound(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePartitionBound() error = %v, want error %t", err, tt.wantErr)
+			}
+			if !from.Equal(tt.from) || !to.Equal(tt.to) || isDefault != tt.wantDefault {
+				t.Errorf("parsePartitionBound() = %s, %s, %t, want %s, %s, %t", from, to, isDefault, tt.from, tt.to, tt.wantDefault)
+			}
+		})
+	}
+}
+
+func TestListPartitions(t *testing.T) {
+	c, mock := newMockCleaner(t, Options{})
+	mock.ExpectQuery(catalogQuery).WithArgs("audit_logs").WillReturnRows(
+		sqlmock.NewRows([]string{"relname", "ident", "bound"}).
+			AddRow("audit_logs_20240115_1200", "public.audit_logs_20240115_1200", rangeBound(utc(2024, 1, 15, 12, 0), utc(2024, 1, 15, 13, 0))).
+			AddRow("audit_logs_archive", "public.audit_logs_archive", "FOR VALUES FROM (MINVALUE) TO ('2024-01-15 12:00:00+00')").
+			AddRow("audit_logs_default", "public.audit_logs_default", "DEFAULT").
+			AddRow("audit_logs_odd", "public.audit_logs_odd", "FOR VALUES IN (1)"),
+	)
+
+	partitions, err := c.listPartitions(t.Context())
+	if err != nil {
+		t.Fatalf("listPartitions() = %v", err)
+	}
+	want := []partition{
+		{name: "audit_logs_20240115_1200", ident: "public.audit_logs_20240115_1200", from: utc(2024, 1, 15, 12, 0), to: utc(2024, 1, 15, 13, 0)},
+		{name: "audit_logs_archive", ident: "public.audit_logs_archive", to: utc(2024, 1, 15, 12, 0)},
+	}
+	if !slices.EqualFunc(partitions, want, func(a, b partition) bool {
+		return a.name == b.name && a.ident == b.ident && a.from.Equal(b.from) && a.to.Equal(b.to)
+	}) {
+		t.Errorf("listPartitions() = %v, want %v", partitions, want)
+	}
+}
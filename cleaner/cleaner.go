@@ -0,0 +1,1394 @@
+// Package cleaner removes expired rows from PostgreSQL tables, either by
+// deleting them in batches or by dropping whole partitions, optionally
+// archiving them to CSV first. It can also fill a table with synthetic audit
+// logs for testing.
+package cleaner
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Cleaner manages a single table: it removes the rows that have outlived
+// the table's maximum age and can write synthetic audit logs to it.
+type Cleaner struct {
+	db        *sql.DB
+	opts      Options
+	ident     string // Table quoted for use in SQL
+	timeIdent string // Time column quoted for use in SQL
+
+	// columns are the extra columns the generator writes: those of
+	// opts.Columns the table actually has
+	columns []Column
+	gen     *generator
+
+	// strategy is the cleanup strategy in use, resolved from the configured
+	// one by Prepare
+	strategy string
+
+	// defaultPartition is the table's DEFAULT partition with the partition
+	// strategy, nil if it has none
+	defaultPartition *partition
+
+	// detach is how partitions are detached before they are dropped with
+	// DropDetach, resolved by Prepare; empty drops them directly
+	detach string
+
+	// partitioned records whether the table is natively partitioned, on
+	// any key; indexes are opts.Indexes, parsed by Prepare
+	partitioned bool
+	indexes     []index
+
+	// inspectionFailed records whether any dry-run query has failed
+	inspectionFailed atomic.Bool
+
+	// Unix nanoseconds of the last successful insert and cleanup, 0 if none
+	lastInsert  atomic.Int64
+	lastCleanup atomic.Int64
+
+	// skippedCycles counts the cleanup cycles skipped in a row by runs
+	// overrunning the cleanup interval, see SkippedCleanups
+	skippedCycles atomic.Int64
+
+	// paused skips every cleanup pass until resumed, see Pause
+	paused atomic.Bool
+
+	// tableMissing records that the last cleanup pass found no table, so
+	// that skipping the following ones is not logged again
+	tableMissing atomic.Bool
+
+	// insertStmts holds the prepared INSERT statements by row count
+	insertMu    sync.Mutex
+	insertStmts map[int]*sql.Stmt
+
+	// failing holds the persistent failure of each routine that has one,
+	// see Failing; escalated is closed on the first
+	failMu       sync.Mutex
+	failing      map[string]error
+	escalated    chan struct{}
+	escalateOnce sync.Once
+
+	// Totals since New, for Summary
+	generated         atomic.Int64 // Audit logs written by the generator, for MaxInserts
+	inserted          atomic.Int64
+	insertBatches     atomic.Int64
+	insertLatency     atomic.Int64 // Nanoseconds, summed over insertBatches
+	partitionsCreated atomic.Int64
+	partitionsDropped atomic.Int64
+}
+
+// New returns a Cleaner for the table described by opts. Prepare must be
+// called before the Cleaner is used.
+func New(db *sql.DB, opts Options) *Cleaner {
+	opts = opts.withDefaults()
+	c := &Cleaner{
+		db:        db,
+		opts:      opts,
+		ident:     pq.QuoteIdentifier(opts.Table),
+		timeIdent: pq.QuoteIdentifier(opts.TimeColumn),
+		columns:   opts.Columns,
+		strategy:  StrategyDelete,
+		failing:   make(map[string]error),
+		escalated: make(chan struct{}),
+	}
+	if opts.Generate {
+		seed := opts.Seed
+		if seed == 0 {
+			seed = rand.Uint64()
+		}
+		slog.Info("generator seeded", "table", opts.Table, "seed", seed)
+		c.gen = newGenerator(opts.Traffic, seed)
+	}
+	return c
+}
+
+// Table returns the name of the managed table.
+func (c *Cleaner) Table() string {
+	return c.opts.Table
+}
+
+// Strategy returns the cleanup strategy resolved by Prepare.
+func (c *Cleaner) Strategy() string {
+	return c.strategy
+}
+
+// row is a row of any table, as column names and scanned values.
+type row struct {
+	columns []string
+	values  []any
+}
+
+// attrs returns the row as a column to value map for logging.
+func (r row) attrs() map[string]string {
+	m := make(map[string]string, len(r.columns))
+	for i, col := range r.columns {
+		m[col] = formatValue(r.values[i])
+	}
+	return m
+}
+
+// LastInsert returns when an insert last succeeded, or nil if none has.
+func (c *Cleaner) LastInsert() *time.Time {
+	return unixNanoTime(c.lastInsert.Load())
+}
+
+// LastCleanup returns when a cleanup last succeeded, or nil if none has.
+func (c *Cleaner) LastCleanup() *time.Time {
+	return unixNanoTime(c.lastCleanup.Load())
+}
+
+// SkippedCleanups returns how many cleanup cycles in a row were skipped
+// because runs took longer than the cleanup interval; 0 once a run finishes
+// in time.
+func (c *Cleaner) SkippedCleanups() int64 {
+	return c.skippedCycles.Load()
+}
+
+// Pause stops the Cleaner from starting cleanup passes, scheduled or not,
+// until Resume, e.g. to preserve the data during an investigation; a pass
+// already running completes. Inserts and ingestion carry on. It reports
+// whether the Cleaner was running before.
+func (c *Cleaner) Pause() bool {
+	changed := c.paused.CompareAndSwap(false, true)
+	if changed {
+		cleanupPaused.WithLabelValues(c.opts.Table).Set(1)
+		slog.Warn("cleanup paused", "table", c.opts.Table)
+	}
+	return changed
+}
+
+// Resume lets a paused Cleaner clean up again from its next pass on. It
+// reports whether the Cleaner was paused before.
+func (c *Cleaner) Resume() bool {
+	changed := c.paused.CompareAndSwap(true, false)
+	if changed {
+		cleanupPaused.WithLabelValues(c.opts.Table).Set(0)
+		slog.Info("cleanup resumed", "table", c.opts.Table)
+	}
+	return changed
+}
+
+// Paused reports whether cleanup is paused, see Pause.
+func (c *Cleaner) Paused() bool {
+	return c.paused.Load()
+}
+
+func unixNanoTime(ns int64) *time.Time {
+	if ns == 0 {
+		return nil
+	}
+	t := time.Unix(0, ns)
+	return &t
+}
+
+// Summary totals what a Cleaner has done since it was created.
+type Summary struct {
+	Inserted          int64         // Audit logs committed, generated or ingested
+	Batches           int64         // Successful insert batches
+	BatchLatency      time.Duration // Average duration of a successful insert batch
+	PartitionsCreated int64
+	PartitionsDropped int64
+}
+
+// Summary returns the totals of the Cleaner so far.
+func (c *Cleaner) Summary() Summary {
+	s := Summary{
+		Inserted:          c.inserted.Load(),
+		Batches:           c.insertBatches.Load(),
+		PartitionsCreated: c.partitionsCreated.Load(),
+		PartitionsDropped: c.partitionsDropped.Load(),
+	}
+	if s.Batches > 0 {
+		s.BatchLatency = time.Duration(c.insertLatency.Load() / s.Batches)
+	}
+	return s
+}
+
+// pingTimeout bounds the database ping done after a connection failure.
+const pingTimeout = 2 * time.Second
+
+// withRetry runs fn, retrying transient database errors as configured. Each
+// attempt gets its own QueryTimeout deadline; op names the operation when it
+// times out.
+func (c *Cleaner) withRetry(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	return withRetry(ctx, c.opts.MaxRetries+1, c.opts.RetryBaseDelay, func() error {
+		return c.withTimeout(ctx, op, fn)
+	})
+}
+
+// withTimeout runs fn with a context bounded by QueryTimeout, if set, and
+// logs the operation if the deadline cuts it short.
+func (c *Cleaner) withTimeout(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	if c.opts.QueryTimeout <= 0 {
+		return fn(ctx)
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, c.opts.QueryTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := fn(opCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(opCtx.Err(), context.DeadlineExceeded) {
+		slog.Warn("database operation timed out", "table", c.opts.Table, "operation", op,
+			"elapsed", time.Since(start), "timeout", c.opts.QueryTimeout)
+	}
+	return err
+}
+
+// checkConnection pings the database after err if it was a connection-level
+// failure that outlasted the retries. The pool replaces broken connections
+// on demand, so a successful ping means the next attempt can go ahead; a
+// failed one is logged as an outage rather than another query error.
+func (c *Cleaner) checkConnection(ctx context.Context, err error) {
+	if !isTransient(err) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	if err := c.db.PingContext(ctx); err != nil {
+		slog.Error("database connection lost", "table", c.opts.Table, "error", err)
+		return
+	}
+	slog.Info("database connection re-established", "table", c.opts.Table)
+}
+
+// resetTable drops the audit table and all of its data.
+func (c *Cleaner) resetTable(ctx context.Context) error {
+	query := fmt.Sprintf(`DROP TABLE IF EXISTS %s CASCADE`, c.ident)
+	if c.opts.DryRun {
+		slog.Info("[DRY RUN] would drop table", "table", c.opts.Table, "query", query)
+		return nil
+	}
+
+	_, err := c.db.ExecContext(ctx, query)
+	return err
+}
+
+// createTable creates the audit table, with any extra generator columns,
+// and an index on its time column.
+func (c *Cleaner) createTable(ctx context.Context) error {
+	var extra strings.Builder
+	for _, col := range c.opts.Columns {
+		fmt.Fprintf(&extra, ",\n\t\t\t%s %s", pq.QuoteIdentifier(col.Name), col.Type)
+	}
+
+	// A hypertable's unique constraints must include its time column
+	id, key := "id SERIAL PRIMARY KEY", ""
+	if c.opts.Storage == StorageTimescale {
+		id, key = "id SERIAL", fmt.Sprintf(",\n\t\t\tPRIMARY KEY (id, %s)", c.timeIdent)
+	}
+
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %[1]s (
+			%[5]s,
+			message TEXT NOT NULL,
+			%[2]s TIMESTAMPTZ NOT NULL DEFAULT NOW()%[4]s%[6]s
+		);
+		CREATE INDEX IF NOT EXISTS %[3]s ON %[1]s(%[2]s);
+	`, c.ident, c.timeIdent, pq.QuoteIdentifier("idx_"+c.opts.Table+"_"+c.opts.TimeColumn), extra.String(), id, key)
+	if c.opts.DryRun {
+		slog.Info("[DRY RUN] would create table", "table", c.opts.Table)
+	} else if _, err := c.db.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	if c.opts.Storage == StorageTimescale {
+		return c.createHypertable(ctx)
+	}
+	return nil
+}
+
+// tableExists reports whether the table exists in the current schema,
+// based on the system catalog.
+func (c *Cleaner) tableExists(ctx context.Context) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM pg_class c
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relname = $1
+			  AND n.nspname = current_schema()
+			  AND c.relkind IN ('r', 'p')
+		)
+	`
+
+	var exists bool
+	err := c.db.QueryRowContext(ctx, query, c.opts.Table).Scan(&exists)
+	return exists, err
+}
+
+// columnSpec is a column the cleaner relies on, with the information_schema
+// data types it accepts.
+type columnSpec struct {
+	name      string
+	dataTypes []string
+}
+
+// requiredColumns lists the columns the cleaner relies on. Cleanup only
+// needs the time column; the generator also writes id and message. Extra
+// generator columns are optional.
+func (c *Cleaner) requiredColumns(generator bool) []columnSpec {
+	timeColumn := columnSpec{c.opts.TimeColumn, []string{"timestamp without time zone", "timestamp with time zone"}}
+	if !generator {
+		return []columnSpec{timeColumn}
+	}
+	return []columnSpec{
+		{"id", []string{"integer"}},
+		{"message", []string{"text"}},
+		timeColumn,
+	}
+}
+
+// columnTypes returns the information_schema data type of every column of
+// the table, by name.
+func (c *Cleaner) columnTypes(ctx context.Context) (map[string]string, error) {
+	query := `
+		SELECT column_name, data_type
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, c.opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]string)
+	for rows.Next() {
+		var name, dataType string
+		if err := rows.Scan(&name, &dataType); err != nil {
+			return nil, err
+		}
+		found[name] = dataType
+	}
+	return found, rows.Err()
+}
+
+// unfilledColumns lists the columns of the table that every insert must
+// fill, being NOT NULL without a default, but that inserts leave out: they
+// would make every insert fail.
+func (c *Cleaner) unfilledColumns(ctx context.Context) ([]string, error) {
+	written := []string{"message", c.opts.TimeColumn}
+	for _, col := range c.columns {
+		written = append(written, col.Name)
+	}
+
+	query := `
+		SELECT column_name
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1
+		  AND is_nullable = 'NO' AND column_default IS NULL
+		  AND is_identity = 'NO' AND is_generated = 'NEVER'
+		  AND column_name <> ALL($2)
+		ORDER BY ordinal_position
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, c.opts.Table, pq.Array(written))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var unfilled []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		unfilled = append(unfilled, name)
+	}
+	return unfilled, rows.Err()
+}
+
+// schemaMismatches compares the columns found in an existing table against
+// requiredColumns and describes every difference.
+func (c *Cleaner) schemaMismatches(found map[string]string, generator bool) []string {
+	var problems []string
+	for _, col := range c.requiredColumns(generator) {
+		dataType, ok := found[col.name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("missing column %s", col.name))
+		case !slices.Contains(col.dataTypes, dataType):
+			problems = append(problems, fmt.Sprintf("column %s is %s, expected %s",
+				col.name, dataType, strings.Join(col.dataTypes, " or ")))
+		}
+	}
+	return problems
+}
+
+// checkFailed turns the problems found with the table into an error listing
+// all of them, or with Force only logs them.
+func (c *Cleaner) checkFailed(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	if c.opts.Force {
+		for _, p := range problems {
+			slog.Warn("table failed a startup check, continuing as forced", "table", c.opts.Table, "problem", p)
+		}
+		return nil
+	}
+	return fmt.Errorf("table %s failed the startup checks (use --force to start anyway): %s",
+		c.opts.Table, strings.Join(problems, "; "))
+}
+
+// Prepare makes sure the table exists and is usable, and settles the cleanup
+// strategy. Only a generating or ingesting Cleaner ever resets, creates or
+// migrates its table; any other table belongs to another application, so it
+// must already exist. An existing table that does not look as expected is
+// an error unless Force is set, and is checked before anything is modified.
+func (c *Cleaner) Prepare(ctx context.Context) error {
+	if err := c.opts.validate(); err != nil {
+		return err
+	}
+	if c.opts.Storage == StorageTimescale {
+		if err := c.checkTimescale(ctx); err != nil {
+			return err
+		}
+	}
+	if err := c.prepareTable(ctx); err != nil {
+		return err
+	}
+	if err := c.resolveStrategy(ctx); err != nil {
+		return err
+	}
+	if c.strategy == StrategyPartition && c.opts.Storage != StorageTimescale {
+		problems, err := c.partitionProblems(ctx)
+		if err != nil {
+			return fmt.Errorf("checking partitions: %w", err)
+		}
+		if err := c.checkFailed(problems); err != nil {
+			return err
+		}
+	}
+	if c.opts.History && !c.opts.DryRun {
+		if err := c.createHistoryTable(ctx); err != nil {
+			return fmt.Errorf("creating history table: %w", err)
+		}
+	}
+	if c.strategy != StrategyPartition && c.opts.Retention == RetentionCount {
+		return fmt.Errorf("%s retention needs table %s to be cleaned up by dropping partitions, but its strategy is %s",
+			RetentionCount, c.opts.Table, c.strategy)
+	}
+	if len(c.opts.Indexes) > 0 {
+		if err := c.prepareIndexes(ctx); err != nil {
+			return fmt.Errorf("preparing indexes: %w", err)
+		}
+	}
+	if err := c.checkRetention(ctx); err != nil {
+		return fmt.Errorf("checking retention: %w", err)
+	}
+	if c.opts.PartitionPremake > 0 && !c.premakes() {
+		slog.Warn("partitions are only created ahead of time for partitioned tables, ignoring it",
+			"table", c.opts.Table, "strategy", c.strategy)
+	}
+	if c.strategy != StrategyPartition && (c.opts.MaxTotalSize > 0 || c.opts.MaxPartitions > 0) {
+		slog.Warn("size and partition count limits only apply to partitioned tables, ignoring them",
+			"table", c.opts.Table, "strategy", c.strategy)
+	}
+	if err := c.prepareDefaultPartition(ctx); err != nil {
+		return err
+	}
+	return c.resolveDetach(ctx)
+}
+
+func (c *Cleaner) prepareTable(ctx context.Context) error {
+	if !c.opts.Generate && !c.opts.Ingest {
+		exists, err := c.tableExists(ctx)
+		if err != nil {
+			return fmt.Errorf("checking for existing table: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("table %s does not exist and is only created for the insert generator or ingestion", c.opts.Table)
+		}
+
+		found, err := c.columnTypes(ctx)
+		if err != nil {
+			return fmt.Errorf("verifying table schema: %w", err)
+		}
+		return c.checkFailed(c.schemaMismatches(found, false))
+	}
+
+	// Drop existing data only when explicitly requested
+	if c.opts.Reset {
+		slog.Warn("reset requested, dropping table", "table", c.opts.Table)
+		if err := c.resetTable(ctx); err != nil {
+			return fmt.Errorf("dropping table: %w", err)
+		}
+	}
+
+	exists, err := c.tableExists(ctx)
+	if err != nil {
+		return fmt.Errorf("checking for existing table: %w", err)
+	}
+
+	// Create table if it doesn't exist
+	if !exists {
+		return c.createTable(ctx)
+	}
+	slog.Info("table already exists, keeping existing data", "table", c.opts.Table)
+
+	found, err := c.columnTypes(ctx)
+	if err != nil {
+		return fmt.Errorf("verifying table schema: %w", err)
+	}
+
+	// Extra columns the table lacks are left out of the inserts, so tables
+	// created by older versions keep working
+	c.columns = nil
+	for _, col := range c.opts.Columns {
+		if found[col.Name] != col.Type {
+			slog.Warn("generator column not in table, leaving it out", "table", c.opts.Table,
+				"column", col.Name, "type", col.Type)
+			continue
+		}
+		c.columns = append(c.columns, col)
+	}
+
+	// Inserts and cleanup would fail on an incompatible table, so refuse to
+	// start before migrating anything
+	unfilled, err := c.unfilledColumns(ctx)
+	if err != nil {
+		return fmt.Errorf("verifying table schema: %w", err)
+	}
+	problems := c.schemaMismatches(found, true)
+	for _, name := range unfilled {
+		problems = append(problems, fmt.Sprintf("column %s is NOT NULL without a default but is not written", name))
+	}
+	if err := c.checkFailed(problems); err != nil {
+		return err
+	}
+
+	if err := c.checkTimeColumnType(ctx, c.opts.MigrateTimestamptz); err != nil {
+		return fmt.Errorf("migrating time column: %w", err)
+	}
+	return nil
+}
+
+// maxParams is the most bind parameters Postgres accepts in one statement.
+const maxParams = 65535
+
+// maxCachedInserts caps the INSERT statements kept prepared, one per row
+// count; chunks of other sizes are inserted without preparing.
+const maxCachedInserts = 8
+
+// insertedLog is an audit log as written to the table.
+type insertedLog struct {
+	id        int
+	message   string
+	createdAt time.Time
+}
+
+// logRow is an audit log to write: its message, its time and the values of
+// the extra columns in c.columns, in that order.
+type logRow struct {
+	message   string
+	createdAt time.Time
+	values    []any
+}
+
+// postToDB writes the audit logs, in multi-row INSERTs of at most
+// InsertChunkSize rows each. The chunks are committed one by one, in order,
+// or all together with ChunkTransaction; with InsertCopy, chunks committed
+// one by one are written with COPY instead. It returns how many logs were
+// committed.
+func (c *Cleaner) postToDB(ctx context.Context, rows []logRow) (inserted int, err error) {
+	chunks := slices.Collect(slices.Chunk(rows, c.opts.InsertChunkSize))
+
+	ctx, span := tracer.Start(ctx, "cleaner.insert", trace.WithAttributes(
+		attribute.String("cleaner.table", c.opts.Table),
+		attribute.Int("cleaner.rows", len(rows)),
+		attribute.Int("cleaner.chunks", len(chunks)),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("cleaner.inserted", inserted))
+		endSpan(span, err)
+	}()
+
+	start := time.Now()
+	defer func() {
+		if err == nil {
+			c.insertBatches.Add(1)
+			c.insertLatency.Add(int64(time.Since(start)))
+		}
+	}()
+
+	oneByOne := !c.opts.ChunkTransaction || len(chunks) == 1
+	copyChunks := oneByOne && c.opts.InsertMode == InsertCopy
+	if !copyChunks {
+		c.prepareInserts(ctx, chunks)
+	}
+
+	if oneByOne {
+		for i, chunk := range chunks {
+			var logs []insertedLog
+			err := c.withRetry(ctx, "insert", func(ctx context.Context) error {
+				var err error
+				if copyChunks {
+					logs, err = c.copyChunk(ctx, chunk)
+				} else {
+					logs, err = c.insertChunk(ctx, nil, chunk)
+				}
+				return err
+			})
+			if err != nil {
+				return inserted, err
+			}
+			inserted += len(logs)
+			c.logsCommitted(logs)
+			c.chunkProgress(i, len(chunks), inserted, len(rows))
+		}
+		return inserted, nil
+	}
+
+	var committed []insertedLog
+	err = c.withRetry(ctx, "insert", func(ctx context.Context) error {
+		tx, err := c.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		committed = committed[:0]
+		for i, chunk := range chunks {
+			logs, err := c.insertChunk(ctx, tx, chunk)
+			if err != nil {
+				return err
+			}
+			committed = append(committed, logs...)
+			c.chunkProgress(i, len(chunks), len(committed), len(rows))
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return 0, err
+	}
+	c.logsCommitted(committed)
+	return len(committed), nil
+}
+
+// insertChunk writes the audit logs with a single INSERT, in tx unless it
+// is nil, using the prepared statement for their number if there is one.
+func (c *Cleaner) insertChunk(ctx context.Context, tx *sql.Tx, rows []logRow) ([]insertedLog, error) {
+	args := make([]any, 0, len(rows)*(2+len(c.columns)))
+	for _, r := range rows {
+		args = append(args, r.message, r.createdAt)
+		args = append(args, r.values...)
+	}
+
+	c.insertMu.Lock()
+	stmt := c.insertStmts[len(rows)]
+	c.insertMu.Unlock()
+
+	var result *sql.Rows
+	var err error
+	switch {
+	case stmt != nil && tx != nil:
+		result, err = tx.StmtContext(ctx, stmt).QueryContext(ctx, args...)
+	case stmt != nil:
+		result, err = stmt.QueryContext(ctx, args...)
+	case tx != nil:
+		result, err = tx.QueryContext(ctx, c.insertQuery(len(rows)), args...)
+	default:
+		result, err = c.db.QueryContext(ctx, c.insertQuery(len(rows)), args...)
+	}
+	if err != nil {
+		// The statement is prepared again next time, in case the error
+		// came from the statement itself, e.g. after the table changed
+		if stmt != nil {
+			c.insertMu.Lock()
+			if c.insertStmts[len(rows)] == stmt {
+				delete(c.insertStmts, len(rows))
+				stmt.Close()
+			}
+			c.insertMu.Unlock()
+		}
+		return nil, err
+	}
+	defer result.Close()
+
+	logs := make([]insertedLog, 0, len(rows))
+	for result.Next() {
+		var l insertedLog
+		if err := result.Scan(&l.id, &l.message, &l.createdAt); err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, result.Err()
+}
+
+// insertQuery returns the INSERT statement for n audit logs.
+func (c *Cleaner) insertQuery(n int) string {
+	names := []string{"message", c.timeIdent}
+	for _, col := range c.columns {
+		names = append(names, pq.QuoteIdentifier(col.Name))
+	}
+
+	values := make([]string, n)
+	for i := range values {
+		placeholders := make([]string, len(names))
+		for j := range placeholders {
+			placeholders[j] = fmt.Sprintf("$%d", i*len(names)+j+1)
+		}
+		values[i] = "(" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	return fmt.Sprintf(`
+        INSERT INTO %s (%s)
+        VALUES %s
+        RETURNING id, message, %s
+    `, c.ident, strings.Join(names, ", "), strings.Join(values, ", "), c.timeIdent)
+}
+
+// prepareInserts prepares the INSERT statements for the sizes of chunks
+// that are not prepared yet, while fewer than maxCachedInserts are. They are
+// prepared up front rather than inside a transaction, which would need a
+// second connection. A statement that fails to prepare is left out, so that
+// the insert reports the error itself. database/sql prepares a statement
+// again on every connection it runs on, including those that replace
+// broken ones.
+func (c *Cleaner) prepareInserts(ctx context.Context, chunks [][]logRow) {
+	c.insertMu.Lock()
+	defer c.insertMu.Unlock()
+
+	for _, chunk := range chunks {
+		n := len(chunk)
+		if _, ok := c.insertStmts[n]; ok || len(c.insertStmts) >= maxCachedInserts {
+			continue
+		}
+		stmt, err := c.db.PrepareContext(ctx, c.insertQuery(n))
+		if err != nil {
+			slog.Debug("could not prepare insert statement", "table", c.opts.Table, "rows", n, "error", err)
+			continue
+		}
+		if c.insertStmts == nil {
+			c.insertStmts = make(map[int]*sql.Stmt)
+		}
+		c.insertStmts[n] = stmt
+		insertPrepares.Inc()
+	}
+}
+
+// logsCommitted counts and logs audit logs once they are committed.
+func (c *Cleaner) logsCommitted(logs []insertedLog) {
+	if len(logs) == 0 {
+		return
+	}
+	logsInserted.Add(float64(len(logs)))
+	c.inserted.Add(int64(len(logs)))
+	c.lastInsert.Store(time.Now().UnixNano())
+	for _, l := range logs {
+		// Logs written with COPY have no id
+		if l.id == 0 {
+			slog.Info("audit log inserted", "message", l.message, "created_at", l.createdAt)
+			continue
+		}
+		slog.Info("audit log inserted", "id", l.id, "message", l.message, "created_at", l.createdAt)
+	}
+}
+
+// chunkProgress logs the progress of an insert split into several chunks.
+func (c *Cleaner) chunkProgress(chunk, chunks, inserted, total int) {
+	if chunks > 1 {
+		slog.Debug("insert chunk written", "table", c.opts.Table,
+			"chunk", chunk+1, "chunks", chunks, "inserted", inserted, "total", total)
+	}
+}
+
+// CleanupResult describes what a cleanup run removed.
+type CleanupResult struct {
+	Rows       int      // Rows deleted, including those of dropped partitions
+	Partitions []string // Names of the dropped partitions
+	Bytes      int64    // Size of the dropped partitions; deleted rows only free space once vacuumed
+	Archive    string   // Path of the archive written, if any
+	Skipped    bool     // Another pass held the table's cleanup lock, so nothing was done
+	Paused     bool     // Cleanup is paused, so nothing was done
+	Missing    bool     // The table does not exist, so nothing was done
+}
+
+// deleteOldRecords deletes every row older than the table's maximum age and
+// returns what was removed. In dry-run mode nothing is deleted and the
+// result is always empty.
+func (c *Cleaner) deleteOldRecords(ctx context.Context) (result CleanupResult, err error) {
+	cutoffTime := c.cutoff()
+
+	ctx, span := tracer.Start(ctx, "cleaner.cleanup", trace.WithAttributes(
+		attribute.String("cleaner.table", c.opts.Table),
+		attribute.String("cleaner.strategy", c.strategy),
+		attribute.Bool("cleaner.dry_run", c.opts.DryRun),
+	))
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("cleaner.rows", result.Rows),
+			attribute.Int("cleaner.partitions", len(result.Partitions)),
+			attribute.Int64("cleaner.bytes", result.Bytes),
+		)
+		endSpan(span, err)
+	}()
+
+	if c.opts.DryRun {
+		var err error
+		if c.strategy == StrategyPartition {
+			err = c.reportExpiredPartitions(ctx, cutoffTime)
+		} else {
+			err = c.reportOldRecords(ctx, cutoffTime)
+		}
+		if err != nil {
+			c.inspectionFailed.Store(true)
+		}
+		return CleanupResult{}, err
+	}
+
+	// Expired rows are archived before their deletion is committed
+	var archive *archiveWriter
+	if c.opts.ArchiveDir != "" {
+		archive = newArchiveWriter(c.opts.ArchiveDir, c.opts.Table, cutoffTime, c.opts.ArchiveGzip)
+		defer func() {
+			if err := archive.close(); err != nil {
+				slog.Error("failed to close archive", "path", archive.path, "error", err)
+				c.notify(ctx, Event{Type: EventArchiveFailed, Error: err.Error()})
+			} else if archive.rows > 0 {
+				slog.Info("records archived", "path", archive.path, "count", archive.rows)
+			}
+			if archive.rows > 0 {
+				result.Archive = archive.path
+			}
+		}()
+	}
+
+	if c.strategy == StrategyPartition {
+		result, err = c.dropExpiredPartitions(ctx, cutoffTime, archive)
+		if err != nil {
+			return result, err
+		}
+		if c.opts.MaxTotalSize > 0 || c.opts.MaxPartitions > 0 {
+			limited, err := c.dropOverLimit(ctx, archive)
+			result.Rows += limited.Rows
+			result.Partitions = append(result.Partitions, limited.Partitions...)
+			result.Bytes += limited.Bytes
+			if err != nil {
+				return result, err
+			}
+		}
+		if (c.opts.Vacuum || c.opts.MaintenanceInterval > 0) && len(result.Partitions) > 0 {
+			if err := c.analyze(ctx); err != nil {
+				return result, err
+			}
+		}
+		if c.defaultPartition == nil {
+			return result, nil
+		}
+		deleted, err := c.cleanDefaultPartition(ctx, cutoffTime, archive)
+		result.Rows += deleted
+		return result, err
+	}
+	result.Rows, err = c.deleteExpiredRows(ctx, c.ident, cutoffTime, archive)
+	return result, err
+}
+
+// deleteExpiredRows deletes the rows of the table or partition ident that
+// are older than cutoff in batches, pausing between batches to limit the
+// load on the database, and optionally vacuums it afterwards.
+func (c *Cleaner) deleteExpiredRows(ctx context.Context, ident string, cutoff time.Time, archive *archiveWriter) (int, error) {
+	totalDeleted := 0
+	for {
+		var deleted []row
+		err := c.withRetry(ctx, "delete batch", func(ctx context.Context) error {
+			var err error
+			deleted, err = c.deleteBatch(ctx, ident, cutoff, c.opts.BatchSize, archive)
+			return err
+		})
+		if err != nil {
+			return totalDeleted, err
+		}
+
+		if len(deleted) == 0 {
+			break // No more records to delete
+		}
+
+		for _, r := range deleted {
+			slog.Debug("row deleted", "table", c.opts.Table, "row", r.attrs())
+		}
+
+		totalDeleted += len(deleted)
+		recordsDeleted.WithLabelValues(c.opts.Table).Add(float64(len(deleted)))
+		slog.Info("batch deleted", "table", c.opts.Table, "count", len(deleted))
+
+		// Small pause between batches to avoid overwhelming the database
+		select {
+		case <-ctx.Done():
+			return totalDeleted, ctx.Err()
+		case <-time.After(c.opts.BatchPause):
+		}
+	}
+
+	if c.opts.Vacuum && totalDeleted > 0 {
+		start := time.Now()
+		if _, err := c.db.ExecContext(ctx, fmt.Sprintf(`VACUUM (ANALYZE) %s`, ident)); err != nil {
+			return totalDeleted, fmt.Errorf("vacuuming table: %w", err)
+		}
+		slog.Info("table vacuumed", "table", c.opts.Table, "duration", time.Since(start))
+	}
+
+	return totalDeleted, nil
+}
+
+// analyze refreshes the statistics of a partitioned table after partitions
+// were dropped. A plain VACUUM of the parent would vacuum every partition,
+// which dropping partitions makes unnecessary. Like any VACUUM or ANALYZE it
+// runs outside a transaction.
+func (c *Cleaner) analyze(ctx context.Context) error {
+	start := time.Now()
+	if _, err := c.db.ExecContext(ctx, fmt.Sprintf(`ANALYZE %s`, c.ident)); err != nil {
+		return fmt.Errorf("analyzing table: %w", err)
+	}
+	slog.Info("table analyzed", "table", c.opts.Table, "duration", time.Since(start))
+	return nil
+}
+
+// cutoff returns the time before which rows are expired.
+func (c *Cleaner) cutoff() time.Time {
+	return c.opts.Clock.Now().UTC().Add(-c.opts.MaxAge)
+}
+
+// reportOldRecords logs how many records, and roughly how many bytes, a real
+// cleanup run would delete, without modifying anything.
+func (c *Cleaner) reportOldRecords(ctx context.Context, cutoff time.Time) error {
+	query := fmt.Sprintf(`
+		SELECT count(*), COALESCE(sum(pg_column_size(t.*)), 0), pg_total_relation_size($2::regclass)
+		FROM %s t
+		WHERE %s < $1
+	`, c.ident, c.timeIdent)
+
+	var count, bytes, tableBytes int64
+	err := c.withRetry(ctx, "inspect old records", func(ctx context.Context) error {
+		return c.db.QueryRowContext(ctx, query, cutoff, c.ident).Scan(&count, &bytes, &tableBytes)
+	})
+	if err != nil {
+		return fmt.Errorf("inspecting old records: %w", err)
+	}
+
+	slog.Info("[DRY RUN] would delete records, nothing was modified",
+		"table", c.opts.Table, "count", count, "bytes", bytes, "table_bytes", tableBytes,
+		"max_age", c.opts.MaxAge, "cutoff", cutoff)
+	return nil
+}
+
+// DryRunFailed reports whether any dry-run inspection query has failed.
+func (c *Cleaner) DryRunFailed() bool {
+	return c.inspectionFailed.Load()
+}
+
+// deleteBatch deletes up to batchSize rows older than cutoff from the table
+// or partition ident in a single transaction. If archive is non-nil the
+// rows are written and synced to it before the transaction commits, so a
+// failed archive write leaves them in place for the next cleanup cycle, and
+// are rolled back from it if the transaction fails before committing. Rows
+// are addressed by ctid so that any table can be cleaned up, whatever its
+// primary key; the cutoff is checked again because ctids are only unique
+// within a single partition.
+func (c *Cleaner) deleteBatch(ctx context.Context, ident string, cutoff time.Time, batchSize int, archive *archiveWriter) ([]row, error) {
+	query := fmt.Sprintf(`
+		DELETE FROM %[1]s
+		WHERE ctid = ANY(ARRAY(
+			SELECT ctid FROM %[1]s
+			WHERE %[2]s < $1
+			ORDER BY %[2]s ASC
+			LIMIT $2
+		)) AND %[2]s < $1
+		RETURNING *
+	`, ident, c.timeIdent)
+
+	if err := archive.mark(); err != nil {
+		return nil, err
+	}
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, cutoff, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := archiveRows(rows, archive)
+	if err != nil {
+		return nil, archive.rollback(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// archiveRows reads all of rows, writing them to archive if it is non-nil,
+// and syncs the archive once they have been read. rows is always closed.
+func archiveRows(rows *sql.Rows, archive *archiveWriter) ([]row, error) {
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []row
+	for rows.Next() {
+		r := row{columns: columns, values: make([]any, len(columns))}
+		dest := make([]any, len(columns))
+		for i := range r.values {
+			dest[i] = &r.values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+
+		if archive != nil {
+			if err := archive.write(r); err != nil {
+				return nil, fmt.Errorf("archiving row: %w", err)
+			}
+		}
+		result = append(result, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if archive != nil {
+		if err := archive.sync(); err != nil {
+			return nil, fmt.Errorf("syncing archive %s: %w", archive.path, err)
+		}
+	}
+	return result, nil
+}
+
+// Run combines RunInserter, for a generating Cleaner outside a dry run, and
+// RunCleanup, when CleanupInterval is set. It returns ctx's error once ctx is
+// cancelled and both loops have stopped.
+func (c *Cleaner) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	// A dry run is strictly read-only, so the generator stays off
+	switch {
+	case !c.opts.Generate || c.opts.InsertInterval <= 0:
+	case c.opts.DryRun:
+		slog.Info("[DRY RUN] insert generator disabled, no data will be modified", "table", c.opts.Table)
+	default:
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.RunInserter(ctx)
+		}()
+	}
+
+	if c.opts.CleanupInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.RunCleanup(ctx)
+		}()
+	}
+
+	wg.Wait()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// RunInserter writes synthetic audit logs every InsertInterval until ctx is
+// cancelled, then returns ctx's error: one per tick, or as many as add up to
+// InsertRate, shaped by InsertJitter and RampUp. With MaxInserts, it returns
+// nil instead once it has written that many. Failed inserts are logged and
+// counted, not returned, and the next tick backs off, longer after every
+// failure in a row. Only a generating Cleaner outside a dry run can insert.
+func (c *Cleaner) RunInserter(ctx context.Context) error {
+	switch {
+	case !c.opts.Generate:
+		return errors.New("insert generator is not enabled for table " + c.opts.Table)
+	case c.opts.DryRun:
+		return errors.New("insert generator cannot run in a dry run")
+	case c.opts.InsertInterval <= 0:
+		return fmt.Errorf("insert interval must be greater than 0, got %s", c.opts.InsertInterval)
+	case c.insertLimitReached():
+		return nil
+	}
+
+	counter := 1
+	failures := failures{routine: "insert", slowAfter: c.opts.InsertBackoffThreshold, maxWait: c.opts.InsertMaxBackoff}
+	start := c.opts.Clock.Now()
+	wait := c.insertWait()
+	ticker := c.opts.Clock.NewTicker(wait)
+	defer ticker.Stop()
+
+	// due carries the fraction of an insert left over from earlier ticks
+	var due float64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+		}
+
+		due += c.insertsDue(wait, c.opts.Clock.Now().Sub(start))
+		wait = c.insertWait()
+		ticker.Reset(wait)
+		if due < 1 {
+			continue
+		}
+
+		// Failed inserts are not made up for in a burst later
+		n := int(due)
+		if c.opts.MaxInserts > 0 {
+			n = min(n, int(c.opts.MaxInserts-c.generated.Load()))
+		}
+		now := c.opts.Clock.Now().UTC()
+		rows := make([]logRow, n)
+		for i := range rows {
+			rows[i] = c.generatedRow(fmt.Sprintf("Audit log #%d", counter+i), now)
+		}
+		due -= float64(len(rows))
+
+		inserted, err := c.postToDB(ctx, rows)
+		counter += inserted
+		c.generated.Add(int64(inserted))
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			insertFailures.Inc()
+			slog.Error("failed to insert audit logs", "table", c.opts.Table,
+				"count", len(rows)-inserted, "error", err)
+			c.checkConnection(ctx, err)
+			// The logs due while backing off are not made up for either
+			ticker.Reset(c.insertFailed(&failures, err, wait))
+		} else {
+			c.insertSucceeded(&failures, wait)
+		}
+
+		if c.insertLimitReached() {
+			slog.Info("insert limit reached, stopping the generator", "table", c.opts.Table,
+				"inserted", c.generated.Load())
+			return nil
+		}
+	}
+}
+
+// insertLimitReached reports whether the generator has written MaxInserts
+// audit logs.
+func (c *Cleaner) insertLimitReached() bool {
+	return c.opts.MaxInserts > 0 && c.generated.Load() >= c.opts.MaxInserts
+}
+
+// InsertsFinished reports whether the generator has stopped for good, having
+// written MaxInserts audit logs; no insert is due after that.
+func (c *Cleaner) InsertsFinished() bool {
+	return c.insertLimitReached()
+}
+
+// generatedRow makes up an audit log with the given message, written at now.
+func (c *Cleaner) generatedRow(message string, now time.Time) logRow {
+	req := c.gen.request()
+	r := logRow{message: message, createdAt: now}
+	for _, col := range c.columns {
+		r.values = append(r.values, c.gen.value(req, col))
+	}
+	return r
+}
+
+// insertWait returns the time until the next insert tick: InsertInterval,
+// varied randomly by up to InsertJitter of it either way.
+func (c *Cleaner) insertWait() time.Duration {
+	return jittered(c.opts.InsertInterval, c.opts.InsertJitter)
+}
+
+// cleanupWait returns the time until the next cleanup tick: CleanupInterval,
+// varied randomly by up to CleanupJitter of it either way.
+func (c *Cleaner) cleanupWait() time.Duration {
+	return jittered(c.opts.CleanupInterval, c.opts.CleanupJitter)
+}
+
+// jittered returns base varied randomly by up to the fraction jitter of it
+// either way.
+func jittered(base time.Duration, jitter float64) time.Duration {
+	return time.Duration(float64(base) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+// insertsDue returns how many inserts a tick after wait accounts for,
+// elapsed into the generator's run. Fractions add up over later ticks.
+func (c *Cleaner) insertsDue(wait, elapsed time.Duration) float64 {
+	var due float64
+	if c.opts.InsertRate > 0 {
+		due = c.opts.InsertRate * wait.Seconds()
+	} else {
+		due = float64(wait) / float64(c.opts.InsertInterval)
+	}
+	if c.opts.RampUp > 0 && elapsed < c.opts.RampUp {
+		due *= float64(elapsed) / float64(c.opts.RampUp)
+	}
+	return due
+}
+
+// RunCleanup runs a cleanup pass every CleanupInterval, varied by
+// CleanupJitter, until ctx is cancelled, then returns ctx's error. Failed
+// passes are logged, not returned, and retried after a backoff that grows
+// with every failure in a row.
+func (c *Cleaner) RunCleanup(ctx context.Context) error {
+	if c.opts.CleanupInterval <= 0 {
+		return fmt.Errorf("cleanup interval must be greater than 0, got %s", c.opts.CleanupInterval)
+	}
+
+	failures := failures{routine: "cleanup"}
+	ticker := c.opts.Clock.NewTicker(c.cleanupWait())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+		}
+		wait := c.cleanupWait()
+		ticker.Reset(wait)
+
+		start := c.opts.Clock.Now()
+		_, err := c.Cleanup(ctx)
+		switch {
+		case err == nil:
+			c.succeeded(&failures)
+		case ctx.Err() == nil:
+			ticker.Reset(c.failed(&failures, err, c.cleanupWait()))
+		}
+		if ctx.Err() == nil {
+			c.recordOverrun(c.opts.Clock.Now().Sub(start), wait)
+		}
+	}
+}
+
+// recordOverrun counts the cleanup cycles a run that took elapsed lost by
+// overrunning wait, the time to the next cycle. Runs never overlap, so no
+// run is ever skipped for being in progress: the ticker only fires again
+// once the run is done, and the cycles that came due in the meantime are
+// lost instead, a sign that cleanup cannot keep up.
+func (c *Cleaner) recordOverrun(elapsed, wait time.Duration) {
+	skipped := int64(elapsed / wait)
+	if skipped == 0 {
+		c.skippedCycles.Store(0)
+		return
+	}
+	cleanupOverrunCycles.WithLabelValues(c.opts.Table).Add(float64(skipped))
+	inRow := c.skippedCycles.Add(skipped)
+	slog.Warn("cleanup overran its interval, skipping cycles", "table", c.opts.Table,
+		"duration", elapsed, "interval", wait, "skipped", skipped, "skipped_in_row", inRow)
+}
+
+// Cleanup runs a single cleanup pass, records its outcome and returns what
+// was removed. The pass is skipped while cleanup is paused, while the table
+// does not exist, or while another pass, of this instance or another, holds
+// the table's cleanup lock. A missing table is not an error: it may simply
+// not be created yet, so it is logged once and cleaned up once it exists.
+func (c *Cleaner) Cleanup(ctx context.Context) (CleanupResult, error) {
+	if c.Paused() {
+		slog.Info("cleanup paused, skipping run", "table", c.opts.Table)
+		return CleanupResult{Paused: true}, nil
+	}
+
+	// The table may not be created yet, or have been dropped by hand, and
+	// every statement of the pass would fail without saying why
+	var exists bool
+	err := c.withRetry(ctx, "check table", func(ctx context.Context) error {
+		var err error
+		exists, err = c.tableExists(ctx)
+		return err
+	})
+	if err != nil {
+		err = fmt.Errorf("checking table %s: %w", c.opts.Table, err)
+		slog.Error("cleanup failed", "table", c.opts.Table, "error", err)
+		c.notify(ctx, Event{Type: EventCleanupFailed, Error: err.Error()})
+		return CleanupResult{}, err
+	}
+	if !exists {
+		if !c.tableMissing.Swap(true) {
+			slog.Warn("table does not exist, skipping cleanup until it is created", "table", c.opts.Table)
+		}
+		return CleanupResult{Missing: true}, nil
+	}
+	if c.tableMissing.Swap(false) {
+		slog.Info("table created, resuming cleanup", "table", c.opts.Table)
+	}
+
+	// Only one instance cleans up a table at a time. A dry run modifies
+	// nothing, so it doesn't need the lock.
+	if !c.opts.DryRun {
+		release, ok, err := c.tryLock(ctx)
+		if err != nil {
+			err = fmt.Errorf("taking cleanup lock: %w", err)
+			slog.Error("cleanup failed", "table", c.opts.Table, "error", err)
+			c.notify(ctx, Event{Type: EventCleanupFailed, Error: err.Error()})
+			return CleanupResult{}, err
+		}
+		if !ok {
+			cleanupSkipped.WithLabelValues(c.opts.Table).Inc()
+			slog.Debug("cleanup skipped, held by another instance", "table", c.opts.Table)
+			return CleanupResult{Skipped: true}, nil
+		}
+		defer release()
+	}
+
+	slog.Debug("running cleanup job", "table", c.opts.Table)
+	start := time.Now()
+	started := c.opts.Clock.Now().UTC()
+	result, err := c.deleteOldRecords(ctx)
+	cleanupDuration.WithLabelValues(c.opts.Table).Set(time.Since(start).Seconds())
+
+	// Failed and interrupted runs are recorded as well
+	if c.opts.History && !c.opts.DryRun {
+		if herr := c.recordRun(ctx, started, result, err); herr != nil {
+			herr = fmt.Errorf("recording cleanup run: %w", herr)
+			if err == nil {
+				err = herr
+			} else {
+				slog.Error("failed to record cleanup run", "table", c.opts.Table, "error", herr)
+			}
+		}
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		slog.Info("cleanup interrupted", "table", c.opts.Table, "deleted", result.Rows, "reason", ctx.Err())
+	case err != nil:
+		slog.Error("cleanup failed", "table", c.opts.Table, "deleted", result.Rows, "error", err)
+		event := Event{Type: EventCleanupFailed, Error: err.Error()}
+		if pe := (*partitionError)(nil); errors.As(err, &pe) {
+			event.Partition = pe.partition
+		}
+		c.notify(ctx, event)
+		c.checkConnection(ctx, err)
+	default:
+		c.lastCleanup.Store(time.Now().UnixNano())
+		attrs := []any{"table", c.opts.Table, "deleted", result.Rows}
+		if c.strategy == StrategyPartition {
+			attrs = append(attrs, "partitions_dropped", len(result.Partitions), "bytes_freed", result.Bytes)
+		}
+		attrs = append(attrs, "max_age", c.opts.MaxAge, "duration", time.Since(start))
+		slog.Info("cleanup finished", attrs...)
+		c.reportStats(ctx)
+	}
+	return result, err
+}
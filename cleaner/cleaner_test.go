@@ -0,0 +1,531 @@
+package cleaner
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newMockCleaner returns a Cleaner for opts backed by a mock database that
+// expects statements verbatim, up to whitespace, and checks at the end of
+// the test that every expected statement ran.
+func newMockCleaner(t *testing.T, opts Options) (*Cleaner, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("opening mock database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	if opts.Table == "" {
+		opts.Table = "audit_logs"
+	}
+	return New(db, opts), mock
+}
+
+// collapseSpace collapses the runs of white space in the statement s, as
+// the mock database does before comparing statements.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// insertArgs returns the arguments of an INSERT of rows.
+func insertArgs(rows []logRow) []driver.Value {
+	var args []driver.Value
+	for _, r := range rows {
+		args = append(args, r.message, r.createdAt)
+	}
+	return args
+}
+
+// insertedRows returns the rows an INSERT of rows returns, with ids counted
+// from firstID.
+func insertedRows(rows []logRow, firstID int) *sqlmock.Rows {
+	result := sqlmock.NewRows([]string{"id", "message", "created_at"})
+	for i, r := range rows {
+		result.AddRow(firstID+i, r.message, r.createdAt)
+	}
+	return result
+}
+
+// testRows returns n audit logs a second apart from start.
+func testRows(n int, start time.Time) []logRow {
+	rows := make([]logRow, n)
+	for i := range rows {
+		rows[i] = logRow{message: fmt.Sprintf("log %d", i), createdAt: start.Add(time.Duration(i) * time.Second)}
+	}
+	return rows
+}
+
+func TestInsertQuery(t *testing.T) {
+	c := New(nil, Options{Table: "audit_logs", Columns: []Column{{Name: "method", Type: "text"}}})
+	want := `INSERT INTO "audit_logs" (message, "created_at", "method") VALUES ($1, $2, $3), ($4, $5, $6) RETURNING id, message, "created_at"`
+	if got := collapseSpace(c.insertQuery(2)); got != want {
+		t.Errorf("insertQuery(2) =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPostToDBChunks(t *testing.T) {
+	c, mock := newMockCleaner(t, Options{InsertChunkSize: 2})
+	rows := testRows(5, utc(2024, 1, 15, 12, 0))
+
+	// Statements are prepared for each chunk size up front, then the
+	// chunks are committed one by one
+	pair := mock.ExpectPrepare(c.insertQuery(2))
+	single := mock.ExpectPrepare(c.insertQuery(1))
+	pair.ExpectQuery().WithArgs(insertArgs(rows[0:2])...).WillReturnRows(insertedRows(rows[0:2], 1))
+	pair.ExpectQuery().WithArgs(insertArgs(rows[2:4])...).WillReturnRows(insertedRows(rows[2:4], 3))
+	single.ExpectQuery().WithArgs(insertArgs(rows[4:5])...).WillReturnRows(insertedRows(rows[4:5], 5))
+
+	inserted, err := c.postToDB(t.Context(), rows)
+	if err != nil {
+		t.Fatalf("postToDB() = %v", err)
+	}
+	if inserted != len(rows) {
+		t.Errorf("postToDB() inserted %d rows, want %d", inserted, len(rows))
+	}
+}
+
+func TestPostToDBRollsBackTransaction(t *testing.T) {
+	c, mock := newMockCleaner(t, Options{InsertChunkSize: 2, ChunkTransaction: true})
+	rows := testRows(5, utc(2024, 1, 15, 12, 0))
+	failure := errors.New("value too long for type character varying(255)")
+
+	// Statements that fail to prepare are left to the insert itself
+	mock.ExpectPrepare(c.insertQuery(2)).WillReturnError(errors.New("prepare failed"))
+	mock.ExpectPrepare(c.insertQuery(1)).WillReturnError(errors.New("prepare failed"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(c.insertQuery(2)).WithArgs(insertArgs(rows[0:2])...).WillReturnRows(insertedRows(rows[0:2], 1))
+	mock.ExpectQuery(c.insertQuery(2)).WithArgs(insertArgs(rows[2:4])...).WillReturnError(failure)
+	mock.ExpectRollback()
+
+	inserted, err := c.postToDB(t.Context(), rows)
+	if !errors.Is(err, failure) {
+		t.Fatalf("postToDB() = %v, want %v", err, failure)
+	}
+	if inserted != 0 {
+		t.Errorf("postToDB() inserted %d rows, want none after the rollback", inserted)
+	}
+}
+
+func TestPostToDBCopy(t *testing.T) {
+	c, mock := newMockCleaner(t, Options{InsertChunkSize: 2, InsertMode: InsertCopy})
+	rows := testRows(3, utc(2024, 1, 15, 12, 0))
+	failure := errors.New("invalid input syntax for type timestamp with time zone")
+	copyIn := `COPY "audit_logs" ("message", "created_at") FROM STDIN`
+
+	// lib/pq buffers a row per Exec and sends them with the Exec without
+	// arguments; each chunk is copied in a transaction of its own, so a
+	// failed chunk leaves the chunks before it committed
+	mock.ExpectBegin()
+	first := mock.ExpectPrepare(copyIn)
+	for _, r := range rows[0:2] {
+		first.ExpectExec().WithArgs(r.message, r.createdAt).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	first.ExpectExec().WithoutArgs().WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	second := mock.ExpectPrepare(copyIn)
+	second.ExpectExec().WithArgs(rows[2].message, rows[2].createdAt).WillReturnResult(sqlmock.NewResult(0, 1))
+	second.ExpectExec().WithoutArgs().WillReturnError(failure)
+	mock.ExpectRollback()
+
+	inserted, err := c.postToDB(t.Context(), rows)
+	if !errors.Is(err, failure) {
+		t.Fatalf("postToDB() = %v, want %v", err, failure)
+	}
+	if inserted != 2 {
+		t.Errorf("postToDB() inserted %d rows, want the 2 of the first chunk", inserted)
+	}
+}
+
+func TestInsertModeDefault(t *testing.T) {
+	tests := []struct {
+		driver, mode string
+		want         string
+	}{
+		{DriverPQ, "", InsertInsert},
+		{DriverPGX, "", InsertCopy},
+		{DriverPQ, InsertCopy, InsertCopy},
+		{DriverPGX, InsertInsert, InsertInsert},
+	}
+	for _, tt := range tests {
+		opts := Options{Driver: tt.driver, InsertMode: tt.mode}.withDefaults()
+		if opts.InsertMode != tt.want {
+			t.Errorf("InsertMode with driver %s and mode %q = %q, want %q", tt.driver, tt.mode, opts.InsertMode, tt.want)
+		}
+	}
+}
+
+func TestDeleteBatch(t *testing.T) {
+	cutoff := utc(2024, 1, 15, 12, 0)
+	query := `
+		DELETE FROM "audit_logs"
+		WHERE ctid = ANY(ARRAY(
+			SELECT ctid FROM "audit_logs"
+			WHERE "created_at" < $1
+			ORDER BY "created_at" ASC
+			LIMIT $2
+		)) AND "created_at" < $1
+		RETURNING *
+	`
+	failure := errors.New("canceling statement due to user request")
+
+	tests := []struct {
+		name    string
+		deleted int
+		err     error
+	}{
+		{"rows deleted", 3, nil},
+		{"no rows left", 0, nil},
+		{"delete fails", 0, failure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, mock := newMockCleaner(t, Options{})
+			mock.ExpectBegin()
+			q := mock.ExpectQuery(query).WithArgs(cutoff, 5)
+			if tt.err != nil {
+				q.WillReturnError(tt.err)
+				mock.ExpectRollback()
+			} else {
+				result := sqlmock.NewRows([]string{"id", "message", "created_at"})
+				for i := range tt.deleted {
+					result.AddRow(i+1, "old", cutoff.Add(-time.Hour))
+				}
+				q.WillReturnRows(result)
+				mock.ExpectCommit()
+			}
+
+			deleted, err := c.deleteBatch(t.Context(), c.ident, cutoff, 5, nil)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("deleteBatch() = %v, want %v", err, tt.err)
+			}
+			if len(deleted) != tt.deleted {
+				t.Errorf("deleteBatch() deleted %d rows, want %d", len(deleted), tt.deleted)
+			}
+		})
+	}
+}
+
+// tableExistsQuery is the statement checking for the table before cleanup.
+const tableExistsQuery = `SELECT EXISTS ( SELECT 1 FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relname = $1 AND n.nspname = current_schema() AND c.relkind IN ('r', 'p') )`
+
+func TestCleanupMissingTable(t *testing.T) {
+	c, mock := newMockCleaner(t, Options{MaxAge: time.Hour})
+
+	// Neither pass takes the lock or deletes anything, and neither fails
+	for range 2 {
+		mock.ExpectQuery(tableExistsQuery).WithArgs("audit_logs").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		result, err := c.Cleanup(t.Context())
+		if err != nil {
+			t.Fatalf("Cleanup() = %v, want nil without the table", err)
+		}
+		if !result.Missing || result.Rows != 0 {
+			t.Errorf("Cleanup() = %+v, want a missing table and no rows", result)
+		}
+	}
+	if !c.tableMissing.Load() {
+		t.Error("the missing table was not recorded")
+	}
+}
+
+func TestRunCleanupOverrun(t *testing.T) {
+	clock := newFakeClock(utc(2024, 1, 15, 12, 0))
+	c, mock := newMockCleaner(t, Options{MaxAge: time.Hour, CleanupInterval: time.Minute, Clock: clock})
+	lost := func() float64 { return testutil.ToFloat64(cleanupOverrunCycles.WithLabelValues("audit_logs")) }
+	before := lost()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() { done <- c.RunCleanup(ctx) }()
+	clock.waitForTickers(t, 1)
+
+	// Three more cycles come due while the first run is still in progress
+	mock.ExpectQuery(tableExistsQuery).WithArgs("audit_logs").WillDelayFor(500 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	clock.Advance(time.Minute)
+	waitForExpectations(t, mock)
+
+	// The ticker kept one of them, which runs right after, in time
+	mock.ExpectQuery(tableExistsQuery).WithArgs("audit_logs").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	clock.Advance(3*time.Minute + 30*time.Second)
+	waitForExpectations(t, mock)
+	if got := lost() - before; got != 3 {
+		t.Errorf("lost %v cycles to the overrun, want 3", got)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.SkippedCleanups() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("SkippedCleanups() = %d after a run in time, want 0", c.SkippedCleanups())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("RunCleanup() = %v, want context.Canceled", err)
+	}
+}
+
+func TestCleanupDryRun(t *testing.T) {
+	now := utc(2024, 1, 15, 12, 0)
+	c, mock := newMockCleaner(t, Options{MaxAge: time.Hour, DryRun: true, Clock: newFakeClock(now)})
+
+	// Only the statements below may run: a lock, DELETE or DROP would not
+	// match any of them and fail the pass
+	mock.ExpectQuery(tableExistsQuery).WithArgs("audit_logs").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT count(*), COALESCE(sum(pg_column_size(t.*)), 0), pg_total_relation_size($2::regclass)
+		FROM "audit_logs" t WHERE "created_at" < $1`).WithArgs(now.Add(-time.Hour), `"audit_logs"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "sum", "size"}).AddRow(5, 500, 8192))
+	mock.ExpectQuery(`SELECT c.relname, c.oid = $1::regclass, COALESCE(pg_get_expr(c.relpartbound, c.oid), ''),
+		GREATEST(c.reltuples, 0)::bigint, pg_total_relation_size(c.oid) FROM pg_class c WHERE c.oid = $1::regclass
+		OR c.oid IN (SELECT inhrelid FROM pg_inherits WHERE inhparent = $1::regclass) ORDER BY c.relname`).
+		WithArgs(`"audit_logs"`).
+		WillReturnRows(sqlmock.NewRows([]string{"relname", "parent", "bound", "rows", "bytes"}).
+			AddRow("audit_logs", true, "", 20, 8192))
+
+	result, err := c.Cleanup(t.Context())
+	if err != nil {
+		t.Fatalf("Cleanup() = %v", err)
+	}
+	if result.Rows != 0 || len(result.Partitions) != 0 {
+		t.Errorf("Cleanup() = %+v, want nothing removed in a dry run", result)
+	}
+	if c.DryRunFailed() {
+		t.Error("DryRunFailed() = true after a successful inspection")
+	}
+}
+
+func TestDeleteExpiredRowsCancelled(t *testing.T) {
+	cutoff := utc(2024, 1, 15, 12, 0)
+
+	// A context cancelled beforehand runs no statement at all
+	c, _ := newMockCleaner(t, Options{BatchSize: 2})
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if deleted, err := c.deleteExpiredRows(ctx, c.ident, cutoff, nil); deleted != 0 || !errors.Is(err, context.Canceled) {
+		t.Errorf("deleteExpiredRows() = %d, %v with a cancelled context, want 0, %v", deleted, err, context.Canceled)
+	}
+
+	// Cancelling during the pause between batches returns at once, with
+	// the rows deleted so far
+	c, mock := newMockCleaner(t, Options{BatchSize: 2, BatchPause: time.Hour})
+	mock.ExpectBegin()
+	mock.ExpectQuery(deleteQuery).WithArgs(cutoff, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "message"}).AddRow(1, "log").AddRow(2, "log"))
+	mock.ExpectCommit()
+
+	ctx, cancel = context.WithCancel(t.Context())
+	type outcome struct {
+		deleted int
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		deleted, err := c.deleteExpiredRows(ctx, c.ident, cutoff, nil)
+		done <- outcome{deleted, err}
+	}()
+	waitForExpectations(t, mock)
+	cancel()
+
+	select {
+	case got := <-done:
+		if got.deleted != 2 || !errors.Is(got.err, context.Canceled) {
+			t.Errorf("deleteExpiredRows() = %d, %v, want 2, %v", got.deleted, got.err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("deleteExpiredRows() did not return once cancelled")
+	}
+}
+
+func TestJittered(t *testing.T) {
+	if got := jittered(time.Minute, 0); got != time.Minute {
+		t.Errorf("jittered(1m, 0) = %s, want 1m", got)
+	}
+
+	// Waits vary both ways, never by more than the jitter
+	var shorter, longer bool
+	for range 1000 {
+		got := jittered(time.Minute, 0.1)
+		if got < 54*time.Second || got > 66*time.Second {
+			t.Fatalf("jittered(1m, 0.1) = %s, want within 54s and 66s", got)
+		}
+		shorter = shorter || got < time.Minute
+		longer = longer || got > time.Minute
+	}
+	if !shorter || !longer {
+		t.Errorf("jittered(1m, 0.1) did not vary both ways: shorter %t, longer %t", shorter, longer)
+	}
+}
+
+func TestDeleteExpiredRowsVacuum(t *testing.T) {
+	cutoff := utc(2024, 1, 15, 12, 0)
+	tests := []struct {
+		name       string
+		vacuum     bool
+		deleted    int
+		wantVacuum bool
+	}{
+		{"rows deleted", true, 2, true},
+		{"nothing deleted", true, 0, false},
+		{"disabled", false, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, mock := newMockCleaner(t, Options{BatchSize: 5, Vacuum: tt.vacuum})
+			if tt.deleted > 0 {
+				batch := sqlmock.NewRows([]string{"id", "message"})
+				for i := range tt.deleted {
+					batch.AddRow(i+1, "log")
+				}
+				mock.ExpectBegin()
+				mock.ExpectQuery(deleteQuery).WithArgs(cutoff, 5).WillReturnRows(batch)
+				mock.ExpectCommit()
+			}
+			mock.ExpectBegin()
+			mock.ExpectQuery(deleteQuery).WithArgs(cutoff, 5).WillReturnRows(sqlmock.NewRows([]string{"id", "message"}))
+			mock.ExpectCommit()
+			// Any other statement, a VACUUM included, would fail the run
+			if tt.wantVacuum {
+				mock.ExpectExec(`VACUUM (ANALYZE) "audit_logs"`).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+
+			deleted, err := c.deleteExpiredRows(t.Context(), c.ident, cutoff, nil)
+			if err != nil || deleted != tt.deleted {
+				t.Fatalf("deleteExpiredRows() = %d, %v, want %d", deleted, err, tt.deleted)
+			}
+		})
+	}
+}
+
+func TestCleanupAnalyzesAfterDrop(t *testing.T) {
+	now := utc(2024, 1, 15, 12, 30)
+	expired := partition{name: "audit_logs_20240115_0930", ident: `"audit_logs_20240115_0930"`}
+	tests := []struct {
+		name        string
+		vacuum      bool
+		expired     bool
+		wantAnalyze bool
+	}{
+		{"partition dropped", true, true, true},
+		{"nothing dropped", true, false, false},
+		{"disabled", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, mock := newMockCleaner(t, Options{MaxAge: time.Hour, LockTimeout: 5 * time.Second, Vacuum: tt.vacuum,
+				Clock: newFakeClock(now)})
+			c.strategy = StrategyPartition
+
+			listed := sqlmock.NewRows([]string{"relname", "ident", "bound"})
+			if tt.expired {
+				listed.AddRow(expired.name, expired.ident, rangeBound(utc(2024, 1, 15, 9, 30), utc(2024, 1, 15, 10, 30)))
+			}
+			mock.ExpectQuery(catalogQuery).WithArgs("audit_logs").WillReturnRows(listed)
+			mock.ExpectQuery(holdsExistQuery).WithArgs(`"cleaner_holds"`).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			if tt.expired {
+				expectDrop(mock, expired, 10, 8192, nil)
+			}
+			if tt.wantAnalyze {
+				mock.ExpectExec(`ANALYZE "audit_logs"`).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+
+			if _, err := c.deleteOldRecords(t.Context()); err != nil {
+				t.Fatalf("deleteOldRecords() = %v", err)
+			}
+		})
+	}
+}
+
+func TestSchemaMismatches(t *testing.T) {
+	matching := map[string]string{"id": "integer", "message": "text", "created_at": "timestamp with time zone"}
+	tests := []struct {
+		name      string
+		found     map[string]string
+		generator bool
+		want      []string
+	}{
+		{"matching", matching, true, nil},
+		{"missing column", map[string]string{"id": "integer", "created_at": "timestamp without time zone"}, true,
+			[]string{"missing column message"}},
+		{"wrong type", map[string]string{"id": "bigint", "message": "text", "created_at": "date"}, true, []string{
+			"column id is bigint, expected integer",
+			"column created_at is date, expected timestamp without time zone or timestamp with time zone",
+		}},
+		{"cleanup only needs the time column", map[string]string{"created_at": "timestamp with time zone"}, false, nil},
+		{"missing time column", map[string]string{"id": "integer"}, false, []string{"missing column created_at"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newMockCleaner(t, Options{})
+			if got := c.schemaMismatches(tt.found, tt.generator); !slices.Equal(got, tt.want) {
+				t.Errorf("schemaMismatches() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckFailed(t *testing.T) {
+	problems := []string{"missing column message", "column id is bigint, expected integer"}
+
+	c, _ := newMockCleaner(t, Options{})
+	err := c.checkFailed(problems)
+	want := "table audit_logs failed the startup checks (use --force to start anyway): " +
+		"missing column message; column id is bigint, expected integer"
+	if err == nil || err.Error() != want {
+		t.Errorf("checkFailed() = %v, want %q", err, want)
+	}
+	if err := c.checkFailed(nil); err != nil {
+		t.Errorf("checkFailed(nil) = %v, want nil", err)
+	}
+
+	forced, _ := newMockCleaner(t, Options{Force: true})
+	if err := forced.checkFailed(problems); err != nil {
+		t.Errorf("checkFailed() with Force = %v, want nil", err)
+	}
+}
+
+func TestCreateTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns []Column
+		want    string
+	}{
+		{"no extra columns", nil, `CREATE TABLE IF NOT EXISTS "audit_logs" ( id SERIAL PRIMARY KEY, message TEXT NOT NULL,
+			"created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW() );
+			CREATE INDEX IF NOT EXISTS "idx_audit_logs_created_at" ON "audit_logs"("created_at");`},
+		{"declared columns", []Column{{Name: "user_id", Type: "uuid"}, {Name: "status_code", Type: "integer"}},
+			`CREATE TABLE IF NOT EXISTS "audit_logs" ( id SERIAL PRIMARY KEY, message TEXT NOT NULL,
+			"created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW(), "user_id" uuid, "status_code" integer );
+			CREATE INDEX IF NOT EXISTS "idx_audit_logs_created_at" ON "audit_logs"("created_at");`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, mock := newMockCleaner(t, Options{Columns: tt.columns})
+			mock.ExpectExec(tt.want).WillReturnResult(sqlmock.NewResult(0, 0))
+			if err := c.createTable(t.Context()); err != nil {
+				t.Fatalf("createTable() = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package cleaner
+
+import "time"
+
+// Clock tells the current time and paces the insert and cleanup loops.
+// Cleaner takes its notion of "now" from a Clock so that cutoffs,
+// timestamps and ticks can be controlled, e.g. by a fake clock.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks like a time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// realClock is the Clock backed by the system time.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+// realTicker is the Ticker backed by a time.Ticker.
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
@@ -0,0 +1,129 @@
+package cleaner
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when advanced, delivering the ticks
+// that fall due to its tickers.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) NewTicker(d time.Duration) Ticker {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTicker{clock: f, c: make(chan time.Time, 1), period: d, next: f.now.Add(d)}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d. Like a time.Ticker, a ticker whose
+// receiver falls behind drops ticks.
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	for _, t := range f.tickers {
+		for !t.stopped && !t.next.After(f.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+// waitForTickers waits until n tickers were created, so that advancing the
+// clock reaches a routine started in another goroutine.
+func (f *fakeClock) waitForTickers(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.mu.Lock()
+		created := len(f.tickers)
+		f.mu.Unlock()
+		if created >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d tickers created, want %d", created, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fakeTicker is a Ticker of a fakeClock.
+type fakeTicker struct {
+	clock   *fakeClock
+	c       chan time.Time
+	period  time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.period, t.next, t.stopped = d, t.clock.now.Add(d), false
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.stopped = true
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	ticker := clock.NewTicker(time.Minute)
+
+	clock.Advance(59 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked before the period passed")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	if got := <-ticker.C(); !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("tick = %s, want %s", got, start.Add(time.Minute))
+	}
+
+	ticker.Reset(time.Hour)
+	clock.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked after Reset before the new period passed")
+	default:
+	}
+
+	ticker.Stop()
+	clock.Advance(2 * time.Hour)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked after Stop")
+	default:
+	}
+	if got, want := clock.Now(), start.Add(2*time.Hour+2*time.Minute); !got.Equal(want) {
+		t.Errorf("Now() = %s, want %s", got, want)
+	}
+}
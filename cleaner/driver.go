@@ -0,0 +1,112 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+)
+
+// Drivers are the database/sql drivers the *sql.DB given to New may use.
+const (
+	DriverPQ  = "pq"  // github.com/lib/pq
+	DriverPGX = "pgx" // github.com/jackc/pgx/v5/stdlib
+)
+
+// sqlState returns the SQLSTATE code of the Postgres error in err's chain,
+// as reported by either driver, or "" if there is none.
+func sqlState(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// copyChunk writes the audit logs with COPY, in a transaction of their
+// own. COPY returns no rows, so the logs it returns lack their id.
+func (c *Cleaner) copyChunk(ctx context.Context, rows []logRow) ([]insertedLog, error) {
+	names := []string{"message", c.opts.TimeColumn}
+	for _, col := range c.columns {
+		names = append(names, col.Name)
+	}
+	values := make([][]any, len(rows))
+	for i, r := range rows {
+		values[i] = append([]any{r.message, r.createdAt}, r.values...)
+	}
+
+	var err error
+	if c.opts.Driver == DriverPGX {
+		err = c.copyFromPGX(ctx, names, values)
+	} else {
+		err = c.copyInPQ(ctx, names, values)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make([]insertedLog, len(rows))
+	for i, r := range rows {
+		logs[i] = insertedLog{message: r.message, createdAt: r.createdAt}
+	}
+	return logs, nil
+}
+
+// copyFromPGX copies the values into the columns names through the pgx
+// connection underneath the *sql.DB. CopyFrom runs in a transaction of its
+// own.
+func (c *Cleaner) copyFromPGX(ctx context.Context, names []string, values [][]any) error {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		pgxConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("driver connection is a %T, not a pgx connection", driverConn)
+		}
+		_, err := pgxConn.Conn().CopyFrom(ctx, pgx.Identifier{c.opts.Table}, names, pgx.CopyFromRows(values))
+		return err
+	})
+}
+
+// copyInPQ copies the values into the columns names with lib/pq, which
+// sends COPY through a statement prepared in a transaction: every Exec
+// buffers a row and the final one without arguments flushes them. The
+// transaction is rolled back if any of it fails.
+func (c *Cleaner) copyInPQ(ctx context.Context, names []string, values [][]any) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(c.opts.Table, names...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range values {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,166 @@
+package cleaner
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Classes of the errors that fail a routine, as counted by
+// auditlog_cleaner_routine_errors_total.
+const (
+	classConnection    = "connection"    // Connection refused or lost, or the server shutting down
+	classSerialization = "serialization" // Serialization failure or deadlock
+	classTimeout       = "timeout"       // Lock, statement or query timeout
+	classResources     = "resources"     // Out of disk, memory or connections
+	classSchema        = "schema"        // Missing table or column, or missing privileges
+	classOther         = "other"
+)
+
+// maxRoutineBackoff caps how long a failing routine waits before its next
+// attempt.
+const maxRoutineBackoff = 5 * time.Minute
+
+// errorClass classifies the error that failed a routine. Only classSchema
+// is permanent: retrying cannot help until someone fixes the database.
+func errorClass(err error) string {
+	switch {
+	case isTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return classTimeout
+	case isTransient(err):
+		return classConnection
+	}
+
+	code := sqlState(err)
+	switch {
+	case code == "40001" || code == "40P01": // serialization_failure, deadlock_detected
+		return classSerialization
+	case strings.HasPrefix(code, "53"): // insufficient_resources, e.g. disk_full
+		return classResources
+	case code == "42P01" || code == "42703" || code == "42501": // undefined_table, undefined_column, insufficient_privilege
+		return classSchema
+	}
+	return classOther
+}
+
+// failures follows the consecutive failures of one routine of a Cleaner,
+// which runs in a single goroutine.
+type failures struct {
+	routine   string
+	slowAfter int           // Consecutive failures from which the routine backs off; 2 if 0
+	maxWait   time.Duration // Longest backoff; maxRoutineBackoff if 0
+	count     int           // Consecutive failures
+	permanent int           // Consecutive failures with a permanent error
+}
+
+// failed records a failure of f's routine with err, escalates it once
+// FailureThreshold consecutive failures had a permanent error, and returns
+// how long the routine should wait before its next attempt: base, doubled
+// for every consecutive failure from the slowAfter-th on, up to maxWait.
+func (c *Cleaner) failed(f *failures, err error, base time.Duration) time.Duration {
+	class := errorClass(err)
+	routineErrors.WithLabelValues(c.opts.Table, f.routine, class).Inc()
+
+	f.count++
+	if class == classSchema {
+		f.permanent++
+	} else {
+		f.permanent = 0
+	}
+	if c.opts.FailureThreshold > 0 && f.permanent >= c.opts.FailureThreshold {
+		c.escalate(f.routine, fmt.Errorf("%s failed %d times in a row: %w", f.routine, f.permanent, err))
+	}
+
+	slowAfter := cmp.Or(f.slowAfter, 2)
+	if f.count < slowAfter {
+		return base
+	}
+	wait := max(base, min(base<<min(f.count-slowAfter+1, 16), cmp.Or(f.maxWait, maxRoutineBackoff)))
+	if f.count > 1 {
+		slog.Warn("routine keeps failing, backing off", "table", c.opts.Table, "routine", f.routine,
+			"class", class, "failures", f.count, "wait", wait)
+	}
+	return wait
+}
+
+// succeeded resets f after its routine succeeded, and clears the routine's
+// escalated failure, if any.
+func (c *Cleaner) succeeded(f *failures) {
+	if f.count > 0 {
+		slog.Info("routine recovered", "table", c.opts.Table, "routine", f.routine, "failures", f.count)
+	}
+	f.count, f.permanent = 0, 0
+
+	c.failMu.Lock()
+	delete(c.failing, f.routine)
+	c.failMu.Unlock()
+}
+
+// insertFailed records a failed insert of the generator like failed, and
+// reports the generator backing off once InsertBackoffThreshold inserts in
+// a row have failed.
+func (c *Cleaner) insertFailed(f *failures, err error, base time.Duration) time.Duration {
+	wait := c.failed(f, err, base)
+	if f.count == c.opts.InsertBackoffThreshold {
+		insertBackoffTrips.WithLabelValues(c.opts.Table).Inc()
+		insertBackoff.WithLabelValues(c.opts.Table).Set(1)
+		slog.Warn("inserts keep failing, slowing down the generator", "table", c.opts.Table,
+			"failures", f.count, "max_wait", c.opts.InsertMaxBackoff)
+	}
+	insertWait.WithLabelValues(c.opts.Table).Set(wait.Seconds())
+	return wait
+}
+
+// insertSucceeded records a successful insert of the generator like
+// succeeded, which ends its backoff.
+func (c *Cleaner) insertSucceeded(f *failures, wait time.Duration) {
+	if f.count >= c.opts.InsertBackoffThreshold {
+		insertBackoff.WithLabelValues(c.opts.Table).Set(0)
+		slog.Info("inserts succeed again, resuming the insert interval", "table", c.opts.Table,
+			"interval", c.opts.InsertInterval)
+	}
+	insertWait.WithLabelValues(c.opts.Table).Set(wait.Seconds())
+	c.succeeded(f)
+}
+
+// escalate records err as the persistent failure of routine and closes the
+// Escalated channel, unless an earlier failure already has.
+func (c *Cleaner) escalate(routine string, err error) {
+	c.failMu.Lock()
+	defer c.failMu.Unlock()
+
+	if _, ok := c.failing[routine]; !ok {
+		slog.Error("routine failing persistently", "table", c.opts.Table, "routine", routine, "error", err)
+	}
+	c.failing[routine] = err
+	c.escalateOnce.Do(func() { close(c.escalated) })
+}
+
+// Failing returns the persistent failure of a routine of the Cleaner, one
+// that failed FailureThreshold times in a row with a permanent error, such
+// as a missing column; nil once every such routine has succeeded again.
+func (c *Cleaner) Failing() error {
+	c.failMu.Lock()
+	defer c.failMu.Unlock()
+
+	routines := make([]string, 0, len(c.failing))
+	for routine := range c.failing {
+		routines = append(routines, routine)
+	}
+	if len(routines) == 0 {
+		return nil
+	}
+	slices.Sort(routines)
+	return c.failing[routines[0]]
+}
+
+// Escalated returns a channel that is closed the first time a routine of
+// the Cleaner fails persistently, see Failing.
+func (c *Cleaner) Escalated() <-chan struct{} {
+	return c.escalated
+}
@@ -0,0 +1,137 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"wrapped undefined table", fmt.Errorf("checking table: %w", &pq.Error{Code: "42P01"}), classSchema},
+		{"undefined table", &pq.Error{Code: "42P01"}, classSchema},
+		{"undefined column", &pq.Error{Code: "42703"}, classSchema},
+		{"insufficient privilege", &pq.Error{Code: "42501"}, classSchema},
+		{"lock timeout", &pq.Error{Code: "55P03"}, classTimeout},
+		{"statement timeout", &pq.Error{Code: "57014"}, classTimeout},
+		{"query timeout", fmt.Errorf("insert: %w", context.DeadlineExceeded), classTimeout},
+		{"connection failure", &pq.Error{Code: "08006"}, classConnection},
+		{"server shutting down", &pq.Error{Code: "57P01"}, classConnection},
+		{"serialization failure", &pq.Error{Code: "40001"}, classSerialization},
+		{"deadlock", &pq.Error{Code: "40P01"}, classSerialization},
+		{"disk full", &pq.Error{Code: "53100"}, classResources},
+		{"too many connections", &pq.Error{Code: "53300"}, classResources},
+		{"syntax error", &pq.Error{Code: "42601"}, classOther},
+		{"not a database error", errors.New("archive write failed"), classOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorClass(tt.err); got != tt.want {
+				t.Errorf("errorClass(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailedBacksOff(t *testing.T) {
+	c := New(nil, Options{Table: "audit_logs"})
+	f := &failures{routine: "cleanup", maxWait: 16 * time.Second}
+	failure := &pq.Error{Code: "08006"}
+
+	// The first failure waits the base interval, then the wait doubles up
+	// to maxWait
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 16 * time.Second}
+	for i, w := range want {
+		if got := c.failed(f, failure, time.Second); got != w {
+			t.Errorf("failure %d waits %s, want %s", i+1, got, w)
+		}
+	}
+
+	c.succeeded(f)
+	if got := c.failed(f, failure, time.Second); got != time.Second {
+		t.Errorf("failure after a success waits %s, want %s", got, time.Second)
+	}
+}
+
+func TestInsertFailedBacksOff(t *testing.T) {
+	failure := &pq.Error{Code: "08006"}
+	tests := []struct {
+		name      string
+		threshold int
+		maxWait   time.Duration
+		want      []time.Duration // Waits after the successive failures
+	}{
+		{"from the first failure", 1, 10 * time.Second,
+			[]time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}},
+		{"from the third failure", 3, 10 * time.Second,
+			[]time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second}},
+		{"cap below the interval", 1, time.Millisecond, []time.Duration{time.Second, time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := "insert_backoff_" + strings.ReplaceAll(tt.name, " ", "_")
+			c := New(nil, Options{Table: table, InsertBackoffThreshold: tt.threshold, InsertMaxBackoff: tt.maxWait})
+			f := &failures{routine: "insert", slowAfter: tt.threshold, maxWait: tt.maxWait}
+			tripped := func() float64 { return testutil.ToFloat64(insertBackoff.WithLabelValues(table)) }
+
+			for i, want := range tt.want {
+				if got := c.insertFailed(f, failure, time.Second); got != want {
+					t.Errorf("failure %d waits %s, want %s", i+1, got, want)
+				}
+				if got, want := tripped() == 1, i+1 >= tt.threshold; got != want {
+					t.Errorf("backing off after failure %d = %t, want %t", i+1, got, want)
+				}
+			}
+
+			c.insertSucceeded(f, time.Second)
+			if tripped() != 0 {
+				t.Error("still backing off after a successful insert")
+			}
+			if got := c.insertFailed(f, failure, time.Second); got != tt.want[0] {
+				t.Errorf("failure after a success waits %s, want %s", got, tt.want[0])
+			}
+		})
+	}
+}
+
+func TestFailedEscalates(t *testing.T) {
+	c := New(nil, Options{Table: "audit_logs", FailureThreshold: 2})
+	f := &failures{routine: "cleanup"}
+	missing := fmt.Errorf("deleting rows: %w", &pq.Error{Code: "42P01"})
+	escalated := func() bool {
+		select {
+		case <-c.Escalated():
+			return true
+		default:
+			return false
+		}
+	}
+
+	// Transient failures in between restart the count of permanent ones
+	c.failed(f, missing, time.Second)
+	c.failed(f, &pq.Error{Code: "08006"}, time.Second)
+	c.failed(f, missing, time.Second)
+	if c.Failing() != nil || escalated() {
+		t.Fatalf("escalated after non-consecutive permanent failures: %v", c.Failing())
+	}
+
+	c.failed(f, missing, time.Second)
+	if !errors.Is(c.Failing(), missing) || !escalated() {
+		t.Fatalf("Failing() = %v after two permanent failures in a row, want %v", c.Failing(), missing)
+	}
+
+	c.succeeded(f)
+	if c.Failing() != nil {
+		t.Errorf("Failing() = %v after a success, want nil", c.Failing())
+	}
+}
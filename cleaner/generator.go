@@ -0,0 +1,200 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+// DefaultColumns are the extra columns of a synthetic audit log, each filled
+// from the request the generator makes up for the row.
+var DefaultColumns = []Column{
+	{Name: "method", Type: "text"},
+	{Name: "user_id", Type: "uuid"},
+	{Name: "path", Type: "text"},
+	{Name: "status_code", Type: "integer"},
+	{Name: "ip", Type: "inet"},
+	{Name: "payload", Type: "jsonb"},
+}
+
+// Traffic shapes the synthetic requests behind the generated audit logs.
+// Zero values select the defaults.
+type Traffic struct {
+	Methods   map[string]int // Relative weight of each HTTP method
+	Paths     []string       // Request paths, picked uniformly
+	Users     int            // Size of the pool of user IDs
+	ErrorRate float64        // Fraction of requests that fail with a 4xx or 5xx
+}
+
+// withDefaults returns t with the zero values replaced by their defaults.
+func (t Traffic) withDefaults() Traffic {
+	if len(t.Methods) == 0 {
+		t.Methods = map[string]int{"GET": 70, "POST": 15, "PUT": 8, "PATCH": 2, "DELETE": 5}
+	}
+	if len(t.Paths) == 0 {
+		t.Paths = []string{
+			"/api/users", "/api/users/me", "/api/orders", "/api/orders/items",
+			"/api/products", "/api/products/search", "/api/invoices", "/api/sessions",
+		}
+	}
+	if t.Users <= 0 {
+		t.Users = 100
+	}
+	return t
+}
+
+// successStatus is the status code of a successful request per method.
+var successStatus = map[string]int{"POST": 201, "DELETE": 204}
+
+// errorStatuses are the weighted status codes of failed requests per
+// method; other methods use the "" entry.
+var errorStatuses = map[string][]weighted[int]{
+	"GET":    {{404, 6}, {401, 2}, {403, 1}, {500, 1}},
+	"POST":   {{400, 4}, {422, 3}, {409, 1}, {401, 1}, {500, 1}},
+	"DELETE": {{404, 4}, {403, 3}, {409, 2}, {500, 1}},
+	"":       {{400, 3}, {404, 2}, {409, 2}, {422, 2}, {500, 1}},
+}
+
+// weighted is a value with its relative weight in a random pick.
+type weighted[T any] struct {
+	value  T
+	weight int
+}
+
+// pick returns one of choices at random, in proportion to their weights.
+func pick[T any](rng *rand.Rand, choices []weighted[T]) T {
+	total := 0
+	for _, c := range choices {
+		total += c.weight
+	}
+	n := rng.IntN(total)
+	for _, c := range choices {
+		if n < c.weight {
+			return c.value
+		}
+		n -= c.weight
+	}
+	return choices[len(choices)-1].value
+}
+
+// generator makes up the synthetic requests behind the generated audit logs.
+// Its random source makes the same seed yield the same audit logs; like the
+// inserter using it, it is confined to a single goroutine.
+type generator struct {
+	traffic Traffic
+	methods []weighted[string]
+	users   []string
+	rng     *rand.Rand
+}
+
+func newGenerator(traffic Traffic, seed uint64) *generator {
+	traffic = traffic.withDefaults()
+
+	g := &generator{traffic: traffic, rng: rand.New(rand.NewPCG(seed, seed))}
+	for method, weight := range traffic.Methods {
+		g.methods = append(g.methods, weighted[string]{method, weight})
+	}
+	// Map order is random, but a seed must pick the same methods
+	slices.SortFunc(g.methods, func(a, b weighted[string]) int { return strings.Compare(a.value, b.value) })
+	for range traffic.Users {
+		g.users = append(g.users, g.uuid())
+	}
+	return g
+}
+
+// request is one synthetic request. Columns named after its fields take
+// their values from it.
+type request struct {
+	method  string
+	userID  string
+	path    string
+	status  int
+	ip      string
+	payload string
+}
+
+func (g *generator) request() request {
+	method := pick(g.rng, g.methods)
+
+	status, ok := successStatus[method]
+	if !ok {
+		status = 200
+	}
+	if g.rng.Float64() < g.traffic.ErrorRate {
+		statuses, ok := errorStatuses[method]
+		if !ok {
+			statuses = errorStatuses[""]
+		}
+		status = pick(g.rng, statuses)
+	}
+
+	payload, _ := json.Marshal(map[string]any{
+		"request_id":  g.uuid(),
+		"duration_ms": g.rng.IntN(500) + 1,
+		"bytes":       g.rng.IntN(64 * 1024),
+	})
+
+	return request{
+		method:  method,
+		userID:  g.users[g.rng.IntN(len(g.users))],
+		path:    g.traffic.Paths[g.rng.IntN(len(g.traffic.Paths))],
+		status:  status,
+		ip:      fmt.Sprintf("10.%d.%d.%d", g.rng.IntN(256), g.rng.IntN(256), g.rng.IntN(256)),
+		payload: string(payload),
+	}
+}
+
+// value returns the value of col for req: the matching request field for
+// the default columns, a random value of the column's type otherwise.
+func (g *generator) value(req request, col Column) any {
+	switch {
+	case col.Name == "method" && col.Type == "text":
+		return req.method
+	case col.Name == "user_id" && col.Type == "uuid":
+		return req.userID
+	case col.Name == "path" && col.Type == "text":
+		return req.path
+	case col.Name == "status_code" && col.Type == "integer":
+		return req.status
+	case col.Name == "ip" && col.Type == "inet":
+		return req.ip
+	case col.Name == "payload" && col.Type == "jsonb":
+		return req.payload
+	default:
+		return g.randomValue(col.Name, col.Type)
+	}
+}
+
+// randomValue returns a random value of the given column type, one of
+// ColumnTypes, for the insert generator.
+func (g *generator) randomValue(column, dataType string) any {
+	switch dataType {
+	case "integer":
+		return g.rng.Int32N(1000)
+	case "bigint":
+		return g.rng.Int64N(1 << 40)
+	case "boolean":
+		return g.rng.IntN(2) == 0
+	case "uuid":
+		return g.uuid()
+	case "inet":
+		return fmt.Sprintf("10.%d.%d.%d", g.rng.IntN(256), g.rng.IntN(256), g.rng.IntN(256))
+	case "jsonb":
+		return fmt.Sprintf(`{"value": %d}`, g.rng.IntN(1000))
+	default:
+		return fmt.Sprintf("%s-%d", column, g.rng.IntN(1000))
+	}
+}
+
+// uuid returns a random version 4 UUID.
+func (g *generator) uuid() string {
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = byte(g.rng.IntN(256))
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
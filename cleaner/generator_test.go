@@ -0,0 +1,100 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestGeneratorMethodWeights(t *testing.T) {
+	weights := map[string]int{"GET": 70, "POST": 20, "DELETE": 10}
+	g := newGenerator(Traffic{Methods: weights}, 42)
+
+	const samples = 10000
+	counts := make(map[string]int)
+	for range samples {
+		counts[g.request().method]++
+	}
+	for method, weight := range weights {
+		share := float64(counts[method]) / samples
+		if want := float64(weight) / 100; math.Abs(share-want) > 0.02 {
+			t.Errorf("%s picked %.3f of the time, want %.2f", method, share, want)
+		}
+	}
+	if len(counts) != len(weights) {
+		t.Errorf("picked methods %v, want only %v", counts, weights)
+	}
+}
+
+func TestGeneratorSeed(t *testing.T) {
+	columns := append(slices.Clone(DefaultColumns), Column{Name: "note", Type: "text"})
+	generated := func(seed uint64) [][]any {
+		c, _ := newMockCleaner(t, Options{Generate: true, Seed: seed, Columns: columns})
+		var rows [][]any
+		for range 20 {
+			rows = append(rows, c.generatedRow("Audit log", utc(2024, 1, 15, 12, 0)).values)
+		}
+		return rows
+	}
+
+	first, again, other := generated(7), generated(7), generated(8)
+	if !slices.EqualFunc(first, again, slices.Equal) {
+		t.Errorf("seed 7 generated %v, then %v", first, again)
+	}
+	if slices.EqualFunc(first, other, slices.Equal) {
+		t.Error("seeds 7 and 8 generated the same audit logs")
+	}
+}
+
+func TestGeneratorRequest(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ip := regexp.MustCompile(`^10\.\d{1,3}\.\d{1,3}\.\d{1,3}$`)
+	traffic := Traffic{Users: 5, ErrorRate: 0.5}.withDefaults()
+	g := newGenerator(traffic, 1)
+
+	users := make(map[string]bool)
+	for range 1000 {
+		req := g.request()
+		if _, ok := traffic.Methods[req.method]; !ok {
+			t.Fatalf("method %q is not one of %v", req.method, traffic.Methods)
+		}
+		if !uuid.MatchString(req.userID) {
+			t.Fatalf("user ID %q is not a version 4 UUID", req.userID)
+		}
+		users[req.userID] = true
+		if !slices.Contains(traffic.Paths, req.path) {
+			t.Fatalf("path %q is not one of %v", req.path, traffic.Paths)
+		}
+		if !ip.MatchString(req.ip) {
+			t.Fatalf("IP %q is not in 10.0.0.0/8", req.ip)
+		}
+
+		// Successes use the method's status, failures one of its errors
+		success, ok := successStatus[req.method]
+		if !ok {
+			success = 200
+		}
+		errorsOf, ok := errorStatuses[req.method]
+		if !ok {
+			errorsOf = errorStatuses[""]
+		}
+		if req.status != success && !slices.ContainsFunc(errorsOf, func(w weighted[int]) bool { return w.value == req.status }) {
+			t.Fatalf("%s got status %d, want %d or one of %v", req.method, req.status, success, errorsOf)
+		}
+
+		var payload map[string]any
+		if err := json.Unmarshal([]byte(req.payload), &payload); err != nil {
+			t.Fatalf("payload %s is not JSON: %v", req.payload, err)
+		}
+		for _, key := range []string{"request_id", "duration_ms", "bytes"} {
+			if _, ok := payload[key]; !ok {
+				t.Fatalf("payload %s has no %s", req.payload, key)
+			}
+		}
+	}
+	if len(users) != traffic.Users {
+		t.Errorf("requests came from %d users, want the pool of %d", len(users), traffic.Users)
+	}
+}
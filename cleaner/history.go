@@ -0,0 +1,113 @@
+package cleaner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// HistoryTable is the bookkeeping table that cleanup runs are recorded in
+// when Options.History is set.
+const HistoryTable = "cleaner_history"
+
+// HistoryEntry is one cleanup run recorded in the history table.
+type HistoryEntry struct {
+	Table      string
+	Strategy   string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Partitions []string // Names of the dropped partitions
+	Rows       int64
+	Bytes      *int64 // Size of the dropped partitions; nil for row deletion
+	Archive    string // Path of the archive written, if any
+	Error      string // Empty if the run succeeded
+}
+
+// createHistoryTable creates the history table unless it already exists.
+func (c *Cleaner) createHistoryTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			id BIGSERIAL PRIMARY KEY,
+			table_name TEXT NOT NULL,
+			strategy TEXT NOT NULL,
+			started_at TIMESTAMPTZ NOT NULL,
+			finished_at TIMESTAMPTZ NOT NULL,
+			partitions_dropped TEXT[] NOT NULL DEFAULT '{}',
+			rows_dropped BIGINT NOT NULL,
+			bytes_freed BIGINT,
+			archive TEXT,
+			error TEXT
+		)
+	`, pq.QuoteIdentifier(HistoryTable))
+
+	return c.withRetry(ctx, "create history table", func(ctx context.Context) error {
+		_, err := c.db.ExecContext(ctx, query)
+		return err
+	})
+}
+
+// recordRun inserts a cleanup run into the history table. It runs even if
+// ctx has been canceled, so that interrupted runs are recorded too.
+func (c *Cleaner) recordRun(ctx context.Context, started time.Time, result CleanupResult, runErr error) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s (table_name, strategy, started_at, finished_at,
+			partitions_dropped, rows_dropped, bytes_freed, archive, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, pq.QuoteIdentifier(HistoryTable))
+
+	// Rows deleted in batches only free space once vacuumed, so their size
+	// is left unknown
+	var bytes sql.NullInt64
+	if c.strategy == StrategyPartition {
+		bytes = sql.NullInt64{Int64: result.Bytes, Valid: true}
+	}
+	var errText sql.NullString
+	if runErr != nil {
+		errText = sql.NullString{String: runErr.Error(), Valid: true}
+	}
+	archive := sql.NullString{String: result.Archive, Valid: result.Archive != ""}
+	args := []any{c.opts.Table, c.strategy, started, c.opts.Clock.Now().UTC(),
+		pq.Array(result.Partitions), result.Rows, bytes, archive, errText}
+
+	return c.withRetry(context.WithoutCancel(ctx), "record cleanup run", func(ctx context.Context) error {
+		_, err := c.db.ExecContext(ctx, query, args...)
+		return err
+	})
+}
+
+// History returns the last n cleanup runs recorded in the history table,
+// newest first.
+func History(ctx context.Context, db *sql.DB, n int) ([]HistoryEntry, error) {
+	query := fmt.Sprintf(`
+		SELECT table_name, strategy, started_at, finished_at, partitions_dropped,
+		       rows_dropped, bytes_freed, COALESCE(archive, ''), COALESCE(error, '')
+		FROM %s
+		ORDER BY started_at DESC, id DESC
+		LIMIT $1
+	`, pq.QuoteIdentifier(HistoryTable))
+
+	rows, err := db.QueryContext(ctx, query, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var e HistoryEntry
+		var bytes sql.NullInt64
+		err := rows.Scan(&e.Table, &e.Strategy, &e.StartedAt, &e.FinishedAt, pq.Array(&e.Partitions),
+			&e.Rows, &bytes, &e.Archive, &e.Error)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Valid {
+			e.Bytes = &bytes.Int64
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,158 @@
+package cleaner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// HoldsTable is the table of legal holds, kept across restarts. It is
+// created by AddHold; without it, only Options.Holds apply.
+const HoldsTable = "cleaner_holds"
+
+// Hold exempts the partitions whose range overlaps [From, To) from being
+// dropped, whatever their age and the limits, until the hold is removed.
+type Hold struct {
+	ID        int64  // In HoldsTable; 0 for a hold of Options.Holds
+	Table     string // Empty holds every table
+	From, To  time.Time
+	Reason    string
+	CreatedAt time.Time
+}
+
+// covers reports whether the hold applies to the partition p of table.
+func (h Hold) covers(table string, p partition) bool {
+	return (h.Table == "" || h.Table == table) && p.overlaps(h.From, h.To)
+}
+
+// createHoldsTable creates the holds table unless it already exists.
+func createHoldsTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			id BIGSERIAL PRIMARY KEY,
+			table_name TEXT,
+			range_from TIMESTAMPTZ NOT NULL,
+			range_to TIMESTAMPTZ NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			CHECK (range_from < range_to)
+		)
+	`, pq.QuoteIdentifier(HoldsTable)))
+	return err
+}
+
+// AddHold records h in the holds table, creating the table if needed, and
+// returns its id.
+func AddHold(ctx context.Context, db *sql.DB, h Hold) (int64, error) {
+	if !h.From.Before(h.To) {
+		return 0, fmt.Errorf("hold start %s is not before its end %s", h.From.Format(time.RFC3339), h.To.Format(time.RFC3339))
+	}
+	if err := createHoldsTable(ctx, db); err != nil {
+		return 0, fmt.Errorf("creating holds table: %w", err)
+	}
+
+	var id int64
+	err := db.QueryRowContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s (table_name, range_from, range_to, reason)
+		VALUES (NULLIF($1, ''), $2, $3, $4)
+		RETURNING id
+	`, pq.QuoteIdentifier(HoldsTable)), h.Table, h.From, h.To, h.Reason).Scan(&id)
+	return id, err
+}
+
+// RemoveHold deletes the hold id from the holds table and reports whether
+// there was one.
+func RemoveHold(ctx context.Context, db *sql.DB, id int64) (bool, error) {
+	if exists, err := holdsTableExists(ctx, db); err != nil || !exists {
+		return false, err
+	}
+	result, err := db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, pq.QuoteIdentifier(HoldsTable)), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Holds returns the holds recorded in the holds table, oldest range first;
+// none if the table does not exist.
+func Holds(ctx context.Context, db *sql.DB) ([]Hold, error) {
+	if exists, err := holdsTableExists(ctx, db); err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, COALESCE(table_name, ''), range_from, range_to, reason, created_at
+		FROM %s
+		ORDER BY range_from, id
+	`, pq.QuoteIdentifier(HoldsTable)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var holds []Hold
+	for rows.Next() {
+		var h Hold
+		if err := rows.Scan(&h.ID, &h.Table, &h.From, &h.To, &h.Reason, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+	return holds, rows.Err()
+}
+
+func holdsTableExists(ctx context.Context, db *sql.DB) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, pq.QuoteIdentifier(HoldsTable)).Scan(&exists)
+	return exists, err
+}
+
+// activeHolds returns the holds of Options.Holds and of the holds table
+// that apply to the table.
+func (c *Cleaner) activeHolds(ctx context.Context) ([]Hold, error) {
+	var recorded []Hold
+	err := c.withRetry(ctx, "list holds", func(ctx context.Context) error {
+		var err error
+		recorded, err = Holds(ctx, c.db)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing holds: %w", err)
+	}
+
+	var holds []Hold
+	for _, h := range append(c.opts.Holds, recorded...) {
+		if h.Table == "" || h.Table == c.opts.Table {
+			holds = append(holds, h)
+		}
+	}
+	return holds, nil
+}
+
+// heldBy returns the first of holds that covers p, if any.
+func (c *Cleaner) heldBy(holds []Hold, p partition) (Hold, bool) {
+	for _, h := range holds {
+		if h.covers(c.opts.Table, p) {
+			return h, true
+		}
+	}
+	return Hold{}, false
+}
+
+// logHeld logs a partition kept by a hold.
+func (c *Cleaner) logHeld(p partition, h Hold) {
+	attrs := []any{"table", c.opts.Table, "partition", p.name,
+		"hold_from", h.From.Format(time.RFC3339), "hold_to", h.To.Format(time.RFC3339)}
+	if h.ID != 0 {
+		attrs = append(attrs, "hold_id", h.ID)
+	}
+	if h.Reason != "" {
+		attrs = append(attrs, "reason", h.Reason)
+	}
+	slog.Info("partition held, not dropping it", attrs...)
+}
@@ -0,0 +1,59 @@
+package cleaner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHoldCovers(t *testing.T) {
+	day := partition{name: "audit_logs_20240303_0000", from: utc(2024, 3, 3, 0, 0), to: utc(2024, 3, 4, 0, 0)}
+	tests := []struct {
+		name  string
+		hold  Hold
+		table string
+		want  bool
+	}{
+		{"same range", Hold{From: day.from, To: day.to}, "audit_logs", true},
+		{"part of the range", Hold{From: utc(2024, 3, 3, 12, 0), To: utc(2024, 3, 3, 13, 0)}, "audit_logs", true},
+		{"spanning the range", Hold{From: utc(2024, 3, 1, 0, 0), To: utc(2024, 3, 10, 0, 0)}, "audit_logs", true},
+		{"ending at the lower bound", Hold{From: utc(2024, 3, 2, 0, 0), To: day.from}, "audit_logs", false},
+		{"starting at the upper bound", Hold{From: day.to, To: utc(2024, 3, 5, 0, 0)}, "audit_logs", false},
+		{"matching table", Hold{Table: "audit_logs", From: day.from, To: day.to}, "audit_logs", true},
+		{"other table", Hold{Table: "access_logs", From: day.from, To: day.to}, "audit_logs", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hold.covers(tt.table, day); got != tt.want {
+				t.Errorf("covers(%s, [%s, %s)) = %t, want %t", tt.table, day.from, day.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeldBy(t *testing.T) {
+	c := New(nil, Options{Table: "audit_logs"})
+	holds := []Hold{
+		{ID: 1, Table: "access_logs", From: utc(2024, 3, 1, 0, 0), To: utc(2024, 4, 1, 0, 0)},
+		{ID: 2, From: utc(2024, 3, 3, 6, 0), To: utc(2024, 3, 3, 7, 0)},
+		{ID: 3, Table: "audit_logs", From: utc(2024, 3, 1, 0, 0), To: utc(2024, 3, 5, 0, 0)},
+	}
+	tests := []struct {
+		name   string
+		p      partition
+		wantID int64 // 0 when no hold covers the partition
+	}{
+		{"first covering hold", partition{from: utc(2024, 3, 3, 0, 0), to: utc(2024, 3, 4, 0, 0)}, 2},
+		{"table hold", partition{from: utc(2024, 3, 4, 0, 0), to: utc(2024, 3, 5, 0, 0)}, 3},
+		{"not held", partition{from: utc(2024, 3, 5, 0, 0), to: utc(2024, 3, 6, 0, 0)}, 0},
+		{"MINVALUE", partition{to: utc(2024, 1, 1, 0, 0)}, 0},
+		{"MAXVALUE", partition{from: utc(2024, 3, 6, 0, 0).Add(-time.Hour)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, ok := c.heldBy(holds, tt.p)
+			if ok != (tt.wantID != 0) || h.ID != tt.wantID {
+				t.Errorf("heldBy() = hold %d, %t, want hold %d", h.ID, ok, tt.wantID)
+			}
+		})
+	}
+}
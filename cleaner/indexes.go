@@ -0,0 +1,232 @@
+package cleaner
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Index modes select how the indexes of Options.Indexes are built.
+const (
+	IndexParent     = "parent"     // On the table, from which Postgres copies them to every partition
+	IndexConcurrent = "concurrent" // With CREATE INDEX CONCURRENTLY, on each partition as it is created
+)
+
+// indexColumnPattern matches one column of an index definition, optionally
+// descending, e.g. "created_at DESC".
+var indexColumnPattern = regexp.MustCompile(`^([a-z_][a-z0-9_]*)(?:\s+(?i:(ASC|DESC)))?$`)
+
+// indexColumn is a column of an index.
+type indexColumn struct {
+	name string
+	desc bool
+}
+
+// index is an index of Options.Indexes, parsed.
+type index struct {
+	def     string // As configured
+	columns []indexColumn
+}
+
+// ValidIndex reports whether def is a valid index definition for
+// Options.Indexes.
+func ValidIndex(def string) bool {
+	_, err := parseIndex(def)
+	return err == nil
+}
+
+// parseIndex parses an index definition: a comma-separated list of
+// columns, each optionally followed by ASC or DESC, e.g. "method",
+// "created_at DESC" or "user_id, created_at DESC".
+func parseIndex(def string) (index, error) {
+	ix := index{def: def}
+	for _, part := range strings.Split(def, ",") {
+		m := indexColumnPattern.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return index{}, fmt.Errorf("index %q is not valid (columns with lowercase letters, digits and underscores, each optionally followed by ASC or DESC)", def)
+		}
+		ix.columns = append(ix.columns, indexColumn{name: m[1], desc: strings.EqualFold(m[2], "DESC")})
+	}
+	return ix, nil
+}
+
+// name names the index on the table or partition table after its columns,
+// like idx_audit_logs_created_at_desc, cut to the length Postgres keeps.
+func (ix index) name(table string) string {
+	var b strings.Builder
+	b.WriteString("idx_" + table)
+	for _, col := range ix.columns {
+		b.WriteString("_" + col.name)
+		if col.desc {
+			b.WriteString("_desc")
+		}
+	}
+	name := b.String()
+	if len(name) > maxIdentifierLength {
+		name = name[:maxIdentifierLength]
+	}
+	return name
+}
+
+// key returns the column list of the index as pg_get_indexdef renders it,
+// without quotes, e.g. "user_id, created_at DESC".
+func (ix index) key() string {
+	parts := make([]string, len(ix.columns))
+	for i, col := range ix.columns {
+		parts[i] = col.name
+		if col.desc {
+			parts[i] += " DESC"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sql returns the column list of the index for CREATE INDEX.
+func (ix index) sql() string {
+	parts := make([]string, len(ix.columns))
+	for i, col := range ix.columns {
+		parts[i] = pq.QuoteIdentifier(col.name)
+		if col.desc {
+			parts[i] += " DESC"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// prepareIndexes creates the indexes of Options.Indexes: on the table,
+// which Postgres copies to its partitions, unless IndexConcurrent builds
+// them on each new partition instead. A table that is not partitioned gets
+// them concurrently then. The partitions of a partitioned table are then
+// checked for every index, and those lacking one are logged.
+func (c *Cleaner) prepareIndexes(ctx context.Context) error {
+	c.indexes = c.indexes[:0]
+	for _, def := range c.opts.Indexes {
+		ix, err := parseIndex(def)
+		if err != nil {
+			return err
+		}
+		c.indexes = append(c.indexes, ix)
+	}
+
+	// TimescaleDB copies the indexes of a hypertable to its chunks itself,
+	// and cannot build them concurrently
+	perPartition := c.opts.IndexMode == IndexConcurrent && c.partitioned && c.opts.Storage != StorageTimescale
+	if !perPartition {
+		concurrently := c.opts.IndexMode == IndexConcurrent && !c.partitioned && c.opts.Storage != StorageTimescale
+		for _, ix := range c.indexes {
+			if err := c.createIndex(ctx, ix.name(c.opts.Table), c.ident, ix, concurrently); err != nil {
+				return fmt.Errorf("creating index %q: %w", ix.def, err)
+			}
+		}
+	}
+
+	if c.partitioned && c.opts.Storage != StorageTimescale {
+		return c.checkPartitionIndexes(ctx)
+	}
+	return nil
+}
+
+// createPartitionIndexes builds the indexes of Options.Indexes on a new
+// partition with IndexConcurrent. Failures are logged rather than returned,
+// since the partition itself is usable; the startup check reports the
+// indexes that are still missing.
+func (c *Cleaner) createPartitionIndexes(ctx context.Context, partition string) {
+	if c.opts.IndexMode != IndexConcurrent || !c.partitioned || c.opts.Storage == StorageTimescale {
+		return
+	}
+	for _, ix := range c.indexes {
+		if err := c.createIndex(ctx, ix.name(partition), pq.QuoteIdentifier(partition), ix, true); err != nil {
+			slog.Error("failed to create partition index", "table", c.opts.Table, "partition", partition,
+				"index", ix.def, "error", err)
+		}
+	}
+}
+
+// createIndex creates the index name of ix on the table target, quoted for
+// use in SQL, unless it exists. A concurrent build runs outside a
+// transaction; an invalid index left behind by an earlier concurrent build
+// that failed is dropped and built again.
+func (c *Cleaner) createIndex(ctx context.Context, name, target string, ix index, concurrently bool) error {
+	if c.opts.DryRun {
+		slog.Info("[DRY RUN] would create index", "table", c.opts.Table, "target", target, "index", name)
+		return nil
+	}
+
+	var valid bool
+	err := c.db.QueryRowContext(ctx, `SELECT indisvalid FROM pg_index WHERE indexrelid = to_regclass($1)`,
+		pq.QuoteIdentifier(name)).Scan(&valid)
+	switch {
+	case err == nil && valid:
+		return nil
+	case err == nil:
+		slog.Warn("dropping invalid index left by a failed build", "table", c.opts.Table, "index", name)
+		if _, err := c.db.ExecContext(ctx, `DROP INDEX CONCURRENTLY IF EXISTS `+pq.QuoteIdentifier(name)); err != nil {
+			return fmt.Errorf("dropping invalid index %s: %w", name, err)
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	mode := ""
+	if concurrently {
+		mode = "CONCURRENTLY "
+	}
+	query := fmt.Sprintf(`CREATE INDEX %sIF NOT EXISTS %s ON %s (%s)`, mode, pq.QuoteIdentifier(name), target, ix.sql())
+	if _, err := c.db.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	slog.Info("index created", "table", c.opts.Table, "target", target, "index", name)
+	return nil
+}
+
+// indexKeyPattern extracts the column list from pg_get_indexdef.
+var indexKeyPattern = regexp.MustCompile(`USING \w+ \((.*)\)$`)
+
+// checkPartitionIndexes logs every partition of the table that lacks a
+// valid index on the columns of an index of Options.Indexes, whatever its
+// name.
+func (c *Cleaner) checkPartitionIndexes(ctx context.Context) error {
+	partitions, err := c.catalogPartitions(ctx)
+	if err != nil {
+		return fmt.Errorf("listing partitions: %w", err)
+	}
+
+	for _, p := range partitions {
+		keys := make(map[string]bool)
+		rows, err := c.db.QueryContext(ctx, `
+			SELECT pg_get_indexdef(indexrelid)
+			FROM pg_index
+			WHERE indrelid = $1::regclass AND indisvalid
+		`, p.ident)
+		if err != nil {
+			return fmt.Errorf("listing indexes of partition %s: %w", p.name, err)
+		}
+		for rows.Next() {
+			var def string
+			if err := rows.Scan(&def); err != nil {
+				rows.Close()
+				return err
+			}
+			if m := indexKeyPattern.FindStringSubmatch(def); m != nil {
+				keys[strings.ReplaceAll(m[1], `"`, "")] = true
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, ix := range c.indexes {
+			if !keys[ix.key()] {
+				slog.Warn("partition is missing an index", "table", c.opts.Table, "partition", p.name, "index", ix.def)
+			}
+		}
+	}
+	return nil
+}
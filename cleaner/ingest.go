@@ -0,0 +1,429 @@
+package cleaner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBufferFull is returned by Ingester.Submit when the buffer has no room
+// left for the submitted records, none of which are then queued.
+var ErrBufferFull = errors.New("ingest buffer is full")
+
+// ErrTooManyPartitions is returned by Ingester.Submit for a submission whose
+// records span more partition ranges than IngestOptions.MaxPartitions.
+var ErrTooManyPartitions = errors.New("records span too many partitions")
+
+// ErrIngesterClosed is returned by Ingester.Submit once the Ingester has
+// stopped accepting records to flush the buffer on shutdown.
+var ErrIngesterClosed = errors.New("ingester is shutting down")
+
+// Record is an audit log submitted for ingestion: its fields by column
+// name, as JSON values. message and the time column are optional; the time
+// defaults to the moment the record is submitted.
+type Record map[string]json.RawMessage
+
+// RecordError is the problem with one submitted record.
+type RecordError struct {
+	Index   int    `json:"index"` // Position of the record in the submission
+	Message string `json:"error"`
+}
+
+// InvalidRecordsError is returned by Ingester.Submit for a submission with
+// invalid records, listing every one of them; none of the records are
+// queued.
+type InvalidRecordsError struct {
+	Records []RecordError
+}
+
+func (e *InvalidRecordsError) Error() string {
+	first := e.Records[0]
+	if len(e.Records) == 1 {
+		return fmt.Sprintf("record %d: %s", first.Index, first.Message)
+	}
+	return fmt.Sprintf("record %d: %s (and %d more invalid records)", first.Index, first.Message, len(e.Records)-1)
+}
+
+// IngestOptions configures an Ingester. Zero values select the defaults.
+type IngestOptions struct {
+	BufferSize    int           // Records held before Submit fails with ErrBufferFull, 10000 by default
+	FlushInterval time.Duration // Longest a record waits in the buffer, 1s by default
+
+	// PartitionStep is the time range of the partitions created for
+	// records that no partition covers yet, that of the Cleaner's
+	// PartitionGranularity by default, or 1 day without one
+	PartitionStep time.Duration
+
+	// MaxFuture rejects records timestamped more than this after now, 1
+	// hour by default; the table's maximum age bounds them in the past
+	MaxFuture time.Duration
+	// MaxPartitions is the most ranges of PartitionStep the records of one
+	// submission may span, 10 by default, as each may need a partition
+	// created
+	MaxPartitions int
+}
+
+// Ingester buffers audit logs submitted by other services and writes them
+// to the table of an ingesting Cleaner in batches.
+type Ingester struct {
+	c    *Cleaner
+	opts IngestOptions
+
+	mu     sync.Mutex
+	buffer []logRow
+	closed bool
+
+	// ready wakes Run up as soon as a full batch is buffered
+	ready chan struct{}
+}
+
+// NewIngester returns an Ingester writing to c's table, whose Options must
+// have Ingest set. Run must be running for the records to be written.
+func NewIngester(c *Cleaner, opts IngestOptions) *Ingester {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 10000
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.PartitionStep <= 0 {
+		opts.PartitionStep = 24 * time.Hour
+		if g, ok := granularities[c.opts.PartitionGranularity]; ok {
+			opts.PartitionStep = g.step
+		}
+	}
+	if opts.MaxFuture <= 0 {
+		opts.MaxFuture = time.Hour
+	}
+	if opts.MaxPartitions <= 0 {
+		opts.MaxPartitions = 10
+	}
+	return &Ingester{c: c, opts: opts, ready: make(chan struct{}, 1)}
+}
+
+// Submit validates records and queues them to be written. It returns an
+// *InvalidRecordsError if any record is invalid, ErrTooManyPartitions if
+// they span too many partitions, ErrBufferFull if there is not enough room
+// for all of them, or ErrIngesterClosed during shutdown; in each case none
+// of the records are queued.
+func (i *Ingester) Submit(records []Record) error {
+	now := i.c.opts.Clock.Now().UTC()
+	latest := now.Add(i.opts.MaxFuture)
+	rows := make([]logRow, 0, len(records))
+	var invalid []RecordError
+	for n, record := range records {
+		r, err := i.c.parseRecord(record, now, latest)
+		if err != nil {
+			invalid = append(invalid, RecordError{Index: n, Message: err.Error()})
+			continue
+		}
+		rows = append(rows, r)
+	}
+	if len(invalid) > 0 {
+		ingestRejected.WithLabelValues("invalid").Add(float64(len(records)))
+		return &InvalidRecordsError{Records: invalid}
+	}
+	if i.c.createsIngestPartitions() {
+		if n := partitionRanges(rows, i.opts.PartitionStep); n > i.opts.MaxPartitions {
+			ingestRejected.WithLabelValues("partitions").Add(float64(len(rows)))
+			return fmt.Errorf("%w: %d ranges of %s, at most %d per request", ErrTooManyPartitions, n, i.opts.PartitionStep, i.opts.MaxPartitions)
+		}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	switch {
+	case i.closed:
+		ingestRejected.WithLabelValues("closed").Add(float64(len(rows)))
+		return ErrIngesterClosed
+	case len(i.buffer)+len(rows) > i.opts.BufferSize:
+		ingestRejected.WithLabelValues("full").Add(float64(len(rows)))
+		return ErrBufferFull
+	}
+
+	i.buffer = append(i.buffer, rows...)
+	ingestBuffered.Set(float64(len(i.buffer)))
+	if len(i.buffer) >= i.c.opts.InsertChunkSize {
+		select {
+		case i.ready <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run writes the buffered records every FlushInterval, or as soon as a full
+// chunk of InsertChunkSize is buffered, until ctx is cancelled. It then
+// stops accepting records and, before returning ctx's error, writes all
+// that are still buffered, without giving up on ctx; a failed write is
+// only retried up to MaxRetries times then.
+func (i *Ingester) Run(ctx context.Context) error {
+	if !i.c.opts.Ingest {
+		return errors.New("ingestion is not enabled for table " + i.c.opts.Table)
+	}
+
+	ticker := i.c.opts.Clock.NewTicker(i.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			i.drain(context.WithoutCancel(ctx))
+			return ctx.Err()
+		case <-ticker.C():
+		case <-i.ready:
+		}
+
+		if err := i.flush(ctx); err != nil && ctx.Err() == nil {
+			i.c.checkConnection(ctx, err)
+		}
+	}
+}
+
+// drain closes the Ingester and writes the buffered records until none
+// are left or a write fails, in which case the rest are dropped.
+func (i *Ingester) drain(ctx context.Context) {
+	i.mu.Lock()
+	i.closed = true
+	buffered := len(i.buffer)
+	i.mu.Unlock()
+
+	if buffered > 0 {
+		slog.Info("flushing ingested audit logs before shutdown", "table", i.c.opts.Table, "count", buffered)
+	}
+	for buffered > 0 {
+		if err := i.flush(ctx); err != nil {
+			i.mu.Lock()
+			dropped := len(i.buffer)
+			i.buffer = nil
+			i.mu.Unlock()
+			ingestBuffered.Set(0)
+			slog.Error("dropping ingested audit logs that could not be written on shutdown",
+				"table", i.c.opts.Table, "count", dropped, "error", err)
+			return
+		}
+		i.mu.Lock()
+		buffered = len(i.buffer)
+		i.mu.Unlock()
+	}
+}
+
+// flush writes the records buffered so far. Records that could not be
+// written are put back at the front of the buffer, to be retried on the
+// next flush.
+func (i *Ingester) flush(ctx context.Context) error {
+	i.mu.Lock()
+	rows := i.buffer
+	i.buffer = nil
+	i.mu.Unlock()
+	if len(rows) == 0 {
+		return nil
+	}
+
+	inserted := 0
+	err := i.c.ensureIngestPartitions(ctx, rows, i.opts.PartitionStep)
+	if err == nil {
+		inserted, err = i.c.postToDB(ctx, rows)
+	}
+
+	i.mu.Lock()
+	if err != nil {
+		i.buffer = append(rows[inserted:], i.buffer...)
+	}
+	ingestBuffered.Set(float64(len(i.buffer)))
+	i.mu.Unlock()
+
+	if err != nil {
+		insertFailures.Inc()
+		slog.Error("failed to write ingested audit logs, keeping them buffered", "table", i.c.opts.Table,
+			"count", len(rows)-inserted, "error", err)
+	}
+	return err
+}
+
+// createsIngestPartitions reports whether ingested rows that no partition
+// covers get one created: with the partition strategy, unless a DEFAULT
+// partition catches them.
+func (c *Cleaner) createsIngestPartitions() bool {
+	return c.strategy == StrategyPartition && c.opts.Storage != StorageTimescale && c.defaultPartition == nil
+}
+
+// partitionRanges returns the number of distinct ranges of step the times
+// of rows fall in.
+func partitionRanges(rows []logRow, step time.Duration) int {
+	ranges := make(map[time.Time]bool)
+	for _, r := range rows {
+		ranges[r.createdAt.UTC().Truncate(step)] = true
+	}
+	return len(ranges)
+}
+
+// ensureIngestPartitions creates a partition of step for the time of every
+// row that no partition covers yet, like Backfill. Without the partition
+// strategy, or with a DEFAULT partition to catch such rows, there is nothing
+// to do.
+func (c *Cleaner) ensureIngestPartitions(ctx context.Context, rows []logRow, step time.Duration) error {
+	if !c.createsIngestPartitions() {
+		return nil
+	}
+
+	var existing []partition
+	err := c.withRetry(ctx, "list partitions", func(ctx context.Context) error {
+		var err error
+		existing, err = c.listPartitions(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("listing partitions: %w", err)
+	}
+
+	for _, r := range rows {
+		if slices.ContainsFunc(existing, func(p partition) bool {
+			return p.overlaps(r.createdAt, r.createdAt.Add(time.Nanosecond))
+		}) {
+			continue
+		}
+
+		lower := r.createdAt.UTC().Truncate(step)
+		upper := lower.Add(step)
+		name, err := c.newPartitionName(lower, step)
+		if err != nil {
+			return err
+		}
+		if _, err := c.ensurePartition(ctx, name, lower, upper); err != nil {
+			return fmt.Errorf("creating partition %s: %w", name, err)
+		}
+		existing = append(existing, partition{name: name, from: lower, to: upper})
+	}
+	return nil
+}
+
+// uuidPattern matches a UUID in its canonical form.
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// parseRecord turns a submitted record into a row of the table, written at
+// now unless it has a time of its own, which must not be after latest.
+// Records that Postgres would reject are rejected here, so that a bad
+// record can never fail the batch it ends up in.
+func (c *Cleaner) parseRecord(record Record, now, latest time.Time) (logRow, error) {
+	r := logRow{createdAt: now, values: make([]any, len(c.columns))}
+
+	// Fields are checked in order for a stable error message
+	names := make([]string, 0, len(record))
+	for name := range record {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		raw := record[name]
+		if string(raw) == "null" {
+			continue
+		}
+
+		switch name {
+		case "message":
+			message, err := parseText(raw)
+			if err != nil {
+				return logRow{}, fmt.Errorf("message %w", err)
+			}
+			r.message = message
+		case c.opts.TimeColumn:
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return logRow{}, fmt.Errorf("%s must be an RFC 3339 timestamp string", name)
+			}
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return logRow{}, fmt.Errorf("%s must be an RFC 3339 timestamp, got %q", name, s)
+			}
+			if t.Before(c.cutoff()) {
+				return logRow{}, fmt.Errorf("%s %s is older than the maximum age of %s", name, s, c.opts.MaxAge)
+			}
+			if t.After(latest) {
+				return logRow{}, fmt.Errorf("%s %s is more than %s in the future", name, s, latest.Sub(now))
+			}
+			r.createdAt = t.UTC()
+		default:
+			n := slices.IndexFunc(c.columns, func(col Column) bool { return col.Name == name })
+			if n < 0 {
+				return logRow{}, fmt.Errorf("unknown field %q", name)
+			}
+			value, err := columnValue(c.columns[n].Type, raw)
+			if err != nil {
+				return logRow{}, fmt.Errorf("%s %w", name, err)
+			}
+			r.values[n] = value
+		}
+	}
+	return r, nil
+}
+
+// columnValue converts a JSON value to a value of a column of dataType, one
+// of ColumnTypes. Its error completes a sentence starting with the field.
+func columnValue(dataType string, raw json.RawMessage) (any, error) {
+	switch dataType {
+	case "integer":
+		var n int32
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return nil, errors.New("must be a 32-bit integer")
+		}
+		return n, nil
+	case "bigint":
+		var n int64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return nil, errors.New("must be a 64-bit integer")
+		}
+		return n, nil
+	case "boolean":
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return nil, errors.New("must be a boolean")
+		}
+		return b, nil
+	case "uuid":
+		s, err := parseText(raw)
+		if err == nil && !uuidPattern.MatchString(s) {
+			err = errors.New("must be a UUID")
+		}
+		return s, err
+	case "inet":
+		s, err := parseText(raw)
+		if err == nil && net.ParseIP(s) == nil {
+			if _, perr := netip.ParsePrefix(s); perr != nil {
+				err = errors.New("must be an IP address or network")
+			}
+		}
+		return s, err
+	case "jsonb":
+		// Postgres keeps no NUL characters in JSON strings either
+		if strings.Contains(string(raw), `\u0000`) {
+			return nil, errors.New("must not contain NUL characters")
+		}
+		return string(raw), nil
+	default:
+		return parseText(raw)
+	}
+}
+
+// parseText converts a JSON string to a text value, which Postgres accepts
+// without NUL characters only.
+func parseText(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", errors.New("must be a string")
+	}
+	if strings.ContainsRune(s, 0) {
+		return "", errors.New("must not contain NUL characters")
+	}
+	return s, nil
+}
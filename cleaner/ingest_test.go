@@ -0,0 +1,155 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newIngestCleaner returns a Cleaner ingesting into audit_logs with a few
+// extra columns, at the time of clock.
+func newIngestCleaner(clock Clock) *Cleaner {
+	return New(nil, Options{
+		Table:  "audit_logs",
+		Ingest: true,
+		MaxAge: 30 * 24 * time.Hour,
+		Clock:  clock,
+		Columns: []Column{
+			{Name: "method", Type: "text"},
+			{Name: "status", Type: "integer"},
+			{Name: "request_id", Type: "uuid"},
+			{Name: "client", Type: "inet"},
+			{Name: "details", Type: "jsonb"},
+		},
+	})
+}
+
+// record parses a record from JSON.
+func record(t *testing.T, s string) Record {
+	t.Helper()
+	var r Record
+	if err := json.Unmarshal([]byte(s), &r); err != nil {
+		t.Fatalf("parsing record %s: %v", s, err)
+	}
+	return r
+}
+
+func TestParseRecord(t *testing.T) {
+	now := utc(2024, 3, 1, 12, 0)
+	c := newIngestCleaner(newFakeClock(now))
+	latest := now.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		record   string
+		wantTime time.Time
+		wantErr  string // Empty when the record is valid
+	}{
+		{"time defaults to now", `{"message": "login"}`, now, ""},
+		{"explicit time", `{"created_at": "2024-03-01T11:30:00Z"}`, utc(2024, 3, 1, 11, 30), ""},
+		{"time with an offset", `{"created_at": "2024-03-01T12:30:00+01:00"}`, utc(2024, 3, 1, 11, 30), ""},
+		{"time at the future limit", `{"created_at": "2024-03-01T13:00:00Z"}`, latest, ""},
+		{"all columns", `{"method": "POST", "status": 201, "request_id": "0b5e3d52-8f0a-4c1e-9a57-2f0c6f3f1a2b", "client": "10.0.0.0/8", "details": {"a": 1}}`, now, ""},
+		{"nulls", `{"method": null, "created_at": null}`, now, ""},
+		{"time past the future limit", `{"created_at": "2024-03-01T13:00:01Z"}`, time.Time{}, "is more than 1h0m0s in the future"},
+		{"time past the maximum age", `{"created_at": "2024-01-01T00:00:00Z"}`, time.Time{}, "is older than the maximum age"},
+		{"time not RFC 3339", `{"created_at": "2024-03-01 12:00"}`, time.Time{}, "must be an RFC 3339 timestamp"},
+		{"unknown field", `{"verb": "GET"}`, time.Time{}, `unknown field "verb"`},
+		{"message not a string", `{"message": 42}`, time.Time{}, "message must be a string"},
+		{"NUL in a string", `{"method": "GE\u0000T"}`, time.Time{}, "method must not contain NUL characters"},
+		{"integer out of range", `{"status": 3000000000}`, time.Time{}, "status must be a 32-bit integer"},
+		{"invalid UUID", `{"request_id": "42"}`, time.Time{}, "request_id must be a UUID"},
+		{"invalid address", `{"client": "localhost"}`, time.Time{}, "client must be an IP address or network"},
+		{"NUL in JSON", `{"details": {"a": "\u0000"}}`, time.Time{}, "details must not contain NUL characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := c.parseRecord(record(t, tt.record), now, latest)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseRecord() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRecord() = %v, want no error", err)
+			}
+			if !r.createdAt.Equal(tt.wantTime) {
+				t.Errorf("created_at = %s, want %s", r.createdAt, tt.wantTime)
+			}
+		})
+	}
+}
+
+// hourlyRecords returns n records, one in each hour from start.
+func hourlyRecords(t *testing.T, start time.Time, n int) []Record {
+	records := make([]Record, n)
+	for i := range records {
+		records[i] = record(t, fmt.Sprintf(`{"created_at": %q}`, start.Add(time.Duration(i)*time.Hour).Format(time.RFC3339)))
+	}
+	return records
+}
+
+func TestSubmit(t *testing.T) {
+	now := utc(2024, 3, 1, 12, 0)
+	tests := []struct {
+		name      string
+		strategy  string
+		records   []Record
+		bufferMax int
+		wantErr   error
+	}{
+		{"within the partition limit", StrategyPartition, hourlyRecords(t, now.Add(-2*time.Hour), 3), 0, nil},
+		{"over the partition limit", StrategyPartition, hourlyRecords(t, now.Add(-4*time.Hour), 4), 0, ErrTooManyPartitions},
+		{"many rows in few partitions", StrategyPartition, append(hourlyRecords(t, now, 1), hourlyRecords(t, now, 1)...), 0, nil},
+		{"partitions are not created", StrategyDelete, hourlyRecords(t, now.Add(-10*time.Hour), 10), 0, nil},
+		{"buffer full", StrategyDelete, hourlyRecords(t, now.Add(-2*time.Hour), 3), 2, ErrBufferFull},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newIngestCleaner(newFakeClock(now))
+			c.strategy = tt.strategy
+			i := NewIngester(c, IngestOptions{PartitionStep: time.Hour, MaxPartitions: 3, BufferSize: tt.bufferMax})
+
+			err := i.Submit(tt.records)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("Submit() = %v, want %v", err, tt.wantErr)
+			}
+			wantBuffered := len(tt.records)
+			if err != nil {
+				wantBuffered = 0
+			}
+			if len(i.buffer) != wantBuffered {
+				t.Errorf("buffered %d records, want %d", len(i.buffer), wantBuffered)
+			}
+		})
+	}
+}
+
+func TestSubmitReportsInvalidRecords(t *testing.T) {
+	now := utc(2024, 3, 1, 12, 0)
+	i := NewIngester(newIngestCleaner(newFakeClock(now)), IngestOptions{})
+
+	err := i.Submit([]Record{
+		record(t, `{"message": "ok"}`),
+		record(t, `{"created_at": "2099-01-01T00:00:00Z"}`),
+		record(t, `{"status": "200"}`),
+	})
+	var invalid *InvalidRecordsError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Submit() = %v, want an *InvalidRecordsError", err)
+	}
+	var indexes []int
+	for _, r := range invalid.Records {
+		indexes = append(indexes, r.Index)
+	}
+	if fmt.Sprint(indexes) != "[1 2]" {
+		t.Errorf("invalid records %v, want [1 2]", indexes)
+	}
+	if len(i.buffer) != 0 {
+		t.Errorf("buffered %d records, want none", len(i.buffer))
+	}
+}
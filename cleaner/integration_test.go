@@ -0,0 +1,313 @@
+//go:build integration
+
+package cleaner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+)
+
+// The integration tests run the Cleaner against real Postgres servers,
+// started in containers, so they need Docker:
+//
+//	go test -tags integration ./cleaner
+//
+// They run against every major version in postgresVersions, which
+// INTEGRATION_POSTGRES_VERSIONS can replace with a comma-separated list.
+// BenchmarkInsertModes compares COPY and INSERT on the latest of them:
+//
+//	go test -tags integration -run '^$' -bench InsertModes ./cleaner
+var postgresVersions = []string{"13", "14", "15", "16"}
+
+// integrationNow is the time of the fake clock the tests start from.
+var integrationNow = time.Date(2024, 1, 15, 12, 30, 0, 0, time.UTC)
+
+func TestIntegration(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	versions := postgresVersions
+	if list := os.Getenv("INTEGRATION_POSTGRES_VERSIONS"); list != "" {
+		versions = strings.Split(list, ",")
+	}
+	for _, version := range versions {
+		t.Run("postgres "+version, func(t *testing.T) {
+			db := openDB(t, "postgres", startPostgres(t, "postgres:"+version+"-alpine"))
+			t.Run("cleanup drops expired partitions", func(t *testing.T) { testCleanupDropsExpired(t, db) })
+			t.Run("cutoff on a partition boundary", func(t *testing.T) { testCutoffOnBoundary(t, db) })
+			t.Run("no expired partitions", func(t *testing.T) { testNothingExpired(t, db) })
+			t.Run("racing partition creation", func(t *testing.T) { testRacingPremake(t, db) })
+		})
+	}
+}
+
+// startPostgres starts a Postgres server from image for the test and
+// returns its connection string.
+func startPostgres(tb testing.TB, image string) string {
+	tb.Helper()
+	ctr, err := postgres.Run(tb.Context(), image,
+		postgres.WithDatabase("audit"),
+		postgres.WithUsername("audit"),
+		postgres.WithPassword("audit"),
+		postgres.BasicWaitStrategies(),
+	)
+	testcontainers.CleanupContainer(tb, ctr)
+	if err != nil {
+		tb.Fatalf("starting %s: %v", image, err)
+	}
+
+	dsn, err := ctr.ConnectionString(tb.Context(), "sslmode=disable")
+	if err != nil {
+		tb.Fatalf("getting connection string: %v", err)
+	}
+	return dsn
+}
+
+// openDB opens a connection to dsn with the database/sql driver, "postgres"
+// for lib/pq or "pgx".
+func openDB(tb testing.TB, driver, dsn string) *sql.DB {
+	tb.Helper()
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		tb.Fatalf("opening database: %v", err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	return db
+}
+
+// skipWithoutDocker skips a benchmark when Docker is not available, like
+// testcontainers.SkipIfProviderIsNotHealthy does for tests.
+func skipWithoutDocker(b *testing.B) {
+	b.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			b.Skipf("Docker is not available: %v", r)
+		}
+	}()
+	provider, err := testcontainers.ProviderDocker.GetProvider()
+	if err == nil {
+		err = provider.Health(context.Background())
+	}
+	if err != nil {
+		b.Skipf("Docker is not available: %v", err)
+	}
+}
+
+// newIntegrationCleaner recreates audit_logs, range-partitioned on
+// created_at, and returns a prepared Cleaner for it whose clock starts at
+// integrationNow, together with the clock.
+func newIntegrationCleaner(t *testing.T, db *sql.DB, opts Options) (*Cleaner, *fakeClock) {
+	t.Helper()
+	ctx := t.Context()
+	_, err := db.ExecContext(ctx, `
+		DROP TABLE IF EXISTS audit_logs;
+		CREATE TABLE audit_logs (
+			id BIGSERIAL,
+			message TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL
+		) PARTITION BY RANGE (created_at);
+	`)
+	if err != nil {
+		t.Fatalf("creating table: %v", err)
+	}
+
+	clock := newFakeClock(integrationNow)
+	opts.Table = "audit_logs"
+	opts.Strategy = StrategyPartition
+	opts.PartitionGranularity = GranularityHour
+	opts.Clock = clock
+	c := New(db, opts)
+	if err := c.Prepare(ctx); err != nil {
+		t.Fatalf("Prepare() = %v", err)
+	}
+	return c, clock
+}
+
+// fillHours creates the hourly partitions from from up to to and writes
+// rowsPerHour(h) audit logs to the partition starting at hour h of the day,
+// spread over its hour, and returns the rows written by partition.
+func fillHours(t *testing.T, c *Cleaner, from, to time.Time, rowsPerHour func(hour int) int) map[string]int {
+	t.Helper()
+	ctx := t.Context()
+	if _, err := c.Backfill(ctx, from, to, time.Hour, 100); err != nil {
+		t.Fatalf("Backfill() = %v", err)
+	}
+
+	written := make(map[string]int)
+	for lower := from; lower.Before(to); lower = lower.Add(time.Hour) {
+		n := rowsPerHour(lower.Hour())
+		_, err := c.db.ExecContext(ctx, `
+			INSERT INTO audit_logs (message, created_at)
+			SELECT 'log ' || i, $1::timestamptz + (i * interval '1 hour') / $2::integer
+			FROM generate_series(0, $2::integer - 1) AS i
+		`, lower, n)
+		if err != nil {
+			t.Fatalf("inserting rows from %s: %v", lower.Format(time.RFC3339), err)
+		}
+		name, _, _, err := c.partitionFor(lower)
+		if err != nil {
+			t.Fatalf("naming partition: %v", err)
+		}
+		written[name] = n
+	}
+	return written
+}
+
+// rowsByPartition returns the number of rows in every partition of
+// audit_logs, including empty ones, by partition name.
+func rowsByPartition(t *testing.T, db *sql.DB) map[string]int {
+	t.Helper()
+	rows, err := db.QueryContext(t.Context(), `
+		SELECT c.relname, (SELECT count(*) FROM audit_logs l WHERE l.tableoid = c.oid)
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'audit_logs'::regclass
+	`)
+	if err != nil {
+		t.Fatalf("counting rows: %v", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			t.Fatalf("counting rows: %v", err)
+		}
+		counts[name] = count
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("counting rows: %v", err)
+	}
+	return counts
+}
+
+// checkCleanup runs a cleanup pass and checks that it drops exactly the
+// partitions dropped, with their rows, and leaves the others untouched.
+func checkCleanup(t *testing.T, c *Cleaner, written map[string]int, dropped ...string) {
+	t.Helper()
+	result, err := c.Cleanup(t.Context())
+	if err != nil {
+		t.Fatalf("Cleanup() = %v", err)
+	}
+
+	wantRows := 0
+	kept := maps.Clone(written)
+	for _, name := range dropped {
+		wantRows += written[name]
+		delete(kept, name)
+	}
+	if got := slices.Sorted(slices.Values(result.Partitions)); !slices.Equal(got, dropped) {
+		t.Errorf("Cleanup() dropped %v, want %v", got, dropped)
+	}
+	if result.Rows != wantRows {
+		t.Errorf("Cleanup() deleted %d rows, want %d", result.Rows, wantRows)
+	}
+	if got := rowsByPartition(t, c.db); !maps.Equal(got, kept) {
+		t.Errorf("partitions after cleanup = %v, want %v", got, kept)
+	}
+}
+
+func testCleanupDropsExpired(t *testing.T, db *sql.DB) {
+	c, _ := newIntegrationCleaner(t, db, Options{MaxAge: 3 * time.Hour})
+	written := fillHours(t, c, utc(2024, 1, 15, 6, 0), utc(2024, 1, 15, 14, 0),
+		func(hour int) int { return hour })
+
+	// The cutoff is 09:30, so the partition of 09:00 is kept for the rows
+	// after it
+	checkCleanup(t, c, written, "audit_logs_20240115_06", "audit_logs_20240115_07", "audit_logs_20240115_08")
+}
+
+func testCutoffOnBoundary(t *testing.T, db *sql.DB) {
+	c, clock := newIntegrationCleaner(t, db, Options{MaxAge: 3 * time.Hour})
+	written := fillHours(t, c, utc(2024, 1, 15, 6, 0), utc(2024, 1, 15, 14, 0),
+		func(hour int) int { return hour })
+
+	// With the cutoff at 10:00, the partition ending there holds only
+	// expired rows and the one starting there none
+	clock.Advance(30 * time.Minute)
+	checkCleanup(t, c, written, "audit_logs_20240115_06", "audit_logs_20240115_07",
+		"audit_logs_20240115_08", "audit_logs_20240115_09")
+}
+
+func testNothingExpired(t *testing.T, db *sql.DB) {
+	c, _ := newIntegrationCleaner(t, db, Options{MaxAge: 24 * time.Hour})
+	written := fillHours(t, c, utc(2024, 1, 15, 6, 0), utc(2024, 1, 15, 14, 0),
+		func(hour int) int { return hour })
+
+	checkCleanup(t, c, written)
+}
+
+func testRacingPremake(t *testing.T, db *sql.DB) {
+	// Two instances create the same partitions ahead of time at once
+	first, _ := newIntegrationCleaner(t, db, Options{PartitionPremake: 3})
+	second := New(db, first.opts)
+	if err := second.Prepare(t.Context()); err != nil {
+		t.Fatalf("Prepare() = %v", err)
+	}
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, c := range []*Cleaner{first, second} {
+		wg.Go(func() {
+			<-start
+			if _, err := c.premakePartitions(t.Context()); err != nil {
+				t.Errorf("premakePartitions() = %v", err)
+			}
+		})
+	}
+	close(start)
+	wg.Wait()
+
+	want := map[string]int{
+		"audit_logs_20240115_12": 0,
+		"audit_logs_20240115_13": 0,
+		"audit_logs_20240115_14": 0,
+		"audit_logs_20240115_15": 0,
+	}
+	if got := rowsByPartition(t, db); !maps.Equal(got, want) {
+		t.Errorf("partitions after racing premakePartitions = %v, want %v", got, want)
+	}
+}
+
+// BenchmarkInsertModes writes batches of 10000 audit logs with COPY and
+// with INSERT, through both drivers, into a plain table. Chunks are
+// committed one by one, as by default.
+func BenchmarkInsertModes(b *testing.B) {
+	skipWithoutDocker(b)
+	dsn := startPostgres(b, "postgres:"+postgresVersions[len(postgresVersions)-1]+"-alpine")
+	rows := testRows(10000, integrationNow)
+
+	for _, driver := range []string{DriverPQ, DriverPGX} {
+		sqlDriver := "postgres"
+		if driver == DriverPGX {
+			sqlDriver = "pgx"
+		}
+		db := openDB(b, sqlDriver, dsn)
+		for _, mode := range []string{InsertInsert, InsertCopy} {
+			b.Run(fmt.Sprintf("%s/%s", driver, mode), func(b *testing.B) {
+				c := New(db, Options{Table: "audit_logs", Driver: driver, InsertMode: mode, Generate: true, Reset: true})
+				if err := c.Prepare(b.Context()); err != nil {
+					b.Fatalf("Prepare() = %v", err)
+				}
+				b.ReportMetric(float64(len(rows)), "rows/op")
+				for b.Loop() {
+					if _, err := c.postToDB(b.Context(), rows); err != nil {
+						b.Fatalf("postToDB() = %v", err)
+					}
+				}
+			})
+		}
+	}
+}
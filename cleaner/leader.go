@@ -0,0 +1,152 @@
+package cleaner
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Elector elects a single leader among the instances sharing a database,
+// with a session-level advisory lock held on a dedicated connection. The
+// lock is released as soon as the leader's session ends, e.g. because its
+// process or connection died, so that a standby can take over.
+type Elector struct {
+	db       *sql.DB
+	name     string
+	interval time.Duration
+	leader   atomic.Bool
+}
+
+// NewElector returns an Elector campaigning for the lock called name. The
+// leader checks its session, and standbys retry the lock, every interval.
+func NewElector(db *sql.DB, name string, interval time.Duration) *Elector {
+	return &Elector{db: db, name: name, interval: interval}
+}
+
+// Leader reports whether this instance currently holds the leadership.
+func (e *Elector) Leader() bool {
+	return e.leader.Load()
+}
+
+// Run campaigns for the leadership until ctx is done. Whenever it is won,
+// lead is called with a context that is canceled as soon as the leadership
+// is lost, and Run waits for lead to return before campaigning again.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) {
+	waiting := false
+	for {
+		conn, err := e.acquire(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			slog.Warn("leader election failed, retrying", "error", err)
+		case conn == nil:
+			if !waiting {
+				slog.Info("another instance is the leader, standing by")
+				waiting = true
+			}
+		default:
+			waiting = false
+			e.hold(ctx, conn, lead)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+// acquire tries to take the leader lock on a new connection and returns the
+// connection holding it, or nil if another session holds the lock.
+func (e *Elector) acquire(ctx context.Context) (*sql.Conn, error) {
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, e.name).Scan(&ok)
+	if err != nil || !ok {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// hold runs lead while conn's session, and with it the lock, stays alive.
+// A failed ping means the session may be gone and another instance may
+// already lead, so lead is stopped right away.
+func (e *Elector) hold(ctx context.Context, conn *sql.Conn, lead func(ctx context.Context)) {
+	e.promote()
+	leadCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leadCtx)
+	}()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	var lost error
+watch:
+	for {
+		select {
+		case <-ctx.Done():
+			break watch
+		case <-done:
+			break watch
+		case <-ticker.C:
+			pingCtx, cancelPing := context.WithTimeout(ctx, pingTimeout)
+			err := conn.PingContext(pingCtx)
+			cancelPing()
+			if err != nil && ctx.Err() == nil {
+				lost = err
+				break watch
+			}
+		}
+	}
+
+	if lost != nil {
+		slog.Error("lost leadership, stopping", "error", lost)
+	}
+	cancel()
+	<-done
+	e.demote()
+	e.release(conn, lost != nil)
+}
+
+// release gives up the lock. A connection that failed its ping is discarded
+// instead, which ends its session if it is still alive.
+func (e *Elector) release(conn *sql.Conn, broken bool) {
+	defer conn.Close()
+	if !broken {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		defer cancel()
+		_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext($1))`, e.name)
+		if err == nil {
+			return
+		}
+		slog.Warn("failed to release leader lock, closing its connection", "error", err)
+	}
+	conn.Raw(func(any) error { return driver.ErrBadConn })
+}
+
+func (e *Elector) promote() {
+	e.leader.Store(true)
+	isLeader.Set(1)
+	leadershipChanges.Inc()
+	slog.Info("acquired leadership, starting routines")
+}
+
+func (e *Elector) demote() {
+	e.leader.Store(false)
+	isLeader.Set(0)
+	leadershipChanges.Inc()
+	slog.Info("leadership released")
+}
@@ -0,0 +1,47 @@
+package cleaner
+
+import (
+	"context"
+	"database/sql/driver"
+	"log/slog"
+)
+
+// lockName is the name of the table's cleanup advisory lock, hashed into the
+// lock key. Instances cleaning up the same table share it; different tables
+// never contend.
+func (c *Cleaner) lockName() string {
+	return "auditlog-cleaner:" + c.opts.Table
+}
+
+// tryLock takes the table's cleanup advisory lock without waiting and
+// reports whether it got it. Advisory locks belong to a session, so the lock
+// is held on a dedicated connection until release is called.
+func (c *Cleaner) tryLock(ctx context.Context) (release func(), ok bool, err error) {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, c.lockName()).Scan(&ok)
+	if err != nil || !ok {
+		conn.Close()
+		return nil, false, err
+	}
+
+	release = func() {
+		// ctx may already be cancelled at shutdown, which must not keep
+		// the lock held
+		unlockCtx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		defer cancel()
+
+		_, err := conn.ExecContext(unlockCtx, `SELECT pg_advisory_unlock(hashtext($1))`, c.lockName())
+		if err != nil {
+			// Discarding the connection ends the session, which releases
+			// the lock as well
+			slog.Warn("failed to release cleanup lock, closing its connection", "table", c.opts.Table, "error", err)
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}
+	return release, true, nil
+}
@@ -0,0 +1,51 @@
+package cleaner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const (
+	tryLockQuery = `SELECT pg_try_advisory_lock(hashtext($1))`
+	unlockQuery  = `SELECT pg_advisory_unlock(hashtext($1))`
+)
+
+func TestTryLock(t *testing.T) {
+	c, mock := newMockCleaner(t, Options{})
+	mock.ExpectQuery(tryLockQuery).WithArgs("auditlog-cleaner:audit_logs").
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectExec(unlockQuery).WithArgs("auditlog-cleaner:audit_logs").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	release, ok, err := c.tryLock(t.Context())
+	if err != nil || !ok {
+		t.Fatalf("tryLock() = %t, %v, want the lock", ok, err)
+	}
+	release()
+}
+
+func TestCleanupLockHeld(t *testing.T) {
+	c, mock := newMockCleaner(t, Options{MaxAge: time.Hour})
+	skipped := func() float64 { return testutil.ToFloat64(cleanupSkipped.WithLabelValues("audit_logs")) }
+	before := skipped()
+
+	// Another instance holds the lock, so the pass deletes nothing
+	mock.ExpectQuery(tableExistsQuery).WithArgs("audit_logs").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(tryLockQuery).WithArgs("auditlog-cleaner:audit_logs").
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+
+	result, err := c.Cleanup(t.Context())
+	if err != nil {
+		t.Fatalf("Cleanup() = %v, want nil while the lock is held", err)
+	}
+	if !result.Skipped || result.Rows != 0 {
+		t.Errorf("Cleanup() = %+v, want a skipped pass", result)
+	}
+	if got := skipped() - before; got != 1 {
+		t.Errorf("counted %v skipped passes, want 1", got)
+	}
+}
@@ -0,0 +1,152 @@
+package cleaner
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// maintainedPartitions is how many of the newest partitions a maintenance
+// pass analyzes; they take the writes, so their statistics go stale first.
+const maintainedPartitions = 2
+
+// RunMaintenance runs a maintenance pass every MaintenanceInterval until ctx
+// is cancelled, then returns ctx's error. Failed passes are logged, not
+// returned, and retried after a backoff that grows with every failure in a
+// row.
+func (c *Cleaner) RunMaintenance(ctx context.Context) error {
+	if c.opts.MaintenanceInterval <= 0 {
+		return fmt.Errorf("maintenance interval must be greater than 0, got %s", c.opts.MaintenanceInterval)
+	}
+
+	failures := failures{routine: "maintenance"}
+	ticker := c.opts.Clock.NewTicker(c.opts.MaintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+		}
+
+		err := c.Maintain(ctx)
+		switch {
+		case err == nil:
+			if failures.count > 0 {
+				ticker.Reset(c.opts.MaintenanceInterval)
+			}
+			c.succeeded(&failures)
+		case ctx.Err() == nil:
+			slog.Error("maintenance failed", "table", c.opts.Table, "error", err)
+			c.checkConnection(ctx, err)
+			ticker.Reset(c.failed(&failures, err, c.opts.MaintenanceInterval))
+		}
+	}
+}
+
+// Maintain refreshes the statistics of the table and of its newest
+// partitions with ANALYZE, and logs their dead tuples and exports them as a
+// metric. Dropping partitions makes VACUUM unnecessary for them, and the
+// system catalogs bloated by creating and dropping partitions can only be
+// vacuumed by their owner. The statements run outside a transaction on a
+// dedicated connection. A dry run only reports the dead tuples.
+func (c *Cleaner) Maintain(ctx context.Context) error {
+	start := time.Now()
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	targets := []string{c.ident}
+	if c.strategy == StrategyPartition && c.opts.Storage != StorageTimescale {
+		partitions, err := c.listPartitions(ctx)
+		if err != nil {
+			return fmt.Errorf("listing partitions: %w", err)
+		}
+		// Oldest first; a partition up to MAXVALUE, whose to is zero, last
+		slices.SortFunc(partitions, func(a, b partition) int {
+			switch {
+			case a.to.Equal(b.to):
+				return 0
+			case a.to.IsZero():
+				return 1
+			case b.to.IsZero():
+				return -1
+			default:
+				return a.to.Compare(b.to)
+			}
+		})
+		for _, p := range partitions[max(0, len(partitions)-maintainedPartitions):] {
+			targets = append(targets, p.ident)
+		}
+	}
+
+	for _, target := range targets {
+		if c.opts.DryRun {
+			slog.Info("[DRY RUN] would analyze", "table", c.opts.Table, "target", target)
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, `ANALYZE `+target); err != nil {
+			return fmt.Errorf("analyzing %s: %w", target, err)
+		}
+	}
+
+	dead, err := c.reportDeadTuples(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("reading dead tuples: %w", err)
+	}
+
+	maintenanceDuration.WithLabelValues(c.opts.Table).Set(time.Since(start).Seconds())
+	slog.Info("maintenance finished", "table", c.opts.Table, "analyzed", len(targets),
+		"dead_tuples", dead, "duration", time.Since(start))
+	return nil
+}
+
+// reportDeadTuples logs the dead tuples of the table and each of its
+// partitions that has any, as counted by the statistics collector, exports
+// their total as a metric and returns it.
+func (c *Cleaner) reportDeadTuples(ctx context.Context, conn *sql.Conn) (int64, error) {
+	query := `
+		SELECT s.relname, s.n_live_tup, s.n_dead_tup
+		FROM pg_stat_user_tables s
+		WHERE s.schemaname = current_schema()
+		  AND (s.relname = $1 OR s.relid IN (
+			SELECT i.inhrelid
+			FROM pg_inherits i
+			JOIN pg_class parent ON parent.oid = i.inhparent
+			JOIN pg_namespace pn ON pn.oid = parent.relnamespace
+			WHERE parent.relname = $1 AND pn.nspname = current_schema()
+		  ))
+		ORDER BY s.relname
+	`
+
+	rows, err := conn.QueryContext(ctx, query, c.opts.Table)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var total int64
+	for rows.Next() {
+		var name string
+		var live, dead int64
+		if err := rows.Scan(&name, &live, &dead); err != nil {
+			return 0, err
+		}
+		total += dead
+		if dead > 0 {
+			slog.Info("dead tuples", "table", c.opts.Table, "relation", name, "live", live, "dead", dead)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	deadTuples.WithLabelValues(c.opts.Table).Set(float64(total))
+	return total, nil
+}
@@ -0,0 +1,105 @@
+package cleaner
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	logsInserted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auditlog_cleaner_logs_inserted_total",
+		Help: "Total number of audit logs inserted.",
+	})
+	insertFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auditlog_cleaner_insert_failures_total",
+		Help: "Total number of failed audit log inserts.",
+	})
+	insertBackoff = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auditlog_cleaner_insert_backoff",
+		Help: "Whether the generator is backing off after failed inserts (1) or inserting every interval (0), per table.",
+	}, []string{"table"})
+	insertBackoffTrips = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auditlog_cleaner_insert_backoff_trips_total",
+		Help: "Total number of times the generator started backing off after failed inserts, per table.",
+	}, []string{"table"})
+	insertWait = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auditlog_cleaner_insert_wait_seconds",
+		Help: "Wait before the generator's next insert, longer than the insert interval while backing off, per table.",
+	}, []string{"table"})
+	routineErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auditlog_cleaner_routine_errors_total",
+		Help: "Total number of failed insert, cleanup and maintenance runs, per table, routine and error class.",
+	}, []string{"table", "routine", "class"})
+	insertPrepares = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auditlog_cleaner_insert_statements_prepared_total",
+		Help: "Total number of INSERT statements prepared and cached for reuse across batches.",
+	})
+	recordsDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auditlog_cleaner_records_deleted_total",
+		Help: "Total number of expired rows deleted, per table.",
+	}, []string{"table"})
+	partitionsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auditlog_cleaner_partitions_created_total",
+		Help: "Total number of partitions created, per table.",
+	}, []string{"table"})
+	cleanupSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auditlog_cleaner_cleanup_skipped_total",
+		Help: "Total number of cleanup runs skipped because another instance held the table's lock, per table.",
+	}, []string{"table"})
+	cleanupOverrunCycles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auditlog_cleaner_cleanup_overrun_cycles_total",
+		Help: "Total number of cleanup cycles that came due while a run overran the cleanup interval, and so never ran, per table.",
+	}, []string{"table"})
+	partitionsHeld = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auditlog_cleaner_partitions_held",
+		Help: "Number of partitions whose range overlaps a hold, which keeps them from being dropped, per table.",
+	}, []string{"table"})
+	cleanupPaused = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auditlog_cleaner_cleanup_paused",
+		Help: "Whether cleanup is paused (1) or running (0), per table.",
+	}, []string{"table"})
+	cleanupDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auditlog_cleaner_cleanup_duration_seconds",
+		Help: "Duration of the most recent cleanup run, per table.",
+	}, []string{"table"})
+	maintenanceDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auditlog_cleaner_maintenance_duration_seconds",
+		Help: "Duration of the most recent maintenance pass, per table.",
+	}, []string{"table"})
+	deadTuples = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auditlog_cleaner_dead_tuples",
+		Help: "Dead tuples in the table and its partitions at the most recent maintenance pass, per table.",
+	}, []string{"table"})
+	tableRows = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auditlog_cleaner_table_rows",
+		Help: "Approximate number of rows after the most recent cleanup run, per table.",
+	}, []string{"table"})
+	tableBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auditlog_cleaner_table_bytes",
+		Help: "Total size including indexes after the most recent cleanup run, per table.",
+	}, []string{"table"})
+	tablePartitions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auditlog_cleaner_table_partitions",
+		Help: "Number of partitions after the most recent cleanup run, per table.",
+	}, []string{"table"})
+	isLeader = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "auditlog_cleaner_leader",
+		Help: "1 while this instance holds the leadership with leader election enabled, 0 otherwise.",
+	})
+	leadershipChanges = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auditlog_cleaner_leadership_changes_total",
+		Help: "Total number of times this instance acquired or gave up the leadership.",
+	})
+	ingestBuffered = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "auditlog_cleaner_ingest_buffered",
+		Help: "Number of ingested audit logs waiting in the buffer to be written.",
+	})
+	ingestRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auditlog_cleaner_ingest_rejected_total",
+		Help: "Total number of submitted audit logs rejected, per reason: invalid, partitions, full or closed.",
+	}, []string{"reason"})
+	defaultPartitionRows = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auditlog_cleaner_default_partition_rows",
+		Help: "Number of rows left in the default partition after the most recent cleanup run, per table.",
+	}, []string{"table"})
+)
@@ -0,0 +1,192 @@
+package cleaner
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Placeholders of Options.PartitionTemplate.
+const (
+	placeholderTable       = "{table}"
+	placeholderGranularity = "{granularity}"
+	placeholderTime        = "{time}"
+)
+
+// placeholderPattern matches the placeholders of a partition name template.
+var placeholderPattern = regexp.MustCompile(`\{[^}]*\}`)
+
+// Partition granularities are the ranges of the partitions the Cleaner
+// creates for a table.
+const (
+	GranularityMinute = "minute"
+	GranularityHour   = "hour"
+	GranularityDay    = "day"
+)
+
+// granularities holds the range of the partitions of each granularity and
+// the layout that formats the start of their range in their names.
+var granularities = map[string]struct {
+	step   time.Duration
+	layout string
+}{
+	GranularityMinute: {time.Minute, "20060102_1504"},
+	GranularityHour:   {time.Hour, "20060102_15"},
+	GranularityDay:    {24 * time.Hour, "20060102"},
+}
+
+// GranularityStep returns the range of the partitions of granularity, and
+// whether it is a partition granularity at all.
+func GranularityStep(granularity string) (time.Duration, bool) {
+	g, ok := granularities[granularity]
+	return g.step, ok
+}
+
+// partitionNameLayouts format the start of a partition's range in its name
+// unless PartitionTimeLayout is set: to the minute, or to the second for
+// steps below a minute.
+var partitionNameLayouts = []string{"20060102_1504", "20060102_150405"}
+
+// granularity renders step in its largest whole unit, e.g. 1d, 6h, 30m or
+// 10s, for the {granularity} placeholder.
+func granularity(step time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case step >= day && step%day == 0:
+		return fmt.Sprintf("%dd", step/day)
+	case step >= time.Hour && step%time.Hour == 0:
+		return fmt.Sprintf("%dh", step/time.Hour)
+	case step >= time.Minute && step%time.Minute == 0:
+		return fmt.Sprintf("%dm", step/time.Minute)
+	}
+	return fmt.Sprintf("%ds", step/time.Second)
+}
+
+// timeLayouts returns the layouts the start of a partition's range may be
+// formatted with in its name.
+func (o Options) timeLayouts() []string {
+	if o.PartitionTimeLayout != "" {
+		return []string{o.PartitionTimeLayout}
+	}
+	if g, ok := granularities[o.PartitionGranularity]; ok {
+		return append([]string{g.layout}, partitionNameLayouts...)
+	}
+	return partitionNameLayouts
+}
+
+// partitionName names the partition of step starting at lower after
+// PartitionTemplate, like audit_logs_20240115_1200.
+func (o Options) partitionName(lower time.Time, step time.Duration) string {
+	layout := o.PartitionTimeLayout
+	if g, ok := granularities[o.PartitionGranularity]; ok && layout == "" {
+		layout = g.layout
+	}
+	if layout == "" {
+		layout = partitionNameLayouts[0]
+		if step < time.Minute {
+			layout = partitionNameLayouts[1]
+		}
+	}
+	return strings.NewReplacer(
+		placeholderTable, o.Table,
+		placeholderGranularity, granularity(step),
+		placeholderTime, lower.UTC().Format(layout),
+	).Replace(o.PartitionTemplate)
+}
+
+// parsePartitionName reads the start of a partition's range back from its
+// name, and reports whether the name follows PartitionTemplate at all.
+func (o Options) parsePartitionName(name string) (time.Time, bool) {
+	template := o.PartitionTemplate
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	prev := 0
+	for _, loc := range placeholderPattern.FindAllStringIndex(template, -1) {
+		pattern.WriteString(regexp.QuoteMeta(template[prev:loc[0]]))
+		switch template[loc[0]:loc[1]] {
+		case placeholderTable:
+			pattern.WriteString(regexp.QuoteMeta(o.Table))
+		case placeholderGranularity:
+			pattern.WriteString(`[0-9]+[dhms]`)
+		case placeholderTime:
+			pattern.WriteString(`(.+)`)
+		}
+		prev = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(template[prev:]) + "$")
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return time.Time{}, false
+	}
+	m := re.FindStringSubmatch(name)
+	if len(m) != 2 {
+		return time.Time{}, false
+	}
+	for _, layout := range o.timeLayouts() {
+		if t, err := time.Parse(layout, m[1]); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// validatePartitionTemplate checks that PartitionTemplate names the start
+// of each partition's range once and only yields valid identifiers.
+func (o Options) validatePartitionTemplate() error {
+	if strings.Count(o.PartitionTemplate, placeholderTime) != 1 {
+		return fmt.Errorf("partition name template %q of table %s must contain %s exactly once",
+			o.PartitionTemplate, o.Table, placeholderTime)
+	}
+	for _, p := range placeholderPattern.FindAllString(o.PartitionTemplate, -1) {
+		if p != placeholderTable && p != placeholderGranularity && p != placeholderTime {
+			return fmt.Errorf("partition name template %q of table %s has unknown placeholder %s (%s, %s and %s are supported)",
+				o.PartitionTemplate, o.Table, p, placeholderTable, placeholderGranularity, placeholderTime)
+		}
+	}
+
+	// A day and a second are the steps with the longest and the most
+	// detailed names under the default layouts
+	sample := time.Date(2024, 1, 15, 12, 30, 45, 0, time.UTC)
+	for _, step := range []time.Duration{24 * time.Hour, time.Second} {
+		if name := o.partitionName(sample.Truncate(step), step); !ValidIdentifier(name) {
+			return fmt.Errorf("partition name template %q of table %s yields names like %q, which are not valid (lowercase letters, digits and underscores only, at most %d characters)",
+				o.PartitionTemplate, o.Table, name, maxIdentifierLength)
+		}
+	}
+	return nil
+}
+
+// newPartitionName names a partition the Cleaner is about to create, after
+// checking that the name is a valid identifier and reads back to lower, so
+// that partitions of step never share a name.
+func (c *Cleaner) newPartitionName(lower time.Time, step time.Duration) (string, error) {
+	name := c.opts.partitionName(lower, step)
+	if !ValidIdentifier(name) {
+		return "", fmt.Errorf("partition name %s is not valid (lowercase letters, digits and underscores only, at most %d characters)",
+			name, maxIdentifierLength)
+	}
+	if start, ok := c.opts.parsePartitionName(name); !ok || !start.Equal(lower.UTC()) {
+		return "", fmt.Errorf("partition name %s does not tell apart partitions of %s starting at %s; its time layout is too coarse",
+			name, step, lower.UTC().Format(time.RFC3339))
+	}
+	return name, nil
+}
+
+// partitionFor returns the name and range of the partition of
+// PartitionGranularity that holds t, e.g. audit_logs_20240115_12 for
+// [12:00, 13:00) with GranularityHour.
+func (c *Cleaner) partitionFor(t time.Time) (name string, from, to time.Time, err error) {
+	step := granularities[c.opts.PartitionGranularity].step
+	from = t.UTC().Truncate(step)
+	name, err = c.newPartitionName(from, step)
+	return name, from, from.Add(step), err
+}
+
+// namedPartition reports whether name follows PartitionTemplate for the
+// table.
+func (c *Cleaner) namedPartition(name string) bool {
+	_, ok := c.opts.parsePartitionName(name)
+	return ok
+}
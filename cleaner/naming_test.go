@@ -0,0 +1,168 @@
+package cleaner
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGranularity(t *testing.T) {
+	tests := []struct {
+		step time.Duration
+		want string
+	}{
+		{24 * time.Hour, "1d"},
+		{7 * 24 * time.Hour, "7d"},
+		{36 * time.Hour, "36h"},
+		{6 * time.Hour, "6h"},
+		{time.Hour, "1h"},
+		{90 * time.Minute, "90m"},
+		{time.Minute, "1m"},
+		{90 * time.Second, "90s"},
+		{10 * time.Second, "10s"},
+	}
+	for _, tt := range tests {
+		if got := granularity(tt.step); got != tt.want {
+			t.Errorf("granularity(%s) = %q, want %q", tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestPartitionFor(t *testing.T) {
+	at := time.Date(2024, 1, 15, 12, 34, 56, 0, time.UTC)
+	tests := []struct {
+		granularity string
+		opts        Options
+		wantName    string
+		wantSQL     string
+	}{
+		{GranularityMinute, Options{}, "audit_logs_20240115_1234",
+			`CREATE TABLE IF NOT EXISTS "audit_logs_20240115_1234" PARTITION OF "audit_logs" FOR VALUES FROM ('2024-01-15T12:34:00Z') TO ('2024-01-15T12:35:00Z')`},
+		{GranularityHour, Options{}, "audit_logs_20240115_12",
+			`CREATE TABLE IF NOT EXISTS "audit_logs_20240115_12" PARTITION OF "audit_logs" FOR VALUES FROM ('2024-01-15T12:00:00Z') TO ('2024-01-15T13:00:00Z')`},
+		{GranularityDay, Options{}, "audit_logs_20240115",
+			`CREATE TABLE IF NOT EXISTS "audit_logs_20240115" PARTITION OF "audit_logs" FOR VALUES FROM ('2024-01-15T00:00:00Z') TO ('2024-01-16T00:00:00Z')`},
+		{GranularityHour, Options{PartitionTimeLayout: "2006010215"}, "audit_logs_2024011512",
+			`CREATE TABLE IF NOT EXISTS "audit_logs_2024011512" PARTITION OF "audit_logs" FOR VALUES FROM ('2024-01-15T12:00:00Z') TO ('2024-01-15T13:00:00Z')`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.granularity+tt.opts.PartitionTimeLayout, func(t *testing.T) {
+			tt.opts.Table = "audit_logs"
+			tt.opts.PartitionGranularity = tt.granularity
+			c := New(nil, tt.opts)
+
+			name, from, to, err := c.partitionFor(at)
+			if err != nil {
+				t.Fatalf("partitionFor() = %v", err)
+			}
+			if name != tt.wantName {
+				t.Errorf("partitionFor() name = %q, want %q", name, tt.wantName)
+			}
+			if got := c.partitionStatement(name, from, to); got != tt.wantSQL {
+				t.Errorf("partitionStatement() =\n%s\nwant\n%s", got, tt.wantSQL)
+			}
+		})
+	}
+}
+
+func TestPartitionName(t *testing.T) {
+	lower := time.Date(2024, 1, 15, 12, 30, 45, 0, time.UTC)
+	tests := []struct {
+		name   string
+		opts   Options
+		lower  time.Time
+		step   time.Duration
+		want   string
+		wantOK bool // Whether the name reads back to lower
+	}{
+		{"default", Options{}, lower.Truncate(time.Minute), time.Minute, "audit_logs_20240115_1230", true},
+		{"seconds", Options{}, lower.Truncate(10 * time.Second), 10 * time.Second, "audit_logs_20240115_123040", true},
+		{"prefix", Options{PartitionPrefix: "p_"}, lower.Truncate(time.Hour), time.Hour, "p_20240115_1200", true},
+		{"template", Options{PartitionTemplate: "{table}_{granularity}_{time}"}, lower.Truncate(24 * time.Hour), 24 * time.Hour, "audit_logs_1d_20240115_0000", true},
+		{"time first", Options{PartitionTemplate: "p{time}_{table}"}, lower.Truncate(time.Hour), time.Hour, "p20240115_1200_audit_logs", true},
+		{"day layout", Options{PartitionTimeLayout: "20060102"}, lower.Truncate(24 * time.Hour), 24 * time.Hour, "audit_logs_20240115", true},
+		{"other zone", Options{}, time.Date(2024, 1, 15, 13, 0, 0, 0, time.FixedZone("CET", 3600)), time.Hour, "audit_logs_20240115_1200", true},
+		{"layout too coarse", Options{PartitionTimeLayout: "20060102"}, lower.Truncate(time.Hour), time.Hour, "audit_logs_20240115", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Table = "audit_logs"
+			opts := tt.opts.withDefaults()
+
+			name := opts.partitionName(tt.lower, tt.step)
+			if name != tt.want {
+				t.Fatalf("partitionName() = %q, want %q", name, tt.want)
+			}
+			start, ok := opts.parsePartitionName(name)
+			if !ok {
+				t.Fatalf("parsePartitionName(%q) does not match the template", name)
+			}
+			if got := start.Equal(tt.lower); got != tt.wantOK {
+				t.Errorf("parsePartitionName(%q) = %s, reads back to %s: %t, want %t", name, start, tt.lower, got, tt.wantOK)
+			}
+
+			c := New(nil, opts)
+			if _, err := c.newPartitionName(tt.lower, tt.step); (err == nil) != tt.wantOK {
+				t.Errorf("newPartitionName() error = %v, want error %t", err, !tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParsePartitionName(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     time.Time // Zero when the name does not follow the template
+	}{
+		{"audit_logs_20240115_1230", "", utc(2024, 1, 15, 12, 30)},
+		{"audit_logs_20240115_123045", "", utc(2024, 1, 15, 12, 30).Add(45 * time.Second)},
+		{"audit_logs_default", "", time.Time{}},
+		{"audit_logs_20241315_1230", "", time.Time{}},
+		{"audit_logs_archive_20240115_1230", "", time.Time{}},
+		{"other_logs_20240115_1230", "", time.Time{}},
+		{"audit_logs_6h_20240115_1200", "{table}_{granularity}_{time}", utc(2024, 1, 15, 12, 0)},
+		{"audit_logs_6x_20240115_1200", "{table}_{granularity}_{time}", time.Time{}},
+		{"audit_logs_20240115_1200", "{table}_{granularity}_{time}", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{Table: "audit_logs", PartitionTemplate: tt.template}.withDefaults()
+			got, ok := opts.parsePartitionName(tt.name)
+			if ok != !tt.want.IsZero() || !got.Equal(tt.want) {
+				t.Errorf("parsePartitionName(%q) = %s, %t, want %s", tt.name, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePartitionTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  string // Empty when the template is valid
+	}{
+		{"{table}_{time}", ""},
+		{"{table}_{granularity}_{time}", ""},
+		{"archive_{time}", ""},
+		{"{table}", "must contain {time} exactly once"},
+		{"{time}_{time}", "must contain {time} exactly once"},
+		{"{table}_{date}_{time}", "unknown placeholder {date}"},
+		{"{table}-{time}", "which are not valid"},
+		{strings.Repeat("x", 60) + "_{time}", "which are not valid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			opts := Options{Table: "audit_logs", PartitionTemplate: tt.template}.withDefaults()
+			err := opts.validatePartitionTemplate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validatePartitionTemplate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validatePartitionTemplate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
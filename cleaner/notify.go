@@ -0,0 +1,207 @@
+package cleaner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event types sent to a Notifier.
+const (
+	EventCleanupFailed    = "cleanup_failed"
+	EventArchiveFailed    = "archive_failed"
+	EventPartitionDropped = "partition_dropped" // Only with Options.NotifyOnSuccess
+)
+
+// Event is something a Cleaner did that someone may want to hear about
+// without tailing its logs.
+type Event struct {
+	Type      string    `json:"event"`
+	Table     string    `json:"table"`
+	Partition string    `json:"partition,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"timestamp"`
+	Host      string    `json:"host"`
+}
+
+// Notifier delivers events, e.g. to a chat channel.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// hostname names this host in events.
+var hostname = sync.OnceValue(func() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+})
+
+// notify hands an event to the configured Notifier, if any. Delivery
+// failures are logged, never returned.
+func (c *Cleaner) notify(ctx context.Context, e Event) {
+	if c.opts.Notifier == nil {
+		return
+	}
+	e.Table = c.opts.Table
+	e.Time = c.opts.Clock.Now().UTC()
+	e.Host = hostname()
+	if err := c.opts.Notifier.Notify(context.WithoutCancel(ctx), e); err != nil {
+		slog.Error("failed to send notification", "table", c.opts.Table, "event", e.Type, "error", err)
+	}
+}
+
+// Webhook delivery is retried this many times on server errors, with
+// exponential backoff from webhookBaseDelay.
+const (
+	webhookAttempts  = 3
+	webhookBaseDelay = time.Second
+)
+
+// WebhookNotifier POSTs each event as JSON to a URL.
+type WebhookNotifier struct {
+	url     string
+	payload func(Event) any
+	client  *http.Client
+}
+
+// NewWebhookNotifier returns a Notifier that posts events as they are,
+// e.g. {"event": "cleanup_failed", "table": "audit_logs", ...}.
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:     url,
+		payload: func(e Event) any { return e },
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// NewSlackNotifier returns a Notifier that posts events to a Slack incoming
+// webhook as a short text message.
+func NewSlackNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:     url,
+		payload: func(e Event) any { return map[string]string{"text": slackText(e)} },
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// slackText renders an event for a Slack message.
+func slackText(e Event) string {
+	text := fmt.Sprintf("*%s* on `%s`", e.Type, e.Table)
+	if e.Partition != "" {
+		text += fmt.Sprintf(", partition `%s`", e.Partition)
+	}
+	text += fmt.Sprintf(" (host %s, %s)", e.Host, e.Time.Format(time.RFC3339))
+	if e.Error != "" {
+		text += "\n```" + e.Error + "```"
+	}
+	return text
+}
+
+// Notify posts e, retrying server errors and connection failures with
+// backoff until ctx is done.
+func (w *WebhookNotifier) Notify(ctx context.Context, e Event) error {
+	body, err := json.Marshal(w.payload(e))
+	if err != nil {
+		return err
+	}
+
+	delay := webhookBaseDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, body)
+		if err == nil || !retry || attempt >= webhookAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post sends body once and reports whether a failure is worth retrying.
+func (w *WebhookNotifier) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return false, nil
+}
+
+// notifyTimeout caps the time spent delivering a single event.
+const notifyTimeout = 30 * time.Second
+
+// AsyncNotifier queues events for delivery in the background, so that a
+// slow or failing Notifier never holds up cleanup. Events that arrive while
+// the queue is full are dropped.
+type AsyncNotifier struct {
+	next   Notifier
+	events chan Event
+	done   chan struct{}
+}
+
+// NewAsyncNotifier returns an AsyncNotifier that delivers through next,
+// queueing up to size events. Close must be called to stop it, once nothing
+// sends it events anymore.
+func NewAsyncNotifier(next Notifier, size int) *AsyncNotifier {
+	a := &AsyncNotifier{next: next, events: make(chan Event, size), done: make(chan struct{})}
+	go a.run()
+	return a
+}
+
+func (a *AsyncNotifier) run() {
+	defer close(a.done)
+	for e := range a.events {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		if err := a.next.Notify(ctx, e); err != nil {
+			slog.Error("failed to send notification", "table", e.Table, "event", e.Type, "error", err)
+		}
+		cancel()
+	}
+}
+
+// Notify queues e without waiting for it to be delivered.
+func (a *AsyncNotifier) Notify(_ context.Context, e Event) error {
+	select {
+	case a.events <- e:
+		return nil
+	default:
+		return errors.New("notification queue is full, event dropped")
+	}
+}
+
+// Close stops accepting events and waits until the queued ones have been
+// delivered or ctx is done.
+func (a *AsyncNotifier) Close(ctx context.Context) error {
+	close(a.events)
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,107 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// ObjectStore keeps partition archives outside the database, e.g. in S3.
+type ObjectStore interface {
+	// Upload stores everything read from r under key, streaming it
+	// without knowing its size in advance.
+	Upload(ctx context.Context, key string, r io.Reader) error
+	// Size returns the size of the object stored under key.
+	Size(ctx context.Context, key string) (int64, error)
+	// Location describes where key is stored, for logs.
+	Location(key string) string
+}
+
+// s3PartSize is the size of each part of a multipart upload, which bounds
+// the memory an upload takes regardless of the partition's size.
+const s3PartSize = 16 << 20
+
+// S3Config locates an S3 bucket, on AWS or any S3-compatible service such
+// as MinIO.
+type S3Config struct {
+	// Endpoint is a host or URL, e.g. http://minio:9000 for a MinIO server
+	// without TLS; s3.amazonaws.com by default
+	Endpoint string
+	Region   string
+	Bucket   string
+	Prefix   string // Prepended to every object key
+
+	// Static credentials; when empty, they are taken from the standard AWS
+	// environment variables or the instance's IAM role
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// S3Store is an ObjectStore backed by an S3 bucket.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Store returns an ObjectStore writing to the bucket described by cfg.
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	host, secure := "s3.amazonaws.com", true
+	if cfg.Endpoint != "" {
+		host = cfg.Endpoint
+		if strings.Contains(cfg.Endpoint, "://") {
+			u, err := url.Parse(cfg.Endpoint)
+			if err != nil {
+				return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+			}
+			host, secure = u.Host, u.Scheme == "https"
+		}
+	}
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.IAM{},
+	})
+	if cfg.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	}
+
+	client, err := minio.New(host, &minio.Options{Creds: creds, Secure: secure, Region: cfg.Region})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Store{client: client, bucket: cfg.Bucket, prefix: cfg.Prefix}, nil
+}
+
+func (s *S3Store) object(key string) string {
+	return path.Join(s.prefix, key)
+}
+
+// Upload streams r into a multipart upload of s3PartSize parts.
+func (s *S3Store) Upload(ctx context.Context, key string, r io.Reader) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.object(key), r, -1, minio.PutObjectOptions{
+		ContentType: "application/gzip",
+		PartSize:    s3PartSize,
+	})
+	return err
+}
+
+// Size returns the size of the object stored under key.
+func (s *S3Store) Size(ctx context.Context, key string) (int64, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.object(key), minio.StatObjectOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// Location returns the s3:// URL of key.
+func (s *S3Store) Location(key string) string {
+	return "s3://" + s.bucket + "/" + s.object(key)
+}
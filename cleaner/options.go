@@ -0,0 +1,441 @@
+package cleaner
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// Cleanup strategies.
+const (
+	StrategyAuto      = "auto"      // partition for suitable tables, delete otherwise
+	StrategyPartition = "partition" // Drop partitions that lie entirely before the cutoff
+	StrategyDelete    = "delete"    // Delete expired rows in batches
+)
+
+// Retention modes select what makes a partition old enough to drop.
+const (
+	RetentionAge   = "age"   // Partitions older than MaxAge, then any beyond the limits
+	RetentionCount = "count" // Only the oldest partitions beyond MaxPartitions
+)
+
+// Storage modes select how a generator table is laid out.
+const (
+	StorageNative    = "native"    // A plain table, or one partitioned by the user
+	StorageTimescale = "timescale" // A TimescaleDB hypertable, cleaned up with drop_chunks
+)
+
+// Drop strategies select how a partition is dropped.
+const (
+	DropDirect = "drop"        // DROP TABLE, which briefly locks the whole table
+	DropDetach = "detach_drop" // Detach the partition from the table first, then drop it
+)
+
+// Insert modes select how chunks of audit logs committed one by one are
+// written.
+const (
+	InsertCopy   = "copy"   // COPY, which is faster but returns no ids
+	InsertInsert = "insert" // A multi-row INSERT returning the ids
+)
+
+// ColumnTypes lists the data types the insert generator can fill with
+// random values. Each is also the type's information_schema name.
+var ColumnTypes = []string{"text", "integer", "bigint", "boolean", "uuid", "inet", "jsonb"}
+
+// identifierPattern restricts table and column names to plain lowercase
+// identifiers, so the quoted name used in SQL, the name stored in pg_class
+// and the name used for archive files all agree.
+var identifierPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// ValidIdentifier reports whether name can be used as a table or column
+// name: lowercase letters, digits and underscores, at most 63 characters.
+func ValidIdentifier(name string) bool {
+	return identifierPattern.MatchString(name)
+}
+
+// Column is an extra column the insert generator fills with random values.
+type Column struct {
+	Name string
+	Type string // One of ColumnTypes
+}
+
+// Options configures a Cleaner for a single table. Zero values select the
+// documented defaults.
+type Options struct {
+	Table      string
+	TimeColumn string        // Rows are expired based on this column, created_at by default
+	MaxAge     time.Duration // Rows older than this are removed
+
+	// PartitionPrefix starts the names of the partitions the Cleaner
+	// creates, "<Table>_" by default; the start of their range follows
+	PartitionPrefix string
+
+	// PartitionTemplate names the partitions the Cleaner creates instead,
+	// "<PartitionPrefix>{time}" by default, with the placeholders {table},
+	// {granularity} for their step, e.g. 1d, and {time} for the start of
+	// their range. PartitionTimeLayout formats {time}, to the minute, or
+	// to the second for steps below a minute, by default; names must tell
+	// the start of every partition apart.
+	PartitionTemplate   string
+	PartitionTimeLayout string
+
+	// PartitionGranularity is the range of the partitions the Cleaner
+	// creates for ingested audit logs, GranularityMinute, GranularityHour
+	// or GranularityDay. Their names give the start of the range to the
+	// minute, the hour or the day, unless PartitionTimeLayout is set. Empty
+	// leaves the range to IngestOptions.PartitionStep.
+	PartitionGranularity string
+
+	// PartitionPremake keeps partitions of PartitionGranularity, which it
+	// needs, created for the current range and this many ranges after it,
+	// see RunPartitionMaintainer; 0 creates none ahead of time
+	PartitionPremake int
+
+	// MaxTotalSize and MaxPartitions additionally limit a table cleaned up
+	// by dropping partitions: after the expired partitions, the oldest ones
+	// are dropped until the table's partitions take at most MaxTotalSize
+	// bytes and number at most MaxPartitions. 0 disables a limit.
+	MaxTotalSize  int64
+	MaxPartitions int
+
+	// MinRetained exempts the newest partitions, by upper bound, from every
+	// drop, whatever their age and the limits above; 0 exempts none
+	MinRetained int
+
+	// Holds keep the partitions overlapping their range from being dropped,
+	// like those recorded in HoldsTable. They don't apply to rows deleted
+	// in batches.
+	Holds []Hold
+
+	// SafetyMargin is added to MaxAge for partitions: one whose upper bound
+	// lies within SafetyMargin before the cutoff is kept until a later run,
+	// in case it is still being written to; 0 adds none
+	SafetyMargin time.Duration
+
+	// Retention is RetentionAge by default. With RetentionCount, partitions
+	// are no longer dropped for their age, only to keep the newest
+	// MaxPartitions, which must be set; MaxAge still applies to the rows
+	// of a DEFAULT partition.
+	Retention string
+
+	// CleanupInterval is the time between cleanup runs; 0 disables the
+	// cleanup loop
+	CleanupInterval time.Duration
+	Strategy        string        // StrategyAuto by default
+	BatchSize       int           // Rows per DELETE with the delete strategy, 5 by default
+	BatchPause      time.Duration // Pause between DELETE batches
+	Vacuum          bool          // Run VACUUM (ANALYZE) after deleting rows, or ANALYZE after dropping partitions
+
+	// MaintenanceInterval is the time between maintenance passes, see
+	// Maintain; 0 disables them. With maintenance, partitioned tables are
+	// also analyzed after dropping partitions.
+	MaintenanceInterval time.Duration
+
+	// Storage is StorageNative by default. With StorageTimescale the
+	// timescaledb extension must be installed, the generator creates its
+	// table as a hypertable chunked by ChunkInterval, 1 day by default, and
+	// the partition strategy drops whole chunks
+	Storage       string
+	ChunkInterval time.Duration
+
+	// Indexes are created on the table by Prepare, each a comma-separated
+	// list of columns optionally followed by ASC or DESC, e.g.
+	// "created_at DESC". With IndexMode IndexParent, the default, Postgres
+	// copies them to every partition; with IndexConcurrent they are built
+	// concurrently on each partition the Cleaner creates instead.
+	Indexes   []string
+	IndexMode string
+
+	ArchiveDir  string // Expired rows are written here before removal; empty disables archiving
+	ArchiveGzip bool
+
+	// ArchiveStore, if set, receives every partition as gzip-compressed CSV
+	// before it is dropped, instead of ArchiveDir; the partition is only
+	// dropped once the upload is verified. Rows deleted in batches are still
+	// archived to ArchiveDir.
+	ArchiveStore ObjectStore
+
+	// History records every cleanup run in HistoryTable, which Prepare
+	// creates if needed
+	History bool
+
+	// Generate makes the Cleaner own the table: Prepare creates, resets or
+	// migrates it as requested, and Run writes synthetic audit logs to it
+	// every InsertInterval
+	Generate       bool
+	InsertInterval time.Duration
+
+	// MaxInserts stops the generator once it has written this many audit
+	// logs; 0 means no limit
+	MaxInserts int64
+
+	// Ingest makes the Cleaner own the table like Generate, so that audit
+	// logs submitted to an Ingester can be written to it, without the
+	// generator running
+	Ingest bool
+
+	// InsertRate is the number of audit logs written per second, spread
+	// over the InsertInterval ticks; 0 writes one log per tick
+	InsertRate float64
+	// InsertJitter varies each wait between ticks randomly by up to this
+	// fraction of InsertInterval, from 0 up to but excluding 1
+	InsertJitter float64
+	// RampUp raises the insert rate linearly from 0 over this long after
+	// the generator starts
+	RampUp time.Duration
+	// InsertBackoffThreshold is how many inserts in a row may fail before
+	// the generator backs off, 2 by default: from then on it waits twice as
+	// long after every failed insert, up to InsertMaxBackoff (5 minutes by
+	// default), and returns to InsertInterval once an insert succeeds
+	InsertBackoffThreshold int
+	InsertMaxBackoff       time.Duration
+	// InsertChunkSize caps the rows per INSERT statement, 500 by default;
+	// larger batches are split into chunks, which are committed one by one
+	// unless ChunkTransaction is set
+	InsertChunkSize  int
+	ChunkTransaction bool
+	// InsertMode writes chunks committed one by one with COPY or INSERT,
+	// InsertCopy by default with DriverPGX and InsertInsert with DriverPQ.
+	// Chunks committed together with ChunkTransaction always use INSERT.
+	InsertMode string
+
+	Columns            []Column // Extra columns, e.g. DefaultColumns
+	Traffic            Traffic  // Shape of the requests behind the generated rows
+	Seed               uint64   // Seeds the generated rows, so a seed reproduces them; 0 picks one at random
+	Reset              bool     // Drop the table in Prepare, destroying all data
+	MigrateTimestamptz bool     // Convert a TIMESTAMP time column to TIMESTAMPTZ in Prepare
+
+	// DefaultPartition creates a DEFAULT partition for a table cleaned up
+	// by dropping partitions if it has none, so that rows outside every
+	// partition can still be inserted
+	DefaultPartition bool
+
+	// Notifier, if set, is told about failed cleanup runs and archives,
+	// and with NotifyOnSuccess about every dropped partition
+	Notifier        Notifier
+	NotifyOnSuccess bool
+
+	// DropStrategy is DropDirect by default. With DropDetach each partition
+	// is detached from the table before it is dropped, with DETACH
+	// PARTITION CONCURRENTLY from Postgres 14 on, so that inserts into the
+	// table are not blocked by the drop; it does not apply to
+	// StorageTimescale
+	DropStrategy string
+
+	// LockTimeout and StatementTimeout are set for the transaction around
+	// each partition drop, and for each detach; 0 keeps the server's setting
+	LockTimeout      time.Duration
+	StatementTimeout time.Duration
+
+	// QueryTimeout bounds each database operation, such as an insert, a
+	// delete batch or a partition drop; 0 means no limit
+	QueryTimeout time.Duration
+
+	// CleanupJitter varies each wait between cleanup ticks randomly by up
+	// to this fraction of CleanupInterval, from 0 up to but excluding 1
+	CleanupJitter float64
+
+	// FailureThreshold is how many times in a row a routine may fail with
+	// a permanent error, such as a missing column, before Failing reports
+	// it; 0 never reports one
+	FailureThreshold int
+
+	// Driver is the database/sql driver of the *sql.DB, DriverPQ by
+	// default. It selects how COPY is sent, and the default InsertMode.
+	Driver string
+
+	MaxRetries     int           // Retries of transient database errors
+	RetryBaseDelay time.Duration // 100ms by default, doubled after every failed attempt
+
+	DryRun bool  // Report what cleanup would do without modifying anything
+	Force  bool  // Start despite a table failing the checks in Prepare, logging the problems
+	Clock  Clock // The system clock by default
+}
+
+// withDefaults returns o with the zero values replaced by their defaults.
+func (o Options) withDefaults() Options {
+	if o.TimeColumn == "" {
+		o.TimeColumn = "created_at"
+	}
+	if o.PartitionPrefix == "" {
+		o.PartitionPrefix = o.Table + "_"
+	}
+	if o.PartitionTemplate == "" {
+		o.PartitionTemplate = o.PartitionPrefix + placeholderTime
+	}
+	if o.Strategy == "" {
+		o.Strategy = StrategyAuto
+	}
+	if o.Retention == "" {
+		o.Retention = RetentionAge
+	}
+	if o.Storage == "" {
+		o.Storage = StorageNative
+	}
+	if o.DropStrategy == "" {
+		o.DropStrategy = DropDirect
+	}
+	if o.IndexMode == "" {
+		o.IndexMode = IndexParent
+	}
+	if o.Driver == "" {
+		o.Driver = DriverPQ
+	}
+	if o.InsertMode == "" {
+		o.InsertMode = InsertInsert
+		if o.Driver == DriverPGX {
+			o.InsertMode = InsertCopy
+		}
+	}
+	if o.ChunkInterval <= 0 {
+		o.ChunkInterval = 24 * time.Hour
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 5
+	}
+	if o.InsertChunkSize <= 0 {
+		o.InsertChunkSize = 500
+	}
+	if o.RetryBaseDelay <= 0 {
+		o.RetryBaseDelay = 100 * time.Millisecond
+	}
+	if o.InsertBackoffThreshold <= 0 {
+		o.InsertBackoffThreshold = 2
+	}
+	if o.InsertMaxBackoff <= 0 {
+		o.InsertMaxBackoff = maxRoutineBackoff
+	}
+	if o.Clock == nil {
+		o.Clock = realClock{}
+	}
+	return o
+}
+
+// validate rejects names that cannot be used safely in SQL and file names,
+// and settings the loops cannot run with.
+func (o Options) validate() error {
+	if !ValidIdentifier(o.Table) {
+		return fmt.Errorf("table name %q is not valid (lowercase letters, digits and underscores only)", o.Table)
+	}
+	if o.History && o.Table == HistoryTable {
+		return fmt.Errorf("table %s is reserved for the cleanup history", HistoryTable)
+	}
+	if !ValidIdentifier(o.TimeColumn) {
+		return fmt.Errorf("time column %q of table %s is not valid (lowercase letters, digits and underscores only)", o.TimeColumn, o.Table)
+	}
+	if !ValidIdentifier(o.PartitionPrefix) || len(o.PartitionPrefix)+len(partitionNameLayouts[1]) > maxIdentifierLength {
+		return fmt.Errorf("partition name prefix %q of table %s is not valid (lowercase letters, digits and underscores only, at most %d characters)",
+			o.PartitionPrefix, o.Table, maxIdentifierLength-len(partitionNameLayouts[1]))
+	}
+	if err := o.validatePartitionTemplate(); err != nil {
+		return err
+	}
+	if _, ok := granularities[o.PartitionGranularity]; !ok && o.PartitionGranularity != "" {
+		return fmt.Errorf("unknown partition granularity %q", o.PartitionGranularity)
+	}
+	if o.PartitionPremake < 0 {
+		return fmt.Errorf("partitions to create ahead of time must not be negative, got %d", o.PartitionPremake)
+	}
+	if o.PartitionPremake > 0 && o.PartitionGranularity == "" {
+		return fmt.Errorf("creating partitions of table %s ahead of time needs a partition granularity", o.Table)
+	}
+	switch o.Strategy {
+	case StrategyAuto, StrategyPartition, StrategyDelete:
+	default:
+		return fmt.Errorf("unknown cleanup strategy %q", o.Strategy)
+	}
+	switch o.Storage {
+	case StorageNative, StorageTimescale:
+	default:
+		return fmt.Errorf("unknown storage mode %q", o.Storage)
+	}
+	switch o.DropStrategy {
+	case DropDirect:
+	case DropDetach:
+		if o.Storage == StorageTimescale {
+			return fmt.Errorf("%s drop strategy does not apply to %s storage", DropDetach, StorageTimescale)
+		}
+	default:
+		return fmt.Errorf("unknown drop strategy %q", o.DropStrategy)
+	}
+	switch o.IndexMode {
+	case IndexParent, IndexConcurrent:
+	default:
+		return fmt.Errorf("unknown index mode %q", o.IndexMode)
+	}
+	switch o.Driver {
+	case DriverPQ, DriverPGX:
+	default:
+		return fmt.Errorf("unknown database driver %q", o.Driver)
+	}
+	switch o.InsertMode {
+	case InsertCopy, InsertInsert:
+	default:
+		return fmt.Errorf("unknown insert mode %q", o.InsertMode)
+	}
+	for _, def := range o.Indexes {
+		if _, err := parseIndex(def); err != nil {
+			return fmt.Errorf("table %s: %w", o.Table, err)
+		}
+	}
+	if o.MaxAge < 0 {
+		return fmt.Errorf("max age of table %s must not be negative, got %s", o.Table, o.MaxAge)
+	}
+	if o.SafetyMargin < 0 {
+		return fmt.Errorf("safety margin of table %s must not be negative, got %s", o.Table, o.SafetyMargin)
+	}
+	if o.MaxTotalSize < 0 || o.MaxPartitions < 0 || o.MinRetained < 0 {
+		return fmt.Errorf("size and partition count limits of table %s must not be negative", o.Table)
+	}
+	switch o.Retention {
+	case RetentionAge:
+	case RetentionCount:
+		if o.MaxPartitions == 0 {
+			return fmt.Errorf("%s retention of table %s needs a partition count limit", RetentionCount, o.Table)
+		}
+	default:
+		return fmt.Errorf("unknown retention mode %q", o.Retention)
+	}
+	for _, col := range o.Columns {
+		if !ValidIdentifier(col.Name) {
+			return fmt.Errorf("column name %q is not valid (lowercase letters, digits and underscores only)", col.Name)
+		}
+		if !slices.Contains(ColumnTypes, col.Type) {
+			return fmt.Errorf("column %s has unsupported type %q", col.Name, col.Type)
+		}
+	}
+	for method, weight := range o.Traffic.Methods {
+		if method == "" || weight <= 0 {
+			return fmt.Errorf("method weights must name a method and be greater than 0, got %q=%d", method, weight)
+		}
+	}
+	if o.MaxInserts < 0 {
+		return fmt.Errorf("insert limit must not be negative, got %d", o.MaxInserts)
+	}
+	if o.FailureThreshold < 0 {
+		return fmt.Errorf("failure threshold must not be negative, got %d", o.FailureThreshold)
+	}
+	if o.InsertRate < 0 {
+		return fmt.Errorf("insert rate must not be negative, got %g", o.InsertRate)
+	}
+	if o.InsertJitter < 0 || o.InsertJitter >= 1 {
+		return fmt.Errorf("insert jitter must be at least 0 and less than 1, got %g", o.InsertJitter)
+	}
+	if o.CleanupJitter < 0 || o.CleanupJitter >= 1 {
+		return fmt.Errorf("cleanup jitter must be at least 0 and less than 1, got %g", o.CleanupJitter)
+	}
+	if o.RampUp < 0 {
+		return fmt.Errorf("ramp-up must not be negative, got %s", o.RampUp)
+	}
+	// Every row of a chunk takes a parameter for the message, the time
+	// column and each extra column
+	if params := o.InsertChunkSize * (2 + len(o.Columns)); params > maxParams {
+		return fmt.Errorf("insert chunk size %d needs %d parameters per statement, more than the %d Postgres allows",
+			o.InsertChunkSize, params, maxParams)
+	}
+	if o.Traffic.ErrorRate < 0 || o.Traffic.ErrorRate > 1 {
+		return fmt.Errorf("error rate must be between 0 and 1, got %g", o.Traffic.ErrorRate)
+	}
+	return nil
+}
@@ -0,0 +1,681 @@
+package cleaner
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// partition is a child partition of a managed table, or a chunk of a
+// hypertable.
+type partition struct {
+	name     string
+	ident    string    // Schema-qualified name quoted for use in SQL
+	from, to time.Time // Time range; from is zero for MINVALUE, to for MAXVALUE
+}
+
+// overlaps reports whether the partition's range overlaps [from, to).
+func (p partition) overlaps(from, to time.Time) bool {
+	return (p.from.IsZero() || p.from.Before(to)) && (p.to.IsZero() || from.Before(p.to))
+}
+
+// partitionError is the failure to drop a partition.
+type partitionError struct {
+	partition string
+	err       error
+}
+
+func (e *partitionError) Error() string {
+	return fmt.Sprintf("dropping partition %s: %v", e.partition, e.err)
+}
+
+func (e *partitionError) Unwrap() error { return e.err }
+
+// resolveStrategy settles which cleanup strategy the table uses. The
+// partition strategy needs a table that is range-partitioned on its time
+// column; auto picks it for such tables and falls back to delete otherwise.
+func (c *Cleaner) resolveStrategy(ctx context.Context) error {
+	if c.opts.Storage == StorageTimescale {
+		return c.resolveHypertableStrategy(ctx)
+	}
+
+	query := `
+		SELECT COALESCE(pg_get_partkeydef(c.oid), ''),
+		       COALESCE(pg_get_partkeydef(c.oid) = format('RANGE (%s)', quote_ident($2)), false)
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relname = $1 AND n.nspname = current_schema()
+	`
+
+	// A table that does not exist yet (dry run) counts as not partitioned
+	var partKey string
+	var byTime bool
+	err := c.db.QueryRowContext(ctx, query, c.opts.Table, c.opts.TimeColumn).Scan(&partKey, &byTime)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("inspecting partitioning: %w", err)
+	}
+	partitioned := partKey != ""
+	c.partitioned = partitioned
+
+	switch c.opts.Strategy {
+	case StrategyPartition:
+		if !byTime {
+			found := "is not partitioned"
+			if partitioned {
+				found = "is partitioned by " + partKey
+			}
+			return fmt.Errorf("%s strategy needs table %s to be range-partitioned on %s, but it %s",
+				StrategyPartition, c.opts.Table, c.opts.TimeColumn, found)
+		}
+		c.strategy = StrategyPartition
+	case StrategyAuto:
+		if byTime {
+			c.strategy = StrategyPartition
+		} else {
+			c.strategy = StrategyDelete
+		}
+		if partitioned && !byTime {
+			slog.Warn("table is not partitioned on its time column, deleting rows instead",
+				"table", c.opts.Table, "time_column", c.opts.TimeColumn)
+		}
+	default:
+		c.strategy = StrategyDelete
+	}
+	return nil
+}
+
+// prepareDefaultPartition looks up the DEFAULT partition of a table cleaned
+// up by dropping partitions and, with DefaultPartition, creates one named
+// <table>_default if it has none.
+func (c *Cleaner) prepareDefaultPartition(ctx context.Context) error {
+	if c.strategy != StrategyPartition || c.opts.Storage == StorageTimescale {
+		return nil
+	}
+
+	query := `
+		SELECT d.relname, format('%I.%I', n.nspname, d.relname)
+		FROM pg_partitioned_table p
+		JOIN pg_class d ON d.oid = p.partdefid
+		JOIN pg_namespace n ON n.oid = d.relnamespace
+		WHERE p.partrelid = $1::regclass
+	`
+
+	var p partition
+	err := c.db.QueryRowContext(ctx, query, c.ident).Scan(&p.name, &p.ident)
+	switch {
+	case err == nil:
+		c.defaultPartition = &p
+		return nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("looking up default partition: %w", err)
+	case !c.opts.DefaultPartition:
+		return nil
+	}
+
+	p = partition{name: c.opts.Table + "_default", ident: pq.QuoteIdentifier(c.opts.Table + "_default")}
+	if c.opts.DryRun {
+		slog.Info("[DRY RUN] would create default partition", "table", c.opts.Table, "partition", p.name)
+		return nil
+	}
+	if _, err := c.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE %s PARTITION OF %s DEFAULT`, p.ident, c.ident)); err != nil {
+		return fmt.Errorf("creating default partition: %w", err)
+	}
+	slog.Info("default partition created", "table", c.opts.Table, "partition", p.name)
+	c.defaultPartition = &p
+	return nil
+}
+
+// Ways of detaching partitions with DropDetach.
+const (
+	detachConcurrently = "concurrently"
+	detachPlain        = "plain"
+)
+
+// resolveDetach settles how partitions are detached with DropDetach:
+// concurrently from Postgres 14, which introduced DETACH PARTITION
+// CONCURRENTLY, and plainly on older servers and for tables with a DEFAULT
+// partition, which it does not support.
+func (c *Cleaner) resolveDetach(ctx context.Context) error {
+	if c.strategy != StrategyPartition || c.opts.DropStrategy != DropDetach {
+		return nil
+	}
+
+	var version int
+	if err := c.db.QueryRowContext(ctx, `SHOW server_version_num`).Scan(&version); err != nil {
+		return fmt.Errorf("checking server version: %w", err)
+	}
+	switch {
+	case version < 140000:
+		slog.Warn("DETACH PARTITION CONCURRENTLY needs Postgres 14 or later, detaching partitions plainly",
+			"table", c.opts.Table, "server_version_num", version)
+		c.detach = detachPlain
+	case c.defaultPartition != nil:
+		slog.Warn("DETACH PARTITION CONCURRENTLY cannot be used on a table with a DEFAULT partition, detaching partitions plainly",
+			"table", c.opts.Table, "partition", c.defaultPartition.name)
+		c.detach = detachPlain
+	default:
+		c.detach = detachConcurrently
+	}
+	slog.Info("partitions are detached before they are dropped", "table", c.opts.Table,
+		"method", c.dropMethod(), "server_version_num", version)
+	return nil
+}
+
+// dropMethod describes how partitions are dropped, for the logs.
+func (c *Cleaner) dropMethod() string {
+	switch c.detach {
+	case detachConcurrently:
+		return "detach concurrently, drop"
+	case detachPlain:
+		return "detach, drop"
+	}
+	return "drop"
+}
+
+// expiredPartitions lists the partitions whose upper bound is at or before
+// cutoff less SafetyMargin, oldest first. Default partitions, partitions
+// bounded by MAXVALUE, the newest MinRetained partitions and partitions
+// under a hold never expire. For a hypertable, its chunks are listed
+// instead.
+func (c *Cleaner) expiredPartitions(ctx context.Context, cutoff time.Time) ([]partition, error) {
+	var listed []partition
+	var err error
+	if c.opts.Storage == StorageTimescale {
+		listed, err = c.listChunks(ctx)
+	} else {
+		listed, err = c.listPartitions(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	holds, err := c.activeHolds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	held := 0
+	for _, p := range listed {
+		if _, ok := c.heldBy(holds, p); ok {
+			held++
+		}
+	}
+	partitionsHeld.WithLabelValues(c.opts.Table).Set(float64(held))
+	return c.selectExpired(listed, holds, cutoff), nil
+}
+
+// selectExpired returns the partitions of listed that expire at cutoff, as
+// described for expiredPartitions, oldest first. listed is sorted in place.
+func (c *Cleaner) selectExpired(listed []partition, holds []Hold, cutoff time.Time) []partition {
+	// Oldest first by upper bound, MAXVALUE last; the newest MinRetained
+	// are never dropped, whatever the cutoff
+	slices.SortFunc(listed, func(a, b partition) int {
+		switch {
+		case a.to.IsZero() && b.to.IsZero():
+			return 0
+		case a.to.IsZero():
+			return 1
+		case b.to.IsZero():
+			return -1
+		}
+		return a.to.Compare(b.to)
+	})
+	retainFrom := len(listed) - c.opts.MinRetained
+
+	var partitions []partition
+	for i, p := range listed {
+		if p.to.IsZero() || p.to.After(cutoff) {
+			continue
+		}
+		if p.to.After(cutoff.Add(-c.opts.SafetyMargin)) {
+			slog.Info("expired partition kept, it is within the safety margin", "table", c.opts.Table,
+				"partition", p.name, "upper_bound", p.to, "safety_margin", c.opts.SafetyMargin)
+			continue
+		}
+		if i >= retainFrom {
+			slog.Warn("expired partition kept, the newest partitions are always retained", "table", c.opts.Table,
+				"partition", p.name, "min_retained", c.opts.MinRetained)
+			continue
+		}
+		if h, ok := c.heldBy(holds, p); ok {
+			c.logHeld(p, h)
+			continue
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions
+}
+
+// dropExpiredPartitions drops every partition that lies entirely before
+// cutoff and returns which partitions were dropped, with their total row
+// count and size. A partition whose drop runs into the lock or statement
+// timeout is left for the next cycle. With RetentionCount, age alone drops
+// nothing.
+func (c *Cleaner) dropExpiredPartitions(ctx context.Context, cutoff time.Time, archive *archiveWriter) (CleanupResult, error) {
+	if c.opts.Retention == RetentionCount {
+		return CleanupResult{}, nil
+	}
+
+	var partitions []partition
+	err := c.withRetry(ctx, "list expired partitions", func(ctx context.Context) error {
+		var err error
+		partitions, err = c.expiredPartitions(ctx, cutoff)
+		return err
+	})
+	if err != nil {
+		return CleanupResult{}, fmt.Errorf("listing expired partitions: %w", err)
+	}
+	return c.dropPartitions(ctx, partitions, archive, policyMaxAge)
+}
+
+// dropPartitions drops the given partitions in order and returns which were
+// dropped, with their total row count and size. policy names the retention
+// policy that selected them, for the logs.
+func (c *Cleaner) dropPartitions(ctx context.Context, partitions []partition, archive *archiveWriter, policy string) (CleanupResult, error) {
+	var result CleanupResult
+	for _, p := range partitions {
+		spanCtx, span := tracer.Start(ctx, "cleaner.drop_partition", trace.WithAttributes(
+			attribute.String("cleaner.table", c.opts.Table),
+			attribute.String("cleaner.partition", p.name),
+			attribute.String("cleaner.policy", policy),
+		))
+		var deleted int
+		var bytes int64
+		var err error
+		if c.detach != "" {
+			err = c.withRetry(spanCtx, "detach partition", func(ctx context.Context) error {
+				return c.detachPartition(ctx, p)
+			})
+		}
+		detached := err == nil && c.detach != ""
+		if err == nil {
+			err = c.withRetry(spanCtx, "drop partition", func(ctx context.Context) error {
+				var err error
+				deleted, bytes, err = c.dropPartition(ctx, p, archive)
+				return err
+			})
+		}
+		span.SetAttributes(attribute.Int("cleaner.rows", deleted), attribute.Int64("cleaner.bytes", bytes))
+		endSpan(span, err)
+		if err != nil && detached {
+			// A detached partition is no longer listed, so no later cycle
+			// would drop it
+			return result, &partitionError{partition: p.name,
+				err: fmt.Errorf("it was detached from table %s, but is left behind and must be dropped manually: %w", c.opts.Table, err)}
+		}
+		if err != nil && ctx.Err() == nil && isTimeout(err) {
+			slog.Warn("partition drop timed out, retrying next cycle", "table", c.opts.Table,
+				"partition", p.name, "error", err)
+			continue
+		}
+		if errors.Is(err, errPartitionChanged) {
+			slog.Warn("partition still written to after it expired, retrying next cycle", "table", c.opts.Table,
+				"partition", p.name, "error", err)
+			continue
+		}
+		if err != nil {
+			return result, &partitionError{partition: p.name, err: err}
+		}
+
+		result.Rows += deleted
+		result.Partitions = append(result.Partitions, p.name)
+		result.Bytes += bytes
+		c.partitionsDropped.Add(1)
+		recordsDeleted.WithLabelValues(c.opts.Table).Add(float64(deleted))
+		slog.Info("partition dropped", "table", c.opts.Table, "partition", p.name, "policy", policy,
+			"method", c.dropMethod(), "count", deleted, "bytes", bytes)
+		if c.opts.NotifyOnSuccess {
+			c.notify(ctx, Event{Type: EventPartitionDropped, Partition: p.name})
+		}
+	}
+	return result, nil
+}
+
+// cleanDefaultPartition deletes the expired rows of the default partition,
+// which is never dropped, and returns how many there were. Any rows left in
+// it lie outside every other partition, e.g. because of clock skew, and keep
+// a partition from being created for their range, so they are reported.
+func (c *Cleaner) cleanDefaultPartition(ctx context.Context, cutoff time.Time, archive *archiveWriter) (int, error) {
+	p := c.defaultPartition
+	deleted, err := c.deleteExpiredRows(ctx, p.ident, cutoff, archive)
+	if err != nil {
+		return deleted, fmt.Errorf("cleaning default partition %s: %w", p.name, err)
+	}
+
+	var count int64
+	err = c.withRetry(ctx, "count default partition rows", func(ctx context.Context) error {
+		return c.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*) FROM %s`, p.ident)).Scan(&count)
+	})
+	if err != nil {
+		return deleted, fmt.Errorf("counting rows of default partition %s: %w", p.name, err)
+	}
+
+	defaultPartitionRows.WithLabelValues(c.opts.Table).Set(float64(count))
+	if count > 0 {
+		slog.Warn("default partition holds rows outside every partition", "table", c.opts.Table,
+			"partition", p.name, "count", count)
+	}
+	return deleted, nil
+}
+
+// errPartitionChanged fails the drop of a partition whose rows changed
+// after it was archived.
+var errPartitionChanged = errors.New("partition changed since it was archived")
+
+// dropPartition drops a single partition and returns how many rows it held
+// and its size in bytes. When archiving, the rows are archived first, in a
+// read-only transaction of their own, and the drop then runs in a short
+// transaction that blocks writes to the partition and checks that it still
+// holds as many rows as were archived; a partition detached beforehand
+// cannot be written to, so it is dropped without the check. Without an
+// archive, the rows are counted before the drop. The archived rows are
+// rolled back from archive if the drop fails. The drop transaction's lock
+// and statement timeouts keep it from queueing behind long-running queries
+// indefinitely.
+func (c *Cleaner) dropPartition(ctx context.Context, p partition, archive *archiveWriter) (int, int64, error) {
+	if err := archive.mark(); err != nil {
+		return 0, 0, err
+	}
+
+	var bytes int64
+	if err := c.db.QueryRowContext(ctx, `SELECT pg_total_relation_size($1::regclass)`, p.ident).Scan(&bytes); err != nil {
+		return 0, 0, err
+	}
+
+	archived := c.opts.ArchiveStore != nil || archive != nil
+	var count int
+	var err error
+	if archived {
+		count, err = c.archivePartition(ctx, p, archive)
+	} else {
+		err = c.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*) FROM %s`, p.ident)).Scan(&count)
+	}
+	if err != nil {
+		return 0, 0, archive.rollback(err)
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, archive.rollback(err)
+	}
+	defer tx.Rollback()
+	if err := c.setTimeouts(ctx, tx, true); err != nil {
+		return 0, 0, archive.rollback(err)
+	}
+
+	if archived && c.detach == "" {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`LOCK TABLE %s IN SHARE MODE`, p.ident)); err != nil {
+			return 0, 0, archive.rollback(err)
+		}
+		var current int
+		if err := tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*) FROM %s`, p.ident)).Scan(&current); err != nil {
+			return 0, 0, archive.rollback(err)
+		}
+		if current != count {
+			return 0, 0, archive.rollback(fmt.Errorf("%w: it holds %d rows, %d were archived", errPartitionChanged, current, count))
+		}
+	}
+
+	drop, args := c.dropStatement(p)
+	if _, err := tx.ExecContext(ctx, drop, args...); err != nil {
+		return 0, 0, archive.rollback(err)
+	}
+	return count, bytes, tx.Commit()
+}
+
+// execer runs statements, in a transaction or on a connection.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// setTimeouts applies LockTimeout and StatementTimeout to the transaction
+// of ex if local is set, and to its session otherwise.
+func (c *Cleaner) setTimeouts(ctx context.Context, ex execer, local bool) error {
+	for _, t := range c.timeouts() {
+		_, err := ex.ExecContext(ctx, `SELECT set_config($1, $2, $3)`, t.setting, fmt.Sprint(t.value.Milliseconds()), local)
+		if err != nil {
+			return fmt.Errorf("setting %s: %w", t.setting, err)
+		}
+	}
+	return nil
+}
+
+// timeout is a setting of the server limiting how long a statement waits.
+type timeout struct {
+	setting string
+	value   time.Duration
+}
+
+// timeouts returns the timeouts to set for a partition drop or detach:
+// LockTimeout and StatementTimeout, unless they keep the server's setting.
+func (c *Cleaner) timeouts() []timeout {
+	var timeouts []timeout
+	for _, t := range []timeout{
+		{"lock_timeout", c.opts.LockTimeout},
+		{"statement_timeout", c.opts.StatementTimeout},
+	} {
+		if t.value > 0 {
+			timeouts = append(timeouts, t)
+		}
+	}
+	return timeouts
+}
+
+// detachPartition detaches partition p from the table, so that dropping it
+// no longer locks the table. DETACH PARTITION CONCURRENTLY cannot run in a
+// transaction, so its timeouts are set for the session of a connection of
+// its own, which is discarded unless they can be reset. A concurrent
+// detach interrupted after its first phase is left pending, and the next
+// attempt completes it with FINALIZE.
+func (c *Cleaner) detachPartition(ctx context.Context, p partition) error {
+	detach := fmt.Sprintf(`ALTER TABLE %s DETACH PARTITION %s`, c.ident, p.ident)
+	if c.detach == detachPlain {
+		tx, err := c.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if err := c.setTimeouts(ctx, tx, true); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, detach); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := c.setTimeouts(ctx, conn, false); err != nil {
+		return errors.Join(err, c.resetTimeouts(ctx, conn))
+	}
+	_, err = conn.ExecContext(ctx, detach+` CONCURRENTLY`)
+	if sqlState(err) == "55000" { // object_not_in_prerequisite_state: already pending detach
+		slog.Info("completing interrupted detach", "table", c.opts.Table, "partition", p.name)
+		_, err = conn.ExecContext(ctx, detach+` FINALIZE`)
+	}
+	return errors.Join(err, c.resetTimeouts(ctx, conn))
+}
+
+// resetTimeouts resets the timeouts setTimeouts set for the session of
+// conn, and discards conn if they cannot be.
+func (c *Cleaner) resetTimeouts(ctx context.Context, conn *sql.Conn) error {
+	for _, t := range c.timeouts() {
+		if _, err := conn.ExecContext(ctx, `RESET `+t.setting); err != nil {
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+			return fmt.Errorf("resetting %s: %w", t.setting, err)
+		}
+	}
+	return nil
+}
+
+// archivePartition reads the rows of partition p in a read-only
+// transaction, uploads them to the archive store if there is one and
+// writes them to archive otherwise, and returns how many there were.
+func (c *Cleaner) archivePartition(ctx context.Context, p partition, archive *archiveWriter) (int, error) {
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if c.opts.ArchiveStore != nil {
+		count, err = c.uploadPartition(ctx, tx, p)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT * FROM %s`, p.ident))
+		if err != nil {
+			return 0, err
+		}
+		archived, err := archiveRows(rows, archive)
+		if err != nil {
+			return 0, err
+		}
+		count = len(archived)
+	}
+	return count, tx.Commit()
+}
+
+// archiveKey is the object key of partition p's archive, e.g.
+// audit_logs/2024/01/15/audit_logs_20240115_1200.csv.gz, named after the
+// start of its range, or its end if it starts at MINVALUE.
+func (c *Cleaner) archiveKey(p partition) string {
+	start, step := p.from, p.to.Sub(p.from)
+	if start.IsZero() {
+		start, step = p.to, time.Hour
+	}
+	return fmt.Sprintf("%s/%s/%s.csv.gz", c.opts.Table, start.UTC().Format("2006/01/02"),
+		c.opts.partitionName(start, step))
+}
+
+// uploadPartition streams the rows of partition p, read in tx, to the
+// archive store as gzip-compressed CSV and returns how many there were. It
+// only succeeds once the stored object is verified to have the size that
+// was sent.
+func (c *Cleaner) uploadPartition(ctx context.Context, tx *sql.Tx, p partition) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT * FROM %s`, p.ident))
+	if err != nil {
+		return 0, err
+	}
+
+	store, key := c.opts.ArchiveStore, c.archiveKey(p)
+	pr, pw := io.Pipe()
+	uploaded := make(chan error, 1)
+	go func() {
+		err := store.Upload(ctx, key, pr)
+		// Unblock the writer if the upload gave up early
+		pr.CloseWithError(err)
+		uploaded <- err
+	}()
+
+	sent := &countingWriter{w: pw}
+	count, err := writeGzipCSV(rows, sent)
+	pw.CloseWithError(err)
+	if uploadErr := <-uploaded; err == nil && uploadErr != nil {
+		err = fmt.Errorf("uploading archive to %s: %w", store.Location(key), uploadErr)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := store.Size(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("verifying archive %s: %w", store.Location(key), err)
+	}
+	if size != sent.n {
+		return 0, fmt.Errorf("archive %s has %d bytes, but %d were uploaded", store.Location(key), size, sent.n)
+	}
+	slog.Info("partition archived", "table", c.opts.Table, "partition", p.name,
+		"location", store.Location(key), "count", count, "bytes", size)
+	return count, nil
+}
+
+// reportExpiredPartitions logs which partitions a real cleanup run would
+// drop, with their row counts and sizes, without modifying anything.
+func (c *Cleaner) reportExpiredPartitions(ctx context.Context, cutoff time.Time) error {
+	var partitions []partition
+	if c.opts.Retention != RetentionCount {
+		err := c.withRetry(ctx, "list expired partitions", func(ctx context.Context) error {
+			var err error
+			partitions, err = c.expiredPartitions(ctx, cutoff)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("listing expired partitions: %w", err)
+		}
+	}
+
+	totalCount, totalBytes, err := c.reportPartitions(ctx, partitions, policyMaxAge)
+	if err != nil {
+		return err
+	}
+	dropped := len(partitions)
+
+	if c.opts.MaxTotalSize > 0 || c.opts.MaxPartitions > 0 {
+		var byCount, bySize []partition
+		err := c.withRetry(ctx, "check partition limits", func(ctx context.Context) error {
+			var err error
+			byCount, bySize, err = c.overLimit(ctx, partitions)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("checking partition limits: %w", err)
+		}
+
+		for _, selected := range []struct {
+			partitions []partition
+			policy     string
+		}{{byCount, policyMaxPartitions}, {bySize, policyMaxTotalSize}} {
+			count, bytes, err := c.reportPartitions(ctx, selected.partitions, selected.policy)
+			if err != nil {
+				return err
+			}
+			totalCount += count
+			totalBytes += bytes
+			dropped += len(selected.partitions)
+		}
+	}
+
+	slog.Info("[DRY RUN] would drop partitions, nothing was modified",
+		"table", c.opts.Table, "partitions", dropped, "count", totalCount, "bytes", totalBytes,
+		"max_age", c.opts.MaxAge, "cutoff", cutoff)
+	return nil
+}
+
+// reportPartitions logs the row count and size of each partition a real
+// cleanup run would drop under policy, and returns their totals.
+func (c *Cleaner) reportPartitions(ctx context.Context, partitions []partition, policy string) (int64, int64, error) {
+	var totalCount, totalBytes int64
+	for _, p := range partitions {
+		query := fmt.Sprintf(`SELECT count(*), pg_total_relation_size($1::regclass) FROM %s`, p.ident)
+
+		var count, bytes int64
+		err := c.withRetry(ctx, "inspect partition", func(ctx context.Context) error {
+			return c.db.QueryRowContext(ctx, query, p.ident).Scan(&count, &bytes)
+		})
+		if err != nil {
+			return 0, 0, fmt.Errorf("inspecting partition %s: %w", p.name, err)
+		}
+
+		totalCount += count
+		totalBytes += bytes
+		slog.Info("[DRY RUN] would drop partition", "table", c.opts.Table, "partition", p.name,
+			"policy", policy, "count", count, "bytes", bytes)
+	}
+	return totalCount, totalBytes, nil
+}
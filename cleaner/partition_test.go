@@ -0,0 +1,488 @@
+package cleaner
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// utc returns the time of the given date and clock in UTC.
+func utc(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+}
+
+// partitionNames returns the names of partitions, in order.
+func partitionNames(partitions []partition) []string {
+	names := make([]string, len(partitions))
+	for i, p := range partitions {
+		names[i] = p.name
+	}
+	return names
+}
+
+func TestPartitionOverlaps(t *testing.T) {
+	boundary := utc(2024, 1, 15, 12, 0)
+	tests := []struct {
+		name     string
+		p        partition
+		from, to time.Time
+		want     bool
+	}{
+		{"row at the lower bound", partition{from: boundary, to: boundary.Add(time.Minute)}, boundary, boundary.Add(time.Nanosecond), true},
+		{"row at the upper bound", partition{from: boundary.Add(-time.Minute), to: boundary}, boundary, boundary.Add(time.Nanosecond), false},
+		{"row just before the upper bound", partition{from: boundary.Add(-time.Minute), to: boundary}, boundary.Add(-time.Nanosecond), boundary, true},
+		{"adjacent ranges", partition{from: boundary, to: boundary.Add(time.Hour)}, boundary.Add(-time.Hour), boundary, false},
+		{"range inside", partition{from: boundary, to: boundary.Add(time.Hour)}, boundary.Add(time.Minute), boundary.Add(2 * time.Minute), true},
+		{"range around", partition{from: boundary, to: boundary.Add(time.Hour)}, boundary.Add(-time.Hour), boundary.Add(2 * time.Hour), true},
+		{"MINVALUE", partition{to: boundary}, utc(1970, 1, 1, 0, 0), utc(1970, 1, 2, 0, 0), true},
+		{"MAXVALUE", partition{from: boundary}, utc(9999, 1, 1, 0, 0), utc(9999, 1, 2, 0, 0), true},
+		{"before MAXVALUE partition", partition{from: boundary}, boundary.Add(-time.Hour), boundary, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.overlaps(tt.from, tt.to); got != tt.want {
+				t.Errorf("[%s, %s).overlaps(%s, %s) = %t, want %t", tt.p.from, tt.p.to, tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectExpired(t *testing.T) {
+	minutes := []partition{
+		{name: "p_1158", from: utc(2024, 1, 15, 11, 58), to: utc(2024, 1, 15, 11, 59)},
+		{name: "p_1159", from: utc(2024, 1, 15, 11, 59), to: utc(2024, 1, 15, 12, 0)},
+		{name: "p_1200", from: utc(2024, 1, 15, 12, 0), to: utc(2024, 1, 15, 12, 1)},
+	}
+	// Month-long partitions of 31, 29 (2024 is a leap year) and 31 days
+	months := []partition{
+		{name: "p_202401", from: utc(2024, 1, 1, 0, 0), to: utc(2024, 2, 1, 0, 0)},
+		{name: "p_202402", from: utc(2024, 2, 1, 0, 0), to: utc(2024, 3, 1, 0, 0)},
+		{name: "p_202403", from: utc(2024, 3, 1, 0, 0), to: utc(2024, 4, 1, 0, 0)},
+	}
+
+	tests := []struct {
+		name   string
+		opts   Options
+		listed []partition
+		holds  []Hold
+		cutoff time.Time
+		want   []string
+	}{
+		{
+			name:   "cutoff equal to an upper bound",
+			listed: minutes,
+			cutoff: utc(2024, 1, 15, 12, 0),
+			want:   []string{"p_1158", "p_1159"},
+		},
+		{
+			name:   "cutoff just before an upper bound",
+			listed: minutes,
+			cutoff: utc(2024, 1, 15, 12, 0).Add(-time.Nanosecond),
+			want:   []string{"p_1158"},
+		},
+		{
+			name:   "no expired partitions",
+			listed: minutes,
+			cutoff: utc(2024, 1, 15, 11, 58),
+			want:   nil,
+		},
+		{
+			name:   "safety margin",
+			opts:   Options{SafetyMargin: time.Minute},
+			listed: minutes,
+			cutoff: utc(2024, 1, 15, 12, 0),
+			want:   []string{"p_1158"},
+		},
+		{
+			name:   "newest retained",
+			opts:   Options{MinRetained: 2},
+			listed: minutes,
+			cutoff: utc(2024, 1, 15, 13, 0),
+			want:   []string{"p_1158"},
+		},
+		{
+			name:   "held",
+			listed: minutes,
+			holds:  []Hold{{From: utc(2024, 1, 15, 11, 58).Add(30 * time.Second), To: utc(2024, 1, 15, 11, 59)}},
+			cutoff: utc(2024, 1, 15, 13, 0),
+			want:   []string{"p_1159", "p_1200"},
+		},
+		{
+			name:   "held on another table",
+			listed: minutes,
+			holds:  []Hold{{Table: "other_logs", From: utc(2024, 1, 1, 0, 0), To: utc(2024, 2, 1, 0, 0)}},
+			cutoff: utc(2024, 1, 15, 13, 0),
+			want:   []string{"p_1158", "p_1159", "p_1200"},
+		},
+		{
+			name: "MINVALUE and MAXVALUE",
+			listed: []partition{
+				{name: "p_max", from: utc(2024, 1, 15, 12, 0)},
+				{name: "p_min", to: utc(2024, 1, 15, 11, 0)},
+			},
+			cutoff: utc(2025, 1, 1, 0, 0),
+			want:   []string{"p_min"},
+		},
+		{
+			name:   "end of a 29-day month",
+			listed: months,
+			cutoff: utc(2024, 3, 1, 0, 0),
+			want:   []string{"p_202401", "p_202402"},
+		},
+		{
+			name:   "last minute of a 29-day month",
+			listed: months,
+			cutoff: utc(2024, 2, 29, 23, 59),
+			want:   []string{"p_202401"},
+		},
+		{
+			name:   "30 days after the end of January",
+			listed: months,
+			cutoff: utc(2024, 2, 1, 0, 0).Add(30 * 24 * time.Hour),
+			want:   []string{"p_202401", "p_202402"},
+		},
+		{
+			name:   "listed out of order",
+			listed: []partition{minutes[2], minutes[0], minutes[1]},
+			cutoff: utc(2024, 1, 15, 12, 1),
+			want:   []string{"p_1158", "p_1159", "p_1200"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Table = "audit_logs"
+			c := New(nil, opts)
+			got := partitionNames(c.selectExpired(slices.Clone(tt.listed), tt.holds, tt.cutoff))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selectExpired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCutoffFollowsClock(t *testing.T) {
+	clock := newFakeClock(utc(2024, 3, 1, 0, 0))
+	c := New(nil, Options{Table: "audit_logs", MaxAge: 30 * 24 * time.Hour, Clock: clock})
+
+	tests := []struct {
+		advance time.Duration
+		want    time.Time
+	}{
+		{0, utc(2024, 1, 31, 0, 0)},
+		{time.Minute, utc(2024, 1, 31, 0, 1)},
+		{24*time.Hour - time.Minute, utc(2024, 2, 1, 0, 0)},
+	}
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		if got := c.cutoff(); !got.Equal(tt.want) {
+			t.Errorf("cutoff() at %s = %s, want %s", clock.Now(), got, tt.want)
+		}
+	}
+}
+
+// expectDrop expects the statements that drop partition p without an
+// archive, holding count rows and bytes bytes, with a lock timeout of 5s. A
+// non-nil dropErr fails the DROP TABLE, which rolls the transaction back.
+func expectDrop(mock sqlmock.Sqlmock, p partition, count int, bytes int64, dropErr error) {
+	mock.ExpectQuery(`SELECT pg_total_relation_size($1::regclass)`).WithArgs(p.ident).
+		WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(bytes))
+	mock.ExpectQuery(`SELECT count(*) FROM ` + p.ident).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT set_config($1, $2, $3)`).WithArgs("lock_timeout", "5000", true).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	drop := mock.ExpectExec(`DROP TABLE ` + p.ident)
+	if dropErr != nil {
+		drop.WillReturnError(dropErr)
+		mock.ExpectRollback()
+		return
+	}
+	drop.WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+}
+
+// holdsExistQuery is the statement that looks for the holds table.
+const holdsExistQuery = `SELECT to_regclass($1) IS NOT NULL`
+
+func TestDropPartitions(t *testing.T) {
+	partitions := []partition{
+		{name: "audit_logs_20240115_1000", ident: `"audit_logs_20240115_1000"`, from: utc(2024, 1, 15, 10, 0), to: utc(2024, 1, 15, 11, 0)},
+		{name: "audit_logs_20240115_1100", ident: `"audit_logs_20240115_1100"`, from: utc(2024, 1, 15, 11, 0), to: utc(2024, 1, 15, 12, 0)},
+		{name: "audit_logs_20240115_1200", ident: `"audit_logs_20240115_1200"`, from: utc(2024, 1, 15, 12, 0), to: utc(2024, 1, 15, 13, 0)},
+	}
+	lockTimeout := &pq.Error{Code: "55P03", Message: "canceling statement due to lock timeout"}
+
+	c, mock := newMockCleaner(t, Options{LockTimeout: 5 * time.Second})
+	expectDrop(mock, partitions[0], 10, 8192, nil)
+	expectDrop(mock, partitions[1], 20, 16384, lockTimeout)
+	expectDrop(mock, partitions[2], 30, 24576, nil)
+
+	// A partition whose drop times out is left for the next cycle
+	result, err := c.dropPartitions(t.Context(), partitions, nil, policyMaxAge)
+	if err != nil {
+		t.Fatalf("dropPartitions() = %v", err)
+	}
+	want := []string{"audit_logs_20240115_1000", "audit_logs_20240115_1200"}
+	if !slices.Equal(result.Partitions, want) || result.Rows != 40 || result.Bytes != 32768 {
+		t.Errorf("dropPartitions() = %v, %d rows, %d bytes, want %v, 40 rows, 32768 bytes",
+			result.Partitions, result.Rows, result.Bytes, want)
+	}
+}
+
+func TestDropPartitionsStopsAtFailure(t *testing.T) {
+	partitions := []partition{
+		{name: "audit_logs_20240115_1000", ident: `"audit_logs_20240115_1000"`, from: utc(2024, 1, 15, 10, 0), to: utc(2024, 1, 15, 11, 0)},
+		{name: "audit_logs_20240115_1100", ident: `"audit_logs_20240115_1100"`, from: utc(2024, 1, 15, 11, 0), to: utc(2024, 1, 15, 12, 0)},
+	}
+	denied := &pq.Error{Code: "42501", Message: "must be owner of table audit_logs_20240115_1000"}
+
+	c, mock := newMockCleaner(t, Options{LockTimeout: 5 * time.Second})
+	expectDrop(mock, partitions[0], 10, 8192, denied)
+
+	result, err := c.dropPartitions(t.Context(), partitions, nil, policyMaxAge)
+	var pErr *partitionError
+	if !errors.As(err, &pErr) || pErr.partition != partitions[0].name {
+		t.Fatalf("dropPartitions() = %v, want a failure of %s", err, partitions[0].name)
+	}
+	if len(result.Partitions) != 0 {
+		t.Errorf("dropPartitions() dropped %v, want none", result.Partitions)
+	}
+}
+
+func TestDropExpiredPartitions(t *testing.T) {
+	now := utc(2024, 1, 15, 12, 30)
+	c, mock := newMockCleaner(t, Options{MaxAge: time.Hour, LockTimeout: 5 * time.Second, Clock: newFakeClock(now)})
+
+	// Only the partition ending exactly at the cutoff of 11:30 has expired
+	expired := partition{name: "audit_logs_20240115_1030", ident: `"audit_logs_20240115_1030"`}
+	mock.ExpectQuery(catalogQuery).WithArgs("audit_logs").WillReturnRows(
+		sqlmock.NewRows([]string{"relname", "ident", "bound"}).
+			AddRow(expired.name, expired.ident, rangeBound(utc(2024, 1, 15, 10, 30), utc(2024, 1, 15, 11, 30))).
+			AddRow("audit_logs_20240115_1130", `"audit_logs_20240115_1130"`, rangeBound(utc(2024, 1, 15, 11, 30), utc(2024, 1, 15, 12, 30))).
+			AddRow("audit_logs_20240115_1230", `"audit_logs_20240115_1230"`, rangeBound(utc(2024, 1, 15, 12, 30), utc(2024, 1, 15, 13, 30))),
+	)
+	mock.ExpectQuery(holdsExistQuery).WithArgs(`"cleaner_holds"`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	expectDrop(mock, expired, 5, 8192, nil)
+
+	result, err := c.dropExpiredPartitions(t.Context(), c.cutoff(), nil)
+	if err != nil {
+		t.Fatalf("dropExpiredPartitions() = %v", err)
+	}
+	if !slices.Equal(result.Partitions, []string{expired.name}) {
+		t.Errorf("dropExpiredPartitions() dropped %v, want [%s]", result.Partitions, expired.name)
+	}
+}
+
+func TestResolveDetach(t *testing.T) {
+	tests := []struct {
+		name             string
+		strategy         string
+		version          int
+		defaultPartition bool
+		want             string
+	}{
+		{"drop directly", DropDirect, 0, false, ""},
+		{"postgres 16", DropDetach, 160004, false, detachConcurrently},
+		{"postgres 14", DropDetach, 140000, false, detachConcurrently},
+		{"postgres 13", DropDetach, 130015, false, detachPlain},
+		{"default partition", DropDetach, 160004, true, detachPlain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, mock := newMockCleaner(t, Options{DropStrategy: tt.strategy})
+			c.strategy = StrategyPartition
+			if tt.defaultPartition {
+				c.defaultPartition = &partition{name: "audit_logs_default", ident: `"audit_logs_default"`}
+			}
+			if tt.version != 0 {
+				mock.ExpectQuery(`SHOW server_version_num`).
+					WillReturnRows(sqlmock.NewRows([]string{"server_version_num"}).AddRow(fmt.Sprint(tt.version)))
+			}
+
+			if err := c.resolveDetach(t.Context()); err != nil {
+				t.Fatalf("resolveDetach() = %v", err)
+			}
+			if c.detach != tt.want {
+				t.Errorf("detach = %q, want %q", c.detach, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetachPartition(t *testing.T) {
+	p := partition{name: "audit_logs_20240115_1100", ident: `"audit_logs_20240115_1100"`}
+	detach := `ALTER TABLE "audit_logs" DETACH PARTITION ` + p.ident
+	pending := &pq.Error{Code: "55000", Message: `partition "audit_logs_20240115_1100" already pending detach`}
+
+	t.Run("concurrently", func(t *testing.T) {
+		c, mock := newMockCleaner(t, Options{LockTimeout: 5 * time.Second})
+		c.detach = detachConcurrently
+		mock.ExpectExec(`SELECT set_config($1, $2, $3)`).WithArgs("lock_timeout", "5000", false).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(detach + ` CONCURRENTLY`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`RESET lock_timeout`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		if err := c.detachPartition(t.Context(), p); err != nil {
+			t.Fatalf("detachPartition() = %v", err)
+		}
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		c, mock := newMockCleaner(t, Options{})
+		c.detach = detachConcurrently
+		mock.ExpectExec(detach + ` CONCURRENTLY`).WillReturnError(pending)
+		mock.ExpectExec(detach + ` FINALIZE`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		if err := c.detachPartition(t.Context(), p); err != nil {
+			t.Fatalf("detachPartition() = %v", err)
+		}
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		c, mock := newMockCleaner(t, Options{LockTimeout: 5 * time.Second})
+		c.detach = detachPlain
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT set_config($1, $2, $3)`).WithArgs("lock_timeout", "5000", true).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(detach).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		if err := c.detachPartition(t.Context(), p); err != nil {
+			t.Fatalf("detachPartition() = %v", err)
+		}
+	})
+}
+
+func TestDropPartitionsByStrategy(t *testing.T) {
+	p := partition{name: "audit_logs_20240115_1100", ident: `"audit_logs_20240115_1100"`,
+		from: utc(2024, 1, 15, 11, 0), to: utc(2024, 1, 15, 12, 0)}
+	detach := `ALTER TABLE "audit_logs" DETACH PARTITION ` + p.ident
+
+	// expectCountedDrop expects the row count and size of p to be read,
+	// and p to be dropped in a transaction with a lock timeout
+	expectCountedDrop := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(`SELECT pg_total_relation_size($1::regclass)`).WithArgs(p.ident).
+			WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(8192))
+		mock.ExpectQuery(`SELECT count(*) FROM ` + p.ident).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(12))
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT set_config($1, $2, $3)`).WithArgs("lock_timeout", "5000", true).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`DROP TABLE ` + p.ident).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+	}
+
+	tests := []struct {
+		name    string
+		opts    Options
+		detach  string
+		archive bool
+		expect  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:   "drop",
+			opts:   Options{DropStrategy: DropDirect},
+			expect: expectCountedDrop,
+		},
+		{
+			name:   "detach concurrently",
+			opts:   Options{DropStrategy: DropDetach},
+			detach: detachConcurrently,
+			expect: func(mock sqlmock.Sqlmock) {
+				// Outside of any transaction, with the timeout set for the session
+				mock.ExpectExec(`SELECT set_config($1, $2, $3)`).WithArgs("lock_timeout", "5000", false).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(detach + ` CONCURRENTLY`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`RESET lock_timeout`).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectCountedDrop(mock)
+			},
+		},
+		{
+			name:   "detach plainly",
+			opts:   Options{DropStrategy: DropDetach},
+			detach: detachPlain,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`SELECT set_config($1, $2, $3)`).WithArgs("lock_timeout", "5000", true).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(detach).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+				expectCountedDrop(mock)
+			},
+		},
+		{
+			name:    "detach and archive",
+			opts:    Options{DropStrategy: DropDetach},
+			detach:  detachConcurrently,
+			archive: true,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`SELECT set_config($1, $2, $3)`).WithArgs("lock_timeout", "5000", false).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(detach + ` CONCURRENTLY`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`RESET lock_timeout`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`SELECT pg_total_relation_size($1::regclass)`).WithArgs(p.ident).
+					WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(8192))
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT * FROM ` + p.ident).WillReturnRows(
+					sqlmock.NewRows([]string{"id", "message"}).AddRow(1, "a").AddRow(2, "b"))
+				mock.ExpectCommit()
+				// The detached partition cannot be written to, so it is
+				// dropped without locking it and counting its rows again
+				mock.ExpectBegin()
+				mock.ExpectExec(`SELECT set_config($1, $2, $3)`).WithArgs("lock_timeout", "5000", true).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`DROP TABLE ` + p.ident).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.LockTimeout = 5 * time.Second
+			c, mock := newMockCleaner(t, tt.opts)
+			c.detach = tt.detach
+			tt.expect(mock)
+			var archive *archiveWriter
+			if tt.archive {
+				archive = newArchiveWriter(t.TempDir(), "audit_logs", utc(2024, 1, 15, 12, 0), false)
+			}
+
+			result, err := c.dropPartitions(t.Context(), []partition{p}, archive, policyMaxAge)
+			if err != nil {
+				t.Fatalf("dropPartitions() = %v", err)
+			}
+			if !slices.Equal(result.Partitions, []string{p.name}) {
+				t.Errorf("dropped %v, want %s", result.Partitions, p.name)
+			}
+		})
+	}
+}
+
+func TestDropPartitionsReportsDetachedPartition(t *testing.T) {
+	p := partition{name: "audit_logs_20240115_1100", ident: `"audit_logs_20240115_1100"`,
+		from: utc(2024, 1, 15, 11, 0), to: utc(2024, 1, 15, 12, 0)}
+	c, mock := newMockCleaner(t, Options{DropStrategy: DropDetach, LockTimeout: 5 * time.Second})
+	c.detach = detachConcurrently
+
+	// A drop that times out is not left for the next cycle once the
+	// partition is detached
+	mock.ExpectExec(`SELECT set_config($1, $2, $3)`).WithArgs("lock_timeout", "5000", false).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ALTER TABLE "audit_logs" DETACH PARTITION ` + p.ident + ` CONCURRENTLY`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`RESET lock_timeout`).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDrop(mock, p, 12, 8192, &pq.Error{Code: "55P03"})
+
+	_, err := c.dropPartitions(t.Context(), []partition{p}, nil, policyMaxAge)
+	if err == nil || !strings.Contains(err.Error(), "must be dropped manually") {
+		t.Fatalf("dropPartitions() = %v, want the detached partition reported", err)
+	}
+}
@@ -0,0 +1,98 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// maxPremakeInterval caps the time between the passes of
+// RunPartitionMaintainer.
+const maxPremakeInterval = time.Hour
+
+// premakes reports whether the Cleaner creates partitions ahead of time:
+// with PartitionPremake, for a table cleaned up by dropping partitions.
+func (c *Cleaner) premakes() bool {
+	return c.opts.PartitionPremake > 0 && c.strategy == StrategyPartition && c.opts.Storage != StorageTimescale
+}
+
+// RunPartitionMaintainer creates the partitions of PartitionGranularity for
+// the current range and the PartitionPremake ranges after it right away,
+// and then every quarter of the granularity's range, at most every hour,
+// until ctx is cancelled; it then returns ctx's error. Inserts thus find
+// their partition in place instead of creating it on demand. Failed passes
+// are logged, not returned, and retried after a backoff that grows with
+// every failure in a row. Without PartitionPremake it returns nil at once.
+func (c *Cleaner) RunPartitionMaintainer(ctx context.Context) error {
+	if !c.premakes() {
+		return nil
+	}
+
+	interval := min(granularities[c.opts.PartitionGranularity].step/4, maxPremakeInterval)
+	failures := failures{routine: "partition maintainer"}
+	ticker := c.opts.Clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := c.premakePartitions(ctx)
+		switch {
+		case err == nil:
+			if failures.count > 0 {
+				ticker.Reset(interval)
+			}
+			c.succeeded(&failures)
+		case ctx.Err() == nil:
+			slog.Error("creating partitions ahead of time failed", "table", c.opts.Table, "error", err)
+			c.checkConnection(ctx, err)
+			ticker.Reset(c.failed(&failures, err, interval))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+		}
+	}
+}
+
+// premakePartitions creates the partitions of PartitionGranularity for the
+// current range and the PartitionPremake ranges after it that no partition
+// covers yet, and returns the names of those it created. Partitions that
+// already exist, or that another session creates at the same time, are
+// skipped without an error, so running it again, or on several instances
+// at once, is harmless.
+func (c *Cleaner) premakePartitions(ctx context.Context) ([]string, error) {
+	var existing []partition
+	err := c.withRetry(ctx, "list partitions", func(ctx context.Context) error {
+		var err error
+		existing, err = c.listPartitions(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing partitions: %w", err)
+	}
+
+	var created []string
+	step := granularities[c.opts.PartitionGranularity].step
+	now := c.opts.Clock.Now()
+	for i := range c.opts.PartitionPremake + 1 {
+		name, from, to, err := c.partitionFor(now.Add(time.Duration(i) * step))
+		if err != nil {
+			return created, err
+		}
+		if slices.ContainsFunc(existing, func(p partition) bool { return p.overlaps(from, to) }) {
+			continue
+		}
+
+		made, err := c.ensurePartition(ctx, name, from, to)
+		if err != nil {
+			return created, fmt.Errorf("creating partition %s: %w", name, err)
+		}
+		if made {
+			created = append(created, name)
+		}
+	}
+	return created, nil
+}
@@ -0,0 +1,137 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestPremakePartitions(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 15, 12, 34, 56, 0, time.UTC))
+	c, mock := newMockCleaner(t, Options{PartitionGranularity: GranularityHour, PartitionPremake: 3, Clock: clock})
+	c.strategy = StrategyPartition
+
+	// The current range is partitioned already, the next one is created by
+	// another instance at the same time, and the two after it are missing
+	mock.ExpectQuery(catalogQuery).WithArgs("audit_logs").WillReturnRows(
+		sqlmock.NewRows([]string{"relname", "ident", "bound"}).
+			AddRow("audit_logs_20240115_12", `"audit_logs_20240115_12"`, rangeBound(utc(2024, 1, 15, 12, 0), utc(2024, 1, 15, 13, 0))),
+	)
+	for _, p := range []struct {
+		name, create string
+		err          error
+	}{
+		{"audit_logs_20240115_13", `CREATE TABLE IF NOT EXISTS "audit_logs_20240115_13" PARTITION OF "audit_logs" FOR VALUES FROM ('2024-01-15T13:00:00Z') TO ('2024-01-15T14:00:00Z')`,
+			&pq.Error{Code: "42P07", Message: `relation "audit_logs_20240115_13" already exists`}},
+		{"audit_logs_20240115_14", `CREATE TABLE IF NOT EXISTS "audit_logs_20240115_14" PARTITION OF "audit_logs" FOR VALUES FROM ('2024-01-15T14:00:00Z') TO ('2024-01-15T15:00:00Z')`, nil},
+		{"audit_logs_20240115_15", `CREATE TABLE IF NOT EXISTS "audit_logs_20240115_15" PARTITION OF "audit_logs" FOR VALUES FROM ('2024-01-15T15:00:00Z') TO ('2024-01-15T16:00:00Z')`, nil},
+	} {
+		mock.ExpectQuery(existsQuery).WithArgs(`"` + p.name + `"`).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		exec := mock.ExpectExec(p.create)
+		if p.err != nil {
+			exec.WillReturnError(p.err)
+		} else {
+			exec.WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+	}
+
+	created, err := c.premakePartitions(t.Context())
+	if err != nil {
+		t.Fatalf("premakePartitions() = %v", err)
+	}
+	if want := []string{"audit_logs_20240115_14", "audit_logs_20240115_15"}; !slices.Equal(created, want) {
+		t.Errorf("premakePartitions() created %v, want %v", created, want)
+	}
+}
+
+func TestPremakePartitionsIsIdempotent(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 15, 12, 34, 56, 0, time.UTC))
+	c, mock := newMockCleaner(t, Options{PartitionGranularity: GranularityDay, PartitionPremake: 1, Clock: clock})
+	c.strategy = StrategyPartition
+
+	// Every range is covered, so nothing is checked or created
+	mock.ExpectQuery(catalogQuery).WithArgs("audit_logs").WillReturnRows(
+		sqlmock.NewRows([]string{"relname", "ident", "bound"}).
+			AddRow("audit_logs_20240115", `"audit_logs_20240115"`, rangeBound(utc(2024, 1, 15, 0, 0), utc(2024, 1, 16, 0, 0))).
+			AddRow("audit_logs_20240116", `"audit_logs_20240116"`, rangeBound(utc(2024, 1, 16, 0, 0), utc(2024, 1, 17, 0, 0))),
+	)
+
+	created, err := c.premakePartitions(t.Context())
+	if err != nil {
+		t.Fatalf("premakePartitions() = %v", err)
+	}
+	if len(created) != 0 {
+		t.Errorf("premakePartitions() created %v, want none", created)
+	}
+}
+
+// waitForExpectations waits until every statement expected from mock has
+// run, failing the test after a few seconds.
+func waitForExpectations(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for mock.ExpectationsWereMet() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("statements still expected: %v", mock.ExpectationsWereMet())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRunPartitionMaintainer(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 15, 12, 34, 30, 0, time.UTC))
+	c, mock := newMockCleaner(t, Options{PartitionGranularity: GranularityMinute, PartitionPremake: 2, Clock: clock})
+	c.strategy = StrategyPartition
+
+	// expectCreated expects the partitions of the minutes starting at
+	// start to be created, after listing those that exist
+	var existing []time.Time
+	expectCreated := func(starts ...time.Time) {
+		listed := sqlmock.NewRows([]string{"relname", "ident", "bound"})
+		for _, from := range existing {
+			name := "audit_logs_" + from.Format("20060102_1504")
+			listed.AddRow(name, `"`+name+`"`, rangeBound(from, from.Add(time.Minute)))
+		}
+		mock.ExpectQuery(catalogQuery).WithArgs("audit_logs").WillReturnRows(listed)
+		for _, from := range starts {
+			name := "audit_logs_" + from.Format("20060102_1504")
+			mock.ExpectQuery(existsQuery).WithArgs(`"` + name + `"`).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			mock.ExpectExec(c.partitionStatement(name, from, from.Add(time.Minute))).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		existing = append(existing, starts...)
+	}
+
+	// The first pass runs right away and covers the current minute and the
+	// two after it
+	expectCreated(utc(2024, 1, 15, 12, 34), utc(2024, 1, 15, 12, 35), utc(2024, 1, 15, 12, 36))
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() { done <- c.RunPartitionMaintainer(ctx) }()
+	waitForExpectations(t, mock)
+
+	// A pass within the same minute creates nothing
+	expectCreated()
+	clock.Advance(15 * time.Second)
+	waitForExpectations(t, mock)
+
+	// Once the next minute starts, one more is needed
+	expectCreated(utc(2024, 1, 15, 12, 37))
+	clock.Advance(15 * time.Second)
+	waitForExpectations(t, mock)
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("RunPartitionMaintainer() = %v, want context.Canceled", err)
+	}
+	if got := c.partitionsCreated.Load(); got != 4 {
+		t.Errorf("created %d partitions, want 4", got)
+	}
+}
@@ -0,0 +1,150 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// Retention policies that select partitions to drop, as logged.
+const (
+	policyMaxAge        = "max_age"
+	policyMaxPartitions = "max_partitions"
+	policyMaxTotalSize  = "max_total_size"
+)
+
+// overLimit selects the partitions to drop, oldest first, to bring the table
+// within MaxPartitions and then within MaxTotalSize, ignoring the partitions
+// in skip as if they were already gone. Only partitions that lie entirely in
+// the past are candidates, so the one taking current writes, any future ones
+// and the newest MinRetained are kept even if the limits cannot be met.
+func (c *Cleaner) overLimit(ctx context.Context, skip []partition) (byCount, bySize []partition, err error) {
+	query := `
+		SELECT child.relname, pg_total_relation_size(child.oid),
+		       COALESCE(pg_get_expr(child.relpartbound, child.oid) = 'DEFAULT', false)
+		FROM pg_inherits i
+		JOIN pg_class parent ON parent.oid = i.inhparent
+		JOIN pg_namespace pn ON pn.oid = parent.relnamespace
+		JOIN pg_class child ON child.oid = i.inhrelid
+		WHERE parent.relname = $1 AND pn.nspname = current_schema()
+	`
+
+	skipped := func(name string) bool {
+		return slices.ContainsFunc(skip, func(p partition) bool { return p.name == name })
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, c.opts.Table)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	// The default partition counts towards the size, but is never dropped
+	// and is not one of the partitions MaxPartitions counts
+	sizes := make(map[string]int64)
+	var count int
+	var total int64
+	for rows.Next() {
+		var name string
+		var size int64
+		var isDefault bool
+		if err := rows.Scan(&name, &size, &isDefault); err != nil {
+			return nil, nil, err
+		}
+		if skipped(name) {
+			continue
+		}
+		sizes[name] = size
+		total += size
+		if !isDefault {
+			count++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	candidates, err := c.expiredPartitions(ctx, c.opts.Clock.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, p := range candidates {
+		if skipped(p.name) {
+			continue
+		}
+		switch {
+		case c.opts.MaxPartitions > 0 && count > c.opts.MaxPartitions:
+			byCount = append(byCount, p)
+		case c.opts.MaxTotalSize > 0 && total > c.opts.MaxTotalSize:
+			bySize = append(bySize, p)
+		default:
+			return byCount, bySize, nil
+		}
+		count--
+		total -= sizes[p.name]
+	}
+
+	if (c.opts.MaxPartitions > 0 && count > c.opts.MaxPartitions) || (c.opts.MaxTotalSize > 0 && total > c.opts.MaxTotalSize) {
+		slog.Warn("partition limits exceeded, but only current, future and retained partitions are left",
+			"table", c.opts.Table, "partitions", count, "max_partitions", c.opts.MaxPartitions,
+			"bytes", total, "max_total_size", c.opts.MaxTotalSize)
+	}
+	return byCount, bySize, nil
+}
+
+// dropOverLimit drops the oldest partitions until the table is within
+// MaxPartitions and MaxTotalSize, and returns what was dropped.
+func (c *Cleaner) dropOverLimit(ctx context.Context, archive *archiveWriter) (CleanupResult, error) {
+	var byCount, bySize []partition
+	err := c.withRetry(ctx, "check partition limits", func(ctx context.Context) error {
+		var err error
+		byCount, bySize, err = c.overLimit(ctx, nil)
+		return err
+	})
+	if err != nil {
+		return CleanupResult{}, fmt.Errorf("checking partition limits: %w", err)
+	}
+
+	result, err := c.dropPartitions(ctx, byCount, archive, policyMaxPartitions)
+	if err != nil {
+		return result, err
+	}
+	bySizeResult, err := c.dropPartitions(ctx, bySize, archive, policyMaxTotalSize)
+	result.Rows += bySizeResult.Rows
+	result.Partitions = append(result.Partitions, bySizeResult.Partitions...)
+	result.Bytes += bySizeResult.Bytes
+	return result, err
+}
+
+// checkRetention warns when MaxAge is shorter than twice the range of the
+// table's partitions, or of its chunks: cleanup would then keep dropping
+// partitions soon after their last rows are written. The range is that of
+// the widest existing partition, or ChunkInterval for a hypertable.
+func (c *Cleaner) checkRetention(ctx context.Context) error {
+	if c.strategy != StrategyPartition || c.opts.Retention == RetentionCount {
+		return nil
+	}
+
+	step := c.opts.ChunkInterval
+	if c.opts.Storage != StorageTimescale {
+		partitions, err := c.listPartitions(ctx)
+		if err != nil {
+			return fmt.Errorf("listing partitions: %w", err)
+		}
+		step = 0
+		for _, p := range partitions {
+			if !p.from.IsZero() && !p.to.IsZero() {
+				step = max(step, p.to.Sub(p.from))
+			}
+		}
+	}
+
+	if step > 0 && c.opts.MaxAge < 2*step {
+		slog.Warn("max age is shorter than twice the partition range, freshly written partitions will keep being dropped",
+			"table", c.opts.Table, "max_age", c.opts.MaxAge, "partition_range", step,
+			"min_retained", c.opts.MinRetained)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package cleaner
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// sizesQuery is the statement overLimit reads the partition sizes with.
+const sizesQuery = `
+	SELECT child.relname, pg_total_relation_size(child.oid),
+	       COALESCE(pg_get_expr(child.relpartbound, child.oid) = 'DEFAULT', false)
+	FROM pg_inherits i
+	JOIN pg_class parent ON parent.oid = i.inhparent
+	JOIN pg_namespace pn ON pn.oid = parent.relnamespace
+	JOIN pg_class child ON child.oid = i.inhrelid
+	WHERE parent.relname = $1 AND pn.nspname = current_schema()
+`
+
+func TestOverLimit(t *testing.T) {
+	now := utc(2024, 1, 15, 12, 30)
+	// Hourly partitions of 100 bytes each, from 08:00 to the current one,
+	// and a default partition of 50 bytes
+	hours := []time.Time{utc(2024, 1, 15, 8, 0), utc(2024, 1, 15, 9, 0), utc(2024, 1, 15, 10, 0),
+		utc(2024, 1, 15, 11, 0), utc(2024, 1, 15, 12, 0)}
+
+	tests := []struct {
+		name      string
+		opts      Options
+		skip      []partition
+		wantCount []string
+		wantSize  []string
+	}{
+		{"within the limits", Options{MaxPartitions: 5, MaxTotalSize: 550}, nil, nil, nil},
+		{"too many partitions", Options{MaxPartitions: 3}, nil,
+			[]string{"audit_logs_20240115_0800", "audit_logs_20240115_0900"}, nil},
+		{"too large", Options{MaxTotalSize: 300}, nil,
+			nil, []string{"audit_logs_20240115_0800", "audit_logs_20240115_0900", "audit_logs_20240115_1000"}},
+		{"both", Options{MaxPartitions: 4, MaxTotalSize: 300}, nil,
+			[]string{"audit_logs_20240115_0800"}, []string{"audit_logs_20240115_0900", "audit_logs_20240115_1000"}},
+		{"only past partitions", Options{MaxPartitions: 1}, nil,
+			[]string{"audit_logs_20240115_0800", "audit_logs_20240115_0900", "audit_logs_20240115_1000", "audit_logs_20240115_1100"}, nil},
+		{"already dropped by age", Options{MaxPartitions: 3}, []partition{{name: "audit_logs_20240115_0800"}},
+			[]string{"audit_logs_20240115_0900"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Clock = newFakeClock(now)
+			c, mock := newMockCleaner(t, opts)
+
+			sizes := sqlmock.NewRows([]string{"relname", "size", "is_default"}).AddRow("audit_logs_default", 50, true)
+			bounds := sqlmock.NewRows([]string{"relname", "ident", "bound"}).
+				AddRow("audit_logs_default", `"audit_logs_default"`, "DEFAULT")
+			for _, from := range hours {
+				name := c.opts.partitionName(from, time.Hour)
+				sizes.AddRow(name, 100, false)
+				bounds.AddRow(name, `"`+name+`"`, rangeBound(from, from.Add(time.Hour)))
+			}
+			mock.ExpectQuery(sizesQuery).WithArgs("audit_logs").WillReturnRows(sizes)
+			mock.ExpectQuery(catalogQuery).WithArgs("audit_logs").WillReturnRows(bounds)
+			mock.ExpectQuery(holdsExistQuery).WithArgs(`"cleaner_holds"`).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+			byCount, bySize, err := c.overLimit(t.Context(), tt.skip)
+			if err != nil {
+				t.Fatalf("overLimit() = %v", err)
+			}
+			if got := partitionNames(byCount); !slices.Equal(got, tt.wantCount) && len(got)+len(tt.wantCount) > 0 {
+				t.Errorf("overLimit() by count = %v, want %v", got, tt.wantCount)
+			}
+			if got := partitionNames(bySize); !slices.Equal(got, tt.wantSize) && len(got)+len(tt.wantSize) > 0 {
+				t.Errorf("overLimit() by size = %v, want %v", got, tt.wantSize)
+			}
+		})
+	}
+}
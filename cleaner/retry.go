@@ -0,0 +1,155 @@
+package cleaner
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// maxRetryDelay caps the exponential backoff between attempts.
+const maxRetryDelay = 30 * time.Second
+
+// isTransient reports whether err is a connection-level failure that is
+// worth retrying, as opposed to e.g. a syntax or constraint error.
+func isTransient(err error) bool {
+	// context.DeadlineExceeded satisfies net.Error, but a timeout is not a
+	// connection failure
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// Retrying cannot fix a certificate the other side rejects
+	if IsTLSError(err) {
+		return false
+	}
+
+	if code := sqlState(err); code != "" {
+		switch code {
+		case "57P01", "57P02", "57P03": // admin_shutdown, crash_shutdown, cannot_connect_now
+			return true
+		}
+		return strings.HasPrefix(code, "08") // connection_exception
+	}
+	// pgx marks the errors raised before anything was sent to the server
+	if pgconn.SafeToRetry(err) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// IsTLSError reports whether err is a failed TLS handshake with the
+// database: a certificate that does not verify, an alert from the server,
+// e.g. for a rejected client certificate, or a server without TLS.
+func IsTLSError(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	return errors.As(err, &verifyErr) || errors.As(err, &alertErr) || errors.As(err, &recordErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) ||
+		errors.Is(err, pq.ErrSSLNotSupported)
+}
+
+// isTimeout reports whether err is the server giving up on a statement:
+// lock_not_available from lock_timeout, or query_canceled from
+// statement_timeout.
+func isTimeout(err error) bool {
+	code := sqlState(err)
+	return code == "55P03" || code == "57014"
+}
+
+// isPartitionExists reports whether err is Postgres refusing to create a
+// partition because another session just created it: duplicate_table, a
+// unique_violation on the catalog when both create it at the same time, or
+// invalid_object_definition for a range overlapping the new partition.
+func isPartitionExists(err error) bool {
+	switch sqlState(err) {
+	case "42P07", "23505", "42P17":
+		return true
+	}
+	return false
+}
+
+// retryBackoff returns the backoff after the failed attempt, counted from
+// 1: baseDelay, doubled for every attempt before it, up to maxRetryDelay.
+// It stops doubling at the cap, so that it cannot overflow however long
+// withRetry retries.
+func retryBackoff(baseDelay time.Duration, attempt int) time.Duration {
+	backoff := baseDelay
+	for i := 1; i < attempt && backoff < maxRetryDelay; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRetryDelay)
+}
+
+// withRetry calls fn up to attempts times, sleeping with exponential backoff
+// and jitter between attempts. Only transient errors are retried; anything
+// else is returned immediately.
+func withRetry(ctx context.Context, attempts int, baseDelay time.Duration, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= attempts || !isTransient(err) {
+			return err
+		}
+
+		backoff := retryBackoff(baseDelay, attempt)
+		delay := backoff + rand.N(backoff+1)
+		args := []any{"attempt", attempt, "max_attempts", attempts, "delay", delay, "error", err}
+		if attempts == math.MaxInt {
+			// Retrying until ctx is done
+			args = []any{"attempt", attempt, "delay", delay, "error", err}
+		}
+		slog.Warn("transient database error, retrying", args...)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// WaitForDB pings db until it answers, retrying transient failures up to
+// retries times with exponential backoff from baseDelay, e.g. while Postgres
+// is still starting. A timeout greater than 0 replaces the limit on retries:
+// they go on until timeout has passed, and the last failure is returned.
+func WaitForDB(ctx context.Context, db *sql.DB, retries int, baseDelay, timeout time.Duration) error {
+	attempts := retries + 1
+	waitCtx := ctx
+	if timeout > 0 {
+		attempts = math.MaxInt
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var last error
+	err := withRetry(waitCtx, attempts, baseDelay, func() error {
+		last = db.PingContext(waitCtx)
+		return last
+	})
+	if err != nil && ctx.Err() == nil && waitCtx.Err() != nil && last != nil {
+		return fmt.Errorf("database not reachable within %s: %w", timeout, last)
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+package cleaner
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		baseDelay time.Duration
+		attempt   int
+		want      time.Duration
+	}{
+		{"first attempt", 100 * time.Millisecond, 1, 100 * time.Millisecond},
+		{"second attempt", 100 * time.Millisecond, 2, 200 * time.Millisecond},
+		{"eighth attempt", 100 * time.Millisecond, 8, 12800 * time.Millisecond},
+		{"capped", 100 * time.Millisecond, 10, maxRetryDelay},
+		{"past the shift overflow", 100 * time.Millisecond, 40, maxRetryDelay},
+		{"last attempt", 100 * time.Millisecond, math.MaxInt, maxRetryDelay},
+		{"base above the cap", time.Minute, 1, maxRetryDelay},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryBackoff(tt.baseDelay, tt.attempt); got != tt.want {
+				t.Errorf("retryBackoff(%s, %d) = %s, want %s", tt.baseDelay, tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	permanent := errors.New("syntax error")
+	tests := []struct {
+		name      string
+		attempts  int
+		errs      []error // Returned by the successive calls, then nil
+		wantCalls int
+		wantErr   error
+	}{
+		{"success", 3, nil, 1, nil},
+		{"transient then success", 3, []error{driver.ErrBadConn, driver.ErrBadConn}, 3, nil},
+		{"transient until out of attempts", 2, []error{driver.ErrBadConn, driver.ErrBadConn, driver.ErrBadConn}, 2, driver.ErrBadConn},
+		{"permanent", 3, []error{permanent}, 1, permanent},
+		{"canceled", 3, []error{context.Canceled}, 1, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := withRetry(context.Background(), tt.attempts, time.Microsecond, func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("withRetry() = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("withRetry() called fn %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWithRetryStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := withRetry(ctx, math.MaxInt, time.Millisecond, func() error {
+		calls++
+		if calls == 3 {
+			cancel()
+		}
+		return driver.ErrBadConn
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("withRetry() = %v, want %v", err, context.Canceled)
+	}
+	if calls != 3 {
+		t.Errorf("withRetry() called fn %d times, want 3", calls)
+	}
+}
+
+func TestCleanerRetriesDatabase(t *testing.T) {
+	create := `CREATE TABLE IF NOT EXISTS "audit_logs_20240115_1200" PARTITION OF "audit_logs" FOR VALUES FROM ('2024-01-15T12:00:00Z') TO ('2024-01-15T13:00:00Z')`
+	tests := []struct {
+		name       string
+		maxRetries int
+		errs       []error // Returned by the successive attempts, then success
+		wantErr    bool
+	}{
+		{"transient then success", 2, []error{connectionFailure, connectionFailure}, false},
+		{"transient until out of retries", 1, []error{connectionFailure, connectionFailure}, true},
+		{"permanent", 2, []error{&pq.Error{Code: "42601", Message: "syntax error"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, mock := newMockCleaner(t, Options{MaxRetries: tt.maxRetries, RetryBaseDelay: time.Millisecond})
+			mock.ExpectQuery(existsQuery).WithArgs(`"audit_logs_20240115_1200"`).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			attempts := min(len(tt.errs), tt.maxRetries+1)
+			for _, err := range tt.errs[:attempts] {
+				mock.ExpectExec(create).WillReturnError(err)
+			}
+			if !tt.wantErr {
+				mock.ExpectExec(create).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+
+			created, err := c.ensurePartition(t.Context(), "audit_logs_20240115_1200", utc(2024, 1, 15, 12, 0), utc(2024, 1, 15, 13, 0))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ensurePartition() = %v, want error %t", err, tt.wantErr)
+			}
+			if created == tt.wantErr {
+				t.Errorf("ensurePartition() created = %t, want %t", created, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWaitForDB(t *testing.T) {
+	refused := &pq.Error{Code: "08001", Message: "connection refused"}
+	tests := []struct {
+		name      string
+		retries   int
+		timeout   time.Duration
+		errs      []error // Returned by the successive pings, then success
+		wantPings int
+		wantErr   string // Empty when the database answers in time
+	}{
+		{"answers", 3, 0, nil, 1, ""},
+		{"answers after failing", 3, 0, []error{refused, refused, refused}, 4, ""},
+		{"out of retries", 2, 0, []error{refused, refused, refused}, 3, "pq: connection refused"},
+		{"permanent failure", 3, 0, []error{&pq.Error{Code: "28P01", Message: "password authentication failed"}}, 1,
+			"password authentication failed"},
+		{"timeout", 0, 20 * time.Millisecond, slices.Repeat([]error{refused}, 1000), -1,
+			"database not reachable within 20ms: pq: connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			if err != nil {
+				t.Fatalf("opening mock database: %v", err)
+			}
+			defer db.Close()
+			for _, err := range tt.errs {
+				mock.ExpectPing().WillReturnError(err)
+			}
+			if tt.wantPings > len(tt.errs) {
+				mock.ExpectPing()
+			}
+
+			err = WaitForDB(t.Context(), db, tt.retries, time.Millisecond, tt.timeout)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("WaitForDB() = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("WaitForDB() = %v, want an error containing %q", err, tt.wantErr)
+			}
+			// Until the timeout, pings go on regardless of retries
+			if tt.wantPings >= 0 {
+				if err := mock.ExpectationsWereMet(); err != nil {
+					t.Errorf("WaitForDB() did not ping %d times: %v", tt.wantPings, err)
+				}
+			}
+		})
+	}
+}
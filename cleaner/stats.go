@@ -0,0 +1,101 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// PartitionStats describes the size of one partition of a table.
+type PartitionStats struct {
+	Name  string
+	Range string // Partition bound, e.g. FROM ('2024-01-01') TO ('2024-02-01')
+	Rows  int64  // Approximate, from the planner statistics
+	Bytes int64  // Including indexes and TOAST
+
+	// From and To are the time range of the partition, as parsed from its
+	// bound; zero for MINVALUE or MAXVALUE, for the DEFAULT partition and
+	// for a bound that is not a time range
+	From, To time.Time
+}
+
+// TableStats describes the size of a managed table. For a partitioned table
+// the totals are summed over its partitions.
+type TableStats struct {
+	Table      string
+	Rows       int64 // Approximate, from the planner statistics
+	Bytes      int64 // Including indexes and TOAST
+	Partitions []PartitionStats
+}
+
+// Stats reads the size of the table and of each of its partitions from the
+// system catalog in a single query.
+func (c *Cleaner) Stats(ctx context.Context) (TableStats, error) {
+	query := `
+		SELECT c.relname,
+		       c.oid = $1::regclass,
+		       COALESCE(pg_get_expr(c.relpartbound, c.oid), ''),
+		       GREATEST(c.reltuples, 0)::bigint,
+		       pg_total_relation_size(c.oid)
+		FROM pg_class c
+		WHERE c.oid = $1::regclass
+		   OR c.oid IN (SELECT inhrelid FROM pg_inherits WHERE inhparent = $1::regclass)
+		ORDER BY c.relname
+	`
+
+	stats := TableStats{Table: c.opts.Table}
+	err := c.withRetry(ctx, "read table stats", func(ctx context.Context) error {
+		rows, err := c.db.QueryContext(ctx, query, c.ident)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		stats = TableStats{Table: c.opts.Table}
+		var parent PartitionStats
+		for rows.Next() {
+			var p PartitionStats
+			var isParent bool
+			if err := rows.Scan(&p.Name, &isParent, &p.Range, &p.Rows, &p.Bytes); err != nil {
+				return err
+			}
+			if isParent {
+				parent = p
+				continue
+			}
+			if from, to, _, err := parsePartitionBound(p.Range); err == nil {
+				p.From, p.To = from, to
+			}
+			p.Range = strings.TrimPrefix(p.Range, "FOR VALUES ")
+			stats.Partitions = append(stats.Partitions, p)
+			stats.Rows += p.Rows
+			stats.Bytes += p.Bytes
+		}
+		if len(stats.Partitions) == 0 {
+			stats.Rows, stats.Bytes = parent.Rows, parent.Bytes
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return TableStats{}, fmt.Errorf("reading table stats: %w", err)
+	}
+	return stats, nil
+}
+
+// reportStats logs the table's size after a cleanup run and exports it as
+// metrics.
+func (c *Cleaner) reportStats(ctx context.Context) {
+	stats, err := c.Stats(ctx)
+	if err != nil {
+		slog.Warn("could not read table stats", "table", c.opts.Table, "error", err)
+		return
+	}
+
+	tableRows.WithLabelValues(c.opts.Table).Set(float64(stats.Rows))
+	tableBytes.WithLabelValues(c.opts.Table).Set(float64(stats.Bytes))
+	tablePartitions.WithLabelValues(c.opts.Table).Set(float64(len(stats.Partitions)))
+	slog.Info("table stats", "table", c.opts.Table, "rows", stats.Rows, "bytes", stats.Bytes,
+		"partitions", len(stats.Partitions))
+}
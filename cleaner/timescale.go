@@ -0,0 +1,116 @@
+package cleaner
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// checkTimescale verifies that the timescaledb extension is installed in the
+// database, which StorageTimescale needs.
+func (c *Cleaner) checkTimescale(ctx context.Context) error {
+	var version string
+	err := c.db.QueryRowContext(ctx, `SELECT extversion FROM pg_extension WHERE extname = 'timescaledb'`).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s storage needs the timescaledb extension, which is not installed in this database (CREATE EXTENSION timescaledb)",
+			StorageTimescale)
+	}
+	if err != nil {
+		return fmt.Errorf("looking up the timescaledb extension: %w", err)
+	}
+	slog.Debug("timescaledb extension found", "table", c.opts.Table, "version", version)
+	return nil
+}
+
+// createHypertable turns the freshly created table into a hypertable
+// chunked by ChunkInterval on its time column. The table already has an
+// index on the time column, so TimescaleDB's default one is skipped.
+func (c *Cleaner) createHypertable(ctx context.Context) error {
+	query := `
+		SELECT create_hypertable($1::regclass, $2::name,
+			chunk_time_interval => make_interval(secs => $3),
+			create_default_indexes => false, if_not_exists => true)
+	`
+	if c.opts.DryRun {
+		slog.Info("[DRY RUN] would create hypertable", "table", c.opts.Table, "chunk_interval", c.opts.ChunkInterval)
+		return nil
+	}
+
+	if _, err := c.db.ExecContext(ctx, query, c.ident, c.opts.TimeColumn, c.opts.ChunkInterval.Seconds()); err != nil {
+		return fmt.Errorf("creating hypertable: %w", err)
+	}
+	slog.Info("hypertable created", "table", c.opts.Table, "chunk_interval", c.opts.ChunkInterval)
+	return nil
+}
+
+// resolveHypertableStrategy settles the cleanup strategy of a table with
+// StorageTimescale. The partition strategy drops whole chunks of the
+// hypertable; auto picks it, as every hypertable is chunked on time.
+func (c *Cleaner) resolveHypertableStrategy(ctx context.Context) error {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM timescaledb_information.hypertables
+			WHERE hypertable_schema = current_schema() AND hypertable_name = $1
+		)
+	`
+
+	var hypertable bool
+	if err := c.db.QueryRowContext(ctx, query, c.opts.Table).Scan(&hypertable); err != nil {
+		return fmt.Errorf("inspecting hypertable: %w", err)
+	}
+
+	switch {
+	case !hypertable && c.opts.DryRun:
+		// The table may not have been created yet
+		slog.Warn("table is not a hypertable, deleting rows instead", "table", c.opts.Table)
+		c.strategy = StrategyDelete
+	case !hypertable:
+		return fmt.Errorf("%s storage needs table %s to be a hypertable on %s; convert it with create_hypertable or recreate it with a reset",
+			StorageTimescale, c.opts.Table, c.opts.TimeColumn)
+	case c.opts.Strategy == StrategyDelete:
+		c.strategy = StrategyDelete
+	default:
+		c.strategy = StrategyPartition
+	}
+	return nil
+}
+
+// listChunks lists the chunks of the hypertable with their time ranges,
+// oldest first.
+func (c *Cleaner) listChunks(ctx context.Context) ([]partition, error) {
+	query := `
+		SELECT chunk_name, format('%I.%I', chunk_schema, chunk_name), range_start, range_end
+		FROM timescaledb_information.chunks
+		WHERE hypertable_schema = current_schema() AND hypertable_name = $1
+		ORDER BY range_end
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, c.opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []partition
+	for rows.Next() {
+		var p partition
+		if err := rows.Scan(&p.name, &p.ident, &p.from, &p.to); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, p)
+	}
+	return chunks, rows.Err()
+}
+
+// dropStatement returns the statement that drops partition p: drop_chunks
+// limited to exactly the chunk's time range for a hypertable, so that
+// TimescaleDB keeps its catalog in order, and DROP TABLE otherwise.
+func (c *Cleaner) dropStatement(p partition) (string, []any) {
+	if c.opts.Storage == StorageTimescale {
+		return `SELECT drop_chunks($1::regclass, older_than => $2::timestamptz, newer_than => $3::timestamptz)`,
+			[]any{c.ident, p.to, p.from}
+	}
+	return fmt.Sprintf(`DROP TABLE %s`, p.ident), nil
+}
@@ -0,0 +1,157 @@
+package cleaner
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCheckTimescale(t *testing.T) {
+	const query = `SELECT extversion FROM pg_extension WHERE extname = 'timescaledb'`
+	tests := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		wantErr string // Empty when the extension is found
+	}{
+		{"installed", sqlmock.NewRows([]string{"extversion"}).AddRow("2.14.2"), ""},
+		{"missing", sqlmock.NewRows([]string{"extversion"}), "needs the timescaledb extension"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, mock := newMockCleaner(t, Options{Storage: StorageTimescale})
+			mock.ExpectQuery(query).WillReturnRows(tt.rows)
+
+			err := c.checkTimescale(t.Context())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("checkTimescale() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("checkTimescale() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateHypertable(t *testing.T) {
+	c, mock := newMockCleaner(t, Options{Storage: StorageTimescale, ChunkInterval: 6 * time.Hour})
+	mock.ExpectExec(`
+		SELECT create_hypertable($1::regclass, $2::name,
+			chunk_time_interval => make_interval(secs => $3),
+			create_default_indexes => false, if_not_exists => true)
+	`).WithArgs(`"audit_logs"`, "created_at", float64(6*60*60)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := c.createHypertable(t.Context()); err != nil {
+		t.Fatalf("createHypertable() = %v", err)
+	}
+}
+
+func TestResolveHypertableStrategy(t *testing.T) {
+	const query = `
+		SELECT EXISTS (
+			SELECT 1 FROM timescaledb_information.hypertables
+			WHERE hypertable_schema = current_schema() AND hypertable_name = $1
+		)
+	`
+	tests := []struct {
+		name         string
+		strategy     string
+		dryRun       bool
+		hypertable   bool
+		wantStrategy string
+		wantErr      bool
+	}{
+		{"auto", StrategyAuto, false, true, StrategyPartition, false},
+		{"delete", StrategyDelete, false, true, StrategyDelete, false},
+		{"not a hypertable", StrategyAuto, false, false, "", true},
+		{"not a hypertable yet in a dry run", StrategyAuto, true, false, StrategyDelete, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, mock := newMockCleaner(t, Options{Storage: StorageTimescale, Strategy: tt.strategy, DryRun: tt.dryRun})
+			mock.ExpectQuery(query).WithArgs("audit_logs").
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.hypertable))
+
+			err := c.resolveStrategy(t.Context())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveStrategy() = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && c.strategy != tt.wantStrategy {
+				t.Errorf("strategy = %s, want %s", c.strategy, tt.wantStrategy)
+			}
+		})
+	}
+}
+
+func TestListChunks(t *testing.T) {
+	c, mock := newMockCleaner(t, Options{Storage: StorageTimescale})
+	mock.ExpectQuery(`
+		SELECT chunk_name, format('%I.%I', chunk_schema, chunk_name), range_start, range_end
+		FROM timescaledb_information.chunks
+		WHERE hypertable_schema = current_schema() AND hypertable_name = $1
+		ORDER BY range_end
+	`).WithArgs("audit_logs").WillReturnRows(
+		sqlmock.NewRows([]string{"chunk_name", "ident", "range_start", "range_end"}).
+			AddRow("_hyper_1_1_chunk", "_timescaledb_internal._hyper_1_1_chunk", utc(2024, 1, 14, 0, 0), utc(2024, 1, 15, 0, 0)).
+			AddRow("_hyper_1_2_chunk", "_timescaledb_internal._hyper_1_2_chunk", utc(2024, 1, 15, 0, 0), utc(2024, 1, 16, 0, 0)),
+	)
+
+	chunks, err := c.listChunks(t.Context())
+	if err != nil {
+		t.Fatalf("listChunks() = %v", err)
+	}
+	if got := partitionNames(chunks); !slices.Equal(got, []string{"_hyper_1_1_chunk", "_hyper_1_2_chunk"}) {
+		t.Errorf("listChunks() = %v", got)
+	}
+}
+
+func TestDropStatement(t *testing.T) {
+	p := partition{name: "_hyper_1_1_chunk", ident: "_timescaledb_internal._hyper_1_1_chunk",
+		from: utc(2024, 1, 14, 0, 0), to: utc(2024, 1, 15, 0, 0)}
+	tests := []struct {
+		storage  string
+		wantStmt string
+		wantArgs []any
+	}{
+		{StorageNative, `DROP TABLE _timescaledb_internal._hyper_1_1_chunk`, nil},
+		{StorageTimescale, `SELECT drop_chunks($1::regclass, older_than => $2::timestamptz, newer_than => $3::timestamptz)`,
+			[]any{`"audit_logs"`, p.to, p.from}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.storage, func(t *testing.T) {
+			c := New(nil, Options{Table: "audit_logs", Storage: tt.storage})
+			stmt, args := c.dropStatement(p)
+			if stmt != tt.wantStmt || !slices.Equal(args, tt.wantArgs) {
+				t.Errorf("dropStatement() = %s %v, want %s %v", stmt, args, tt.wantStmt, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestDropChunk(t *testing.T) {
+	c, mock := newMockCleaner(t, Options{Storage: StorageTimescale})
+	p := partition{name: "_hyper_1_1_chunk", ident: "_timescaledb_internal._hyper_1_1_chunk",
+		from: utc(2024, 1, 14, 0, 0), to: utc(2024, 1, 15, 0, 0)}
+
+	mock.ExpectQuery(`SELECT pg_total_relation_size($1::regclass)`).WithArgs(p.ident).
+		WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(8192))
+	mock.ExpectQuery(`SELECT count(*) FROM ` + p.ident).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(12))
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT drop_chunks($1::regclass, older_than => $2::timestamptz, newer_than => $3::timestamptz)`).
+		WithArgs(`"audit_logs"`, p.to, p.from).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	count, bytes, err := c.dropPartition(t.Context(), p, nil)
+	if err != nil {
+		t.Fatalf("dropPartition() = %v", err)
+	}
+	if count != 12 || bytes != 8192 {
+		t.Errorf("dropPartition() = %d rows, %d bytes, want 12 rows, 8192 bytes", count, bytes)
+	}
+}
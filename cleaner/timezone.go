@@ -0,0 +1,70 @@
+package cleaner
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// CheckTimeZone warns when the database session does not run in UTC, as
+// TIMESTAMP columns and NOW() then no longer match the UTC timestamps the
+// Cleaner writes.
+func CheckTimeZone(ctx context.Context, db *sql.DB) error {
+	var tz string
+	if err := db.QueryRowContext(ctx, `SHOW TimeZone`).Scan(&tz); err != nil {
+		return err
+	}
+
+	switch tz {
+	case "UTC", "Etc/UTC":
+	default:
+		slog.Warn("database time zone is not UTC, TIMESTAMP columns and NOW() will not match UTC timestamps",
+			"timezone", tz)
+	}
+	return nil
+}
+
+// checkTimeColumnType warns when the time column is a TIMESTAMP without time
+// zone, or converts it to TIMESTAMPTZ if migrate is set. The conversion
+// reads existing values in the session's TimeZone and rewrites the table
+// under an exclusive lock.
+func (c *Cleaner) checkTimeColumnType(ctx context.Context, migrate bool) error {
+	query := `
+		SELECT data_type
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2
+	`
+
+	// A missing column is reported by schemaMismatches
+	var dataType string
+	err := c.db.QueryRowContext(ctx, query, c.opts.Table, c.opts.TimeColumn).Scan(&dataType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if dataType != "timestamp without time zone" {
+		return nil
+	}
+
+	if !migrate {
+		slog.Warn("time column has no time zone, set MIGRATE_TIMESTAMPTZ to convert it",
+			"table", c.opts.Table, "column", c.opts.TimeColumn)
+		return nil
+	}
+
+	alter := fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN %s TYPE TIMESTAMPTZ`, c.ident, c.timeIdent)
+	if c.opts.DryRun {
+		slog.Info("[DRY RUN] would convert time column to TIMESTAMPTZ", "table", c.opts.Table, "column", c.opts.TimeColumn)
+		return nil
+	}
+
+	if _, err := c.db.ExecContext(ctx, alter); err != nil {
+		return err
+	}
+	slog.Info("time column converted to TIMESTAMPTZ", "table", c.opts.Table, "column", c.opts.TimeColumn)
+	return nil
+}
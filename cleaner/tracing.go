@@ -0,0 +1,21 @@
+package cleaner
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans around inserts and cleanup. It comes from the
+// global tracer provider, which is a no-op unless the application installs
+// one.
+var tracer = otel.Tracer("auditlog-cleaner/cleaner")
+
+// endSpan ends span, marking it as failed if err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
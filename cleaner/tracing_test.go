@@ -0,0 +1,128 @@
+package cleaner
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	tracerProviderOnce sync.Once
+	tracerProvider     *sdktrace.TracerProvider
+)
+
+// recordSpans records the spans ended during the test. The global tracer
+// provider only delegates to the first one installed, so every test
+// registers its recorder with the same provider.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	tracerProviderOnce.Do(func() {
+		tracerProvider = sdktrace.NewTracerProvider()
+		otel.SetTracerProvider(tracerProvider)
+	})
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider.RegisterSpanProcessor(recorder)
+	t.Cleanup(func() { tracerProvider.UnregisterSpanProcessor(recorder) })
+	return recorder
+}
+
+// spanNames returns the names of spans, in the order they ended.
+func spanNames(spans []sdktrace.ReadOnlySpan) []string {
+	names := make([]string, len(spans))
+	for i, s := range spans {
+		names[i] = s.Name()
+	}
+	return names
+}
+
+// spanAttribute returns the value of the attribute key of span.
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestInsertSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	c, mock := newMockCleaner(t, Options{InsertChunkSize: 2})
+	rows := testRows(3, utc(2024, 1, 15, 12, 0))
+	pair := mock.ExpectPrepare(c.insertQuery(2))
+	single := mock.ExpectPrepare(c.insertQuery(1))
+	pair.ExpectQuery().WithArgs(insertArgs(rows[0:2])...).WillReturnRows(insertedRows(rows[0:2], 1))
+	single.ExpectQuery().WithArgs(insertArgs(rows[2:3])...).WillReturnError(errors.New("disk full"))
+
+	if _, err := c.postToDB(t.Context(), rows); err == nil {
+		t.Fatal("postToDB() succeeded, want the failure of the second chunk")
+	}
+
+	spans := recorder.Ended()
+	if got := spanNames(spans); !slices.Equal(got, []string{"cleaner.insert"}) {
+		t.Fatalf("spans = %v, want [cleaner.insert]", got)
+	}
+	span := spans[0]
+	if span.Status().Code != codes.Error {
+		t.Errorf("status = %v, want %v", span.Status().Code, codes.Error)
+	}
+	for key, want := range map[attribute.Key]int64{"cleaner.rows": 3, "cleaner.chunks": 2, "cleaner.inserted": 2} {
+		if got := spanAttribute(span, key).AsInt64(); got != want {
+			t.Errorf("%s = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestCleanupSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	now := utc(2024, 1, 15, 12, 30)
+	c, mock := newMockCleaner(t, Options{MaxAge: time.Hour, LockTimeout: 5 * time.Second, Clock: newFakeClock(now)})
+	c.strategy = StrategyPartition
+
+	expired := []partition{
+		{name: "audit_logs_20240115_0930", ident: `"audit_logs_20240115_0930"`},
+		{name: "audit_logs_20240115_1030", ident: `"audit_logs_20240115_1030"`},
+	}
+	mock.ExpectQuery(catalogQuery).WithArgs("audit_logs").WillReturnRows(
+		sqlmock.NewRows([]string{"relname", "ident", "bound"}).
+			AddRow(expired[0].name, expired[0].ident, rangeBound(utc(2024, 1, 15, 9, 30), utc(2024, 1, 15, 10, 30))).
+			AddRow(expired[1].name, expired[1].ident, rangeBound(utc(2024, 1, 15, 10, 30), utc(2024, 1, 15, 11, 30))),
+	)
+	mock.ExpectQuery(holdsExistQuery).WithArgs(`"cleaner_holds"`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	expectDrop(mock, expired[0], 10, 8192, nil)
+	expectDrop(mock, expired[1], 20, 8192, nil)
+
+	if _, err := c.deleteOldRecords(t.Context()); err != nil {
+		t.Fatalf("deleteOldRecords() = %v", err)
+	}
+
+	spans := recorder.Ended()
+	want := []string{"cleaner.drop_partition", "cleaner.drop_partition", "cleaner.cleanup"}
+	if got := spanNames(spans); !slices.Equal(got, want) {
+		t.Fatalf("spans = %v, want %v", got, want)
+	}
+	cleanup := spans[2]
+	for i, drop := range spans[:2] {
+		if drop.Parent().SpanID() != cleanup.SpanContext().SpanID() {
+			t.Errorf("drop of %s is not a child of the cleanup span", expired[i].name)
+		}
+		if got := spanAttribute(drop, "cleaner.partition").AsString(); got != expired[i].name {
+			t.Errorf("dropped partition = %s, want %s", got, expired[i].name)
+		}
+	}
+	if got := spanAttribute(cleanup, "cleaner.rows").AsInt64(); got != 30 {
+		t.Errorf("cleanup rows = %d, want 30", got)
+	}
+	if cleanup.Status().Code == codes.Error {
+		t.Errorf("cleanup span failed: %s", cleanup.Status().Description)
+	}
+}
@@ -0,0 +1,1657 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"auditlog-cleaner/cleaner"
+)
+
+// Modes select which of the two routines run.
+const (
+	ModeBoth         = "both"
+	ModeCleanupOnly  = "cleanup-only"
+	ModeGenerateOnly = "generate-only"
+)
+
+// SSLModes are the values lib/pq accepts for sslmode.
+var SSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// Run modes select whether the process keeps running.
+const (
+	RunModeDaemon = "daemon" // Run the routines until stopped
+	RunModeOnce   = "once"   // Run a single cleanup pass and exit, like --once
+)
+
+// DatabaseConfig holds the PostgreSQL connection settings.
+type DatabaseConfig struct {
+	// Driver is the database/sql driver, cleaner.DriverPQ or
+	// cleaner.DriverPGX
+	Driver string
+
+	// URL is a postgres:// connection URL; when set, the individual
+	// connection fields below are ignored, except for the TLS files, which
+	// are added to it as parameters
+	URL string
+
+	Host     string
+	Port     int
+	User     string
+	Password string
+	DBName   string
+	SSLMode  string // One of SSLModes
+
+	// Certificate files for TLS; empty leaves lib/pq's defaults
+	SSLRootCert string // CA certificate to verify the server against
+	SSLCert     string // Client certificate
+	SSLKey      string // Client certificate's private key
+
+	// ConnectTimeout bounds each attempt to open a connection
+	ConnectTimeout time.Duration
+
+	// QueryTimeout bounds each database operation; 0 means no limit
+	QueryTimeout time.Duration
+
+	// Connection pool limits; 0 means unlimited
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// TimingConfig holds the insert and cleanup scheduling settings.
+type TimingConfig struct {
+	InsertInterval  time.Duration
+	CleanupInterval time.Duration
+	MaxLogAge       time.Duration
+	ShutdownTimeout time.Duration
+
+	// TickJitter varies each cleanup interval randomly by up to this
+	// percentage, and each insert interval unless InsertJitter is set, so
+	// that replicas started together don't hit the database at once
+	TickJitter float64
+
+	// Insert rate shaping; the zero values insert one log per interval
+	InsertRate   float64       // Logs per second
+	InsertJitter float64       // Random variation of the interval, in percent
+	RampUp       time.Duration // Time to reach the full rate after startup
+
+	// Insert backpressure: after InsertBackoffThreshold failed inserts in
+	// a row, the generator doubles its wait after every failed insert, up
+	// to InsertMaxBackoff, until an insert succeeds
+	InsertBackoffThreshold int
+	InsertMaxBackoff       time.Duration
+
+	// RunDuration shuts the process down gracefully after running this
+	// long, e.g. for load tests; 0 runs until stopped
+	RunDuration time.Duration
+
+	// MaxTotalInserts stops the generator once it has written this many
+	// logs, 0 for no limit; the process then shuts down, unless
+	// KeepCleanup keeps the cleanup running
+	MaxTotalInserts int
+	KeepCleanup     bool
+}
+
+// ArchiveConfig controls archiving of expired records before deletion.
+type ArchiveConfig struct {
+	Dir  string // Archiving is disabled when empty
+	Gzip bool
+
+	// Partitions are archived to this S3 bucket instead, when set
+	S3Bucket          string
+	S3Endpoint        string // Host or URL of an S3-compatible service, AWS by default
+	S3Region          string
+	S3Prefix          string
+	S3AccessKeyID     string // Empty uses the AWS environment or IAM role
+	S3SecretAccessKey string
+}
+
+// NotifyConfig controls the webhook notifications about cleanup.
+type NotifyConfig struct {
+	WebhookURL string // Notifications are disabled when empty
+	Format     string // json or slack
+	OnSuccess  bool   // Also notify about every dropped partition
+}
+
+// CleanupConfig controls how expired rows are removed.
+type CleanupConfig struct {
+	Strategy   string
+	BatchSize  int           // Rows per DELETE with the delete strategy
+	BatchPause time.Duration // Pause between DELETE batches
+	Vacuum     bool          // Run VACUUM (ANALYZE) after deleting rows, ANALYZE after dropping partitions
+
+	// Maintenance analyzes each table and its newest partitions every
+	// MaintenanceInterval and reports their dead tuples
+	Maintenance         bool
+	MaintenanceInterval time.Duration
+
+	// DropStrategy is drop or detach_drop, which detaches each partition
+	// from its table, with DETACH PARTITION CONCURRENTLY where the server
+	// supports it, before dropping it, so that inserts are not blocked by
+	// the drop; DETACH_CONCURRENTLY=true stands for detach_drop
+	DropStrategy string
+
+	// Timeouts for each partition drop and detach; 0 keeps the server's setting
+	LockTimeout      time.Duration
+	StatementTimeout time.Duration
+
+	// History records every cleanup run in the cleaner_history table
+	History bool
+
+	// DefaultPartition creates a DEFAULT partition for partitioned tables
+	// that have none
+	DefaultPartition bool
+
+	// StorageMode is native or timescale; timescale tables are hypertables
+	// chunked by ChunkInterval, whose expired chunks are dropped
+	StorageMode   string
+	ChunkInterval time.Duration
+
+	// Limits on partitioned tables on top of their maximum age: the oldest
+	// partitions are dropped until the table takes at most MaxTotalSize bytes
+	// and has at most MaxPartitions partitions; 0 disables a limit
+	MaxTotalSize  int64
+	MaxPartitions int
+
+	// RetentionMode is age or count; count drops partitions only to keep
+	// the newest RetentionCount, whatever their age
+	RetentionMode  string
+	RetentionCount int
+
+	// MinRetainedPartitions are never dropped, the newest by upper bound,
+	// whatever MAX_LOG_AGE and the limits say
+	MinRetainedPartitions int
+
+	// SafetyMargin is added to the maximum age of partitions, so that one
+	// that may still be written to is never dropped
+	SafetyMargin time.Duration
+
+	// Holds keep the partitions overlapping their range from being
+	// dropped, next to those recorded in the holds table
+	Holds []cleaner.Hold
+}
+
+// IngestConfig controls the HTTP endpoint through which other services
+// write audit logs to TABLE_NAME.
+type IngestConfig struct {
+	Port          int   // Ingestion is disabled when 0
+	MaxBodySize   int64 // Largest request body accepted, in bytes
+	BufferSize    int   // Logs buffered before requests are rejected
+	FlushInterval time.Duration
+
+	// PartitionInterval is the range of the partitions created for logs
+	// that no partition covers yet
+	PartitionInterval time.Duration
+
+	// MaxFuture rejects logs timestamped further ahead, and MaxPartitions
+	// requests whose logs span more ranges of PartitionInterval
+	MaxFuture     time.Duration
+	MaxPartitions int
+
+	// Token must be sent as a bearer token to POST /logs. Without it,
+	// ingestion is only enabled, unprotected, with AllowUnauthenticated.
+	Token                string
+	AllowUnauthenticated bool
+}
+
+// ColumnConfig describes an extra column of the generator's table.
+type ColumnConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// TrafficConfig shapes the synthetic requests behind the generated audit
+// logs. Empty values leave the cleaner package's defaults in place.
+type TrafficConfig struct {
+	Methods   map[string]int // Relative weight of each HTTP method
+	Paths     []string
+	Users     int // Size of the pool of user IDs
+	ErrorRate float64
+	Seed      uint64 // Seeds the generated rows; 0 picks a seed at random
+}
+
+// TableConfig describes one table whose expired rows are cleaned up.
+type TableConfig struct {
+	Name       string
+	TimeColumn string        // Rows are expired based on this timestamp column
+	MaxAge     time.Duration // Rows older than this are deleted
+}
+
+func (t TableConfig) String() string {
+	return fmt.Sprintf("%s(%s, max age %s)", t.Name, t.TimeColumn, t.MaxAge)
+}
+
+// RetryConfig controls how transient database errors are retried.
+type RetryConfig struct {
+	MaxRetries     int
+	BaseDelay      time.Duration // Doubled after every failed attempt
+	ConnectRetries int           // Retries of the initial connection at startup
+
+	// StartupTimeout, if set, retries the initial connection until it has
+	// passed instead, however many retries that takes
+	StartupTimeout time.Duration
+}
+
+// LogConfig controls the log output format and verbosity.
+type LogConfig struct {
+	Format string // text or json
+	Level  string // debug, info, warn or error
+}
+
+// Config is the complete application configuration.
+type Config struct {
+	Database    DatabaseConfig
+	Timing      TimingConfig
+	Archive     ArchiveConfig
+	Notify      NotifyConfig
+	Cleanup     CleanupConfig
+	Ingest      IngestConfig
+	Log         LogConfig
+	Retry       RetryConfig
+	Tables      []TableConfig  // Tables to clean up
+	TableName   string         // Table the insert generator writes to
+	Columns     []ColumnConfig // Extra columns the insert generator fills
+	Traffic     TrafficConfig
+	Mode        string
+	RunMode     string
+	MetricsPort int // 0 disables the metrics and health server
+
+	// AdminToken must be sent as a bearer token to the admin endpoints of
+	// the metrics server. Without it, they are only served, unprotected,
+	// with AdminAllowUnauthenticated.
+	AdminToken                string
+	AdminAllowUnauthenticated bool
+
+	// PartitionNamePrefix starts the names of the partitions created for
+	// TABLE_NAME; empty selects "<TABLE_NAME>_"
+	PartitionNamePrefix string
+
+	// PartitionNameTemplate names the partitions created for TABLE_NAME
+	// instead, with PartitionTimeLayout formatting {time}, see
+	// cleaner.Options.PartitionTemplate
+	PartitionNameTemplate string
+	PartitionTimeLayout   string
+
+	// PartitionGranularity is minute, hour or day, the range of the
+	// partitions created for TABLE_NAME, which also names them to the
+	// minute, hour or day; empty keeps INGEST_PARTITION_INTERVAL
+	PartitionGranularity string
+
+	// PartitionPremake keeps partitions of PartitionGranularity created
+	// for TABLE_NAME for the current range and this many after it; 0
+	// creates them on demand only. PARTITION_LOOKAHEAD sets it as well.
+	PartitionPremake int
+
+	// Indexes are created on TABLE_NAME, each a list of columns like
+	// "created_at DESC"; IndexMode is parent or concurrent, see
+	// cleaner.Options.Indexes
+	Indexes   []string
+	IndexMode string
+
+	// OTLPEndpoint receives traces of inserts and cleanup runs over
+	// OTLP/HTTP; tracing is disabled when empty
+	OTLPEndpoint string
+
+	// The insert generator writes its batches in chunks of at most
+	// BatchChunkSize rows, each committed on its own unless BatchSingleTx
+	BatchChunkSize int
+	BatchSingleTx  bool
+
+	// InsertMode writes chunks committed on their own with COPY or INSERT,
+	// cleaner.InsertCopy or cleaner.InsertInsert; it defaults to COPY with
+	// the pgx driver and to INSERT with lib/pq
+	InsertMode string
+
+	// LeaderElection lets only one of several instances sharing the
+	// database run the routines; the leader checks its lock and standbys
+	// retry it every LeaderInterval
+	LeaderElection bool
+	LeaderInterval time.Duration
+
+	// HealthStaleness fails readiness when no insert has succeeded for
+	// this long; 0 disables the check
+	HealthStaleness time.Duration
+
+	// CleanupSkipThreshold fails readiness once this many cleanup cycles
+	// in a row were skipped by runs overrunning the cleanup interval; 0
+	// disables the check
+	CleanupSkipThreshold int
+
+	// FailureThreshold fails readiness once a routine has failed this many
+	// times in a row with a permanent error, such as a missing column, and
+	// with ExitOnFailure shuts the process down; 0 disables both
+	FailureThreshold int
+	ExitOnFailure    bool
+
+	ResetOnStart bool
+	DryRun       bool
+
+	// MigrateTimestamptz converts an existing generator table's TIMESTAMP
+	// time column to TIMESTAMPTZ on startup
+	MigrateTimestamptz bool
+
+	// Force starts despite a table that fails the startup checks, logging
+	// what is wrong instead
+	Force bool
+
+	// File is the YAML file the settings were read from, if any, see
+	// LoadFile
+	File string
+}
+
+// ValidationError lists every problem found in the configuration, so that
+// all of them can be fixed at once.
+type ValidationError struct {
+	Problems []error
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0].Error()
+	}
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Error()
+	}
+	return fmt.Sprintf("%d problems: %s", len(e.Problems), strings.Join(msgs, "; "))
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return e.Problems
+}
+
+// add records err, unless it is nil.
+func (e *ValidationError) add(err error) {
+	if err != nil {
+		e.Problems = append(e.Problems, err)
+	}
+}
+
+// orNil returns e if it holds any problem, and nil otherwise.
+func (e *ValidationError) orNil() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Load reads the configuration from environment variables and validates it,
+// reporting every problem as a *ValidationError.
+func Load() (*Config, error) {
+	problems := &ValidationError{}
+
+	password, err := loadPassword()
+	problems.add(err)
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	problems.add(validateDatabaseURL(databaseURL))
+
+	port, err := getEnvAsInt("POSTGRES_PORT", 5432)
+	problems.add(err)
+
+	insertInterval, err := getEnvAsDuration("INSERT_INTERVAL", "INSERT_INTERVAL_SECONDS", 5*time.Second)
+	problems.add(err)
+
+	insertRate, err := getEnvAsFloat("INSERT_RATE_PER_SECOND", 0)
+	problems.add(err)
+
+	tickJitter, err := getEnvAsFloat("TICK_JITTER_PERCENT", 0)
+	problems.add(err)
+
+	insertJitter, err := getEnvAsFloat("INSERT_JITTER_PERCENT", tickJitter)
+	problems.add(err)
+
+	rampUp, err := getEnvAsDuration("RAMP_UP_DURATION", "", 0)
+	problems.add(err)
+
+	insertBackoffThreshold, err := getEnvAsInt("INSERT_BACKOFF_THRESHOLD", 2)
+	problems.add(err)
+
+	insertMaxBackoff, err := getEnvAsDuration("INSERT_MAX_BACKOFF", "", 5*time.Minute)
+	problems.add(err)
+
+	runDuration, err := getEnvAsDuration("RUN_DURATION", "", 0)
+	problems.add(err)
+
+	maxTotalInserts, err := getEnvAsInt("MAX_TOTAL_INSERTS", 0)
+	problems.add(err)
+
+	keepCleanup, err := getEnvAsBool("KEEP_CLEANUP_AFTER_INSERTS", false)
+	problems.add(err)
+
+	cleanupInterval, err := getEnvAsDuration("CLEANUP_INTERVAL", "CLEANUP_INTERVAL_SECONDS", time.Minute)
+	problems.add(err)
+
+	maxLogAge, err := getEnvAsDuration("MAX_LOG_AGE", "MAX_LOG_AGE_SECONDS", 30*time.Second)
+	problems.add(err)
+
+	shutdownTimeout, err := getEnvAsDuration("SHUTDOWN_TIMEOUT", "SHUTDOWN_TIMEOUT_SECONDS", 30*time.Second)
+	problems.add(err)
+
+	archiveGzip, err := getEnvAsBool("ARCHIVE_GZIP", false)
+	problems.add(err)
+
+	notifyOnSuccess, err := getEnvAsBool("NOTIFY_ON_SUCCESS", false)
+	problems.add(err)
+
+	batchSize, err := getEnvAsInt("DELETE_BATCH_SIZE", 5)
+	problems.add(err)
+
+	batchPause, err := getEnvAsDuration("DELETE_BATCH_PAUSE", "", time.Second)
+	problems.add(err)
+
+	vacuum, err := getEnvAsBool("VACUUM_AFTER_CLEANUP", false)
+	problems.add(err)
+
+	maintenance, err := getEnvAsBool("MAINTENANCE_ENABLED", false)
+	problems.add(err)
+
+	maintenanceInterval, err := getEnvAsDuration("MAINTENANCE_INTERVAL", "", time.Hour)
+	problems.add(err)
+
+	history, err := getEnvAsBool("HISTORY_ENABLED", false)
+	problems.add(err)
+
+	defaultPartition, err := getEnvAsBool("CREATE_DEFAULT_PARTITION", true)
+	problems.add(err)
+
+	detachConcurrently, err := getEnvAsBool("DETACH_CONCURRENTLY", false)
+	problems.add(err)
+	dropStrategy := cleaner.DropDirect
+	if detachConcurrently {
+		dropStrategy = cleaner.DropDetach
+		if os.Getenv("DROP_STRATEGY") == cleaner.DropDirect {
+			problems.add(fmt.Errorf("DETACH_CONCURRENTLY cannot be combined with DROP_STRATEGY=%s", cleaner.DropDirect))
+		}
+	}
+
+	chunkInterval, err := getEnvAsDuration("CHUNK_TIME_INTERVAL", "", 24*time.Hour)
+	problems.add(err)
+
+	maxTotalSize, err := getEnvAsSize("MAX_TOTAL_SIZE", 0)
+	problems.add(err)
+
+	maxPartitions, err := getEnvAsInt("MAX_PARTITIONS", 0)
+	problems.add(err)
+
+	retentionCount, err := getEnvAsInt("RETENTION_COUNT", 0)
+	problems.add(err)
+
+	minRetained, err := getEnvAsInt("MIN_RETAINED_PARTITIONS", 2)
+	problems.add(err)
+
+	safetyMarginSeconds, err := getEnvAsInt("CLEANUP_SAFETY_MARGIN_SECONDS", 0)
+	problems.add(err)
+
+	lockTimeout, err := getEnvAsDuration("DDL_LOCK_TIMEOUT", "", 5*time.Second)
+	problems.add(err)
+
+	statementTimeout, err := getEnvAsDuration("STATEMENT_TIMEOUT", "", 0)
+	problems.add(err)
+
+	maxRetries, err := getEnvAsInt("DB_MAX_RETRIES", 3)
+	problems.add(err)
+
+	retryBaseMs, err := getEnvAsInt("DB_RETRY_BASE_MS", 100)
+	problems.add(err)
+
+	connectRetries, err := getEnvAsInt("DB_CONNECT_RETRIES", 10)
+	problems.add(err)
+
+	startupTimeoutSeconds, err := getEnvAsInt("STARTUP_DB_TIMEOUT_SECONDS", 0)
+	problems.add(err)
+
+	connectTimeout, err := getEnvAsDuration("DB_CONNECT_TIMEOUT", "", 5*time.Second)
+	problems.add(err)
+
+	queryTimeout, err := getEnvAsDuration("DB_QUERY_TIMEOUT", "", 30*time.Second)
+	problems.add(err)
+
+	maxOpenConns, err := getEnvAsInt("DB_MAX_OPEN_CONNS", 10)
+	problems.add(err)
+
+	maxIdleConns, err := getEnvAsInt("DB_MAX_IDLE_CONNS", 5)
+	problems.add(err)
+
+	connMaxLifetime, err := getEnvAsDuration("DB_CONN_MAX_LIFETIME", "", 30*time.Minute)
+	problems.add(err)
+
+	metricsPort, err := getEnvAsInt("METRICS_PORT", 9090)
+	problems.add(err)
+
+	adminAllowUnauthenticated, err := getEnvAsBool("ADMIN_ALLOW_UNAUTHENTICATED", false)
+	problems.add(err)
+
+	ingestPort, err := getEnvAsInt("INGEST_PORT", 0)
+	problems.add(err)
+
+	ingestMaxBodySize, err := getEnvAsSize("INGEST_MAX_BODY_SIZE", 1<<20)
+	problems.add(err)
+
+	ingestBufferSize, err := getEnvAsInt("INGEST_BUFFER_SIZE", 10000)
+	problems.add(err)
+
+	ingestFlushInterval, err := getEnvAsDuration("INGEST_FLUSH_INTERVAL", "", time.Second)
+	problems.add(err)
+
+	// PARTITION_GRANULARITY sets the range of the partitions created for
+	// ingested logs, unless it is unknown, which Validate reports
+	granularity := os.Getenv("PARTITION_GRANULARITY")
+	ingestPartitionInterval, err := getEnvAsDuration("INGEST_PARTITION_INTERVAL", "", 24*time.Hour)
+	problems.add(err)
+	if step, ok := cleaner.GranularityStep(granularity); ok {
+		if os.Getenv("INGEST_PARTITION_INTERVAL") != "" && ingestPartitionInterval != step {
+			problems.add(fmt.Errorf("INGEST_PARTITION_INTERVAL=%s conflicts with PARTITION_GRANULARITY=%s, set only PARTITION_GRANULARITY",
+				os.Getenv("INGEST_PARTITION_INTERVAL"), granularity))
+		}
+		ingestPartitionInterval = step
+	}
+
+	ingestMaxFuture, err := getEnvAsDuration("INGEST_MAX_FUTURE", "", time.Hour)
+	problems.add(err)
+
+	ingestMaxPartitions, err := getEnvAsInt("INGEST_MAX_PARTITIONS", 10)
+	problems.add(err)
+
+	ingestAllowUnauthenticated, err := getEnvAsBool("INGEST_ALLOW_UNAUTHENTICATED", false)
+	problems.add(err)
+
+	tables, err := loadTables(maxLogAge)
+	problems.add(err)
+
+	holds, err := loadHolds()
+	problems.add(err)
+
+	// The generator defaults to the first managed table
+	var firstTable string
+	if len(tables) > 0 {
+		firstTable = tables[0].Name
+	}
+	tableName := getEnv("TABLE_NAME", firstTable)
+
+	var columns []ColumnConfig
+	if raw := os.Getenv("GENERATOR_COLUMNS"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &columns); err != nil {
+			problems.add(fmt.Errorf("invalid GENERATOR_COLUMNS: %w", err))
+		}
+	} else {
+		for _, col := range cleaner.DefaultColumns {
+			columns = append(columns, ColumnConfig{Name: col.Name, Type: col.Type})
+		}
+	}
+
+	var indexes []string
+	if raw := os.Getenv("INDEXES"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &indexes); err != nil {
+			problems.add(fmt.Errorf("invalid INDEXES: %w", err))
+		}
+	}
+
+	methods, err := parseWeights(os.Getenv("GENERATOR_METHODS"))
+	if err != nil {
+		problems.add(fmt.Errorf("invalid GENERATOR_METHODS: %w", err))
+	}
+
+	var paths []string
+	for _, path := range strings.Split(os.Getenv("GENERATOR_PATHS"), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	users, err := getEnvAsInt("GENERATOR_USERS", 100)
+	problems.add(err)
+
+	errorRate, err := getEnvAsFloat("GENERATOR_ERROR_RATE", 0.05)
+	problems.add(err)
+
+	var seed uint64
+	if raw := os.Getenv("RANDOM_SEED"); raw != "" {
+		if seed, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			problems.add(fmt.Errorf("invalid RANDOM_SEED %q: not a non-negative integer", raw))
+		}
+	}
+
+	batchChunkSize, err := getEnvAsInt("BATCH_CHUNK_SIZE", 500)
+	problems.add(err)
+
+	batchSingleTx, err := getEnvAsBool("BATCH_SINGLE_TRANSACTION", false)
+	problems.add(err)
+
+	defaultInsertMode := cleaner.InsertInsert
+	if os.Getenv("DB_DRIVER") == cleaner.DriverPGX {
+		defaultInsertMode = cleaner.InsertCopy
+	}
+
+	leaderElection, err := getEnvAsBool("LEADER_ELECTION", false)
+	problems.add(err)
+
+	leaderInterval, err := getEnvAsDuration("LEADER_ELECTION_INTERVAL", "", 5*time.Second)
+	problems.add(err)
+
+	healthStaleness, err := getEnvAsDuration("HEALTH_STALENESS", "", time.Minute)
+	problems.add(err)
+
+	failureThreshold, err := getEnvAsInt("FAILURE_THRESHOLD", 5)
+	problems.add(err)
+
+	cleanupSkipThreshold, err := getEnvAsInt("CLEANUP_SKIP_THRESHOLD", 3)
+	problems.add(err)
+
+	// PARTITION_LOOKAHEAD is another name for PARTITION_PREMAKE
+	partitionPremake, err := getEnvAsInt("PARTITION_PREMAKE", 0)
+	problems.add(err)
+	lookahead, err := getEnvAsInt("PARTITION_LOOKAHEAD", 0)
+	problems.add(err)
+	if os.Getenv("PARTITION_LOOKAHEAD") != "" {
+		if os.Getenv("PARTITION_PREMAKE") != "" && lookahead != partitionPremake {
+			problems.add(fmt.Errorf("PARTITION_LOOKAHEAD=%d conflicts with PARTITION_PREMAKE=%d, set only one", lookahead, partitionPremake))
+		}
+		partitionPremake = lookahead
+	}
+
+	exitOnFailure, err := getEnvAsBool("EXIT_ON_FAILURE", false)
+	problems.add(err)
+
+	resetOnStart, err := getEnvAsBool("RESET_ON_START", false)
+	problems.add(err)
+
+	dryRun, err := getEnvAsBool("DRY_RUN", false)
+	problems.add(err)
+
+	migrateTimestamptz, err := getEnvAsBool("MIGRATE_TIMESTAMPTZ", false)
+	problems.add(err)
+
+	force, err := getEnvAsBool("FORCE", false)
+	problems.add(err)
+
+	// Settings that failed to parse would only fail validation again, so
+	// their problems are reported on their own
+	if err := problems.orNil(); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		Database: DatabaseConfig{
+			URL: databaseURL,
+
+			Host:     os.Getenv("POSTGRES_HOST"),
+			Port:     port,
+			User:     os.Getenv("POSTGRES_USER"),
+			Password: password,
+			DBName:   os.Getenv("POSTGRES_DB"),
+			SSLMode:  getEnv("POSTGRES_SSL_MODE", "disable"),
+			Driver:   getEnv("DB_DRIVER", cleaner.DriverPQ),
+
+			SSLRootCert: os.Getenv("POSTGRES_SSL_ROOT_CERT"),
+			SSLCert:     os.Getenv("POSTGRES_SSL_CERT"),
+			SSLKey:      os.Getenv("POSTGRES_SSL_KEY"),
+
+			ConnectTimeout: connectTimeout,
+			QueryTimeout:   queryTimeout,
+
+			MaxOpenConns:    maxOpenConns,
+			MaxIdleConns:    maxIdleConns,
+			ConnMaxLifetime: connMaxLifetime,
+		},
+		Timing: TimingConfig{
+			InsertInterval:  insertInterval,
+			CleanupInterval: cleanupInterval,
+			MaxLogAge:       maxLogAge,
+			ShutdownTimeout: shutdownTimeout,
+
+			InsertRate:   insertRate,
+			TickJitter:   tickJitter,
+			InsertJitter: insertJitter,
+			RampUp:       rampUp,
+
+			InsertBackoffThreshold: insertBackoffThreshold,
+			InsertMaxBackoff:       insertMaxBackoff,
+
+			RunDuration:     runDuration,
+			MaxTotalInserts: maxTotalInserts,
+			KeepCleanup:     keepCleanup,
+		},
+		Archive: ArchiveConfig{
+			Dir:  os.Getenv("ARCHIVE_DIR"),
+			Gzip: archiveGzip,
+
+			S3Bucket:          os.Getenv("ARCHIVE_S3_BUCKET"),
+			S3Endpoint:        os.Getenv("ARCHIVE_S3_ENDPOINT"),
+			S3Region:          os.Getenv("ARCHIVE_S3_REGION"),
+			S3Prefix:          os.Getenv("ARCHIVE_S3_PREFIX"),
+			S3AccessKeyID:     os.Getenv("ARCHIVE_S3_ACCESS_KEY_ID"),
+			S3SecretAccessKey: os.Getenv("ARCHIVE_S3_SECRET_ACCESS_KEY"),
+		},
+		Notify: NotifyConfig{
+			WebhookURL: os.Getenv("NOTIFY_WEBHOOK_URL"),
+			Format:     getEnv("NOTIFY_FORMAT", "json"),
+			OnSuccess:  notifyOnSuccess,
+		},
+		Cleanup: CleanupConfig{
+			Strategy:   getEnv("CLEANUP_STRATEGY", cleaner.StrategyAuto),
+			BatchSize:  batchSize,
+			BatchPause: batchPause,
+			Vacuum:     vacuum,
+
+			Maintenance:         maintenance,
+			MaintenanceInterval: maintenanceInterval,
+
+			DropStrategy: getEnv("DROP_STRATEGY", dropStrategy),
+
+			LockTimeout:      lockTimeout,
+			StatementTimeout: statementTimeout,
+
+			History:          history,
+			DefaultPartition: defaultPartition,
+
+			StorageMode:   getEnv("STORAGE_MODE", cleaner.StorageNative),
+			ChunkInterval: chunkInterval,
+
+			MaxTotalSize:  maxTotalSize,
+			MaxPartitions: maxPartitions,
+
+			RetentionMode:  getEnv("RETENTION_MODE", cleaner.RetentionAge),
+			RetentionCount: retentionCount,
+
+			MinRetainedPartitions: minRetained,
+
+			SafetyMargin: time.Duration(safetyMarginSeconds) * time.Second,
+
+			Holds: holds,
+		},
+		Log: LogConfig{
+			Format: getEnv("LOG_FORMAT", "text"),
+			Level:  getEnv("LOG_LEVEL", "info"),
+		},
+		Ingest: IngestConfig{
+			Port:              ingestPort,
+			MaxBodySize:       ingestMaxBodySize,
+			BufferSize:        ingestBufferSize,
+			FlushInterval:     ingestFlushInterval,
+			PartitionInterval: ingestPartitionInterval,
+
+			MaxFuture:     ingestMaxFuture,
+			MaxPartitions: ingestMaxPartitions,
+
+			Token:                os.Getenv("INGEST_TOKEN"),
+			AllowUnauthenticated: ingestAllowUnauthenticated,
+		},
+		Retry: RetryConfig{
+			MaxRetries:     maxRetries,
+			BaseDelay:      time.Duration(retryBaseMs) * time.Millisecond,
+			ConnectRetries: connectRetries,
+
+			StartupTimeout: time.Duration(startupTimeoutSeconds) * time.Second,
+		},
+		Tables:    tables,
+		TableName: tableName,
+		Columns:   columns,
+		Traffic: TrafficConfig{
+			Methods:   methods,
+			Paths:     paths,
+			Users:     users,
+			ErrorRate: errorRate,
+			Seed:      seed,
+		},
+		BatchChunkSize:  batchChunkSize,
+		BatchSingleTx:   batchSingleTx,
+		InsertMode:      getEnv("INSERT_MODE", defaultInsertMode),
+		Mode:            getEnv("MODE", ModeBoth),
+		RunMode:         getEnv("RUN_MODE", RunModeDaemon),
+		MetricsPort:     metricsPort,
+		OTLPEndpoint:    os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		AdminToken:      os.Getenv("ADMIN_TOKEN"),
+		HealthStaleness: healthStaleness,
+		LeaderElection:  leaderElection,
+		LeaderInterval:  leaderInterval,
+		ResetOnStart:    resetOnStart,
+		DryRun:          dryRun,
+
+		PartitionNamePrefix: os.Getenv("PARTITION_NAME_PREFIX"),
+		Indexes:             indexes,
+		IndexMode:           getEnv("INDEX_MODE", cleaner.IndexParent),
+		MigrateTimestamptz:  migrateTimestamptz,
+		Force:               force,
+		FailureThreshold:    failureThreshold,
+		ExitOnFailure:       exitOnFailure,
+
+		CleanupSkipThreshold:  cleanupSkipThreshold,
+		PartitionNameTemplate: os.Getenv("PARTITION_NAME_TEMPLATE"),
+		PartitionTimeLayout:   os.Getenv("PARTITION_TIME_LAYOUT"),
+		PartitionGranularity:  granularity,
+		PartitionPremake:      partitionPremake,
+
+		AdminAllowUnauthenticated: adminAllowUnauthenticated,
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate rejects values that would make the tickers panic or the cleanup
+// job behave nonsensically, listing them all in a *ValidationError.
+func (c *Config) Validate() error {
+	problems := &ValidationError{}
+	if len(c.Tables) == 0 {
+		problems.add(fmt.Errorf("TABLES must list at least one table"))
+	}
+	seen := make(map[string]bool)
+	for _, t := range c.Tables {
+		if !cleaner.ValidIdentifier(t.Name) {
+			problems.add(fmt.Errorf("table name %q is not valid (lowercase letters, digits and underscores only)", t.Name))
+		}
+		if !cleaner.ValidIdentifier(t.TimeColumn) {
+			problems.add(fmt.Errorf("time column %q of table %s is not valid (lowercase letters, digits and underscores only)", t.TimeColumn, t.Name))
+		}
+		if t.MaxAge < 0 {
+			problems.add(fmt.Errorf("max age of table %s must not be negative, got %s", t.Name, t.MaxAge))
+		}
+		if seen[t.Name] {
+			problems.add(fmt.Errorf("table %s is listed more than once in TABLES", t.Name))
+		}
+		seen[t.Name] = true
+	}
+	for _, h := range c.Cleanup.Holds {
+		if h.Table != "" && !seen[h.Table] {
+			problems.add(fmt.Errorf("hold of table %s in HOLDS is not one of the managed tables", h.Table))
+		}
+	}
+	if (c.Mode != ModeCleanupOnly || c.Ingest.Port != 0) && !seen[c.TableName] {
+		problems.add(fmt.Errorf("TABLE_NAME %q must be one of the managed tables", c.TableName))
+	}
+
+	// Extra columns must not shadow the ones the generator always writes
+	taken := map[string]bool{"id": true, "message": true}
+	for _, t := range c.Tables {
+		if t.Name == c.TableName {
+			taken[t.TimeColumn] = true
+		}
+	}
+	for _, col := range c.Columns {
+		if !cleaner.ValidIdentifier(col.Name) {
+			problems.add(fmt.Errorf("column name %q in GENERATOR_COLUMNS is not valid (lowercase letters, digits and underscores only)", col.Name))
+		}
+		if !slices.Contains(cleaner.ColumnTypes, col.Type) {
+			problems.add(fmt.Errorf("column %s in GENERATOR_COLUMNS has unsupported type %q, must be one of %s",
+				col.Name, col.Type, strings.Join(cleaner.ColumnTypes, ", ")))
+		}
+		if taken[col.Name] {
+			problems.add(fmt.Errorf("column %s in GENERATOR_COLUMNS is already part of the table", col.Name))
+		}
+		taken[col.Name] = true
+	}
+	switch c.Mode {
+	case ModeBoth, ModeCleanupOnly, ModeGenerateOnly:
+	default:
+		problems.add(fmt.Errorf("MODE must be %s, %s or %s, got %q", ModeBoth, ModeCleanupOnly, ModeGenerateOnly, c.Mode))
+	}
+	switch c.RunMode {
+	case RunModeDaemon, RunModeOnce:
+	default:
+		problems.add(fmt.Errorf("RUN_MODE must be %s or %s, got %q", RunModeDaemon, RunModeOnce, c.RunMode))
+	}
+	if c.Traffic.Users <= 0 {
+		problems.add(fmt.Errorf("GENERATOR_USERS must be greater than 0, got %d", c.Traffic.Users))
+	}
+	if c.Traffic.ErrorRate < 0 || c.Traffic.ErrorRate > 1 {
+		problems.add(fmt.Errorf("GENERATOR_ERROR_RATE must be between 0 and 1, got %g", c.Traffic.ErrorRate))
+	}
+	if c.BatchChunkSize <= 0 {
+		problems.add(fmt.Errorf("BATCH_CHUNK_SIZE must be greater than 0, got %d", c.BatchChunkSize))
+	}
+	if c.InsertMode != cleaner.InsertCopy && c.InsertMode != cleaner.InsertInsert {
+		problems.add(fmt.Errorf("INSERT_MODE must be %s or %s, got %q", cleaner.InsertCopy, cleaner.InsertInsert, c.InsertMode))
+	}
+	switch c.Cleanup.Strategy {
+	case cleaner.StrategyAuto, cleaner.StrategyPartition, cleaner.StrategyDelete:
+	default:
+		problems.add(fmt.Errorf("CLEANUP_STRATEGY must be %s, %s or %s, got %q",
+			cleaner.StrategyAuto, cleaner.StrategyPartition, cleaner.StrategyDelete, c.Cleanup.Strategy))
+	}
+	switch c.Cleanup.StorageMode {
+	case cleaner.StorageNative, cleaner.StorageTimescale:
+	default:
+		problems.add(fmt.Errorf("STORAGE_MODE must be %s or %s, got %q",
+			cleaner.StorageNative, cleaner.StorageTimescale, c.Cleanup.StorageMode))
+	}
+	switch c.Cleanup.DropStrategy {
+	case cleaner.DropDirect:
+	case cleaner.DropDetach:
+		if c.Cleanup.StorageMode == cleaner.StorageTimescale {
+			problems.add(fmt.Errorf("DROP_STRATEGY=%s does not apply to STORAGE_MODE=%s", cleaner.DropDetach, cleaner.StorageTimescale))
+		}
+	default:
+		problems.add(fmt.Errorf("DROP_STRATEGY must be %s or %s, got %q", cleaner.DropDirect, cleaner.DropDetach, c.Cleanup.DropStrategy))
+	}
+	if c.Cleanup.ChunkInterval <= 0 {
+		problems.add(fmt.Errorf("CHUNK_TIME_INTERVAL must be greater than 0, got %s", c.Cleanup.ChunkInterval))
+	}
+	if c.Cleanup.MaxPartitions < 0 {
+		problems.add(fmt.Errorf("MAX_PARTITIONS must not be negative, got %d", c.Cleanup.MaxPartitions))
+	}
+	switch c.Cleanup.RetentionMode {
+	case cleaner.RetentionAge:
+		if c.Cleanup.RetentionCount != 0 {
+			problems.add(fmt.Errorf("RETENTION_COUNT only applies with RETENTION_MODE=%s", cleaner.RetentionCount))
+		}
+	case cleaner.RetentionCount:
+		if c.Cleanup.RetentionCount <= 0 {
+			problems.add(fmt.Errorf("RETENTION_COUNT must be greater than 0 with RETENTION_MODE=%s, got %d",
+				cleaner.RetentionCount, c.Cleanup.RetentionCount))
+		}
+		if c.Cleanup.MaxPartitions != 0 {
+			problems.add(fmt.Errorf("MAX_PARTITIONS cannot be combined with RETENTION_MODE=%s, use RETENTION_COUNT", cleaner.RetentionCount))
+		}
+	default:
+		problems.add(fmt.Errorf("RETENTION_MODE must be %s or %s, got %q", cleaner.RetentionAge, cleaner.RetentionCount, c.Cleanup.RetentionMode))
+	}
+	if c.Cleanup.MinRetainedPartitions < 0 {
+		problems.add(fmt.Errorf("MIN_RETAINED_PARTITIONS must not be negative, got %d", c.Cleanup.MinRetainedPartitions))
+	}
+	if c.Cleanup.SafetyMargin < 0 {
+		problems.add(fmt.Errorf("CLEANUP_SAFETY_MARGIN_SECONDS must not be negative, got %d", int(c.Cleanup.SafetyMargin.Seconds())))
+	}
+	if c.Cleanup.BatchSize <= 0 {
+		problems.add(fmt.Errorf("DELETE_BATCH_SIZE must be greater than 0, got %d", c.Cleanup.BatchSize))
+	}
+	if c.Cleanup.LockTimeout < 0 {
+		problems.add(fmt.Errorf("DDL_LOCK_TIMEOUT must not be negative, got %s", c.Cleanup.LockTimeout))
+	}
+	if c.Cleanup.StatementTimeout < 0 {
+		problems.add(fmt.Errorf("STATEMENT_TIMEOUT must not be negative, got %s", c.Cleanup.StatementTimeout))
+	}
+	if c.Cleanup.History {
+		for _, t := range c.Tables {
+			if t.Name == cleaner.HistoryTable {
+				problems.add(fmt.Errorf("table %s is reserved for the cleanup history and cannot be cleaned up", t.Name))
+			}
+		}
+	}
+	if c.Cleanup.BatchPause < 0 {
+		problems.add(fmt.Errorf("DELETE_BATCH_PAUSE must not be negative, got %s", c.Cleanup.BatchPause))
+	}
+	if (c.Archive.S3AccessKeyID == "") != (c.Archive.S3SecretAccessKey == "") {
+		problems.add(fmt.Errorf("ARCHIVE_S3_ACCESS_KEY_ID and ARCHIVE_S3_SECRET_ACCESS_KEY must be set together"))
+	}
+	if strings.Contains(c.Archive.S3Endpoint, "://") {
+		u, err := url.Parse(c.Archive.S3Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems.add(fmt.Errorf("ARCHIVE_S3_ENDPOINT must be a host or an http or https URL, got %q", c.Archive.S3Endpoint))
+		}
+	}
+	if c.Notify.WebhookURL != "" {
+		u, err := url.Parse(c.Notify.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems.add(fmt.Errorf("NOTIFY_WEBHOOK_URL must be an http or https URL"))
+		}
+	}
+	switch c.Notify.Format {
+	case "json", "slack":
+	default:
+		problems.add(fmt.Errorf("NOTIFY_FORMAT must be json or slack, got %q", c.Notify.Format))
+	}
+	switch c.Log.Format {
+	case "text", "json":
+	default:
+		problems.add(fmt.Errorf("LOG_FORMAT must be text or json, got %q", c.Log.Format))
+	}
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		problems.add(fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", c.Log.Level))
+	}
+	if c.Retry.MaxRetries < 0 {
+		problems.add(fmt.Errorf("DB_MAX_RETRIES must not be negative, got %d", c.Retry.MaxRetries))
+	}
+	if c.Retry.BaseDelay <= 0 {
+		problems.add(fmt.Errorf("DB_RETRY_BASE_MS must be greater than 0, got %d", c.Retry.BaseDelay.Milliseconds()))
+	}
+	if c.Retry.ConnectRetries < 0 {
+		problems.add(fmt.Errorf("DB_CONNECT_RETRIES must not be negative, got %d", c.Retry.ConnectRetries))
+	}
+	if c.Retry.StartupTimeout < 0 {
+		problems.add(fmt.Errorf("STARTUP_DB_TIMEOUT_SECONDS must not be negative, got %d", int(c.Retry.StartupTimeout.Seconds())))
+	}
+	if !slices.Contains(SSLModes, c.Database.SSLMode) {
+		problems.add(fmt.Errorf("POSTGRES_SSL_MODE must be one of %s, got %q",
+			strings.Join(SSLModes, ", "), c.Database.SSLMode))
+	}
+	if (c.Database.SSLCert == "") != (c.Database.SSLKey == "") {
+		problems.add(fmt.Errorf("POSTGRES_SSL_CERT and POSTGRES_SSL_KEY must be set together"))
+	}
+	problems.add(checkCertFile("POSTGRES_SSL_ROOT_CERT", c.Database.SSLRootCert))
+	problems.add(checkCertFile("POSTGRES_SSL_CERT", c.Database.SSLCert))
+	problems.add(checkCertFile("POSTGRES_SSL_KEY", c.Database.SSLKey))
+	if u, err := url.Parse(c.Database.URL); c.Database.URL != "" && err == nil {
+		for _, p := range c.Database.certParameters() {
+			if p.value != "" && u.Query().Has(p.name) {
+				problems.add(fmt.Errorf("%s cannot be combined with %s in DATABASE_URL", p.env, p.name))
+			}
+		}
+	}
+	switch c.Database.Driver {
+	case cleaner.DriverPQ, cleaner.DriverPGX:
+	default:
+		problems.add(fmt.Errorf("DB_DRIVER must be %s or %s, got %q",
+			cleaner.DriverPQ, cleaner.DriverPGX, c.Database.Driver))
+	}
+	// lib/pq takes the connect timeout in whole seconds
+	if c.Database.ConnectTimeout < time.Second {
+		problems.add(fmt.Errorf("DB_CONNECT_TIMEOUT must be at least 1s, got %s", c.Database.ConnectTimeout))
+	}
+	if c.Database.QueryTimeout < 0 {
+		problems.add(fmt.Errorf("DB_QUERY_TIMEOUT must not be negative, got %s", c.Database.QueryTimeout))
+	}
+	if c.Database.MaxOpenConns < 0 {
+		problems.add(fmt.Errorf("DB_MAX_OPEN_CONNS must not be negative, got %d", c.Database.MaxOpenConns))
+	}
+	// Each table's cleanup holds a connection for its lock and needs another
+	// one to do the work; the leader holds one more for its lock
+	held := len(c.Tables)
+	if c.LeaderElection {
+		held++
+	}
+	if c.Database.MaxOpenConns != 0 && c.Database.MaxOpenConns <= held {
+		problems.add(fmt.Errorf("DB_MAX_OPEN_CONNS must be 0 or more than the %d connections held for locks, got %d",
+			held, c.Database.MaxOpenConns))
+	}
+	if c.Database.MaxIdleConns < 0 {
+		problems.add(fmt.Errorf("DB_MAX_IDLE_CONNS must not be negative, got %d", c.Database.MaxIdleConns))
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		problems.add(fmt.Errorf("DB_CONN_MAX_LIFETIME must not be negative, got %s", c.Database.ConnMaxLifetime))
+	}
+	if c.PartitionNamePrefix != "" && !cleaner.ValidIdentifier(c.PartitionNamePrefix) {
+		problems.add(fmt.Errorf("PARTITION_NAME_PREFIX %q is not valid (lowercase letters, digits and underscores only)", c.PartitionNamePrefix))
+	}
+	if c.PartitionNamePrefix != "" && c.PartitionNameTemplate != "" {
+		problems.add(errors.New("PARTITION_NAME_PREFIX cannot be combined with PARTITION_NAME_TEMPLATE, start the template with the prefix instead"))
+	}
+	if _, ok := cleaner.GranularityStep(c.PartitionGranularity); !ok && c.PartitionGranularity != "" {
+		problems.add(fmt.Errorf("PARTITION_GRANULARITY must be %s, %s or %s, got %q",
+			cleaner.GranularityMinute, cleaner.GranularityHour, cleaner.GranularityDay, c.PartitionGranularity))
+	}
+	if c.PartitionPremake < 0 {
+		problems.add(fmt.Errorf("PARTITION_PREMAKE must not be negative, got %d", c.PartitionPremake))
+	}
+	if c.PartitionPremake > 0 && c.PartitionGranularity == "" {
+		problems.add(errors.New("PARTITION_PREMAKE needs PARTITION_GRANULARITY"))
+	}
+	for _, def := range c.Indexes {
+		if !cleaner.ValidIndex(def) {
+			problems.add(fmt.Errorf("index %q in INDEXES is not valid (columns with lowercase letters, digits and underscores, each optionally followed by ASC or DESC)", def))
+		}
+	}
+	if c.IndexMode != cleaner.IndexParent && c.IndexMode != cleaner.IndexConcurrent {
+		problems.add(fmt.Errorf("INDEX_MODE must be %s or %s, got %q", cleaner.IndexParent, cleaner.IndexConcurrent, c.IndexMode))
+	}
+	if c.MetricsPort < 0 || c.MetricsPort > 65535 {
+		problems.add(fmt.Errorf("METRICS_PORT must be between 0 and 65535, got %d", c.MetricsPort))
+	}
+	if c.Ingest.Port < 0 || c.Ingest.Port > 65535 {
+		problems.add(fmt.Errorf("INGEST_PORT must be between 0 and 65535, got %d", c.Ingest.Port))
+	}
+	if c.Ingest.Port != 0 {
+		if c.Ingest.Port == c.MetricsPort {
+			problems.add(fmt.Errorf("INGEST_PORT must differ from METRICS_PORT, both are %d", c.Ingest.Port))
+		}
+		if c.Ingest.MaxBodySize <= 0 {
+			problems.add(fmt.Errorf("INGEST_MAX_BODY_SIZE must be greater than 0, got %d", c.Ingest.MaxBodySize))
+		}
+		if c.Ingest.BufferSize <= 0 {
+			problems.add(fmt.Errorf("INGEST_BUFFER_SIZE must be greater than 0, got %d", c.Ingest.BufferSize))
+		}
+		if c.Ingest.FlushInterval <= 0 {
+			problems.add(fmt.Errorf("INGEST_FLUSH_INTERVAL must be greater than 0, got %s", c.Ingest.FlushInterval))
+		}
+		if c.Ingest.PartitionInterval <= 0 {
+			problems.add(fmt.Errorf("INGEST_PARTITION_INTERVAL must be greater than 0, got %s", c.Ingest.PartitionInterval))
+		}
+		if c.Ingest.MaxFuture <= 0 {
+			problems.add(fmt.Errorf("INGEST_MAX_FUTURE must be greater than 0, got %s", c.Ingest.MaxFuture))
+		}
+		if c.Ingest.MaxPartitions <= 0 {
+			problems.add(fmt.Errorf("INGEST_MAX_PARTITIONS must be greater than 0, got %d", c.Ingest.MaxPartitions))
+		}
+		if c.Ingest.Token == "" && !c.Ingest.AllowUnauthenticated {
+			problems.add(fmt.Errorf("INGEST_PORT needs INGEST_TOKEN, or INGEST_ALLOW_UNAUTHENTICATED=true to accept logs from anyone"))
+		}
+	}
+	if c.OTLPEndpoint != "" {
+		u, err := url.Parse(c.OTLPEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems.add(fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT must be an http or https URL, got %q", c.OTLPEndpoint))
+		}
+	}
+	if c.LeaderInterval <= 0 {
+		problems.add(fmt.Errorf("LEADER_ELECTION_INTERVAL must be greater than 0, got %s", c.LeaderInterval))
+	}
+	if c.HealthStaleness < 0 {
+		problems.add(fmt.Errorf("HEALTH_STALENESS must not be negative, got %s", c.HealthStaleness))
+	}
+	if c.FailureThreshold < 0 {
+		problems.add(fmt.Errorf("FAILURE_THRESHOLD must not be negative, got %d", c.FailureThreshold))
+	}
+	if c.CleanupSkipThreshold < 0 {
+		problems.add(fmt.Errorf("CLEANUP_SKIP_THRESHOLD must not be negative, got %d", c.CleanupSkipThreshold))
+	}
+	if c.Timing.InsertInterval <= 0 {
+		problems.add(fmt.Errorf("INSERT_INTERVAL must be greater than 0, got %s", c.Timing.InsertInterval))
+	}
+	if c.Timing.InsertRate < 0 {
+		problems.add(fmt.Errorf("INSERT_RATE_PER_SECOND must not be negative, got %g", c.Timing.InsertRate))
+	}
+	if c.Timing.TickJitter < 0 || c.Timing.TickJitter >= 100 {
+		problems.add(fmt.Errorf("TICK_JITTER_PERCENT must be at least 0 and less than 100, got %g", c.Timing.TickJitter))
+	}
+	if c.Timing.InsertJitter < 0 || c.Timing.InsertJitter >= 100 {
+		problems.add(fmt.Errorf("INSERT_JITTER_PERCENT must be at least 0 and less than 100, got %g", c.Timing.InsertJitter))
+	}
+	if c.Timing.RampUp < 0 {
+		problems.add(fmt.Errorf("RAMP_UP_DURATION must not be negative, got %s", c.Timing.RampUp))
+	}
+	if c.Timing.InsertBackoffThreshold < 1 {
+		problems.add(fmt.Errorf("INSERT_BACKOFF_THRESHOLD must be at least 1, got %d", c.Timing.InsertBackoffThreshold))
+	}
+	if c.Timing.InsertMaxBackoff < c.Timing.InsertInterval {
+		problems.add(fmt.Errorf("INSERT_MAX_BACKOFF must be at least INSERT_INTERVAL (%s), got %s", c.Timing.InsertInterval, c.Timing.InsertMaxBackoff))
+	}
+	if c.Timing.RunDuration < 0 {
+		problems.add(fmt.Errorf("RUN_DURATION must not be negative, got %s", c.Timing.RunDuration))
+	}
+	if c.Timing.MaxTotalInserts < 0 {
+		problems.add(fmt.Errorf("MAX_TOTAL_INSERTS must not be negative, got %d", c.Timing.MaxTotalInserts))
+	}
+	if c.Cleanup.Maintenance && c.Cleanup.MaintenanceInterval <= 0 {
+		problems.add(fmt.Errorf("MAINTENANCE_INTERVAL must be greater than 0, got %s", c.Cleanup.MaintenanceInterval))
+	}
+	if c.Timing.CleanupInterval <= 0 {
+		problems.add(fmt.Errorf("CLEANUP_INTERVAL must be greater than 0, got %s", c.Timing.CleanupInterval))
+	}
+	if c.Timing.MaxLogAge < 0 {
+		problems.add(fmt.Errorf("MAX_LOG_AGE must not be negative, got %s", c.Timing.MaxLogAge))
+	}
+	if c.Timing.ShutdownTimeout <= 0 {
+		problems.add(fmt.Errorf("SHUTDOWN_TIMEOUT must be greater than 0, got %s", c.Timing.ShutdownTimeout))
+	}
+	return problems.orNil()
+}
+
+// ConnectionString returns the DSN for this database, in the keyword/value
+// form both drivers accept, or URL with the TLS files added.
+func (d DatabaseConfig) ConnectionString() string {
+	if d.URL != "" {
+		return d.urlWithCertificates()
+	}
+	dsn := fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s connect_timeout=%d",
+		quoteDSNValue(d.Host), d.Port, quoteDSNValue(d.User), quoteDSNValue(d.Password),
+		quoteDSNValue(d.DBName), quoteDSNValue(d.SSLMode), int(d.ConnectTimeout.Seconds()),
+	)
+	if d.SSLRootCert != "" {
+		dsn += " sslrootcert=" + quoteDSNValue(d.SSLRootCert)
+	}
+	if d.SSLCert != "" {
+		dsn += " sslcert=" + quoteDSNValue(d.SSLCert)
+	}
+	if d.SSLKey != "" {
+		dsn += " sslkey=" + quoteDSNValue(d.SSLKey)
+	}
+	return dsn
+}
+
+// certParameter is a TLS file setting: its variable, the connection
+// parameter it sets and its value.
+type certParameter struct {
+	env, name, value string
+}
+
+// certParameters returns the TLS file settings, set or not.
+func (d DatabaseConfig) certParameters() []certParameter {
+	return []certParameter{
+		{"POSTGRES_SSL_ROOT_CERT", "sslrootcert", d.SSLRootCert},
+		{"POSTGRES_SSL_CERT", "sslcert", d.SSLCert},
+		{"POSTGRES_SSL_KEY", "sslkey", d.SSLKey},
+	}
+}
+
+// urlWithCertificates returns URL with the TLS files that are set added as
+// its sslrootcert, sslcert and sslkey parameters, which both drivers read
+// from a URL as well. A URL that cannot be parsed, which Validate reports,
+// is returned as it is.
+func (d DatabaseConfig) urlWithCertificates() string {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return d.URL
+	}
+	query := u.Query()
+	added := false
+	for _, p := range d.certParameters() {
+		if p.value != "" {
+			query.Set(p.name, p.value)
+			added = true
+		}
+	}
+	if !added {
+		return d.URL
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// dsnEscaper escapes the backslashes and single quotes of a quoted value.
+var dsnEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// quoteDSNValue quotes a value of a keyword/value connection string the way
+// libpq reads it, so that a password or path containing spaces, quotes or
+// backslashes stays one value.
+func quoteDSNValue(value string) string {
+	return "'" + dsnEscaper.Replace(value) + "'"
+}
+
+// SafeConnectionString returns the DSN with the password redacted, for logging.
+func (d DatabaseConfig) SafeConnectionString() string {
+	if d.URL != "" {
+		u, err := url.Parse(d.ConnectionString())
+		if err != nil {
+			return "****"
+		}
+		return u.Redacted()
+	}
+	redacted := d
+	redacted.Password = "****"
+	return redacted.ConnectionString()
+}
+
+// Print logs the effective configuration. The database password is never
+// included.
+func (c *Config) Print() {
+	archiveDir := c.Archive.Dir
+	if archiveDir == "" {
+		archiveDir = "disabled"
+	}
+	archiveS3 := "disabled"
+	if c.Archive.S3Bucket != "" {
+		archiveS3 = "s3://" + path.Join(c.Archive.S3Bucket, c.Archive.S3Prefix)
+		if c.Archive.S3Endpoint != "" {
+			archiveS3 += " at " + c.Archive.S3Endpoint
+		}
+	}
+
+	// Webhook URLs often embed a secret token, so only the host is shown
+	notifyWebhook := "disabled"
+	if u, err := url.Parse(c.Notify.WebhookURL); err == nil && u.Host != "" {
+		notifyWebhook = u.Host
+	}
+
+	otlpEndpoint := c.OTLPEndpoint
+	if otlpEndpoint == "" {
+		otlpEndpoint = "disabled"
+	}
+
+	database := fmt.Sprintf("%s@%s:%d/%s", c.Database.User, c.Database.Host, c.Database.Port, c.Database.DBName)
+	if c.Database.URL != "" {
+		database = c.Database.SafeConnectionString()
+	}
+
+	// Only the paths of the TLS files are shown, never their contents; a
+	// DATABASE_URL carries its own TLS settings
+	sslMode := c.Database.SSLMode
+	if c.Database.URL != "" {
+		sslMode = "from DATABASE_URL"
+	}
+	sslFile := func(path string) string {
+		if path == "" {
+			return "none"
+		}
+		return path
+	}
+
+	configFile := c.File
+	if configFile == "" {
+		configFile = "none"
+	}
+
+	slog.Info("configuration",
+		"config_file", configFile,
+		"database", database,
+		"db_driver", c.Database.Driver,
+		"ssl_mode", sslMode,
+		"ssl_root_cert", sslFile(c.Database.SSLRootCert),
+		"ssl_cert", sslFile(c.Database.SSLCert),
+		"ssl_key", sslFile(c.Database.SSLKey),
+		"tables", c.Tables,
+		"generator_table", c.TableName,
+		"partition_name_prefix", c.PartitionNamePrefix,
+		"partition_name_template", c.PartitionNameTemplate,
+		"partition_time_layout", c.PartitionTimeLayout,
+		"partition_granularity", c.PartitionGranularity,
+		"partition_premake", c.PartitionPremake,
+		"indexes", c.Indexes,
+		"index_mode", c.IndexMode,
+		"generator_columns", c.Columns,
+		"generator_traffic", c.Traffic,
+		"mode", c.Mode,
+		"run_mode", c.RunMode,
+		"insert_interval", c.Timing.InsertInterval,
+		"insert_rate_per_second", c.Timing.InsertRate,
+		"insert_jitter_percent", c.Timing.InsertJitter,
+		"ramp_up_duration", c.Timing.RampUp,
+		"insert_backoff_threshold", c.Timing.InsertBackoffThreshold,
+		"insert_max_backoff", c.Timing.InsertMaxBackoff,
+		"max_total_inserts", c.Timing.MaxTotalInserts,
+		"keep_cleanup_after_inserts", c.Timing.KeepCleanup,
+		"run_duration", c.Timing.RunDuration,
+		"batch_chunk_size", c.BatchChunkSize,
+		"batch_single_transaction", c.BatchSingleTx,
+		"insert_mode", c.InsertMode,
+		"cleanup_interval", c.Timing.CleanupInterval,
+		"tick_jitter_percent", c.Timing.TickJitter,
+		"max_log_age", c.Timing.MaxLogAge,
+		"shutdown_timeout", c.Timing.ShutdownTimeout,
+		"archive_dir", archiveDir,
+		"archive_gzip", c.Archive.Gzip,
+		"archive_s3", archiveS3,
+		"archive_s3_region", c.Archive.S3Region,
+		"notify_webhook", notifyWebhook,
+		"notify_format", c.Notify.Format,
+		"notify_on_success", c.Notify.OnSuccess,
+		"cleanup_strategy", c.Cleanup.Strategy,
+		"delete_batch_size", c.Cleanup.BatchSize,
+		"delete_batch_pause", c.Cleanup.BatchPause,
+		"vacuum_after_cleanup", c.Cleanup.Vacuum,
+		"maintenance_enabled", c.Cleanup.Maintenance,
+		"maintenance_interval", c.Cleanup.MaintenanceInterval,
+		"drop_strategy", c.Cleanup.DropStrategy,
+		"ddl_lock_timeout", c.Cleanup.LockTimeout,
+		"statement_timeout", c.Cleanup.StatementTimeout,
+		"history_enabled", c.Cleanup.History,
+		"create_default_partition", c.Cleanup.DefaultPartition,
+		"storage_mode", c.Cleanup.StorageMode,
+		"chunk_time_interval", c.Cleanup.ChunkInterval,
+		"max_total_size", c.Cleanup.MaxTotalSize,
+		"max_partitions", c.Cleanup.MaxPartitions,
+		"retention_mode", c.Cleanup.RetentionMode,
+		"retention_count", c.Cleanup.RetentionCount,
+		"min_retained_partitions", c.Cleanup.MinRetainedPartitions,
+		"cleanup_safety_margin", c.Cleanup.SafetyMargin,
+		"holds", len(c.Cleanup.Holds),
+		"db_max_retries", c.Retry.MaxRetries,
+		"db_retry_base_delay", c.Retry.BaseDelay,
+		"db_connect_retries", c.Retry.ConnectRetries,
+		"startup_db_timeout", c.Retry.StartupTimeout,
+		"db_connect_timeout", c.Database.ConnectTimeout,
+		"db_query_timeout", c.Database.QueryTimeout,
+		"db_max_open_conns", c.Database.MaxOpenConns,
+		"db_max_idle_conns", c.Database.MaxIdleConns,
+		"db_conn_max_lifetime", c.Database.ConnMaxLifetime,
+		"metrics_port", c.MetricsPort,
+		"admin_token_set", c.AdminToken != "",
+		"admin_allow_unauthenticated", c.AdminAllowUnauthenticated,
+		"ingest_port", c.Ingest.Port,
+		"ingest_max_body_size", c.Ingest.MaxBodySize,
+		"ingest_buffer_size", c.Ingest.BufferSize,
+		"ingest_flush_interval", c.Ingest.FlushInterval,
+		"ingest_partition_interval", c.Ingest.PartitionInterval,
+		"ingest_max_future", c.Ingest.MaxFuture,
+		"ingest_max_partitions", c.Ingest.MaxPartitions,
+		"ingest_token_set", c.Ingest.Token != "",
+		"ingest_allow_unauthenticated", c.Ingest.AllowUnauthenticated,
+		"otlp_endpoint", otlpEndpoint,
+		"health_staleness", c.HealthStaleness,
+		"cleanup_skip_threshold", c.CleanupSkipThreshold,
+		"failure_threshold", c.FailureThreshold,
+		"exit_on_failure", c.ExitOnFailure,
+		"leader_election", c.LeaderElection,
+		"leader_election_interval", c.LeaderInterval,
+		"reset_on_start", c.ResetOnStart,
+		"dry_run", c.DryRun,
+		"migrate_timestamptz", c.MigrateTimestamptz,
+		"force", c.Force,
+		"log_format", c.Log.Format,
+		"log_level", c.Log.Level,
+	)
+}
+
+// validateDatabaseURL checks that raw, if set, is a postgres:// or
+// postgresql:// URL. Parse errors are reported without the URL itself, which
+// may hold a password.
+func validateDatabaseURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("invalid DATABASE_URL: %w", err)
+	}
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return fmt.Errorf("invalid DATABASE_URL: scheme must be postgres or postgresql, got %q", u.Scheme)
+	}
+	return nil
+}
+
+// loadPassword returns the database password from the file named by
+// POSTGRES_PASSWORD_FILE, e.g. a mounted secret, or else from
+// POSTGRES_PASSWORD.
+func loadPassword() (string, error) {
+	path := os.Getenv("POSTGRES_PASSWORD_FILE")
+	if path == "" {
+		return os.Getenv("POSTGRES_PASSWORD"), nil
+	}
+	if os.Getenv("POSTGRES_PASSWORD") != "" {
+		slog.Warn("both POSTGRES_PASSWORD_FILE and POSTGRES_PASSWORD are set, using the file", "path", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading POSTGRES_PASSWORD_FILE: %w", err)
+	}
+	password := strings.TrimSpace(string(data))
+	if password == "" {
+		return "", fmt.Errorf("POSTGRES_PASSWORD_FILE %s is empty", path)
+	}
+	return password, nil
+}
+
+// checkCertFile checks that the TLS file named by the variable env exists
+// and can be read, so that a missing file is reported at startup rather
+// than as a failed connection; path is not checked if empty.
+func checkCertFile(env, path string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s file %s does not exist", env, path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s file %s cannot be read: %w", env, path, err)
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return fmt.Errorf("%s %s is a directory, not a file", env, path)
+	}
+	return nil
+}
+
+// loadTables reads the managed tables from the TABLES JSON list, e.g.
+//
+//	[{"name": "audit_logs", "time_column": "created_at", "max_age": "30d"}]
+//
+// time_column defaults to PARTITION_COLUMN, itself created_at by default,
+// and max_age to defaultMaxAge. Without TABLES, the single table named by
+// TABLE_NAME is managed.
+func loadTables(defaultMaxAge time.Duration) ([]TableConfig, error) {
+	timeColumn := getEnv("PARTITION_COLUMN", "created_at")
+	raw := os.Getenv("TABLES")
+	if raw == "" {
+		return []TableConfig{{
+			Name:       getEnv("TABLE_NAME", "audit_logs"),
+			TimeColumn: timeColumn,
+			MaxAge:     defaultMaxAge,
+		}}, nil
+	}
+
+	var specs []struct {
+		Name       string `json:"name"`
+		TimeColumn string `json:"time_column"`
+		MaxAge     string `json:"max_age"`
+	}
+	if err := json.Unmarshal([]byte(raw), &specs); err != nil {
+		return nil, fmt.Errorf("invalid TABLES: %w", err)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("TABLES must list at least one table")
+	}
+
+	tables := make([]TableConfig, 0, len(specs))
+	for _, spec := range specs {
+		t := TableConfig{Name: spec.Name, TimeColumn: spec.TimeColumn, MaxAge: defaultMaxAge}
+		if t.TimeColumn == "" {
+			t.TimeColumn = timeColumn
+		}
+		if spec.MaxAge != "" {
+			maxAge, err := parseDurationOrSeconds(spec.MaxAge)
+			if err != nil {
+				return nil, fmt.Errorf("invalid max_age %q for table %s in TABLES", spec.MaxAge, spec.Name)
+			}
+			t.MaxAge = maxAge
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// loadHolds reads the holds from the HOLDS JSON list, e.g.
+//
+//	[{"from": "2024-03-03", "to": "2024-03-04", "table": "audit_logs", "reason": "case 123"}]
+//
+// A hold without a table applies to every managed table.
+func loadHolds() ([]cleaner.Hold, error) {
+	raw := os.Getenv("HOLDS")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var specs []struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Table  string `json:"table"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(raw), &specs); err != nil {
+		return nil, fmt.Errorf("invalid HOLDS: %w", err)
+	}
+
+	holds := make([]cleaner.Hold, 0, len(specs))
+	for i, spec := range specs {
+		from, err := ParseTime(spec.From)
+		if err != nil {
+			return nil, fmt.Errorf("hold %d in HOLDS: from: %w", i+1, err)
+		}
+		to, err := ParseTime(spec.To)
+		if err != nil {
+			return nil, fmt.Errorf("hold %d in HOLDS: to: %w", i+1, err)
+		}
+		if !from.Before(to) {
+			return nil, fmt.Errorf("hold %d in HOLDS: from %s is not before to %s", i+1, spec.From, spec.To)
+		}
+		holds = append(holds, cleaner.Hold{Table: spec.Table, From: from, To: to, Reason: spec.Reason})
+	}
+	return holds, nil
+}
+
+// parseWeights parses a comma-separated list of names with optional
+// weights, e.g. "GET=70,POST=20,DELETE=10", "GET:70,POST:30" or "GET,POST".
+// Weights must be greater than 0; a name without one weighs 1.
+func parseWeights(raw string) (map[string]int, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	weights := make(map[string]int)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			name, value, ok = strings.Cut(pair, ":")
+		}
+		if name == "" {
+			return nil, fmt.Errorf("entry %q has no name", pair)
+		}
+		if !ok {
+			weights[name] = 1
+			continue
+		}
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("weight of %s must be a whole number greater than 0, got %q", name, value)
+		}
+		weights[name] = weight
+	}
+	return weights, nil
+}
+
+// getEnv returns the value of key, or defaultValue when unset.
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvAsInt returns the integer value of key, or defaultValue when unset.
+func getEnvAsInt(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: not an integer", key, value)
+	}
+	return n, nil
+}
+
+// getEnvAsFloat returns the float value of key, or defaultValue when unset.
+func getEnvAsFloat(key string, defaultValue float64) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: not a number", key, value)
+	}
+	return f, nil
+}
+
+// getEnvAsBool returns the boolean value of key, or defaultValue when unset.
+func getEnvAsBool(key string, defaultValue bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: not a boolean", key, value)
+	}
+	return b, nil
+}
@@ -0,0 +1,618 @@
+package config
+
+import (
+	"errors"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"auditlog-cleaner/cleaner"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// loadWith loads the configuration with env set on top of the defaults.
+func loadWith(t *testing.T, env map[string]string) (*Config, error) {
+	t.Helper()
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	return Load()
+}
+
+func TestLoadRetention(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string // Empty when the configuration is valid
+	}{
+		{"age", map[string]string{"RETENTION_MODE": "age"}, ""},
+		{"age with max partitions", map[string]string{"MAX_PARTITIONS": "10"}, ""},
+		{"count", map[string]string{"RETENTION_MODE": "count", "RETENTION_COUNT": "60"}, ""},
+		{"count without a count", map[string]string{"RETENTION_MODE": "count"}, "RETENTION_COUNT must be greater than 0"},
+		{"count with max partitions", map[string]string{"RETENTION_MODE": "count", "RETENTION_COUNT": "60", "MAX_PARTITIONS": "10"},
+			"MAX_PARTITIONS cannot be combined with RETENTION_MODE=count"},
+		{"count in age mode", map[string]string{"RETENTION_COUNT": "60"}, "RETENTION_COUNT only applies with RETENTION_MODE=count"},
+		{"unknown mode", map[string]string{"RETENTION_MODE": "size"}, `RETENTION_MODE must be age or count, got "size"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadWith(t, tt.env)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadDropStrategy(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr string // Empty when the configuration is valid
+	}{
+		{"default", map[string]string{}, cleaner.DropDirect, ""},
+		{"detach", map[string]string{"DROP_STRATEGY": "detach_drop"}, cleaner.DropDetach, ""},
+		{"detach concurrently", map[string]string{"DETACH_CONCURRENTLY": "true"}, cleaner.DropDetach, ""},
+		{"both", map[string]string{"DROP_STRATEGY": "detach_drop", "DETACH_CONCURRENTLY": "true"}, cleaner.DropDetach, ""},
+		{"conflicting", map[string]string{"DROP_STRATEGY": "drop", "DETACH_CONCURRENTLY": "true"}, "",
+			"DETACH_CONCURRENTLY cannot be combined with DROP_STRATEGY=drop"},
+		{"timescale", map[string]string{"DROP_STRATEGY": "detach_drop", "STORAGE_MODE": "timescale"}, "",
+			"DROP_STRATEGY=detach_drop does not apply to STORAGE_MODE=timescale"},
+		{"unknown", map[string]string{"DROP_STRATEGY": "truncate"}, "", `DROP_STRATEGY must be drop or detach_drop, got "truncate"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadWith(t, tt.env)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() = %v, want no error", err)
+			}
+			if cfg.Cleanup.DropStrategy != tt.want {
+				t.Errorf("DropStrategy = %q, want %q", cfg.Cleanup.DropStrategy, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadInsertMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr string // Empty when the configuration is valid
+	}{
+		{"default with pq", map[string]string{}, cleaner.InsertInsert, ""},
+		{"default with pgx", map[string]string{"DB_DRIVER": "pgx"}, cleaner.InsertCopy, ""},
+		{"copy with pq", map[string]string{"INSERT_MODE": "copy"}, cleaner.InsertCopy, ""},
+		{"insert with pgx", map[string]string{"DB_DRIVER": "pgx", "INSERT_MODE": "insert"}, cleaner.InsertInsert, ""},
+		{"unknown", map[string]string{"INSERT_MODE": "upsert"}, "", `INSERT_MODE must be copy or insert, got "upsert"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadWith(t, tt.env)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() = %v, want no error", err)
+			}
+			if cfg.InsertMode != tt.want {
+				t.Errorf("InsertMode = %q, want %q", cfg.InsertMode, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadPartitionGranularity(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    time.Duration // Range of the partitions created for ingested logs
+		wantErr string        // Empty when the configuration is valid
+	}{
+		{"default", map[string]string{}, 24 * time.Hour, ""},
+		{"minute", map[string]string{"PARTITION_GRANULARITY": "minute"}, time.Minute, ""},
+		{"hour", map[string]string{"PARTITION_GRANULARITY": "hour"}, time.Hour, ""},
+		{"day", map[string]string{"PARTITION_GRANULARITY": "day"}, 24 * time.Hour, ""},
+		{"matching interval", map[string]string{"PARTITION_GRANULARITY": "hour", "INGEST_PARTITION_INTERVAL": "1h"}, time.Hour, ""},
+		{"conflicting interval", map[string]string{"PARTITION_GRANULARITY": "hour", "INGEST_PARTITION_INTERVAL": "1d"}, 0,
+			"INGEST_PARTITION_INTERVAL=1d conflicts with PARTITION_GRANULARITY=hour"},
+		{"unknown", map[string]string{"PARTITION_GRANULARITY": "week"}, 0, `PARTITION_GRANULARITY must be minute, hour or day, got "week"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadWith(t, tt.env)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() = %v, want no error", err)
+			}
+			if cfg.Ingest.PartitionInterval != tt.want {
+				t.Errorf("Ingest.PartitionInterval = %s, want %s", cfg.Ingest.PartitionInterval, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadPartitionPremake(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string // Empty when the configuration is valid
+	}{
+		{"disabled", map[string]string{}, ""},
+		{"hourly", map[string]string{"PARTITION_PREMAKE": "3", "PARTITION_GRANULARITY": "hour"}, ""},
+		{"without granularity", map[string]string{"PARTITION_PREMAKE": "3"}, "PARTITION_PREMAKE needs PARTITION_GRANULARITY"},
+		{"negative", map[string]string{"PARTITION_PREMAKE": "-1", "PARTITION_GRANULARITY": "hour"}, "PARTITION_PREMAKE must not be negative"},
+		{"lookahead", map[string]string{"PARTITION_LOOKAHEAD": "3", "PARTITION_GRANULARITY": "hour"}, ""},
+		{"lookahead without granularity", map[string]string{"PARTITION_LOOKAHEAD": "3"}, "PARTITION_PREMAKE needs PARTITION_GRANULARITY"},
+		{"both", map[string]string{"PARTITION_LOOKAHEAD": "3", "PARTITION_PREMAKE": "3", "PARTITION_GRANULARITY": "hour"}, ""},
+		{"conflicting", map[string]string{"PARTITION_LOOKAHEAD": "3", "PARTITION_PREMAKE": "2", "PARTITION_GRANULARITY": "hour"},
+			"PARTITION_LOOKAHEAD=3 conflicts with PARTITION_PREMAKE=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadWith(t, tt.env)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadIngest(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string // Empty when the configuration is valid
+	}{
+		{"disabled", map[string]string{}, ""},
+		{"with a token", map[string]string{"INGEST_PORT": "8081", "INGEST_TOKEN": "s3cret"}, ""},
+		{"explicitly unprotected", map[string]string{"INGEST_PORT": "8081", "INGEST_ALLOW_UNAUTHENTICATED": "true"}, ""},
+		{"without a token", map[string]string{"INGEST_PORT": "8081"}, "INGEST_PORT needs INGEST_TOKEN"},
+		{"no future window", map[string]string{"INGEST_PORT": "8081", "INGEST_TOKEN": "s3cret", "INGEST_MAX_FUTURE": "0s"},
+			"INGEST_MAX_FUTURE must be greater than 0"},
+		{"no partitions", map[string]string{"INGEST_PORT": "8081", "INGEST_TOKEN": "s3cret", "INGEST_MAX_PARTITIONS": "0"},
+			"INGEST_MAX_PARTITIONS must be greater than 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadWith(t, tt.env)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadTiming(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string // Empty when the configuration is valid
+	}{
+		{"defaults", map[string]string{}, ""},
+		{"durations", map[string]string{"INSERT_INTERVAL": "2s", "CLEANUP_INTERVAL": "1h", "MAX_LOG_AGE": "90d"}, ""},
+		{"no log age", map[string]string{"MAX_LOG_AGE": "0s"}, ""},
+		{"no insert interval", map[string]string{"INSERT_INTERVAL": "0s"}, "INSERT_INTERVAL must be greater than 0"},
+		{"negative cleanup interval", map[string]string{"CLEANUP_INTERVAL": "-1m"}, "CLEANUP_INTERVAL must be greater than 0"},
+		{"negative log age", map[string]string{"MAX_LOG_AGE": "-1h"}, "MAX_LOG_AGE must not be negative"},
+		{"no shutdown timeout", map[string]string{"SHUTDOWN_TIMEOUT": "0"}, "SHUTDOWN_TIMEOUT must be greater than 0"},
+		{"backoff below the interval", map[string]string{"INSERT_INTERVAL": "10s", "INSERT_MAX_BACKOFF": "5s"},
+			"INSERT_MAX_BACKOFF must be at least INSERT_INTERVAL (10s)"},
+		{"jitter of 100%", map[string]string{"TICK_JITTER_PERCENT": "100"}, "TICK_JITTER_PERCENT must be at least 0 and less than 100"},
+		{"not an integer", map[string]string{"POSTGRES_PORT": "five"}, `invalid POSTGRES_PORT "five": not an integer`},
+		{"not a number", map[string]string{"INSERT_RATE_PER_SECOND": "fast"}, `invalid INSERT_RATE_PER_SECOND "fast": not a number`},
+		{"not a boolean", map[string]string{"DRY_RUN": "maybe"}, `invalid DRY_RUN "maybe": not a boolean`},
+		{"not a duration", map[string]string{"RUN_DURATION": "forever"}, `invalid RUN_DURATION "forever"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadWith(t, tt.env)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	_, err := loadWith(t, map[string]string{
+		"INSERT_INTERVAL":  "0s",
+		"CLEANUP_INTERVAL": "0s",
+		"SHUTDOWN_TIMEOUT": "0s",
+	})
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Load() = %v, want a *ValidationError", err)
+	}
+	if len(invalid.Problems) != 3 {
+		t.Errorf("Load() reported %d problems, want 3: %v", len(invalid.Problems), err)
+	}
+}
+
+func TestLoadHolds(t *testing.T) {
+	tests := []struct {
+		name      string
+		holds     string
+		wantHolds int
+		wantErr   string // Empty when the configuration is valid
+	}{
+		{"none", "", 0, ""},
+		{"dates", `[{"from": "2024-03-03", "to": "2024-03-04", "reason": "case 123"}]`, 1, ""},
+		{"times of a table", `[{"from": "2024-03-03T06:00", "to": "2024-03-03T07:00:00Z", "table": "audit_logs"}]`, 1, ""},
+		{"not JSON", `from 2024-03-03`, 0, "invalid HOLDS"},
+		{"invalid time", `[{"from": "yesterday", "to": "2024-03-04"}]`, 0, `hold 1 in HOLDS: from: invalid time "yesterday"`},
+		{"empty range", `[{"from": "2024-03-04", "to": "2024-03-04"}]`, 0, "hold 1 in HOLDS: from 2024-03-04 is not before to 2024-03-04"},
+		{"unmanaged table", `[{"from": "2024-03-03", "to": "2024-03-04", "table": "access_logs"}]`, 0,
+			"hold of table access_logs in HOLDS is not one of the managed tables"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadWith(t, map[string]string{"HOLDS": tt.holds})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() = %v, want no error", err)
+			}
+			if len(cfg.Cleanup.Holds) != tt.wantHolds {
+				t.Errorf("loaded %d holds, want %d", len(cfg.Cleanup.Holds), tt.wantHolds)
+			}
+		})
+	}
+}
+
+func TestLoadPasswordFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+		return path
+	}
+	secret := writeFile("secret", "from the file\n")
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr string // Empty when the configuration is valid
+	}{
+		{"variable", map[string]string{"POSTGRES_PASSWORD": "from the variable"}, "from the variable", ""},
+		{"file", map[string]string{"POSTGRES_PASSWORD_FILE": secret}, "from the file", ""},
+		{"file over variable", map[string]string{"POSTGRES_PASSWORD_FILE": secret, "POSTGRES_PASSWORD": "from the variable"},
+			"from the file", ""},
+		{"missing file", map[string]string{"POSTGRES_PASSWORD_FILE": filepath.Join(dir, "missing")}, "",
+			"reading POSTGRES_PASSWORD_FILE"},
+		{"empty file", map[string]string{"POSTGRES_PASSWORD_FILE": writeFile("empty", "\n")}, "", "is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadWith(t, tt.env)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() = %v, want no error", err)
+			}
+			if cfg.Database.Password != tt.want {
+				t.Errorf("Password = %q, want %q", cfg.Database.Password, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectionStringQuotesValues(t *testing.T) {
+	// The DSN is read back with pgx's parser, which follows libpq
+	passwords := []string{"plain", "two words", "it's", `back\slash`, `\'`, "x sslmode=verify-full", ""}
+	for _, password := range passwords {
+		d := DatabaseConfig{Host: "db", Port: 5432, User: "audit", Password: password, DBName: "audit logs",
+			SSLMode: "disable", ConnectTimeout: 5 * time.Second}
+		parsed, err := pgconn.ParseConfig(d.ConnectionString())
+		if err != nil {
+			t.Errorf("parsing the DSN with password %q: %v", password, err)
+			continue
+		}
+		if parsed.Password != password || parsed.Database != "audit logs" || parsed.User != "audit" || parsed.TLSConfig != nil {
+			t.Errorf("DSN with password %q read back as password %q, database %q, user %q, TLS %t",
+				password, parsed.Password, parsed.Database, parsed.User, parsed.TLSConfig != nil)
+		}
+	}
+}
+
+func TestConnectionStringCertificates(t *testing.T) {
+	base := DatabaseConfig{Host: "db", Port: 5432, User: "audit", DBName: "audit", SSLMode: "verify-full",
+		ConnectTimeout: 5 * time.Second}
+	tests := []struct {
+		name     string
+		root     string
+		cert     string
+		key      string
+		want     []string
+		wantNone []string
+	}{
+		{"none", "", "", "", nil, []string{"sslrootcert", "sslcert", "sslkey"}},
+		{"root only", "/etc/ssl/root.crt", "", "", []string{"sslrootcert='/etc/ssl/root.crt'"}, []string{"sslcert", "sslkey"}},
+		{"client", "/etc/ssl/root.crt", "/etc/ssl/client.crt", "/etc/ssl/client.key",
+			[]string{"sslrootcert='/etc/ssl/root.crt'", "sslcert='/etc/ssl/client.crt'", "sslkey='/etc/ssl/client.key'"}, nil},
+		{"paths with spaces", "/etc/my certs/root.crt", "", "", []string{`sslrootcert='/etc/my certs/root.crt'`}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := base
+			d.SSLRootCert, d.SSLCert, d.SSLKey = tt.root, tt.cert, tt.key
+			dsn := d.ConnectionString()
+			if !strings.HasPrefix(dsn, "host='db' port=5432 user='audit' password='' dbname='audit' sslmode='verify-full' connect_timeout=5") {
+				t.Errorf("ConnectionString() = %s, want the connection settings first", dsn)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(dsn, " "+want) {
+					t.Errorf("ConnectionString() = %s, want it to contain %s", dsn, want)
+				}
+			}
+			for _, unwanted := range tt.wantNone {
+				if strings.Contains(dsn, unwanted) {
+					t.Errorf("ConnectionString() = %s, want no %s", dsn, unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadDatabaseURLCertificates(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"root.crt", "client.crt", "client.key"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("pem"), 0o600); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	root, cert, key := filepath.Join(dir, "root.crt"), filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	const base = "postgres://audit:secret@db:5432/audit?sslmode=verify-full"
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    map[string]string // Parameters of the connection URL
+		wantErr string            // Empty when the configuration is valid
+	}{
+		{"no files", map[string]string{}, map[string]string{"sslmode": "verify-full", "sslrootcert": ""}, ""},
+		{"root", map[string]string{"POSTGRES_SSL_ROOT_CERT": root},
+			map[string]string{"sslmode": "verify-full", "sslrootcert": root, "sslcert": ""}, ""},
+		{"client", map[string]string{"POSTGRES_SSL_ROOT_CERT": root, "POSTGRES_SSL_CERT": cert, "POSTGRES_SSL_KEY": key},
+			map[string]string{"sslrootcert": root, "sslcert": cert, "sslkey": key}, ""},
+		{"conflicting", map[string]string{"DATABASE_URL": base + "&sslrootcert=/etc/ssl/other.crt", "POSTGRES_SSL_ROOT_CERT": root},
+			nil, "POSTGRES_SSL_ROOT_CERT cannot be combined with sslrootcert in DATABASE_URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env["DATABASE_URL"] == "" {
+				tt.env["DATABASE_URL"] = base
+			}
+			cfg, err := loadWith(t, tt.env)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() = %v, want no error", err)
+			}
+
+			dsn := cfg.Database.ConnectionString()
+			u, err := url.Parse(dsn)
+			if err != nil {
+				t.Fatalf("ConnectionString() = %s, not a URL: %v", dsn, err)
+			}
+			if u.User.String() != "audit:secret" || u.Host != "db:5432" || u.Path != "/audit" {
+				t.Errorf("ConnectionString() = %s, want the credentials, host and database of DATABASE_URL", dsn)
+			}
+			for name, want := range tt.want {
+				if got := u.Query().Get(name); got != want {
+					t.Errorf("parameter %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSafeConnectionString(t *testing.T) {
+	tests := []struct {
+		name string
+		db   DatabaseConfig
+		want string
+	}{
+		{"fields", DatabaseConfig{Host: "db", Port: 5432, User: "audit", Password: "s3cret pass", DBName: "audit",
+			SSLMode: "disable", ConnectTimeout: 5 * time.Second},
+			"host='db' port=5432 user='audit' password='****' dbname='audit' sslmode='disable' connect_timeout=5"},
+		{"url", DatabaseConfig{URL: "postgres://audit:s3cret@db:5432/audit?sslmode=disable"},
+			"postgres://audit:xxxxx@db:5432/audit?sslmode=disable"},
+		{"url without a password", DatabaseConfig{URL: "postgres://audit@db/audit"}, "postgres://audit@db/audit"},
+		{"unparsable url", DatabaseConfig{URL: "postgres://audit:s3cret@db:port/audit"}, "****"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.db.SafeConnectionString()
+			if got != tt.want {
+				t.Errorf("SafeConnectionString() = %s, want %s", got, tt.want)
+			}
+			if strings.Contains(got, "s3cret") {
+				t.Errorf("SafeConnectionString() = %s, which contains the password", got)
+			}
+		})
+	}
+}
+
+func TestLoadPool(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		open     int
+		idle     int
+		lifetime time.Duration
+		wantErr  string // Empty when the configuration is valid
+	}{
+		{"default", map[string]string{}, 10, 5, 30 * time.Minute, ""},
+		{"set", map[string]string{"DB_MAX_OPEN_CONNS": "20", "DB_MAX_IDLE_CONNS": "8", "DB_CONN_MAX_LIFETIME": "1h"},
+			20, 8, time.Hour, ""},
+		{"unlimited", map[string]string{"DB_MAX_OPEN_CONNS": "0", "DB_MAX_IDLE_CONNS": "0", "DB_CONN_MAX_LIFETIME": "0"},
+			0, 0, 0, ""},
+		{"too few open", map[string]string{"DB_MAX_OPEN_CONNS": "1"}, 0, 0, 0,
+			"DB_MAX_OPEN_CONNS must be 0 or more than the 1 connections held for locks, got 1"},
+		{"too few open with leader election", map[string]string{"DB_MAX_OPEN_CONNS": "2", "LEADER_ELECTION": "true"}, 0, 0, 0,
+			"DB_MAX_OPEN_CONNS must be 0 or more than the 2 connections held for locks, got 2"},
+		{"negative open", map[string]string{"DB_MAX_OPEN_CONNS": "-1"}, 0, 0, 0, "DB_MAX_OPEN_CONNS must not be negative, got -1"},
+		{"negative idle", map[string]string{"DB_MAX_IDLE_CONNS": "-1"}, 0, 0, 0, "DB_MAX_IDLE_CONNS must not be negative, got -1"},
+		{"negative lifetime", map[string]string{"DB_CONN_MAX_LIFETIME": "-1m"}, 0, 0, 0,
+			"DB_CONN_MAX_LIFETIME must not be negative, got -1m0s"},
+		{"open not a number", map[string]string{"DB_MAX_OPEN_CONNS": "ten"}, 0, 0, 0,
+			`invalid DB_MAX_OPEN_CONNS "ten": not an integer`},
+		{"idle not a number", map[string]string{"DB_MAX_IDLE_CONNS": "5.5"}, 0, 0, 0,
+			`invalid DB_MAX_IDLE_CONNS "5.5": not an integer`},
+		{"lifetime not a duration", map[string]string{"DB_CONN_MAX_LIFETIME": "soon"}, 0, 0, 0,
+			`invalid DB_CONN_MAX_LIFETIME "soon"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadWith(t, tt.env)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() = %v, want no error", err)
+			}
+			d := cfg.Database
+			if d.MaxOpenConns != tt.open || d.MaxIdleConns != tt.idle || d.ConnMaxLifetime != tt.lifetime {
+				t.Errorf("pool = %d open, %d idle, %s lifetime, want %d, %d, %s",
+					d.MaxOpenConns, d.MaxIdleConns, d.ConnMaxLifetime, tt.open, tt.idle, tt.lifetime)
+			}
+		})
+	}
+}
+
+func TestLoadSSLMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		wantErr bool
+	}{
+		{"", false}, // Defaults to disable
+		{"disable", false},
+		{"allow", false},
+		{"prefer", false},
+		{"require", false},
+		{"verify-ca", false},
+		{"verify-full", false},
+		{"Require", true},
+		{"verify_full", true},
+		{"on", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			cfg, err := loadWith(t, map[string]string{"POSTGRES_SSL_MODE": tt.mode})
+			if tt.wantErr {
+				want := `POSTGRES_SSL_MODE must be one of disable, allow, prefer, require, verify-ca, verify-full, got "` + tt.mode + `"`
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Fatalf("Load() = %v, want an error containing %q", err, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() = %v, want no error", err)
+			}
+			want := tt.mode
+			if want == "" {
+				want = "disable"
+			}
+			if cfg.Database.SSLMode != want {
+				t.Errorf("SSLMode = %q, want %q", cfg.Database.SSLMode, want)
+			}
+		})
+	}
+}
+
+func TestParseWeights(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    map[string]int
+		wantErr string // Empty when raw is valid
+	}{
+		{"", nil, ""},
+		{"GET=70,POST=20,DELETE=10", map[string]int{"GET": 70, "POST": 20, "DELETE": 10}, ""},
+		{"GET:70, POST:30", map[string]int{"GET": 70, "POST": 30}, ""},
+		{"GET,POST", map[string]int{"GET": 1, "POST": 1}, ""},
+		{"GET=70,POST", map[string]int{"GET": 70, "POST": 1}, ""},
+		{"GET=0", nil, `weight of GET must be a whole number greater than 0, got "0"`},
+		{"GET=often", nil, `weight of GET must be a whole number greater than 0, got "often"`},
+		{"=10", nil, `entry "=10" has no name`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parseWeights(tt.raw)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseWeights(%q) = %v, want error %q", tt.raw, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseWeights(%q) = %v", tt.raw, err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("parseWeights(%q) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// dayWeekPattern matches the day and week units that time.ParseDuration
+// doesn't understand, e.g. the "90d" in "90d" or the "1w" in "1w12h".
+var dayWeekPattern = regexp.MustCompile(`(\d+(?:\.\d+)?)([dw])`)
+
+// ParseDuration parses a Go duration string, additionally accepting "d" for
+// days and "w" for weeks.
+func ParseDuration(s string) (time.Duration, error) {
+	expanded := dayWeekPattern.ReplaceAllStringFunc(s, func(m string) string {
+		parts := dayWeekPattern.FindStringSubmatch(m)
+		n, _ := strconv.ParseFloat(parts[1], 64)
+		hours := n * 24
+		if parts[2] == "w" {
+			hours *= 7
+		}
+		return strconv.FormatFloat(hours, 'f', -1, 64) + "h"
+	})
+	return time.ParseDuration(expanded)
+}
+
+// timeLayouts are the accepted forms of points in time, e.g. of hold
+// ranges; times without a zone are UTC.
+var timeLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"}
+
+// ParseTime parses a point in time like 2024-01-15, 2024-01-15T12:00 or
+// RFC 3339.
+func ParseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected e.g. 2024-01-15, 2024-01-15T12:00 or RFC 3339", s)
+}
+
+// parseDurationOrSeconds parses s with ParseDuration, treating a plain number
+// as a number of seconds.
+func parseDurationOrSeconds(s string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return ParseDuration(s)
+}
+
+// getEnvAsDuration reads a duration from key, falling back to the deprecated
+// legacyKey holding a number of seconds, and then to defaultValue. Setting
+// both keys to different values is an error. legacyKey may be empty for
+// settings that never had a seconds form.
+func getEnvAsDuration(key, legacyKey string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	legacyValue := os.Getenv(legacyKey)
+
+	var d time.Duration
+	if value != "" {
+		parsed, err := parseDurationOrSeconds(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q: expected a duration like 30s, 15m or 90d", key, value)
+		}
+		d = parsed
+	}
+
+	if legacyValue == "" {
+		if value == "" {
+			return defaultValue, nil
+		}
+		return d, nil
+	}
+
+	legacy, err := parseDurationOrSeconds(legacyValue)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: expected a number of seconds", legacyKey, legacyValue)
+	}
+
+	if value != "" && legacy != d {
+		return 0, fmt.Errorf("%s=%s conflicts with %s=%s, set only %s", key, value, legacyKey, legacyValue, key)
+	}
+	slog.Warn("deprecated setting, use the duration form instead", "setting", legacyKey, "replacement", key)
+	return legacy, nil
+}
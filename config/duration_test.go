@@ -0,0 +1,107 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30s", 30 * time.Second, false},
+		{"15m", 15 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"90d", 90 * 24 * time.Hour, false},
+		{"1.5d", 36 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"1w12h", 180 * time.Hour, false},
+		{"1d2h3m", 26*time.Hour + 3*time.Minute, false},
+		{"0s", 0, false},
+		{"", 0, true},
+		{"90", 0, true},
+		{"d", 0, true},
+		{"5y", 0, true},
+		{"ten minutes", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseDuration(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDuration(%q) error = %v, want error %t", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDuration(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2024-01-15", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), false},
+		{"2024-01-15T12:30", time.Date(2024, 1, 15, 12, 30, 0, 0, time.UTC), false},
+		{"2024-01-15T12:30:00Z", time.Date(2024, 1, 15, 12, 30, 0, 0, time.UTC), false},
+		{"2024-01-15T12:30:00+02:00", time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC), false},
+		{"15/01/2024", time.Time{}, true},
+		{"2024-02-30", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseTime(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTime(%q) error = %v, want error %t", tt.in, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseTime(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetEnvAsDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string // CLEANUP_INTERVAL
+		legacy  string // CLEANUP_INTERVAL_SECONDS
+		want    time.Duration
+		wantErr string // Empty when the value is valid
+	}{
+		{"unset", "", "", time.Minute, ""},
+		{"duration", "5m", "", 5 * time.Minute, ""},
+		{"days", "2d", "", 48 * time.Hour, ""},
+		{"plain seconds", "90", "", 90 * time.Second, ""},
+		{"legacy seconds", "", "120", 2 * time.Minute, ""},
+		{"both agreeing", "2m", "120", 2 * time.Minute, ""},
+		{"both conflicting", "5m", "120", 0, "CLEANUP_INTERVAL=5m conflicts with CLEANUP_INTERVAL_SECONDS=120"},
+		{"invalid", "soon", "", 0, `invalid CLEANUP_INTERVAL "soon"`},
+		{"invalid legacy", "", "soon", 0, `invalid CLEANUP_INTERVAL_SECONDS "soon"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLEANUP_INTERVAL", tt.value)
+			t.Setenv("CLEANUP_INTERVAL_SECONDS", tt.legacy)
+
+			got, err := getEnvAsDuration("CLEANUP_INTERVAL", "CLEANUP_INTERVAL_SECONDS", time.Minute)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("getEnvAsDuration() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getEnvAsDuration() = %v, want no error", err)
+			}
+			if got != tt.want {
+				t.Errorf("getEnvAsDuration() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
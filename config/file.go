@@ -0,0 +1,297 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileKeys maps the settings of a configuration file, by section, to the
+// environment variable each stands for; "" holds the top-level settings.
+// tables, generator.columns and cleanup.holds take lists of objects like
+// their JSON variables, cleanup.indexes and generator.paths a list and
+// generator.methods a map of weights.
+var fileKeys = map[string]map[string]string{
+	"": {
+		"mode":                     "MODE",
+		"run_mode":                 "RUN_MODE",
+		"tables":                   "TABLES",
+		"metrics_port":             "METRICS_PORT",
+		"admin_token":              "ADMIN_TOKEN",
+		"admin_unprotected":        "ADMIN_ALLOW_UNAUTHENTICATED",
+		"otlp_endpoint":            "OTEL_EXPORTER_OTLP_ENDPOINT",
+		"leader_election":          "LEADER_ELECTION",
+		"leader_election_interval": "LEADER_ELECTION_INTERVAL",
+		"health_staleness":         "HEALTH_STALENESS",
+		"cleanup_skip_threshold":   "CLEANUP_SKIP_THRESHOLD",
+		"failure_threshold":        "FAILURE_THRESHOLD",
+		"exit_on_failure":          "EXIT_ON_FAILURE",
+		"reset_on_start":           "RESET_ON_START",
+		"dry_run":                  "DRY_RUN",
+		"migrate_timestamptz":      "MIGRATE_TIMESTAMPTZ",
+		"force":                    "FORCE",
+	},
+	"database": {
+		"driver":            "DB_DRIVER",
+		"url":               "DATABASE_URL",
+		"host":              "POSTGRES_HOST",
+		"port":              "POSTGRES_PORT",
+		"user":              "POSTGRES_USER",
+		"password":          "POSTGRES_PASSWORD",
+		"password_file":     "POSTGRES_PASSWORD_FILE",
+		"name":              "POSTGRES_DB",
+		"ssl_mode":          "POSTGRES_SSL_MODE",
+		"ssl_root_cert":     "POSTGRES_SSL_ROOT_CERT",
+		"ssl_cert":          "POSTGRES_SSL_CERT",
+		"ssl_key":           "POSTGRES_SSL_KEY",
+		"connect_timeout":   "DB_CONNECT_TIMEOUT",
+		"query_timeout":     "DB_QUERY_TIMEOUT",
+		"max_open_conns":    "DB_MAX_OPEN_CONNS",
+		"max_idle_conns":    "DB_MAX_IDLE_CONNS",
+		"conn_max_lifetime": "DB_CONN_MAX_LIFETIME",
+		"connect_retries":   "DB_CONNECT_RETRIES",
+		"startup_timeout":   "STARTUP_DB_TIMEOUT_SECONDS",
+		"max_retries":       "DB_MAX_RETRIES",
+		"retry_base_ms":     "DB_RETRY_BASE_MS",
+	},
+	"timing": {
+		"insert_interval":            "INSERT_INTERVAL",
+		"insert_rate_per_second":     "INSERT_RATE_PER_SECOND",
+		"insert_jitter_percent":      "INSERT_JITTER_PERCENT",
+		"tick_jitter_percent":        "TICK_JITTER_PERCENT",
+		"ramp_up_duration":           "RAMP_UP_DURATION",
+		"insert_backoff_threshold":   "INSERT_BACKOFF_THRESHOLD",
+		"insert_max_backoff":         "INSERT_MAX_BACKOFF",
+		"cleanup_interval":           "CLEANUP_INTERVAL",
+		"max_log_age":                "MAX_LOG_AGE",
+		"shutdown_timeout":           "SHUTDOWN_TIMEOUT",
+		"run_duration":               "RUN_DURATION",
+		"max_total_inserts":          "MAX_TOTAL_INSERTS",
+		"keep_cleanup_after_inserts": "KEEP_CLEANUP_AFTER_INSERTS",
+	},
+	"cleanup": {
+		"strategy":              "CLEANUP_STRATEGY",
+		"delete_batch_size":     "DELETE_BATCH_SIZE",
+		"delete_batch_pause":    "DELETE_BATCH_PAUSE",
+		"vacuum":                "VACUUM_AFTER_CLEANUP",
+		"maintenance":           "MAINTENANCE_ENABLED",
+		"maintenance_interval":  "MAINTENANCE_INTERVAL",
+		"drop_strategy":         "DROP_STRATEGY",
+		"detach_concurrently":   "DETACH_CONCURRENTLY",
+		"lock_timeout":          "DDL_LOCK_TIMEOUT",
+		"statement_timeout":     "STATEMENT_TIMEOUT",
+		"history":               "HISTORY_ENABLED",
+		"default_partition":     "CREATE_DEFAULT_PARTITION",
+		"storage_mode":          "STORAGE_MODE",
+		"chunk_interval":        "CHUNK_TIME_INTERVAL",
+		"max_total_size":        "MAX_TOTAL_SIZE",
+		"max_partitions":        "MAX_PARTITIONS",
+		"retention_mode":        "RETENTION_MODE",
+		"retention_count":       "RETENTION_COUNT",
+		"min_retained":          "MIN_RETAINED_PARTITIONS",
+		"safety_margin":         "CLEANUP_SAFETY_MARGIN_SECONDS",
+		"partition_column":      "PARTITION_COLUMN",
+		"partition_name_prefix": "PARTITION_NAME_PREFIX",
+		"partition_template":    "PARTITION_NAME_TEMPLATE",
+		"partition_layout":      "PARTITION_TIME_LAYOUT",
+		"partition_granularity": "PARTITION_GRANULARITY",
+		"partition_premake":     "PARTITION_PREMAKE",
+		"partition_lookahead":   "PARTITION_LOOKAHEAD",
+		"indexes":               "INDEXES",
+		"holds":                 "HOLDS",
+		"index_mode":            "INDEX_MODE",
+	},
+	"archive": {
+		"dir":                  "ARCHIVE_DIR",
+		"gzip":                 "ARCHIVE_GZIP",
+		"s3_bucket":            "ARCHIVE_S3_BUCKET",
+		"s3_endpoint":          "ARCHIVE_S3_ENDPOINT",
+		"s3_region":            "ARCHIVE_S3_REGION",
+		"s3_prefix":            "ARCHIVE_S3_PREFIX",
+		"s3_access_key_id":     "ARCHIVE_S3_ACCESS_KEY_ID",
+		"s3_secret_access_key": "ARCHIVE_S3_SECRET_ACCESS_KEY",
+	},
+	"notify": {
+		"webhook_url": "NOTIFY_WEBHOOK_URL",
+		"format":      "NOTIFY_FORMAT",
+		"on_success":  "NOTIFY_ON_SUCCESS",
+	},
+	"ingest": {
+		"port":               "INGEST_PORT",
+		"max_body_size":      "INGEST_MAX_BODY_SIZE",
+		"buffer_size":        "INGEST_BUFFER_SIZE",
+		"flush_interval":     "INGEST_FLUSH_INTERVAL",
+		"partition_interval": "INGEST_PARTITION_INTERVAL",
+		"max_future":         "INGEST_MAX_FUTURE",
+		"max_partitions":     "INGEST_MAX_PARTITIONS",
+		"token":              "INGEST_TOKEN",
+		"unprotected":        "INGEST_ALLOW_UNAUTHENTICATED",
+	},
+	"generator": {
+		"table":                    "TABLE_NAME",
+		"columns":                  "GENERATOR_COLUMNS",
+		"methods":                  "GENERATOR_METHODS",
+		"paths":                    "GENERATOR_PATHS",
+		"users":                    "GENERATOR_USERS",
+		"error_rate":               "GENERATOR_ERROR_RATE",
+		"seed":                     "RANDOM_SEED",
+		"batch_chunk_size":         "BATCH_CHUNK_SIZE",
+		"batch_single_transaction": "BATCH_SINGLE_TRANSACTION",
+		"insert_mode":              "INSERT_MODE",
+	},
+	"log": {
+		"format": "LOG_FORMAT",
+		"level":  "LOG_LEVEL",
+	},
+}
+
+// LoadFile reads the YAML configuration file at path, e.g.
+//
+//	database:
+//	  host: db
+//	  password_file: /run/secrets/db-password
+//	timing:
+//	  cleanup_interval: 5m
+//	tables:
+//	  - name: audit_logs
+//	    max_age: 30d
+//
+// and sets the environment variable of each of its settings that is not
+// set yet, so that Load parses and validates them like the environment.
+// Flags and the environment thus take precedence over the file, which
+// takes precedence over the defaults. Every unknown or malformed setting is
+// reported at once, as a *ValidationError, before any variable is set.
+func LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	var file map[string]any
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	problems := &ValidationError{}
+	values := make(map[string]string)
+	for _, key := range sortedKeys(file) {
+		if _, ok := fileKeys[""][key]; ok {
+			problems.add(setFileValue(values, key, fileKeys[""][key], file[key]))
+			continue
+		}
+		keys, ok := fileKeys[key]
+		if !ok {
+			problems.add(fmt.Errorf("unknown setting %s in config file", key))
+			continue
+		}
+		section, ok := file[key].(map[string]any)
+		if !ok {
+			problems.add(fmt.Errorf("%s in config file must be a section of settings", key))
+			continue
+		}
+		for _, name := range sortedKeys(section) {
+			env, ok := keys[name]
+			if !ok {
+				problems.add(fmt.Errorf("unknown setting %s.%s in config file", key, name))
+				continue
+			}
+			problems.add(setFileValue(values, key+"."+name, env, section[name]))
+		}
+	}
+	if err := problems.orNil(); err != nil {
+		return err
+	}
+
+	for env, value := range values {
+		if _, set := os.LookupEnv(env); set {
+			continue
+		}
+		if err := os.Setenv(env, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFileValue converts the value of the setting key to the form of its
+// environment variable env and records it in values.
+func setFileValue(values map[string]string, key, env string, value any) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []any:
+		switch env {
+		case "TABLES", "GENERATOR_COLUMNS", "HOLDS":
+			// max_age and the like may be written as numbers of seconds,
+			// hold ranges as YAML timestamps
+			objects := make([]map[string]string, len(v))
+			for i, item := range v {
+				fields, ok := item.(map[string]any)
+				if !ok {
+					return fmt.Errorf("%s in config file must be a list of objects", key)
+				}
+				objects[i] = make(map[string]string, len(fields))
+				for name, field := range fields {
+					if t, ok := field.(time.Time); ok {
+						objects[i][name] = t.Format(time.RFC3339)
+						continue
+					}
+					objects[i][name] = fmt.Sprint(field)
+				}
+			}
+			data, err := json.Marshal(objects)
+			if err != nil {
+				return fmt.Errorf("invalid %s in config file: %w", key, err)
+			}
+			values[env] = string(data)
+		case "INDEXES":
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			data, err := json.Marshal(items)
+			if err != nil {
+				return fmt.Errorf("invalid %s in config file: %w", key, err)
+			}
+			values[env] = string(data)
+		case "GENERATOR_PATHS":
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[env] = strings.Join(items, ",")
+		default:
+			return fmt.Errorf("%s in config file must be a single value, not a list", key)
+		}
+	case map[string]any:
+		if env != "GENERATOR_METHODS" {
+			return fmt.Errorf("%s in config file must be a single value, not a section", key)
+		}
+		weights := make([]string, 0, len(v))
+		for _, name := range sortedKeys(v) {
+			weights = append(weights, fmt.Sprintf("%s=%v", name, v[name]))
+		}
+		values[env] = strings.Join(weights, ",")
+	default:
+		if env == "TABLES" || env == "GENERATOR_COLUMNS" || env == "HOLDS" {
+			return fmt.Errorf("%s in config file must be a list of objects", key)
+		}
+		values[env] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in order, for stable error messages.
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
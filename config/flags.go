@@ -0,0 +1,60 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// envFlags maps each settings flag to the environment variable it
+// overrides. The password has no flag since command lines are visible to
+// other users; pass --db-password-file instead.
+var envFlags = []struct {
+	name, env, usage string
+}{
+	{"db-host", "POSTGRES_HOST", "database host"},
+	{"db-port", "POSTGRES_PORT", "database port"},
+	{"db-user", "POSTGRES_USER", "database user"},
+	{"db-password-file", "POSTGRES_PASSWORD_FILE", "file holding the database password"},
+	{"db-name", "POSTGRES_DB", "database name"},
+	{"db-ssl-mode", "POSTGRES_SSL_MODE", "TLS mode of the database connection"},
+	{"db-ssl-root-cert", "POSTGRES_SSL_ROOT_CERT", "CA certificate file to verify the database server against"},
+	{"db-ssl-cert", "POSTGRES_SSL_CERT", "client certificate file for the database connection"},
+	{"db-ssl-key", "POSTGRES_SSL_KEY", "private key file of the client certificate"},
+	{"db-connect-timeout", "DB_CONNECT_TIMEOUT", "timeout for opening a database connection"},
+	{"db-query-timeout", "DB_QUERY_TIMEOUT", "timeout for each database operation (0 means no limit)"},
+	{"db-max-open-conns", "DB_MAX_OPEN_CONNS", "maximum number of open database connections"},
+	{"db-max-idle-conns", "DB_MAX_IDLE_CONNS", "maximum number of idle database connections"},
+	{"db-conn-max-lifetime", "DB_CONN_MAX_LIFETIME", "maximum lifetime of a database connection"},
+	{"insert-interval", "INSERT_INTERVAL", "interval between generated audit logs"},
+	{"insert-rate", "INSERT_RATE_PER_SECOND", "generated audit logs per second (0 means one per insert interval)"},
+	{"insert-jitter", "INSERT_JITTER_PERCENT", "random variation of the insert interval, in percent"},
+	{"ramp-up", "RAMP_UP_DURATION", "time for the insert rate to rise linearly to its full value"},
+	{"cleanup-interval", "CLEANUP_INTERVAL", "interval between cleanup passes"},
+	{"max-log-age", "MAX_LOG_AGE", "age after which audit logs are deleted"},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "time to wait for running work on shutdown"},
+}
+
+// RegisterFlags defines a flag on fs for each database and timing setting.
+// Call ApplyFlags after parsing to let them take effect.
+func RegisterFlags(fs *flag.FlagSet) {
+	for _, f := range envFlags {
+		fs.String(f.name, "", fmt.Sprintf("%s (overrides %s)", f.usage, f.env))
+	}
+}
+
+// ApplyFlags copies the settings flags given on the command line into the
+// environment, so that they take precedence over the environment and .env,
+// which in turn take precedence over the defaults. Values are parsed by
+// Load like their environment variables.
+func ApplyFlags(fs *flag.FlagSet) error {
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		for _, f := range envFlags {
+			if f.name == fl.Name && err == nil {
+				err = os.Setenv(f.env, fl.Value.String())
+			}
+		}
+	})
+	return err
+}
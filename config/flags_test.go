@@ -0,0 +1,58 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		want    time.Duration
+		wantErr string // Empty when the configuration is valid
+	}{
+		{"default", map[string]string{}, nil, 30 * time.Second, ""},
+		{"environment", map[string]string{"MAX_LOG_AGE": "1h"}, nil, time.Hour, ""},
+		{"flag", map[string]string{}, []string{"--max-log-age=2h"}, 2 * time.Hour, ""},
+		{"flag over environment", map[string]string{"MAX_LOG_AGE": "1h"}, []string{"--max-log-age=2h"}, 2 * time.Hour, ""},
+		{"invalid flag", map[string]string{"MAX_LOG_AGE": "1h"}, []string{"--max-log-age=soon"}, 0, `invalid MAX_LOG_AGE "soon"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setting the variable, if only to empty, restores it after the
+			// test even when ApplyFlags sets it
+			t.Setenv("MAX_LOG_AGE", "")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			fs := flag.NewFlagSet("auditlog-cleaner", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			RegisterFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatalf("parsing %v: %v", tt.args, err)
+			}
+
+			if err := ApplyFlags(fs); err != nil {
+				t.Fatalf("ApplyFlags() = %v", err)
+			}
+			cfg, err := Load()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() = %v, want no error", err)
+			}
+			if cfg.Timing.MaxLogAge != tt.want {
+				t.Errorf("MaxLogAge = %s, want %s", cfg.Timing.MaxLogAge, tt.want)
+			}
+		})
+	}
+}
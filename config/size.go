@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sizePattern matches a byte size such as "50GB", "512 MB" or "1.5tb".
+var sizePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kmgt]?b?)$`)
+
+// sizeUnits are the multipliers of the size units, in powers of 1024 like
+// Postgres' pg_size_pretty.
+var sizeUnits = map[string]float64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "kb": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40,
+}
+
+// ParseSize parses a byte size with an optional unit of B, KB, MB, GB or
+// TB, each 1024 times the previous one. A plain number is a number of bytes.
+func ParseSize(s string) (int64, error) {
+	m := sizePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	bytes := n * sizeUnits[m[2]]
+	if bytes > math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return int64(bytes), nil
+}
+
+// getEnvAsSize returns the byte size in key, or defaultValue when unset.
+func getEnvAsSize(key string, defaultValue int64) (int64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	size, err := ParseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: expected a size like 500MB or 50GB", key, value)
+	}
+	return size, nil
+}
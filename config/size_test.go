@@ -0,0 +1,36 @@
+package config
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"512B", 512, false},
+		{"1k", 1 << 10, false},
+		{"512 MB", 512 << 20, false},
+		{"50GB", 50 << 30, false},
+		{"1.5tb", 3 << 39, false},
+		{" 2Gb ", 2 << 30, false},
+		{"", 0, true},
+		{"GB", 0, true},
+		{"-1GB", 0, true},
+		{"5PB", 0, true},
+		{"99999999999TB", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSize(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize(%q) error = %v, want error %t", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"auditlog-cleaner/cleaner"
+)
+
+// pingTimeout bounds the database ping done by every probe.
+const pingTimeout = 2 * time.Second
+
+// healthResponse is the JSON body returned by the probe endpoints.
+type healthResponse struct {
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	Version     string     `json:"version"`
+	LastInsert  *time.Time `json:"lastInsert"`
+	LastCleanup *time.Time `json:"lastCleanup"`
+	Leader      *bool      `json:"leader,omitempty"` // Only with leader election
+
+	// SkippedCleanups is the most cleanup cycles in a row any table has
+	// skipped by overrunning the cleanup interval
+	SkippedCleanups int64 `json:"skippedCleanups"`
+
+	// PausedTables lists the tables whose cleanup is paused; a pause is
+	// deliberate, so it leaves the status alone
+	PausedTables []string `json:"pausedTables"`
+}
+
+// healthChecker answers liveness and readiness probes.
+type healthChecker struct {
+	db        *sql.DB
+	generator *cleaner.Cleaner // nil when the insert generator is off
+	cleaners  []*cleaner.Cleaner
+	started   time.Time
+	version   string
+
+	// staleAfter fails readiness when no insert has succeeded for this
+	// long, until the generator finishes; 0 disables the check
+	staleAfter time.Duration
+
+	// skipThreshold fails readiness once a table has skipped this many
+	// cleanup cycles in a row; 0 disables the check
+	skipThreshold int64
+
+	// elector is nil without leader election; a standby writes no logs,
+	// so the staleness check only applies to the leader
+	elector *cleaner.Elector
+}
+
+func newHealthChecker(db *sql.DB, generator *cleaner.Cleaner, cleaners []*cleaner.Cleaner, staleAfter time.Duration, skipThreshold int, elector *cleaner.Elector) *healthChecker {
+	v, _, _ := buildInfo()
+	return &healthChecker{db: db, generator: generator, cleaners: cleaners, started: time.Now(), version: v,
+		staleAfter: staleAfter, skipThreshold: int64(skipThreshold), elector: elector}
+}
+
+// liveness reports healthy as long as the database answers a ping.
+func (h *healthChecker) liveness(w http.ResponseWriter, r *http.Request) {
+	h.respond(w, h.check(r.Context(), false))
+}
+
+// readiness additionally requires inserts and cleanup to be keeping up and
+// no routine to be failing persistently.
+func (h *healthChecker) readiness(w http.ResponseWriter, r *http.Request) {
+	h.respond(w, h.check(r.Context(), true))
+}
+
+func (h *healthChecker) check(ctx context.Context, ready bool) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	if err := h.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("database unreachable: %w", err)
+	}
+
+	// A routine failing persistently needs someone to fix the database
+	if ready {
+		for _, c := range h.cleaners {
+			if err := c.Failing(); err != nil {
+				return fmt.Errorf("table %s: %w", c.Table(), err)
+			}
+			if h.skipThreshold > 0 && c.SkippedCleanups() >= h.skipThreshold {
+				return fmt.Errorf("table %s: cleanup overran its interval, skipping %d cycles in a row", c.Table(), c.SkippedCleanups())
+			}
+		}
+	}
+
+	// A standby and a generator past MAX_TOTAL_INSERTS write no logs
+	standby := h.elector != nil && !h.elector.Leader()
+	if ready && h.staleAfter > 0 && h.generator != nil && !standby && !h.generator.InsertsFinished() {
+		// Before the first insert, measure from startup
+		last := h.started
+		if t := h.generator.LastInsert(); t != nil {
+			last = *t
+		}
+		if since := time.Since(last); since > h.staleAfter {
+			return fmt.Errorf("no successful insert for %s", since.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+func (h *healthChecker) respond(w http.ResponseWriter, err error) {
+	body := healthResponse{
+		Status:      "ok",
+		Version:     h.version,
+		LastCleanup: h.lastCleanupTime(),
+	}
+	body.PausedTables = []string{}
+	for _, c := range h.cleaners {
+		body.SkippedCleanups = max(body.SkippedCleanups, c.SkippedCleanups())
+		if c.Paused() {
+			body.PausedTables = append(body.PausedTables, c.Table())
+		}
+	}
+	if h.generator != nil {
+		body.LastInsert = h.generator.LastInsert()
+	}
+	if h.elector != nil {
+		leader := h.elector.Leader()
+		body.Leader = &leader
+	}
+	status := http.StatusOK
+	if err != nil {
+		body.Status = "unavailable"
+		body.Error = err.Error()
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// lastCleanupTime returns the oldest of the tables' last successful cleanups,
+// or nil while any table has yet to be cleaned up.
+func (h *healthChecker) lastCleanupTime() *time.Time {
+	var oldest *time.Time
+	for _, c := range h.cleaners {
+		t := c.LastCleanup()
+		if t == nil {
+			return nil
+		}
+		if oldest == nil || t.Before(*oldest) {
+			oldest = t
+		}
+	}
+	return oldest
+}
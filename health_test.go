@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"auditlog-cleaner/cleaner"
+)
+
+// probe serves a request to the probe handler and decodes the response.
+func probe(t *testing.T, handler http.HandlerFunc) (int, healthResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var body healthResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	return w.Code, body
+}
+
+func TestReadinessAfterInsertLimit(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("opening mock database: %v", err)
+	}
+	defer db.Close()
+
+	// Every insert is stale at once, so only a finished generator is ready
+	generator := cleaner.New(db, cleaner.Options{Table: "audit_logs", Generate: true,
+		InsertInterval: time.Millisecond, MaxInserts: 1})
+	health := newHealthChecker(db, generator, nil, time.Nanosecond, 0, nil)
+
+	mock.ExpectPing()
+	if code, body := probe(t, health.readiness); code != http.StatusServiceUnavailable {
+		t.Fatalf("readiness before the limit = %d %+v, want %d", code, body, http.StatusServiceUnavailable)
+	}
+
+	mock.ExpectPrepare("INSERT INTO").WillReturnError(errors.New("prepared statements are not supported"))
+	mock.ExpectQuery("INSERT INTO").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "message", "created_at"}).AddRow(1, "Audit log #1", time.Now()))
+	if err := generator.RunInserter(t.Context()); err != nil {
+		t.Fatalf("RunInserter() = %v, want nil once the limit is reached", err)
+	}
+	if !generator.InsertsFinished() {
+		t.Fatal("InsertsFinished() = false after the limit was reached")
+	}
+
+	mock.ExpectPing()
+	if code, body := probe(t, health.readiness); code != http.StatusOK {
+		t.Errorf("readiness after the limit = %d %+v, want %d", code, body, http.StatusOK)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHealthProbes(t *testing.T) {
+	tests := []struct {
+		name       string
+		pingErr    error
+		stale      bool // No insert within staleAfter
+		wantLive   int
+		wantReady  int
+		wantStatus string
+	}{
+		{"healthy", nil, false, http.StatusOK, http.StatusOK, "ok"},
+		{"database down", errors.New("connection refused"), false,
+			http.StatusServiceUnavailable, http.StatusServiceUnavailable, "unavailable"},
+		{"inserts stale", nil, true, http.StatusOK, http.StatusServiceUnavailable, "unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			if err != nil {
+				t.Fatalf("opening mock database: %v", err)
+			}
+			defer db.Close()
+
+			staleAfter := time.Hour
+			if tt.stale {
+				staleAfter = time.Nanosecond
+			}
+			generator := cleaner.New(db, cleaner.Options{Table: "audit_logs", Generate: true, InsertInterval: time.Second})
+			health := newHealthChecker(db, generator, []*cleaner.Cleaner{generator}, staleAfter, 0, nil)
+
+			for _, p := range []struct {
+				name    string
+				handler http.HandlerFunc
+				want    int
+			}{
+				{"liveness", health.liveness, tt.wantLive},
+				{"readiness", health.readiness, tt.wantReady},
+			} {
+				mock.ExpectPing().WillReturnError(tt.pingErr)
+				w := httptest.NewRecorder()
+				p.handler(w, httptest.NewRequest(http.MethodGet, "/"+p.name, nil))
+				if w.Code != p.want {
+					t.Errorf("%s = %d %s, want %d", p.name, w.Code, w.Body, p.want)
+				}
+				if got := w.Header().Get("Content-Type"); got != "application/json" {
+					t.Errorf("%s Content-Type = %q, want application/json", p.name, got)
+				}
+			}
+
+			mock.ExpectPing().WillReturnError(tt.pingErr)
+			if _, body := probe(t, health.readiness); body.Status != tt.wantStatus {
+				t.Errorf("readiness status = %q, want %q", body.Status, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"auditlog-cleaner/cleaner"
+)
+
+// printHistory writes a table of the last n recorded cleanup runs to w,
+// newest first.
+func printHistory(ctx context.Context, w io.Writer, db *sql.DB, n int) error {
+	entries, err := cleaner.History(ctx, db, n)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STARTED\tDURATION\tTABLE\tSTRATEGY\tPARTITIONS\tROWS\tFREED\tARCHIVE\tERROR")
+	for _, e := range entries {
+		partitions, freed, archive, errText := "-", "-", "-", "-"
+		if len(e.Partitions) > 0 {
+			partitions = strings.Join(e.Partitions, ",")
+		}
+		if e.Bytes != nil {
+			freed = formatBytes(*e.Bytes)
+		}
+		if e.Archive != "" {
+			archive = e.Archive
+		}
+		if e.Error != "" {
+			errText = e.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			e.StartedAt.UTC().Format(time.RFC3339), e.FinishedAt.Sub(e.StartedAt).Round(time.Millisecond),
+			e.Table, e.Strategy, partitions, e.Rows, freed, archive, errText)
+	}
+	return tw.Flush()
+}
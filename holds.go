@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"auditlog-cleaner/cleaner"
+	"auditlog-cleaner/config"
+)
+
+// printHolds writes a table of the holds to w: those of HOLDS, then those
+// recorded in the holds table.
+func printHolds(ctx context.Context, w io.Writer, db *sql.DB, configured []cleaner.Hold) error {
+	recorded, err := cleaner.Holds(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTABLE\tFROM\tTO\tREASON")
+	for _, h := range append(configured, recorded...) {
+		id, table, reason := "config", "(all)", "-"
+		if h.ID != 0 {
+			id = strconv.FormatInt(h.ID, 10)
+		}
+		if h.Table != "" {
+			table = h.Table
+		}
+		if h.Reason != "" {
+			reason = h.Reason
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", id, table,
+			h.From.UTC().Format(time.RFC3339), h.To.UTC().Format(time.RFC3339), reason)
+	}
+	return tw.Flush()
+}
+
+// addHold records a hold over the range flag value, two times separated by
+// a slash like 2024-03-03/2024-03-04, for table, or every table if empty.
+func addHold(ctx context.Context, db *sql.DB, rangeFlag, table, reason string) (int64, error) {
+	fromFlag, toFlag, ok := strings.Cut(rangeFlag, "/")
+	if !ok {
+		return 0, fmt.Errorf("--hold-add: invalid range %q: expected two times separated by a slash, e.g. 2024-03-03/2024-03-04", rangeFlag)
+	}
+	from, err := config.ParseTime(fromFlag)
+	if err != nil {
+		return 0, fmt.Errorf("--hold-add: %w", err)
+	}
+	to, err := config.ParseTime(toFlag)
+	if err != nil {
+		return 0, fmt.Errorf("--hold-add: %w", err)
+	}
+	return cleaner.AddHold(ctx, db, cleaner.Hold{Table: table, From: from, To: to, Reason: reason})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"auditlog-cleaner/cleaner"
+)
+
+// ingestResponse is the JSON body returned by POST /logs.
+type ingestResponse struct {
+	Accepted int                   `json:"accepted"`
+	Error    string                `json:"error,omitempty"`
+	Records  []cleaner.RecordError `json:"records,omitempty"` // The invalid records, by index
+}
+
+// newIngestHandler serves POST /logs, which queues a JSON array of audit
+// logs with ingester. A submission is accepted or rejected as a whole:
+// with 400 and every invalid record, or for records spanning too many
+// partitions, 413 for a body over maxBody bytes, or 429 while the buffer is
+// full, asking to retry after flushEvery. With a token, requests must carry
+// it as a bearer token, like those of the admin endpoints.
+func newIngestHandler(ingester *cleaner.Ingester, maxBody int64, flushEvery time.Duration, token string) http.HandlerFunc {
+	retryAfter := strconv.Itoa(int(math.Ceil(flushEvery.Seconds())))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r, token) {
+			return
+		}
+
+		var records []cleaner.Record
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody)).Decode(&records)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			respondIngest(w, http.StatusRequestEntityTooLarge, ingestResponse{
+				Error: fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit),
+			})
+			return
+		case err != nil:
+			respondIngest(w, http.StatusBadRequest, ingestResponse{Error: "body must be a JSON array of objects: " + err.Error()})
+			return
+		case len(records) == 0:
+			respondIngest(w, http.StatusBadRequest, ingestResponse{Error: "body must contain at least one record"})
+			return
+		}
+
+		err = ingester.Submit(records)
+		var invalid *cleaner.InvalidRecordsError
+		switch {
+		case errors.As(err, &invalid):
+			respondIngest(w, http.StatusBadRequest, ingestResponse{Error: "invalid records", Records: invalid.Records})
+		case errors.Is(err, cleaner.ErrTooManyPartitions):
+			respondIngest(w, http.StatusBadRequest, ingestResponse{Error: err.Error()})
+		case errors.Is(err, cleaner.ErrBufferFull):
+			w.Header().Set("Retry-After", retryAfter)
+			respondIngest(w, http.StatusTooManyRequests, ingestResponse{Error: err.Error()})
+		case errors.Is(err, cleaner.ErrIngesterClosed):
+			respondIngest(w, http.StatusServiceUnavailable, ingestResponse{Error: err.Error()})
+		case err != nil:
+			respondIngest(w, http.StatusInternalServerError, ingestResponse{Error: err.Error()})
+		default:
+			respondIngest(w, http.StatusAccepted, ingestResponse{Accepted: len(records)})
+		}
+	}
+}
+
+func respondIngest(w http.ResponseWriter, status int, body ingestResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
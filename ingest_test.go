@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"auditlog-cleaner/cleaner"
+)
+
+func TestIngestHandler(t *testing.T) {
+	future := time.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name           string
+		authorization  string
+		body           string
+		wantStatus     int
+		wantAccepted   int
+		wantRecords    []int // Indexes of the invalid records reported
+		wantRetryAfter string
+	}{
+		{"accepted", "Bearer s3cret", `[{"message": "a"}, {"message": "b"}]`, http.StatusAccepted, 2, nil, ""},
+		{"missing token", "", `[{"message": "a"}]`, http.StatusUnauthorized, 0, nil, ""},
+		{"wrong token", "Bearer other", `[{"message": "a"}]`, http.StatusUnauthorized, 0, nil, ""},
+		{"not an array", "Bearer s3cret", `{"message": "a"}`, http.StatusBadRequest, 0, nil, ""},
+		{"empty array", "Bearer s3cret", `[]`, http.StatusBadRequest, 0, nil, ""},
+		{"invalid records", "Bearer s3cret", `[{"message": "a"}, {"message": 1}, {"verb": "GET"}]`, http.StatusBadRequest, 0, []int{1, 2}, ""},
+		{"time in the future", "Bearer s3cret", fmt.Sprintf(`[{"created_at": %q}]`, future), http.StatusBadRequest, 0, []int{0}, ""},
+		{"body too large", "Bearer s3cret", `[{"message": "` + strings.Repeat("a", 256) + `"}]`, http.StatusRequestEntityTooLarge, 0, nil, ""},
+		{"buffer full", "Bearer s3cret", `[{"message": "a"}, {"message": "b"}, {"message": "c"}, {"message": "d"}]`, http.StatusTooManyRequests, 0, nil, "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cleaner.New(nil, cleaner.Options{Table: "audit_logs", Ingest: true, MaxAge: time.Hour})
+			ingester := cleaner.NewIngester(c, cleaner.IngestOptions{BufferSize: 3})
+			handler := newIngestHandler(ingester, 128, 4500*time.Millisecond, "s3cret")
+
+			r := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(tt.body))
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+			if w.Code == http.StatusUnauthorized {
+				return
+			}
+			var resp ingestResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if resp.Accepted != tt.wantAccepted {
+				t.Errorf("accepted = %d, want %d", resp.Accepted, tt.wantAccepted)
+			}
+			var indexes []int
+			for _, r := range resp.Records {
+				indexes = append(indexes, r.Index)
+			}
+			if fmt.Sprint(indexes) != fmt.Sprint(tt.wantRecords) {
+				t.Errorf("invalid records %v, want %v", indexes, tt.wantRecords)
+			}
+		})
+	}
+}
@@ -1,225 +1,701 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"auditlog-cleaner/cleaner"
+	"auditlog-cleaner/config"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
-	// Load .env file
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	// Parse command line flags
+	reset := flag.Bool("reset", false, "drop the audit table and all its data on startup")
+	dryRun := flag.Bool("dry-run", false, "only report what cleanup would delete, without modifying anything")
+	migrate := flag.Bool("migrate-timestamptz", false, "convert the audit table's TIMESTAMP time column to TIMESTAMPTZ on startup")
+	force := flag.Bool("force", false, "start even if a table fails the startup checks, logging the problems instead")
+	once := flag.Bool("once", false, "run a single cleanup pass and exit, for cron jobs")
+	stats := flag.Bool("stats", false, "print the size of each managed table and its partitions and exit")
+	history := flag.Int("history", 0, "print the last `N` recorded cleanup runs and exit")
+	backfillFrom := flag.String("backfill-from", "", "create the missing partitions of TABLE_NAME from this `time` on, or from this long ago like 72h, and exit")
+	backfillTo := flag.String("backfill-to", "", "end of the --backfill-from range (default now)")
+	backfillStep := flag.String("backfill-step", "1d", "time range of each partition created by --backfill-from")
+	backfillMax := flag.Int("backfill-max", 1000, "refuse a backfill that would create more partitions than this")
+	backfillRate := flag.Float64("backfill-rate", 0, "also write this many generated audit logs per hour of the --backfill-from range, spread evenly over it")
+	holds := flag.Bool("holds", false, "print the holds that keep partitions from being dropped and exit")
+	holdAdd := flag.String("hold-add", "", "keep the partitions overlapping this `range`, e.g. 2024-03-03/2024-03-04, from being dropped until the hold is removed, and exit")
+	holdTable := flag.String("hold-table", "", "table of the --hold-add hold (default every table)")
+	holdReason := flag.String("hold-reason", "", "reason recorded with the --hold-add hold")
+	holdRemove := flag.Int64("hold-remove", 0, "remove the hold with this `id` and exit")
+	configFile := flag.String("config", "", "read settings from this YAML `file`; flags and environment variables take precedence")
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	timeout := flag.Duration("timeout", 0, "abort a --once, --stats, --history or --backfill-from run that takes longer than this (0 means no limit)")
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(versionLine())
+		return
 	}
 
-	// Read environment variables directly
-	host := os.Getenv("POSTGRES_HOST")
-	portStr := os.Getenv("POSTGRES_PORT")
-	user := os.Getenv("POSTGRES_USER")
-	password := os.Getenv("POSTGRES_PASSWORD")
-	dbname := os.Getenv("POSTGRES_DB")
+	// Settings flags override the environment; godotenv never overwrites
+	// variables that are already set
+	if err := config.ApplyFlags(flag.CommandLine); err != nil {
+		fatal("Invalid command line flags", "error", err)
+	}
 
-	// Read timing configuration
-	insertIntervalStr := os.Getenv("INSERT_INTERVAL_SECONDS")
-	cleanupIntervalStr := os.Getenv("CLEANUP_INTERVAL_SECONDS")
-	maxLogAgeStr := os.Getenv("MAX_LOG_AGE_SECONDS")
+	// Precedence: flags > environment > config file > .env > defaults. Like
+	// godotenv, the config file never overwrites variables already set.
+	if *configFile != "" {
+		if err := config.LoadFile(*configFile); err != nil {
+			fatal("Invalid config file", "path", *configFile, "error", err)
+		}
+	}
 
-	// Convert port to int
-	port, err := strconv.Atoi(portStr)
-	if err != nil {
-		log.Fatal("Invalid port number")
+	// Load .env file, which is optional next to a config file
+	err := godotenv.Load()
+	if err != nil && !(*configFile != "" && errors.Is(err, fs.ErrNotExist)) {
+		fatal("Error loading .env file", "error", err)
 	}
 
-	insertInterval, err := strconv.ParseFloat(insertIntervalStr, 64)
-	if err != nil || insertInterval <= 0 {
-		insertInterval = 5.0 // Default: 5 seconds
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	cfg.ResetOnStart = cfg.ResetOnStart || *reset
+	cfg.DryRun = cfg.DryRun || *dryRun
+	cfg.MigrateTimestamptz = cfg.MigrateTimestamptz || *migrate
+	cfg.Force = cfg.Force || *force
+	cfg.File = *configFile
+	if *once {
+		cfg.RunMode = config.RunModeOnce
 	}
 
-	cleanupInterval, err := strconv.ParseFloat(cleanupIntervalStr, 64)
-	if err != nil || cleanupInterval <= 0 {
-		cleanupInterval = 60.0 // Default: 60 seconds
+	slog.SetDefault(newLogger(os.Stdout, cfg.Log))
+	v, commit, built := buildInfo()
+	slog.Info("starting auditlog-cleaner", "version", v, "commit", commit, "build_date", built)
+	cfg.Print()
+
+	oneOffs := 0
+	backfill := *backfillFrom != ""
+	for _, set := range []bool{*once, *stats, *history != 0, backfill, *holds, *holdAdd != "", *holdRemove != 0} {
+		if set {
+			oneOffs++
+		}
+	}
+	if oneOffs > 1 {
+		fatal("only one of --once, --stats, --history, --backfill-from, --holds, --hold-add and --hold-remove can be used")
+	}
+	if *history < 0 {
+		fatal("--history must be greater than 0", "history", *history)
 	}
 
-	maxLogAge, err := strconv.Atoi(maxLogAgeStr)
-	if err != nil || maxLogAge <= 0 {
-		maxLogAge = 30 // Default: 30 seconds
+	if *backfillMax <= 0 {
+		fatal("--backfill-max must be greater than 0", "backfill_max", *backfillMax)
+	}
+	if *backfillRate < 0 {
+		fatal("--backfill-rate must not be negative", "backfill_rate", *backfillRate)
 	}
 
-	fmt.Printf("Configuration:\n")
-	fmt.Printf("  Insert interval: %.1f seconds\n", insertInterval)
-	fmt.Printf("  Cleanup interval: %.1f seconds\n", cleanupInterval)
-	fmt.Printf("  Max log age: %d seconds\n\n", maxLogAge)
+	// RUN_MODE=once works like --once, e.g. for a Kubernetes CronJob;
+	// --stats, --history, --backfill-from and the hold flags take precedence
+	holdOp := *holds || *holdAdd != "" || *holdRemove != 0
+	oneShot := cfg.RunMode == config.RunModeOnce && !*stats && *history == 0 && !backfill && !holdOp
+	if *timeout != 0 && oneOffs == 0 && !oneShot {
+		fatal("--timeout can only be used with --once, --stats, --history, --backfill-from or the hold flags")
+	}
+	if oneShot && cfg.Mode == config.ModeGenerateOnly {
+		fatal("a single cleanup pass was requested, but cleanup is disabled", "mode", cfg.Mode, "run_mode", cfg.RunMode)
+	}
 
-	psqlInfo := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname,
-	)
+	tracing, err := setupTracing(context.Background(), cfg.OTLPEndpoint)
+	if err != nil {
+		fatal("Failed to set up tracing", "error", err)
+	}
+	defer shutdownTracing(tracing, cfg.Timing.ShutdownTimeout)
 
-	fmt.Println("Connection string:", psqlInfo)
+	slog.Info("connecting to database", "dsn", cfg.Database.SafeConnectionString())
 
-	db, err := sql.Open("postgres", psqlInfo)
+	// lib/pq registers itself as "postgres"
+	driverName := "postgres"
+	if cfg.Database.Driver == cleaner.DriverPGX {
+		driverName = "pgx"
+	}
+	db, err := sql.Open(driverName, cfg.Database.ConnectionString())
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to open database", "error", err)
 	}
 	defer db.Close()
+	db.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
+
+	// Cancel the root context on CTRL+C or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// A one-off run must not hang forever
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
-	// Test connection
-	err = db.Ping()
+	// Postgres may still be starting, e.g. under docker-compose, so keep
+	// trying for a while before giving up
+	err = cleaner.WaitForDB(ctx, db, cfg.Retry.ConnectRetries, cfg.Retry.BaseDelay, cfg.Retry.StartupTimeout)
+	if cleaner.IsTLSError(err) {
+		fatal("TLS handshake with database failed, check POSTGRES_SSL_MODE and the certificates",
+			"ssl_mode", cfg.Database.SSLMode, "error", err)
+	}
 	if err != nil {
-		log.Fatal("Cannot connect to database:", err)
+		fatal("Cannot connect to database", "error", err)
 	}
-	fmt.Println("Successfully connected to database!")
+	slog.Info("connected to database")
 
-	// Create table if it doesn't exist
-	createTableQuery := `
-		CREATE TABLE IF NOT EXISTS audit_logs (
-			id SERIAL PRIMARY KEY,
-			message TEXT NOT NULL,
-			created_at TIMESTAMP NOT NULL DEFAULT NOW()
-		);
-		CREATE INDEX IF NOT EXISTS idx_created_at ON audit_logs(created_at);
-	`
+	// Timestamps are written in UTC; a session in another time zone shifts
+	// them as soon as they touch a TIMESTAMP column or NOW()
+	if err := cleaner.CheckTimeZone(ctx, db); err != nil {
+		slog.Warn("could not check database time zone", "error", err)
+	}
 
-	_, err = db.Exec(createTableQuery)
-	if err != nil {
-		log.Fatal("Failed to create table:", err)
+	if *history > 0 {
+		if err := printHistory(ctx, os.Stdout, db, *history); err != nil {
+			fatal("Failed to read cleanup history", "error", err)
+		}
+		return
 	}
-	fmt.Println("Table ready!")
 
-	// Start goroutine to insert audit logs every 5 seconds
-	go insertAuditLogsRoutine(db, insertInterval)
+	// Holds are managed without touching the managed tables
+	switch {
+	case *holds:
+		if err := printHolds(ctx, os.Stdout, db, cfg.Cleanup.Holds); err != nil {
+			fatal("Failed to read holds", "error", err)
+		}
+		return
+	case *holdAdd != "":
+		if *holdTable != "" && !slices.ContainsFunc(cfg.Tables, func(t config.TableConfig) bool { return t.Name == *holdTable }) {
+			fatal("--hold-table is not one of the managed tables", "table", *holdTable)
+		}
+		id, err := addHold(ctx, db, *holdAdd, *holdTable, *holdReason)
+		if err != nil {
+			fatal("Failed to add hold", "error", err)
+		}
+		slog.Info("hold added", "id", id, "range", *holdAdd, "table", *holdTable, "reason", *holdReason)
+		return
+	case *holdRemove != 0:
+		removed, err := cleaner.RemoveHold(ctx, db, *holdRemove)
+		if err != nil {
+			fatal("Failed to remove hold", "error", err)
+		}
+		if !removed {
+			fatal("No hold with this id", "id", *holdRemove)
+		}
+		slog.Info("hold removed", "id", *holdRemove)
+		return
+	}
 
-	// Start goroutine to delete old records every minute
-	go cleanupOldRecordsRoutine(db, cleanupInterval, maxLogAge)
+	// Notifications are sent in the background, so that a slow or failing
+	// webhook never holds up cleanup
+	var notifier cleaner.Notifier
+	var notifications *cleaner.AsyncNotifier
+	if cfg.Notify.WebhookURL != "" {
+		var webhook cleaner.Notifier = cleaner.NewWebhookNotifier(cfg.Notify.WebhookURL)
+		if cfg.Notify.Format == "slack" {
+			webhook = cleaner.NewSlackNotifier(cfg.Notify.WebhookURL)
+		}
+		notifications = cleaner.NewAsyncNotifier(webhook, 100)
+		notifier = notifications
+	}
 
-	// Keep the program running
-	fmt.Println("Audit log system started. Press Ctrl+C to stop.")
-	select {} // Block forever
-}
+	// Partitions are archived to S3 instead of ARCHIVE_DIR when a bucket is
+	// configured
+	var store cleaner.ObjectStore
+	if cfg.Archive.S3Bucket != "" {
+		s3, err := cleaner.NewS3Store(cleaner.S3Config{
+			Endpoint:        cfg.Archive.S3Endpoint,
+			Region:          cfg.Archive.S3Region,
+			Bucket:          cfg.Archive.S3Bucket,
+			Prefix:          cfg.Archive.S3Prefix,
+			AccessKeyID:     cfg.Archive.S3AccessKeyID,
+			SecretAccessKey: cfg.Archive.S3SecretAccessKey,
+		})
+		if err != nil {
+			fatal("Invalid S3 archive settings", "error", err)
+		}
+		store = s3
+	}
 
-func postToDB(db *sql.DB, message string) {
-	query := `
-        INSERT INTO audit_logs (message, created_at)
-        VALUES ($1, $2)
-        RETURNING id, created_at
-    `
+	// Ingestion writes to the database, so a dry run leaves it off like the
+	// generator
+	ingest := cfg.Ingest.Port != 0 && !oneShot && !*stats && !backfill
+	if ingest && cfg.DryRun {
+		slog.Info("[DRY RUN] ingestion disabled, no data will be modified")
+		ingest = false
+	}
 
-	var id int
-	var createdAt time.Time
-	err := db.QueryRow(query, message, time.Now()).Scan(&id, &createdAt)
-	if err != nil {
-		log.Fatalf("Failed to insert audit log: %v", err)
+	// One cleaner per managed table; the generator and ingestion write to
+	// the one named by TABLE_NAME, if any
+	var cleaners []*cleaner.Cleaner
+	var generator, ingestTarget *cleaner.Cleaner
+	for _, table := range cfg.Tables {
+		target := table.Name == cfg.TableName
+		generate := target && cfg.Mode != config.ModeCleanupOnly && !oneShot && !*stats && !backfill
+		// Backfilled audit logs are made up by the generator, which never
+		// runs during a backfill
+		generate = generate || target && backfill && *backfillRate > 0
+		c := cleaner.New(db, newOptions(cfg, table, generate, target && ingest, notifier, store))
+		if generate {
+			generator = c
+		}
+		if target && ingest {
+			ingestTarget = c
+		}
+		cleaners = append(cleaners, c)
 	}
 
-	fmt.Printf("Inserted: ID=%d, Message=%s, Time=%v\n", id, message, createdAt)
-}
+	// Stats are read-only, so the tables are left exactly as they are
+	if *stats {
+		if err := printStats(ctx, os.Stdout, cleaners); err != nil {
+			fatal("Failed to read table stats", "error", err)
+		}
+		return
+	}
+
+	if cfg.ResetOnStart && generator == nil {
+		slog.Warn("reset ignored", "mode", cfg.Mode, "run_mode", cfg.RunMode)
+	}
+	for _, c := range cleaners {
+		if err := c.Prepare(ctx); err != nil {
+			fatal("Failed to prepare table", "table", c.Table(), "error", err)
+		}
+		slog.Info("table ready", "table", c.Table(), "mode", cfg.Mode, "strategy", c.Strategy())
+	}
 
-func deleteOldRecords(db *sql.DB, secondsOld int) {
-	cutoffTime := time.Now().Add(-time.Duration(secondsOld) * time.Second)
-
-	// Delete in batches of 5 to reduce database load
-	batchSize := 5
-	totalDeleted := 0
-
-	for {
-		query := `
-			DELETE FROM audit_logs 
-			WHERE id IN (
-				SELECT id FROM audit_logs 
-				WHERE created_at < $1 
-				ORDER BY created_at ASC 
-				LIMIT $2
-			)
-			RETURNING id, message, created_at
-		`
-
-		rows, err := db.Query(query, cutoffTime, batchSize)
+	if backfill {
+		i := slices.IndexFunc(cleaners, func(c *cleaner.Cleaner) bool { return c.Table() == cfg.TableName })
+		if i < 0 {
+			fatal("TABLE_NAME is not one of the managed tables", "table", cfg.TableName)
+		}
+		if err := runBackfill(ctx, cleaners[i], *backfillFrom, *backfillTo, *backfillStep, *backfillMax, *backfillRate); err != nil {
+			fatal("Backfill failed", "error", err)
+		}
+		return
+	}
+
+	if oneShot {
+		err := runOnce(ctx, cleaners, cfg)
+		flushNotifications(notifications, cfg.Timing.ShutdownTimeout)
 		if err != nil {
-			log.Printf("Error deleting: %v", err)
-			return
+			shutdownTracing(tracing, cfg.Timing.ShutdownTimeout)
+			fatal("Cleanup failed", "error", err)
 		}
+		return
+	}
 
-		var deletedIDs []int
-		var deletedCount int
+	// RUN_DURATION and MAX_TOTAL_INSERTS end the run through the same
+	// graceful shutdown as a signal
+	ctx, shutdown := context.WithCancel(ctx)
+	defer shutdown()
+	if cfg.Timing.RunDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timing.RunDuration)
+		defer cancel()
+	}
+
+	var wg sync.WaitGroup
 
-		for rows.Next() {
-			var id int
-			var message string
-			var createdAt time.Time
+	var elector *cleaner.Elector
+	if cfg.LeaderElection {
+		elector = cleaner.NewElector(db, leaderLockName(cfg), cfg.LeaderInterval)
+	}
 
-			err := rows.Scan(&id, &message, &createdAt)
-			if err != nil {
-				log.Printf("Error scanning: %v", err)
-				continue
+	// Serve Prometheus metrics and health probes until shutdown
+	if cfg.MetricsPort != 0 {
+		// Readiness only tracks inserts when the generator is running
+		staleAfter := cfg.HealthStaleness
+		if cfg.Mode == config.ModeCleanupOnly || cfg.DryRun {
+			staleAfter = 0
+		}
+		health := newHealthChecker(db, generator, cleaners, staleAfter, cfg.CleanupSkipThreshold, elector)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.HandleFunc("/healthz", health.liveness)
+		mux.HandleFunc("/readyz", health.readiness)
+		mux.Handle("GET /partitions", &partitionsHandler{cleaners: cleaners})
+		switch {
+		case cfg.Mode == config.ModeGenerateOnly:
+		case cfg.AdminToken == "" && !cfg.AdminAllowUnauthenticated:
+			slog.Warn("admin endpoints disabled, set ADMIN_TOKEN to serve them")
+		default:
+			mux.Handle("POST /admin/cleanup", newAdminCleanupHandler(ctx, cleaners, cfg.AdminToken))
+			mux.Handle("POST /admin/cleanup/pause", newAdminPauseHandler(cleaners, cfg.AdminToken, true))
+			mux.Handle("POST /admin/cleanup/resume", newAdminPauseHandler(cleaners, cfg.AdminToken, false))
+			if cfg.AdminToken == "" {
+				slog.Warn("admin endpoints are not protected, as ADMIN_ALLOW_UNAUTHENTICATED is set")
 			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveHTTP(ctx, cfg.MetricsPort, mux)
+		}()
+	}
 
-			deletedIDs = append(deletedIDs, id)
-			deletedCount++
-			fmt.Printf("    - ID=%d, Message=%s, Created=%v\n", id, message, createdAt.Format("15:04:05"))
+	// Shut down through the usual path once a routine fails persistently,
+	// so that the orchestrator restarts the process
+	var escalated atomic.Bool
+	if cfg.ExitOnFailure && cfg.FailureThreshold > 0 {
+		for _, c := range cleaners {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				select {
+				case <-ctx.Done():
+				case <-c.Escalated():
+					escalated.Store(true)
+					shutdown()
+				}
+			}()
 		}
-		rows.Close()
+	}
 
-		if deletedCount == 0 {
-			break // No more records to delete
+	// Accept audit logs from other services. Every instance ingests, with
+	// or without the leadership, and the buffer is flushed on shutdown.
+	if ingestTarget != nil {
+		ingester := cleaner.NewIngester(ingestTarget, cleaner.IngestOptions{
+			BufferSize:    cfg.Ingest.BufferSize,
+			FlushInterval: cfg.Ingest.FlushInterval,
+			PartitionStep: cfg.Ingest.PartitionInterval,
+			MaxFuture:     cfg.Ingest.MaxFuture,
+			MaxPartitions: cfg.Ingest.MaxPartitions,
+		})
+		if cfg.Ingest.Token == "" {
+			slog.Warn("POST /logs is not protected, as INGEST_ALLOW_UNAUTHENTICATED is set")
 		}
 
-		totalDeleted += deletedCount
-		fmt.Printf("  Deleted batch of %d records (IDs: %v)\n", deletedCount, deletedIDs)
+		mux := http.NewServeMux()
+		mux.Handle("POST /logs", newIngestHandler(ingester, cfg.Ingest.MaxBodySize, cfg.Ingest.FlushInterval, cfg.Ingest.Token))
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			serveHTTP(ctx, cfg.Ingest.Port, mux)
+		}()
+		go func() {
+			defer wg.Done()
+			ingester.Run(ctx)
+		}()
+	}
 
-		// Small pause between batches to avoid overwhelming the database
-		time.Sleep(1000 * time.Millisecond)
+	// Insert audit logs every INSERT_INTERVAL. A dry run is strictly
+	// read-only, so the generator stays off.
+	inserter := generator
+	switch {
+	case generator == nil:
+		slog.Info("insert generator disabled", "mode", cfg.Mode)
+	case cfg.DryRun:
+		slog.Info("[DRY RUN] insert generator disabled, no data will be modified")
+		inserter = nil
+	}
+	if cfg.Mode == config.ModeGenerateOnly {
+		slog.Info("cleanup disabled", "mode", cfg.Mode)
+	}
+
+	// runRoutines runs the generator and one cleanup loop, and with
+	// MAINTENANCE_ENABLED one maintenance loop, per table until ctx is done,
+	// and with PARTITION_PREMAKE the partition maintainer of TABLE_NAME
+	runRoutines := func(ctx context.Context) {
+		var routines sync.WaitGroup
+		if inserter != nil {
+			routines.Add(1)
+			go func() {
+				defer routines.Done()
+				// RunInserter only returns nil once MAX_TOTAL_INSERTS is reached
+				err := inserter.RunInserter(ctx)
+				if err == nil && (!cfg.Timing.KeepCleanup || cfg.Mode == config.ModeGenerateOnly) {
+					shutdown()
+				}
+			}()
+		}
+		for _, c := range cleaners {
+			if cfg.PartitionPremake > 0 && c.Table() == cfg.TableName {
+				routines.Add(1)
+				go func() {
+					defer routines.Done()
+					c.RunPartitionMaintainer(ctx)
+				}()
+			}
+		}
+		if cfg.Mode != config.ModeGenerateOnly {
+			for _, c := range cleaners {
+				routines.Add(1)
+				go func() {
+					defer routines.Done()
+					c.RunCleanup(ctx)
+				}()
+				if cfg.Cleanup.Maintenance {
+					routines.Add(1)
+					go func() {
+						defer routines.Done()
+						c.RunMaintenance(ctx)
+					}()
+				}
+			}
+		}
+		routines.Wait()
+	}
 
+	// With leader election, only the instance holding the leadership runs
+	// the routines; the others stand by to take over
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if elector != nil {
+			elector.Run(ctx, runRoutines)
+		} else {
+			runRoutines(ctx)
+		}
+	}()
+
+	// Keep the program running until a shutdown signal arrives
+	slog.Info("audit log system started, press Ctrl+C to stop")
+	<-ctx.Done()
+	stop() // A second signal kills the process immediately
+
+	slog.Info("shutting down, waiting for running jobs to finish")
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		flushNotifications(notifications, cfg.Timing.ShutdownTimeout)
+		logSummary(cleaners)
+		slog.Info("shutdown complete")
+		if escalated.Load() {
+			fatal("stopped after a routine failed persistently")
+		}
+		if cfg.DryRun && slices.ContainsFunc(cleaners, (*cleaner.Cleaner).DryRunFailed) {
+			fatal("[DRY RUN] one or more inspection queries failed")
+		}
+	case <-time.After(cfg.Timing.ShutdownTimeout):
+		db.Close()
+		fatal("Shutdown timed out, forcing exit")
 	}
+}
 
-	if totalDeleted > 0 {
-		fmt.Printf("✓ Deleted %d total records older than %d seconds\n", totalDeleted, secondsOld)
-	} else {
-		fmt.Printf("✓ No records older than %d seconds to delete\n", secondsOld)
+// newOptions builds the cleaner options for one managed table. Only the
+// table written by the generator or by ingestion is ever created or
+// migrated, and only the generator's is ever reset.
+func newOptions(cfg *config.Config, table config.TableConfig, generate, ingest bool, notifier cleaner.Notifier, store cleaner.ObjectStore) cleaner.Options {
+	opts := cleaner.Options{
+		Table:            table.Name,
+		TimeColumn:       table.TimeColumn,
+		MaxAge:           table.MaxAge,
+		MaxTotalSize:     cfg.Cleanup.MaxTotalSize,
+		MaxPartitions:    cfg.Cleanup.MaxPartitions,
+		MinRetained:      cfg.Cleanup.MinRetainedPartitions,
+		Holds:            cfg.Cleanup.Holds,
+		SafetyMargin:     cfg.Cleanup.SafetyMargin,
+		CleanupInterval:  cfg.Timing.CleanupInterval,
+		Strategy:         cfg.Cleanup.Strategy,
+		BatchSize:        cfg.Cleanup.BatchSize,
+		BatchPause:       cfg.Cleanup.BatchPause,
+		Vacuum:           cfg.Cleanup.Vacuum,
+		LockTimeout:      cfg.Cleanup.LockTimeout,
+		StatementTimeout: cfg.Cleanup.StatementTimeout,
+		DropStrategy:     cfg.Cleanup.DropStrategy,
+		History:          cfg.Cleanup.History,
+		DefaultPartition: cfg.Cleanup.DefaultPartition,
+		Storage:          cfg.Cleanup.StorageMode,
+		ChunkInterval:    cfg.Cleanup.ChunkInterval,
+		ArchiveDir:       cfg.Archive.Dir,
+		ArchiveGzip:      cfg.Archive.Gzip,
+		ArchiveStore:     store,
+		Notifier:         notifier,
+		NotifyOnSuccess:  cfg.Notify.OnSuccess,
+		QueryTimeout:     cfg.Database.QueryTimeout,
+		MaxRetries:       cfg.Retry.MaxRetries,
+		RetryBaseDelay:   cfg.Retry.BaseDelay,
+		Driver:           cfg.Database.Driver,
+		CleanupJitter:    percentToFraction(cfg.Timing.TickJitter),
+		FailureThreshold: cfg.FailureThreshold,
+		DryRun:           cfg.DryRun,
+		Force:            cfg.Force,
+	}
+	// Count retention is a partition limit without the age check; config
+	// rejects MAX_PARTITIONS alongside it, so nothing is overridden here
+	if cfg.Cleanup.RetentionMode == cleaner.RetentionCount {
+		opts.Retention = cleaner.RetentionCount
+		opts.MaxPartitions = cfg.Cleanup.RetentionCount
+	}
+	if cfg.Cleanup.Maintenance {
+		opts.MaintenanceInterval = cfg.Cleanup.MaintenanceInterval
+	}
+	if table.Name == cfg.TableName {
+		opts.PartitionPrefix = cfg.PartitionNamePrefix
+		opts.PartitionTemplate = cfg.PartitionNameTemplate
+		opts.PartitionTimeLayout = cfg.PartitionTimeLayout
+		opts.PartitionGranularity = cfg.PartitionGranularity
+		opts.PartitionPremake = cfg.PartitionPremake
+		opts.Indexes = cfg.Indexes
+		opts.IndexMode = cfg.IndexMode
 	}
+	if generate || ingest {
+		opts.Ingest = ingest
+		opts.InsertChunkSize = cfg.BatchChunkSize
+		opts.ChunkTransaction = cfg.BatchSingleTx
+		opts.InsertMode = cfg.InsertMode
+		opts.MigrateTimestamptz = cfg.MigrateTimestamptz
+		for _, col := range cfg.Columns {
+			opts.Columns = append(opts.Columns, cleaner.Column{Name: col.Name, Type: col.Type})
+		}
+	}
+	if generate {
+		opts.Generate = true
+		opts.InsertInterval = cfg.Timing.InsertInterval
+		opts.MaxInserts = int64(cfg.Timing.MaxTotalInserts)
+		opts.InsertRate = cfg.Timing.InsertRate
+		opts.InsertJitter = percentToFraction(cfg.Timing.InsertJitter)
+		opts.RampUp = cfg.Timing.RampUp
+		opts.InsertBackoffThreshold = cfg.Timing.InsertBackoffThreshold
+		opts.InsertMaxBackoff = cfg.Timing.InsertMaxBackoff
+		opts.Reset = cfg.ResetOnStart
+		opts.Traffic = cleaner.Traffic{
+			Methods:   cfg.Traffic.Methods,
+			Paths:     cfg.Traffic.Paths,
+			Users:     cfg.Traffic.Users,
+			ErrorRate: cfg.Traffic.ErrorRate,
+		}
+		opts.Seed = cfg.Traffic.Seed
+	}
+	return opts
+}
+
+// percentToFraction converts a setting given in percent, like
+// TICK_JITTER_PERCENT, to the fraction of 1 the cleaner options take.
+func percentToFraction(percent float64) float64 {
+	return percent / 100
 }
 
-func insertAuditLogsRoutine(db *sql.DB, intervalSeconds float64) {
-	counter := 1
-	ticker := time.NewTicker(time.Duration(intervalSeconds*1000) * time.Millisecond)
-	defer ticker.Stop()
+// runOnce runs a single cleanup pass over every managed table, logs a
+// summary and reports whether any table failed.
+func runOnce(ctx context.Context, cleaners []*cleaner.Cleaner, cfg *config.Config) error {
+	var total cleaner.CleanupResult
+	var failed []string
+	for _, c := range cleaners {
+		result, err := c.Cleanup(ctx)
+		total.Rows += result.Rows
+		total.Partitions = append(total.Partitions, result.Partitions...)
+		total.Bytes += result.Bytes
+		if err != nil || c.DryRunFailed() {
+			failed = append(failed, c.Table())
+		}
+	}
 
-	for range ticker.C {
-		message := fmt.Sprintf("Audit log #%d", counter)
-		postToDB(db, message)
-		counter++
+	slog.Info("cleanup summary", "tables", len(cleaners), "deleted", total.Rows,
+		"partitions_dropped", len(total.Partitions), "bytes_freed", total.Bytes,
+		"failed", failed, "dry_run", cfg.DryRun)
+	if len(failed) > 0 {
+		return fmt.Errorf("cleanup failed for %s", strings.Join(failed, ", "))
 	}
+	return nil
 }
 
-func cleanupOldRecordsRoutine(db *sql.DB, intervalSeconds float64, maxAgeSeconds int) {
-	ticker := time.NewTicker(time.Duration(intervalSeconds*1000) * time.Millisecond)
-	defer ticker.Stop()
+// logSummary logs what the run did over every managed table.
+func logSummary(cleaners []*cleaner.Cleaner) {
+	var total cleaner.Summary
+	var latency time.Duration
+	for _, c := range cleaners {
+		s := c.Summary()
+		total.Inserted += s.Inserted
+		total.Batches += s.Batches
+		total.PartitionsCreated += s.PartitionsCreated
+		total.PartitionsDropped += s.PartitionsDropped
+		latency += s.BatchLatency * time.Duration(s.Batches)
+	}
+	if total.Batches > 0 {
+		total.BatchLatency = latency / time.Duration(total.Batches)
+	}
 
-	var mu sync.Mutex
-	isRunning := false
+	slog.Info("run summary", "inserted", total.Inserted, "insert_batches", total.Batches,
+		"avg_batch_latency", total.BatchLatency, "partitions_created", total.PartitionsCreated,
+		"partitions_dropped", total.PartitionsDropped)
+}
 
-	for range ticker.C {
-		mu.Lock()
-		if isRunning {
-			fmt.Println("⚠️  Previous cleanup still running, skipping this cycle")
-			mu.Unlock()
-			continue
-		}
-		isRunning = true
-		mu.Unlock()
+// flushNotifications waits up to timeout for the queued notifications to be
+// delivered. n may be nil.
+func flushNotifications(n *cleaner.AsyncNotifier, timeout time.Duration) {
+	if n == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := n.Close(ctx); err != nil {
+		slog.Warn("dropped notifications that were still queued", "error", err)
+	}
+}
 
-		fmt.Println("\n--- Running cleanup job ---")
-		deleteOldRecords(db, maxAgeSeconds)
+// leaderLockName names the leader election lock after the managed tables,
+// so that deployments managing different tables of one database elect their
+// leaders independently.
+func leaderLockName(cfg *config.Config) string {
+	names := make([]string, len(cfg.Tables))
+	for i, t := range cfg.Tables {
+		names[i] = t.Name
+	}
+	return "auditlog-cleaner:leader:" + strings.Join(names, ",")
+}
+
+// fatal logs msg at error level and exits with status 1.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// newLogger builds the process-wide logger, writing to w, from the validated
+// log settings.
+func newLogger(w io.Writer, cfg config.LogConfig) *slog.Logger {
+	var level slog.Level
+	switch cfg.Level {
+	case "debug":
+		level = slog.LevelDebug
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		level = slog.LevelInfo
+	}
 
-		mu.Lock()
-		isRunning = false
-		mu.Unlock()
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.Format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
 	}
+	return slog.New(slog.NewTextHandler(w, opts))
 }
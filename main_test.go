@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"auditlog-cleaner/cleaner"
+	"auditlog-cleaner/config"
+)
+
+func TestNewLoggerJSON(t *testing.T) {
+	var out bytes.Buffer
+	logger := newLogger(&out, config.LogConfig{Format: "json", Level: "info"})
+	logger.Debug("row deleted", "table", "audit_logs")
+	logger.Info("batch inserted", "table", "audit_logs", "count", 3)
+
+	// Every record is one JSON object; the debug one is below the level
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want 1:\n%s", len(lines), out.String())
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("decoding %s: %v", lines[0], err)
+	}
+	for key, want := range map[string]any{"level": "INFO", "msg": "batch inserted", "table": "audit_logs", "count": 3.0} {
+		if record[key] != want {
+			t.Errorf("%s = %v, want %v", key, record[key], want)
+		}
+	}
+	if _, ok := record["time"]; !ok {
+		t.Error("record has no time")
+	}
+}
+
+func TestNewLoggerText(t *testing.T) {
+	var out bytes.Buffer
+	newLogger(&out, config.LogConfig{Format: "text", Level: "debug"}).Debug("row deleted", "table", "audit_logs")
+	if got := out.String(); !strings.Contains(got, "level=DEBUG") || !strings.Contains(got, "table=audit_logs") {
+		t.Errorf("logged %q, want a text record at DEBUG", got)
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("opening mock database: %v", err)
+	}
+	defer db.Close()
+	cleaners := []*cleaner.Cleaner{
+		cleaner.New(db, cleaner.Options{Table: "audit_logs"}),
+		cleaner.New(db, cleaner.Options{Table: "access_logs"}),
+	}
+
+	// Each table gets exactly one pass: neither exists yet, so a pass is
+	// a single statement and a second one would find none expected
+	for _, table := range []string{"audit_logs", "access_logs"} {
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(table).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	}
+	if err := runOnce(t.Context(), cleaners, &config.Config{}); err != nil {
+		t.Errorf("runOnce() = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// A failed table fails the run, after the other tables had their pass
+	mock.ExpectQuery("SELECT EXISTS").WithArgs("audit_logs").WillReturnError(errors.New("permission denied"))
+	mock.ExpectQuery("SELECT EXISTS").WithArgs("access_logs").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	if err := runOnce(t.Context(), cleaners, &config.Config{}); err == nil || err.Error() != "cleanup failed for audit_logs" {
+		t.Errorf("runOnce() = %v, want the failure of audit_logs", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewOptionsJitter(t *testing.T) {
+	cfg := &config.Config{Timing: config.TimingConfig{TickJitter: 10, InsertJitter: 25}}
+	opts := newOptions(cfg, config.TableConfig{Name: "audit_logs"}, true, false, nil, nil)
+	if opts.CleanupJitter != 0.1 || opts.InsertJitter != 0.25 {
+		t.Errorf("CleanupJitter, InsertJitter = %g, %g, want 0.1, 0.25 for 10%% and 25%%", opts.CleanupJitter, opts.InsertJitter)
+	}
+}
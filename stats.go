@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"auditlog-cleaner/cleaner"
+)
+
+// printStats writes a table of the size of every managed table and its
+// partitions to w.
+func printStats(ctx context.Context, w io.Writer, cleaners []*cleaner.Cleaner) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tPARTITION\tRANGE\tROWS\tSIZE")
+
+	for _, c := range cleaners {
+		stats, err := c.Stats(ctx)
+		if err != nil {
+			return fmt.Errorf("table %s: %w", c.Table(), err)
+		}
+
+		for _, p := range stats.Partitions {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t~%d\t%s\n", stats.Table, p.Name, p.Range, p.Rows, formatBytes(p.Bytes))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t\t~%d\t%s\n", stats.Table, "(total)", stats.Rows, formatBytes(stats.Bytes))
+	}
+	return tw.Flush()
+}
+
+// formatBytes renders n bytes with a binary unit, e.g. 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// partitionsCacheTTL is how long GET /partitions serves the same answer
+// before reading the catalog again.
+const partitionsCacheTTL = 10 * time.Second
+
+// partitionInfo is one partition in the GET /partitions response.
+type partitionInfo struct {
+	Name  string     `json:"name"`
+	Range string     `json:"range"`
+	From  *time.Time `json:"from"` // null for MINVALUE, DEFAULT or a bound that is no time range
+	To    *time.Time `json:"to"`   // null for MAXVALUE, DEFAULT or a bound that is no time range
+	Rows  int64      `json:"rows"` // Estimate from the planner statistics
+	Bytes int64      `json:"bytes"`
+}
+
+// tablePartitions is one table in the GET /partitions response.
+type tablePartitions struct {
+	Table      string          `json:"table"`
+	Rows       int64           `json:"rows"`
+	Bytes      int64           `json:"bytes"`
+	Partitions []partitionInfo `json:"partitions"`
+}
+
+// partitionsResponse is the JSON body returned by GET /partitions.
+type partitionsResponse struct {
+	Error  string            `json:"error,omitempty"`
+	Tables []tablePartitions `json:"tables,omitempty"`
+}
+
+// partitionsHandler serves GET /partitions, the inventory of the partitions
+// of every managed table with their range, estimated rows and size, as read
+// by cleaner.Cleaner.Stats. Answers are cached for partitionsCacheTTL, so
+// that frequent polling doesn't load the catalog.
+type partitionsHandler struct {
+	cleaners []*cleaner.Cleaner
+
+	mu      sync.Mutex
+	cached  []tablePartitions
+	expires time.Time
+}
+
+func (h *partitionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tables, err := h.tables(r.Context())
+	status := http.StatusOK
+	body := partitionsResponse{Tables: tables}
+	if err != nil {
+		slog.Warn("could not list partitions", "error", err)
+		status = http.StatusServiceUnavailable
+		body = partitionsResponse{Error: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// tables returns the cached inventory, reading it again once expired.
+// Failures are not cached.
+func (h *partitionsHandler) tables(ctx context.Context) ([]tablePartitions, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Now().Before(h.expires) {
+		return h.cached, nil
+	}
+
+	tables := make([]tablePartitions, 0, len(h.cleaners))
+	for _, c := range h.cleaners {
+		stats, err := c.Stats(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", c.Table(), err)
+		}
+		t := tablePartitions{Table: stats.Table, Rows: stats.Rows, Bytes: stats.Bytes, Partitions: []partitionInfo{}}
+		for _, p := range stats.Partitions {
+			t.Partitions = append(t.Partitions, partitionInfo{
+				Name:  p.Name,
+				Range: p.Range,
+				From:  timeOrNil(p.From),
+				To:    timeOrNil(p.To),
+				Rows:  p.Rows,
+				Bytes: p.Bytes,
+			})
+		}
+		tables = append(tables, t)
+	}
+	h.cached, h.expires = tables, time.Now().Add(partitionsCacheTTL)
+	return tables, nil
+}
+
+// timeOrNil returns nil for the zero time, so that it is rendered as null.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"auditlog-cleaner/cleaner"
+)
+
+// statsQuery is the statement cleaner.Cleaner.Stats reads sizes with.
+const statsQuery = `
+	SELECT c.relname,
+	       c.oid = $1::regclass,
+	       COALESCE(pg_get_expr(c.relpartbound, c.oid), ''),
+	       GREATEST(c.reltuples, 0)::bigint,
+	       pg_total_relation_size(c.oid)
+	FROM pg_class c
+	WHERE c.oid = $1::regclass
+	   OR c.oid IN (SELECT inhrelid FROM pg_inherits WHERE inhparent = $1::regclass)
+	ORDER BY c.relname
+`
+
+// getPartitions serves GET /partitions with h and decodes the response.
+func getPartitions(t *testing.T, h http.Handler) (int, partitionsResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/partitions", nil))
+	var resp partitionsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	return w.Code, resp
+}
+
+func TestPartitionsHandler(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("opening mock database: %v", err)
+	}
+	defer db.Close()
+	h := &partitionsHandler{cleaners: []*cleaner.Cleaner{cleaner.New(db, cleaner.Options{Table: "audit_logs"})}}
+
+	// A failure is reported and not cached
+	mock.ExpectQuery(statsQuery).WithArgs(`"audit_logs"`).WillReturnError(errors.New("connection refused"))
+	if status, resp := getPartitions(t, h); status != http.StatusServiceUnavailable || resp.Error == "" {
+		t.Errorf("status = %d, error %q, want %d with an error", status, resp.Error, http.StatusServiceUnavailable)
+	}
+
+	mock.ExpectQuery(statsQuery).WithArgs(`"audit_logs"`).WillReturnRows(
+		sqlmock.NewRows([]string{"relname", "is_parent", "bound", "rows", "bytes"}).
+			AddRow("audit_logs", true, "", 0, 0).
+			AddRow("audit_logs_20240115_1200", false, "FOR VALUES FROM ('2024-01-15 12:00:00+00') TO ('2024-01-15 13:00:00+00')", 100, 8192).
+			AddRow("audit_logs_archive", false, "FOR VALUES FROM (MINVALUE) TO ('2024-01-15 12:00:00+00')", 50, 4096).
+			AddRow("audit_logs_default", false, "DEFAULT", 0, 1024),
+	)
+	status, resp := getPartitions(t, h)
+	if status != http.StatusOK || len(resp.Tables) != 1 {
+		t.Fatalf("status = %d, tables %v, want %d with one table", status, resp.Tables, http.StatusOK)
+	}
+	table := resp.Tables[0]
+	if table.Table != "audit_logs" || table.Rows != 150 || table.Bytes != 13312 || len(table.Partitions) != 3 {
+		t.Fatalf("table = %+v, want audit_logs with 150 rows, 13312 bytes and 3 partitions", table)
+	}
+
+	from, to := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 13, 0, 0, 0, time.UTC)
+	tests := []struct {
+		got      partitionInfo
+		name     string
+		rng      string
+		from, to *time.Time
+	}{
+		{table.Partitions[0], "audit_logs_20240115_1200", "FROM ('2024-01-15 12:00:00+00') TO ('2024-01-15 13:00:00+00')", &from, &to},
+		{table.Partitions[1], "audit_logs_archive", "FROM (MINVALUE) TO ('2024-01-15 12:00:00+00')", nil, &from},
+		{table.Partitions[2], "audit_logs_default", "DEFAULT", nil, nil},
+	}
+	sameTime := func(a, b *time.Time) bool { return (a == nil) == (b == nil) && (a == nil || a.Equal(*b)) }
+	for _, tt := range tests {
+		if tt.got.Name != tt.name || tt.got.Range != tt.rng || !sameTime(tt.got.From, tt.from) || !sameTime(tt.got.To, tt.to) {
+			t.Errorf("partition = %+v, want %s with range %s", tt.got, tt.name, tt.rng)
+		}
+	}
+
+	// The next answer comes from the cache, without another query
+	if status, resp := getPartitions(t, h); status != http.StatusOK || len(resp.Tables) != 1 {
+		t.Errorf("cached status = %d, tables %v, want %d with one table", status, resp.Tables, http.StatusOK)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{10 << 20, "10.0 MiB"},
+		{3 << 40, "3.0 TiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
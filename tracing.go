@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// setupTracing exports the cleaner's spans over OTLP/HTTP when endpoint is
+// set and returns the tracer provider, which must be shut down to flush
+// them. With no endpoint it returns nil and the global no-op tracer stays in
+// place, so spans cost next to nothing. The exporter reads the endpoint and
+// any other OTEL_EXPORTER_OTLP_* settings from the environment itself, and
+// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES are honored.
+func setupTracing(ctx context.Context, endpoint string) (*sdktrace.TracerProvider, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "auditlog-cleaner")),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return tp, nil
+}
+
+// shutdownTracing exports the spans still buffered, giving up after
+// timeout.
+func shutdownTracing(tp *sdktrace.TracerProvider, timeout time.Duration) {
+	if tp == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := tp.Shutdown(ctx); err != nil {
+		slog.Warn("failed to export remaining traces", "error", err)
+	}
+}
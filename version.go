@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Build information, set at build time with e.g.
+// go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+var version, commit, buildDate string
+
+// buildInfo returns the version, commit and build date of the binary. The
+// Go toolchain records the commit and its time for builds from a checkout,
+// which fill in for those not set at build time.
+func buildInfo() (v, c, date string) {
+	v, c, date = version, commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && c == "":
+				c = s.Value
+			case s.Key == "vcs.time" && date == "":
+				date = s.Value
+			}
+		}
+	}
+	if v == "" {
+		v = "dev"
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return v, c, date
+}
+
+// versionLine describes the build in one line, as printed by --version.
+func versionLine() string {
+	v, c, date := buildInfo()
+	return fmt.Sprintf("auditlog-cleaner %s (commit %s, built %s)", v, c, date)
+}
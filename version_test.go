@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// setBuildInfo sets the build information for the test, as -ldflags would.
+func setBuildInfo(t *testing.T, v, c, date string) {
+	t.Helper()
+	saved := [3]string{version, commit, buildDate}
+	t.Cleanup(func() { version, commit, buildDate = saved[0], saved[1], saved[2] })
+	version, commit, buildDate = v, c, date
+}
+
+func TestVersionLine(t *testing.T) {
+	setBuildInfo(t, "v1.2.0", "0123abc", "2024-01-15T12:00:00Z")
+	if got, want := versionLine(), "auditlog-cleaner v1.2.0 (commit 0123abc, built 2024-01-15T12:00:00Z)"; got != want {
+		t.Errorf("versionLine() = %q, want %q", got, want)
+	}
+}
+
+func TestVersionLineDefaults(t *testing.T) {
+	// Test binaries carry no VCS information, but a build from a checkout
+	// may fill in the commit and date
+	setBuildInfo(t, "", "", "")
+	v, c, date := buildInfo()
+	if v != "dev" || c == "" || date == "" {
+		t.Errorf("buildInfo() = %q, %q, %q, want dev and a commit and date, if only unknown", v, c, date)
+	}
+	if got := versionLine(); !strings.HasPrefix(got, "auditlog-cleaner dev (commit ") {
+		t.Errorf("versionLine() = %q, want the dev version", got)
+	}
+}